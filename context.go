@@ -1,14 +1,27 @@
 package qilin
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"mime"
 	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 	"weak"
 
+	"github.com/invopop/jsonschema"
 	"golang.org/x/exp/jsonrpc2"
 )
 
@@ -23,6 +36,31 @@ type Context interface {
 	Context() context.Context
 	// SetContext sets the context
 	SetContext(ctx context.Context)
+	// Principal returns the principal resolved by the transport.PrincipalAuthorizer. nil if not available.
+	Principal() any
+	// ConnectionDone returns a channel that is closed when the connection ends.
+	//
+	// Unlike Context().Done(), it is tied to the connection rather than the request,
+	// so long-lived per-connection work can clean up. Under stdio, it is closed when the stdio ends.
+	// nil if the connection is not available.
+	ConnectionDone() <-chan struct{}
+	// Transport returns the kind of the transport the request is served on.
+	Transport() TransportKind
+	// Abort terminates the whole connection the request is served on, not just the request,
+	// such as when the authorization is revoked in the middle of the session.
+	//
+	// It discards the session and closes the transport, so the connection context is canceled.
+	// Use it sparingly. It is no-op if the connection is not available.
+	Abort(reason string)
+	// Locale returns the locale the client passed as `_meta.locale` at initialize for the whole session, such as "ja-JP",
+	// so that the handler can localize by default. Empty if not passed.
+	Locale() string
+	// Detach returns the snapshot of the context, safe to use in a goroutine after the handler returns,
+	// such as for the fire-and-forget work.
+	//
+	// The context itself is reset and reused once the handler returns, so do not capture it in the goroutine.
+	// The snapshot holds the copy of the stored data, and its Context() keeps the values but is not canceled with the request.
+	Detach() Context
 }
 
 var _ Context = (*_context)(nil)
@@ -31,6 +69,11 @@ type _context struct {
 	ctx               context.Context
 	store             sync.Map
 	jsonrpcRequest    *jsonrpc2.Request
+	principal         any
+	connectionCtx     context.Context
+	abort             func(reason string)
+	locale            string
+	transport         TransportKind
 	jsonUnmarshalFunc JSONUnmarshalFunc
 	jsonMarshalFunc   JSONMarshalFunc
 }
@@ -55,16 +98,97 @@ func (c *_context) Context() context.Context {
 	return c.ctx
 }
 
+func (c *_context) Abort(reason string) {
+	if c.abort != nil {
+		c.abort(reason)
+	}
+}
+
+func (c *_context) Detach() Context {
+	d := &_context{
+		principal:         c.principal,
+		connectionCtx:     c.connectionCtx,
+		abort:             c.abort,
+		locale:            c.locale,
+		transport:         c.transport,
+		jsonUnmarshalFunc: c.jsonUnmarshalFunc,
+		jsonMarshalFunc:   c.jsonMarshalFunc,
+	}
+	if c.ctx != nil {
+		d.ctx = context.WithoutCancel(c.ctx)
+	}
+	if c.jsonrpcRequest != nil {
+		req := *c.jsonrpcRequest
+		d.jsonrpcRequest = &req
+	}
+	c.store.Range(func(key, value any) bool {
+		d.store.Store(key, value)
+		return true
+	})
+	return d
+}
+
+func (c *_context) Locale() string {
+	return c.locale
+}
+
 func (c *_context) SetContext(ctx context.Context) {
 	c.ctx = ctx
 }
 
+func (c *_context) Principal() any {
+	return c.principal
+}
+
+func (c *_context) ConnectionDone() <-chan struct{} {
+	if c.connectionCtx == nil {
+		return nil
+	}
+	return c.connectionCtx.Done()
+}
+
+func (c *_context) Transport() TransportKind {
+	return c.transport
+}
+
 func (c *_context) reset() {
-	c.store.Clear()
+	// Clear allocates even if the store is empty, so delete the stored keys instead.
+	c.store.Range(func(key, _ any) bool {
+		c.store.Delete(key)
+		return true
+	})
 	c.jsonrpcRequest = nil
+	c.principal = nil
+	c.connectionCtx = nil
+	c.abort = nil
+	c.locale = ""
+	c.transport = TransportKindUnknown
 	c.ctx = nil
 }
 
+// defaultMimeTypes is the MIME types of the contents not specified by the handler or the resource.
+// The zero value falls back to "text/plain" and "application/json".
+type defaultMimeTypes struct {
+	text string
+	json string
+}
+
+// textMimeType returns the MIME type of the plain text contents.
+func (d defaultMimeTypes) textMimeType() string {
+	if d.text == "" {
+		return "text/plain"
+	}
+	return d.text
+}
+
+// jsonMimeType returns the MIME type of the JSON contents.
+func (d defaultMimeTypes) jsonMimeType() string {
+	if d.json == "" {
+		return "application/json"
+	}
+	return d.json
+}
+
 // BindableContext is the context for handlers that able to bind JSON data
 type BindableContext interface {
 	Context
@@ -77,15 +201,31 @@ type ToolContext interface {
 	BindableContext
 	// ToolName returns the name of the Tool
 	ToolName() string
+	// ToolAnnotations returns the annotations of the Tool. zero value if not annotated.
+	ToolAnnotations() ToolAnnotations
 	// Arguments return the arguments passed to the Tool
 	Arguments() json.RawMessage
+	// SetContentAnnotations sets the annotations of the text content written afterwards in this call,
+	// such as the audience and the priority. They are distinct from the Tool annotations listed in `tools/list`.
+	SetContentAnnotations(annotations ContentAnnotations)
 	// String sends plain text content
 	String(s string) error
 	// JSON sends JSON content
 	JSON(i any) error
+	// Raw sends the pre-marshaled JSON as text content verbatim, without re-marshaling it.
+	// It fails with ErrInvalidRawJSON if raw is not valid JSON.
+	Raw(raw json.RawMessage) error
 	// Image sends image content
 	Image(data []byte, mimeType string) error
+	// Table sends the table as text content rendered in Markdown, along with the headers and rows as `structuredContent` of the result
+	//
+	//  - headers: the column names
+	//  - rows: the cells of each row, in the order of the headers
+	Table(headers []string, rows [][]string) error
 	// Audio sends audio content
+	//
+	// For the clients negotiated a protocol version older than 2025-03-26, it fails with ErrAudioNotSupported,
+	// or sends an embedded blob resource instead with the WithAudioDowngrade option.
 	Audio(data []byte, mimeType string) error
 	// JSONResource sends embed JSON resource content
 	JSONResource(uri *url.URL, i any, mimeType string) error
@@ -93,6 +233,51 @@ type ToolContext interface {
 	StringResource(uri *url.URL, s string, mimeType string) error
 	// BinaryResource sends embed binary resource content
 	BinaryResource(uri *url.URL, data []byte, mimeType string) error
+	// EmbedResource sends embed resource content read from the resource registered at the uri, by running its handler.
+	//
+	// If the resource returns several contents, the first one is embedded.
+	// It fails with ErrResourceNotFound if no resource matches the uri,
+	// and with ErrResourceContentNotWritten if the resource returns no content.
+	EmbedResource(ctx context.Context, uri *url.URL) error
+	// ResourceLink sends a link to the resource without embedding its contents.
+	// It fails with ErrInvalidResourceURI if uri is nil.
+	ResourceLink(uri *url.URL, name, description, mimeType string) error
+	// Fail sends the error result with `isError: true`, carrying the contents describing how to fix the failure,
+	// such as TextContent and ResourceLinkContent.
+	//
+	// Unlike returning an error, the result is sent as the successful response, so that the model can see it and self-correct.
+	Fail(contents ...CallToolContent) error
+	// Stream sends the plain text chunk as the `notifications/tools/stream` notification tied to the request,
+	// ahead of the final result, such as a line of the tailed logs.
+	//
+	// It is only supported on the Streamable HTTP transport, where the response is switched to the SSE stream.
+	// Otherwise, it fails with ErrToolStreamNotSupported.
+	Stream(s string) error
+	// Progress sends the `notifications/progress` notification tied to the progress token of the request.
+	//
+	//  - progress: the progress so far. it must increase every time.
+	//  - total: the total of the progress. 0 if unknown.
+	//  - message: (optional) the message describing the current progress
+	//
+	// It is no-op if the client does not provide the progress token.
+	Progress(progress, total float64, message string) error
+	// ProgressDone sends the final `notifications/progress` notification with the progress reaching the total,
+	// and no further progress is sent for the request.
+	//
+	// It is no-op if the client does not provide the progress token.
+	ProgressDone(message string) error
+	// Elicit requests additional information from the user via the client, and waits for the response,
+	// such as when the arguments lack information the tool needs.
+	//
+	//  - ctx: the context of waiting for the response
+	//  - schema: the JSON Schema of the requested information. it must be a flat object with the properties of primitive types.
+	//  - message: the message to present to the user
+	//
+	// It returns the submitted information if the user accepts.
+	// It returns ErrElicitationDeclined or ErrElicitationCanceled if the user declines or dismisses the request,
+	// and ErrElicitationNotSupported if the client does not advertise the elicitation capability.
+	// The request is sent over the live stream of the session, otherwise it fails with ErrNoLiveStream.
+	Elicit(ctx context.Context, schema *jsonschema.Schema, message string) (map[string]any, error)
 }
 
 var (
@@ -104,22 +289,225 @@ type toolContext struct {
 	_context
 	toolName         string
 	args             json.RawMessage
-	ctx              context.Context
-	annotation       *ToolAnnotations
+	boundArgs        map[reflect.Type]reflect.Value
+	annotation       *ContentAnnotations
+	protocolVersion  string
+	audioDowngrade   func(c ToolContext) *url.URL
+	lenientBinding   bool
 	dest             *CallToolContent
+	result           CallToolContent
 	base64StringFunc Base64StringFunc
+	contentEncoders  map[string]ContentEncoderFunc
+	mimeTypes        defaultMimeTypes
+	startStream      func() Notify
+	streamNotify     Notify
+	notify           Notify
+	progressToken    json.RawMessage
+	progress         float64
+	progressTotal    float64
+	caller           *handler
+	sessionID        string
 }
 
 func (c *toolContext) Arguments() json.RawMessage {
 	return c.args
 }
 
+// Bind binds the arguments into the provided type `i`.
+//
+// The decoded value is cached per type within the call, so middleware and handler binding into the same type
+// share a single unmarshal. Each Bind receives its own deep copy, so the bound values never share maps, slices or pointers.
+// If `i` already holds non-zero values, such as the defaults, the arguments are decoded into it afresh to keep them.
 func (c *toolContext) Bind(i any) error {
 	args := c.Arguments()
 	if len(args) == 0 {
 		return nil
 	}
-	return c.jsonUnmarshalFunc(args, i)
+	rv := reflect.ValueOf(i)
+	if c.lenientBinding && rv.Kind() == reflect.Pointer {
+		args = coerceArguments(args, rv.Type().Elem())
+	}
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return c.jsonUnmarshalFunc(args, i)
+	}
+	zero := rv.Elem().IsZero()
+	if cached, ok := c.boundArgs[rv.Type()]; ok && zero {
+		rv.Elem().Set(deepCopyValue(cached))
+		return nil
+	}
+	if err := c.jsonUnmarshalFunc(args, i); err != nil {
+		return err
+	}
+	if !zero {
+		// the decoded value is merged with the values held before, so it is not the one of the arguments alone.
+		return nil
+	}
+	if c.boundArgs == nil {
+		c.boundArgs = make(map[reflect.Type]reflect.Value)
+	}
+	c.boundArgs[rv.Type()] = deepCopyValue(rv.Elem())
+	return nil
+}
+
+// deepCopyValue returns a copy of v, not sharing the maps, the slices and the pointers with v.
+// The unexported fields, which are never decoded, are copied shallowly.
+func deepCopyValue(v reflect.Value) reflect.Value {
+	dst := reflect.New(v.Type()).Elem()
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			p := reflect.New(v.Type().Elem())
+			p.Elem().Set(deepCopyValue(v.Elem()))
+			dst.Set(p)
+		}
+	case reflect.Interface:
+		if !v.IsNil() {
+			dst.Set(deepCopyValue(v.Elem()))
+		}
+	case reflect.Slice:
+		if !v.IsNil() {
+			dst.Set(reflect.MakeSlice(v.Type(), v.Len(), v.Len()))
+			for i := range v.Len() {
+				dst.Index(i).Set(deepCopyValue(v.Index(i)))
+			}
+		}
+	case reflect.Array:
+		for i := range v.Len() {
+			dst.Index(i).Set(deepCopyValue(v.Index(i)))
+		}
+	case reflect.Map:
+		if !v.IsNil() {
+			dst.Set(reflect.MakeMapWithSize(v.Type(), v.Len()))
+			iter := v.MapRange()
+			for iter.Next() {
+				dst.SetMapIndex(iter.Key(), deepCopyValue(iter.Value()))
+			}
+		}
+	case reflect.Struct:
+		dst.Set(v)
+		for i := range v.NumField() {
+			if dst.Field(i).CanSet() {
+				dst.Field(i).Set(deepCopyValue(v.Field(i)))
+			}
+		}
+	default:
+		dst.Set(v)
+	}
+	return dst
+}
+
+// coerceArguments converts the string-encoded numbers and booleans in the arguments
+// into the JSON numbers and booleans where the target type expects them.
+// It returns the arguments as is if nothing is converted.
+func coerceArguments(args json.RawMessage, t reflect.Type) json.RawMessage {
+	dec := json.NewDecoder(bytes.NewReader(args))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return args
+	}
+	coerced, changed := coerceValue(v, t)
+	if !changed {
+		return args
+	}
+	b, err := json.Marshal(coerced)
+	if err != nil {
+		return args
+	}
+	return b
+}
+
+// coerceValue converts the string-encoded number or boolean v into the kind of t, walking into objects and arrays.
+func coerceValue(v any, t reflect.Type) (any, bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch v := v.(type) {
+	case string:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			if _, err := strconv.ParseFloat(v, 64); err == nil {
+				return json.Number(v), true
+			}
+		case reflect.Bool:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, true
+			}
+		}
+	case map[string]any:
+		var changed bool
+		switch t.Kind() {
+		case reflect.Struct:
+			for key, value := range v {
+				field, ok := jsonField(t, key)
+				if !ok {
+					continue
+				}
+				if coerced, ok := coerceValue(value, field.Type); ok {
+					v[key] = coerced
+					changed = true
+				}
+			}
+		case reflect.Map:
+			for key, value := range v {
+				if coerced, ok := coerceValue(value, t.Elem()); ok {
+					v[key] = coerced
+					changed = true
+				}
+			}
+		}
+		return v, changed
+	case []any:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return v, false
+		}
+		var changed bool
+		for idx, value := range v {
+			if coerced, ok := coerceValue(value, t.Elem()); ok {
+				v[idx] = coerced
+				changed = true
+			}
+		}
+		return v, changed
+	}
+	return v, false
+}
+
+// jsonField finds the struct field for the JSON key in the same way as encoding/json,
+// preferring the exact match over the case-insensitive one.
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	var (
+		fold  reflect.StructField
+		found bool
+	)
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || field.Anonymous && field.Type.Kind() == reflect.Struct {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		if name == key {
+			return field, true
+		}
+		if !found && strings.EqualFold(name, key) {
+			fold, found = field, true
+		}
+	}
+	return fold, found
+}
+
+func (c *toolContext) SetContentAnnotations(annotations ContentAnnotations) {
+	c.annotation = &annotations
 }
 
 func (c *toolContext) String(s string) error {
@@ -132,7 +520,7 @@ func (c *toolContext) String(s string) error {
 }
 
 func (c *toolContext) JSON(i any) error {
-	b, err := c.jsonMarshalFunc(i)
+	b, err := safeMarshal(c.jsonMarshalFunc, i)
 	if err != nil {
 		return err
 	}
@@ -144,8 +532,33 @@ func (c *toolContext) JSON(i any) error {
 	return nil
 }
 
+func (c *toolContext) Raw(raw json.RawMessage) error {
+	if !json.Valid(raw) {
+		return ErrInvalidRawJSON
+	}
+	*c.dest = &textCallToolContent{
+		Text:        string(raw),
+		Annotations: c.annotation,
+		marshal:     c.jsonMarshalFunc,
+	}
+	return nil
+}
+
+func (c *toolContext) Table(headers []string, rows [][]string) error {
+	*c.dest = &tableCallToolContent{
+		Headers:     headers,
+		Rows:        rows,
+		Annotations: c.annotation,
+		marshal:     c.jsonMarshalFunc,
+	}
+	return nil
+}
+
 func (c *toolContext) Image(data []byte, mimeType string) error {
-	enc := c.base64StringFunc(data)
+	enc, err := encodeContent(c.contentEncoders, c.base64StringFunc, data, mimeType)
+	if err != nil {
+		return err
+	}
 	*c.dest = &imageCallToolContent{
 		Data:     enc,
 		MimeType: mimeType,
@@ -155,7 +568,29 @@ func (c *toolContext) Image(data []byte, mimeType string) error {
 }
 
 func (c *toolContext) Audio(data []byte, mimeType string) error {
-	enc := c.base64StringFunc(data)
+	enc, err := encodeContent(c.contentEncoders, c.base64StringFunc, data, mimeType)
+	if err != nil {
+		return err
+	}
+	if c.protocolVersion != "" && c.protocolVersion < ProtocolVersion20250326 {
+		if c.audioDowngrade == nil {
+			return fmt.Errorf("%w: '%s'", ErrAudioNotSupported, c.protocolVersion)
+		}
+		uri := c.audioDowngrade(c)
+		if uri == nil {
+			return fmt.Errorf("%w: nil", ErrInvalidResourceURI)
+		}
+		*c.dest = &embedResourceCallToolContent{
+			Resource: &binaryResourceContent{
+				resourceContentBase: newEmbedResourceContentBase(uri, mimeType),
+				blob:                enc,
+				size:                int64(len(data)),
+				marshal:             c.jsonMarshalFunc,
+			},
+			marshal: c.jsonMarshalFunc,
+		}
+		return nil
+	}
 	*c.dest = &audioCallToolContent{
 		Data:     enc,
 		MimeType: mimeType,
@@ -165,20 +600,18 @@ func (c *toolContext) Audio(data []byte, mimeType string) error {
 }
 
 func (c *toolContext) JSONResource(uri *url.URL, i any, mimeType string) error {
-	b, err := c.jsonMarshalFunc(i)
+	b, err := safeMarshal(c.jsonMarshalFunc, i)
 	if err != nil {
 		return err
 	}
 	if mimeType == "" {
-		mimeType = "application/json"
+		mimeType = c.mimeTypes.jsonMimeType()
 	}
 	*c.dest = &embedResourceCallToolContent{
 		Resource: &textResourceContent{
-			resourceContentBase: resourceContentBase{
-				uri:      weak.Make(uri),
-				mimeType: mimeType,
-			},
-			text: string(b),
+			resourceContentBase: newEmbedResourceContentBase(uri, mimeType),
+			text:                string(b),
+			marshal:             c.jsonMarshalFunc,
 		},
 		marshal: c.jsonMarshalFunc,
 	}
@@ -187,15 +620,13 @@ func (c *toolContext) JSONResource(uri *url.URL, i any, mimeType string) error {
 
 func (c *toolContext) StringResource(uri *url.URL, s string, mimeType string) error {
 	if mimeType == "" {
-		mimeType = "text/plain"
+		mimeType = c.mimeTypes.textMimeType()
 	}
 	*c.dest = &embedResourceCallToolContent{
 		Resource: &textResourceContent{
-			resourceContentBase: resourceContentBase{
-				uri:      weak.Make(uri),
-				mimeType: mimeType,
-			},
-			text: s,
+			resourceContentBase: newEmbedResourceContentBase(uri, mimeType),
+			text:                s,
+			marshal:             c.jsonMarshalFunc,
 		},
 		marshal: c.jsonMarshalFunc,
 	}
@@ -203,34 +634,198 @@ func (c *toolContext) StringResource(uri *url.URL, s string, mimeType string) er
 }
 
 func (c *toolContext) BinaryResource(uri *url.URL, data []byte, mimeType string) error {
-	enc := c.base64StringFunc(data)
 	if mimeType == "" {
 		mimeType = "application/octet-stream"
 	}
+	enc, err := encodeContent(c.contentEncoders, c.base64StringFunc, data, mimeType)
+	if err != nil {
+		return err
+	}
 	*c.dest = &embedResourceCallToolContent{
 		Resource: &binaryResourceContent{
-			resourceContentBase: resourceContentBase{
-				uri:      weak.Make(uri),
-				mimeType: mimeType,
-			},
-			blob: enc,
+			resourceContentBase: newEmbedResourceContentBase(uri, mimeType),
+			blob:                enc,
+			size:                int64(len(data)),
+			marshal:             c.jsonMarshalFunc,
 		},
 		marshal: c.jsonMarshalFunc,
 	}
 	return nil
 }
 
+func (c *toolContext) EmbedResource(ctx context.Context, uri *url.URL) error {
+	if c.caller == nil {
+		return fmt.Errorf("%w: '%s'", ErrResourceNotFound, uri)
+	}
+	result, err := c.caller.readResource(ctx, c.jsonrpcRequest, uri, nil)
+	if err != nil {
+		return err
+	}
+	if len(result.Contents) == 0 {
+		return fmt.Errorf("%w: '%s'", ErrResourceContentNotWritten, uri)
+	}
+	*c.dest = &embedResourceCallToolContent{
+		Resource: withStrongURI(result.Contents[0], uri),
+		marshal:  c.jsonMarshalFunc,
+	}
+	return nil
+}
+
+func (c *toolContext) ResourceLink(uri *url.URL, name, description, mimeType string) error {
+	if uri == nil {
+		return fmt.Errorf("%w: nil", ErrInvalidResourceURI)
+	}
+	*c.dest = &resourceLinkCallToolContent{
+		URI:         uri.String(),
+		Name:        name,
+		Description: description,
+		MimeType:    mimeType,
+		marshal:     c.jsonMarshalFunc,
+	}
+	return nil
+}
+
+// clearResult clears the content written so far, such as by the failed attempt of RetryToolMiddleware.
+func (c *toolContext) clearResult() {
+	if c.dest != nil {
+		*c.dest = nil
+	}
+}
+
 func (c *toolContext) ToolName() string {
 	return c.toolName
 }
 
+func (c *toolContext) ToolAnnotations() ToolAnnotations {
+	if c.caller == nil {
+		return ToolAnnotations{}
+	}
+	tool, ok := c.caller.qilin.tools[c.toolName]
+	if !ok || tool.Annotations == nil {
+		return ToolAnnotations{}
+	}
+	return *tool.Annotations
+}
+
+func (c *toolContext) Fail(contents ...CallToolContent) error {
+	contents = slices.Clone(contents)
+	for i, v := range contents {
+		// the contents created by TextContent and ResourceLinkContent are marshaled by the configured func.
+		// copy them, since the caller may share them, such as the package-level vars.
+		switch v := v.(type) {
+		case *textCallToolContent:
+			if v.marshal == nil {
+				content := *v
+				content.marshal = c.jsonMarshalFunc
+				contents[i] = &content
+			}
+		case *resourceLinkCallToolContent:
+			if v.URI == "" {
+				return fmt.Errorf("%w: nil", ErrInvalidResourceURI)
+			}
+			if v.marshal == nil {
+				content := *v
+				content.marshal = c.jsonMarshalFunc
+				contents[i] = &content
+			}
+		}
+	}
+	*c.dest = &errorCallToolContent{
+		Contents: contents,
+		marshal:  c.jsonMarshalFunc,
+	}
+	return nil
+}
+
+func (c *toolContext) Stream(s string) error {
+	if c.streamNotify == nil {
+		if c.startStream == nil {
+			return ErrToolStreamNotSupported
+		}
+		c.streamNotify = c.startStream()
+	}
+	return c.streamNotify(c.ctx, MethodNotificationToolsStream, toolStreamNotificationParams{
+		RequestID: c.jsonrpcRequest.ID.Raw(),
+		Content: &textCallToolContent{
+			Text:        s,
+			Annotations: c.annotation,
+			marshal:     c.jsonMarshalFunc,
+		},
+	})
+}
+
+func (c *toolContext) Progress(progress, total float64, message string) error {
+	if len(c.progressToken) == 0 {
+		return nil
+	}
+	c.progress, c.progressTotal = progress, total
+	return c.notifyProgress(progress, total, message)
+}
+
+func (c *toolContext) ProgressDone(message string) error {
+	if len(c.progressToken) == 0 {
+		return nil
+	}
+	total := max(c.progressTotal, c.progress)
+	if total == 0 {
+		total = 1
+	}
+	err := c.notifyProgress(total, total, message)
+	c.progressToken = nil
+	return err
+}
+
+// notifyProgress sends the progress notification over the stream of the response if the transport supports it,
+// otherwise over the connection.
+func (c *toolContext) notifyProgress(progress, total float64, message string) error {
+	notify := c.notify
+	if c.streamNotify != nil {
+		notify = c.streamNotify
+	} else if c.startStream != nil {
+		c.streamNotify = c.startStream()
+		notify = c.streamNotify
+	}
+	if notify == nil {
+		return nil
+	}
+	return notify(c.ctx, MethodNotificationProgress, progressNotificationParams{
+		ProgressToken: c.progressToken,
+		Progress:      progress,
+		Total:         total,
+		Message:       message,
+	})
+}
+
+func (c *toolContext) Elicit(ctx context.Context, schema *jsonschema.Schema, message string) (map[string]any, error) {
+	if c.caller == nil {
+		return nil, ErrElicitationNotSupported
+	}
+	return c.caller.qilin.elicit(ctx, c.sessionID, elicitRequestParams{
+		Message:         message,
+		RequestedSchema: schema,
+	})
+}
+
 // reset resets the Tool context
 func (c *toolContext) reset() {
 	c._context.reset()
 	c.annotation = nil
+	c.protocolVersion = ""
+	c.audioDowngrade = nil
+	c.lenientBinding = false
 	c.toolName = ""
 	c.dest = nil
 	c.args = nil
+	c.startStream = nil
+	c.streamNotify = nil
+	c.notify = nil
+	c.progressToken = nil
+	c.progress = 0
+	c.progressTotal = 0
+	c.result = nil
+	c.caller = nil
+	c.sessionID = ""
+	clear(c.boundArgs)
 }
 
 // newToolContext creates a new Tool context
@@ -249,6 +844,9 @@ func newToolContext(
 }
 
 // ResourceContext is the context for resource handlers
+//
+// Each call of String, JSON, Blob, PartialBlob, File and Add appends a content to the result,
+// so a handler can return several contents from a single read.
 type ResourceContext interface {
 	Context
 	// ResourceURI returns the uri of the resource
@@ -257,15 +855,59 @@ type ResourceContext interface {
 	MimeType() string
 	// Param retrieves the path parameter by name
 	Param(name string) string
+	// BindParams binds the path parameters into the struct fields tagged with `param:"<name>"`.
+	BindParams(i any) error
 	// String sends plain text content
 	String(s string) error
 	// JSON sends JSON content
 	JSON(i any) error
+	// Raw sends the pre-marshaled JSON as text content verbatim, without re-marshaling it,
+	// such as the one proxied from an upstream service.
+	// It fails with ErrInvalidRawJSON if raw is not valid JSON.
+	Raw(raw json.RawMessage) error
+	// JSONWithSchema sends JSON content along with its JSON Schema as `_meta.schema`,
+	// so that clients can validate or render the content.
+	//
+	//  - i: the content
+	//  - schema: (Optional) the JSON Schema of the content. if nil, it is reflected from i as of the tool input schema.
+	JSONWithSchema(i any, schema *jsonschema.Schema) error
 	// Blob sends blob content
 	//
 	//  - data: the blob data
 	//  - mimeType: (Optional) the mime type of the blob. if not provided, a resource mime type will be used.
+	//
+	// If a byte range is requested, only the range of data is sent.
 	Blob(data []byte, mimeType string) error
+	// BlobRange returns the byte range requested by the client. nil if not requested.
+	//
+	// The range is given by `_meta.range` of the request, or by the `Range` header on the streamable HTTP transport.
+	BlobRange() *BlobRange
+	// PartialBlob sends blob content that has already been read in the requested range
+	//
+	//  - data: the blob data in the requested range
+	//  - size: the total size of the blob
+	//  - mimeType: (Optional) the mime type of the blob. if not provided, a resource mime type will be used.
+	PartialBlob(data []byte, size int64, mimeType string) error
+	// File sends the content of the file, closing it after reading
+	//
+	//  - path: the path of the file
+	//  - mimeType: (Optional) the mime type of the file. if not provided, a resource mime type or the one of the extension will be used.
+	//
+	// The file is sent as text for the text mime types, otherwise as blob.
+	// If a byte range is requested, only the range of the blob is read from the file.
+	// It returns ErrResourceNotFound if the file does not exist.
+	File(path string, mimeType string) error
+	// Children sends a `resource_link` content for each child resource, reading the resource as a collection
+	// such as a directory. Clients read the children with `resources/read` in turn.
+	//
+	// It returns ErrInvalidResourceURI if a child has no URI.
+	Children(children []Resource) error
+	// Add appends the custom resource content and returns the context for chaining
+	Add(content ResourceContent) ResourceContext
+	// SetLastModified sets the time the resource was last modified, reported as `lastModified` of the contents.
+	//
+	// It takes precedence over ResourceWithLastModified. The custom contents added by Add are not affected.
+	SetLastModified(t time.Time)
 }
 
 var _ ResourceContext = (*resourceContext)(nil)
@@ -273,10 +915,17 @@ var _ ResourceContext = (*resourceContext)(nil)
 type resourceContext struct {
 	_context
 	uri              weak.Pointer[url.URL]
+	name             string
+	description      string
 	mimeType         string
 	pathParams       map[string]string
+	blobRange        *BlobRange
+	lastModified     time.Time
 	dest             *readResourceResult
 	base64StringFunc Base64StringFunc
+	contentEncoders  map[string]ContentEncoderFunc
+	checksum         *resourceChecksum
+	mimeTypes        defaultMimeTypes
 }
 
 func (c *resourceContext) ResourceURI() *url.URL {
@@ -291,15 +940,77 @@ func (c *resourceContext) Param(name string) string {
 	return c.pathParams[name]
 }
 
+func (c *resourceContext) BindParams(i any) error {
+	rv := reflect.ValueOf(i)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrInvalidBindParamsTarget
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	for idx := range rt.NumField() {
+		field := rt.Field(idx)
+		name, ok := field.Tag.Lookup("param")
+		if !ok || !field.IsExported() {
+			continue
+		}
+		v, ok := c.pathParams[name]
+		if !ok {
+			continue
+		}
+		if err := setParam(rv.Field(idx), v); err != nil {
+			return fmt.Errorf("failed to bind path parameter '%s': %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setParam sets the string value of the path parameter to the field
+func setParam(field reflect.Value, v string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(v, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(v, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(v, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
 func (c *resourceContext) String(s string) error {
 	mimeType := c.mimeType
 	if mimeType == "" {
-		mimeType = "text/plain"
+		mimeType = c.mimeTypes.textMimeType()
 	}
 	c.dest.Contents = append(c.dest.Contents, textResourceContent{
 		resourceContentBase: resourceContentBase{
-			uri:      c.uri,
-			mimeType: mimeType,
+			uri:         c.uri,
+			name:        c.name,
+			description: c.description,
+			mimeType:    mimeType,
+			checksum:    c.checksum.of([]byte(s)),
 		},
 		text:    s,
 		marshal: c.jsonMarshalFunc,
@@ -308,27 +1019,179 @@ func (c *resourceContext) String(s string) error {
 }
 
 func (c *resourceContext) JSON(i any) error {
-	b, err := c.jsonMarshalFunc(i)
+	return c.appendJSON(i, nil)
+}
+
+func (c *resourceContext) Raw(raw json.RawMessage) error {
+	if !json.Valid(raw) {
+		return ErrInvalidRawJSON
+	}
+	return c.appendJSONText(string(raw), nil)
+}
+
+func (c *resourceContext) JSONWithSchema(i any, schema *jsonschema.Schema) error {
+	if schema == nil {
+		schema = reflectSchema(i)
+	}
+	return c.appendJSON(i, schema)
+}
+
+// appendJSON appends the JSON content to the result, with the schema if not nil
+func (c *resourceContext) appendJSON(i any, schema *jsonschema.Schema) error {
+	b, err := safeMarshal(c.jsonMarshalFunc, i)
 	if err != nil {
 		return err
 	}
+	return c.appendJSONText(string(b), schema)
+}
+
+// appendJSONText appends the marshaled JSON text to the result, with the schema if not nil
+func (c *resourceContext) appendJSONText(text string, schema *jsonschema.Schema) error {
 	mimeType := c.mimeType
 	if mimeType == "" {
-		mimeType = "application/json"
+		mimeType = c.mimeTypes.jsonMimeType()
 	}
 	c.dest.Contents = append(c.dest.Contents, textResourceContent{
 		resourceContentBase: resourceContentBase{
-			uri:      c.uri,
-			mimeType: mimeType,
+			uri:         c.uri,
+			name:        c.name,
+			description: c.description,
+			mimeType:    mimeType,
+			checksum:    c.checksum.of([]byte(text)),
 		},
-		text:    string(b),
+		text:    text,
+		schema:  schema,
 		marshal: c.jsonMarshalFunc,
 	})
 	return nil
 }
 
 func (c *resourceContext) Blob(data []byte, mimeType string) error {
-	enc := c.base64StringFunc(data)
+	size := int64(len(data))
+	if c.blobRange == nil {
+		return c.appendBlob(data, size, nil, mimeType)
+	}
+	data, applied := c.blobRange.slice(data)
+	return c.appendBlob(data, size, &applied, mimeType)
+}
+
+func (c *resourceContext) BlobRange() *BlobRange {
+	return c.blobRange
+}
+
+func (c *resourceContext) PartialBlob(data []byte, size int64, mimeType string) error {
+	var applied *BlobRange
+	if c.blobRange != nil {
+		applied = &BlobRange{
+			Start: c.blobRange.Start,
+			End:   c.blobRange.Start + int64(len(data)),
+		}
+	}
+	return c.appendBlob(data, size, applied, mimeType)
+}
+
+func (c *resourceContext) File(path string, mimeType string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: '%s'", ErrResourceNotFound, path)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if mimeType == "" {
+		mimeType = c.mimeType
+	}
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(filepath.Ext(path))
+	}
+	if isTextMimeType(mimeType) {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		c.dest.Contents = append(c.dest.Contents, textResourceContent{
+			resourceContentBase: resourceContentBase{
+				uri:         c.uri,
+				name:        c.name,
+				description: c.description,
+				mimeType:    mimeType,
+				checksum:    c.checksum.of(b),
+			},
+			text:    string(b),
+			marshal: c.jsonMarshalFunc,
+		})
+		return nil
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if c.blobRange == nil {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		return c.appendBlob(data, size, nil, mimeType)
+	}
+	applied := c.blobRange.bounds(size)
+	data, err := io.ReadAll(io.NewSectionReader(f, applied.Start, applied.End-applied.Start))
+	if err != nil {
+		return err
+	}
+	return c.appendBlob(data, size, &applied, mimeType)
+}
+
+// isTextMimeType reports whether the content of the mime type is text.
+func isTextMimeType(mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/yaml", "application/x-yaml":
+		return true
+	}
+	return false
+}
+
+func (c *resourceContext) Children(children []Resource) error {
+	for i, child := range children {
+		if child.URI.URL() == nil {
+			return fmt.Errorf("%w: children[%d]", ErrInvalidResourceURI, i)
+		}
+	}
+	for _, child := range children {
+		c.dest.Contents = append(c.dest.Contents, resourceLinkResourceContent{
+			resource: child,
+			marshal:  c.jsonMarshalFunc,
+		})
+	}
+	return nil
+}
+
+func (c *resourceContext) Add(content ResourceContent) ResourceContext {
+	if content != nil {
+		c.dest.Contents = append(c.dest.Contents, content)
+	}
+	return c
+}
+
+func (c *resourceContext) SetLastModified(t time.Time) {
+	c.lastModified = t
+}
+
+// appendBlob appends the binary resource content to the result
+func (c *resourceContext) appendBlob(data []byte, size int64, blobRange *BlobRange, mimeType string) error {
 	if mimeType == "" {
 		switch {
 		case c.mimeType != "":
@@ -337,13 +1200,22 @@ func (c *resourceContext) Blob(data []byte, mimeType string) error {
 			mimeType = "application/octet-stream"
 		}
 	}
+	enc, err := encodeContent(c.contentEncoders, c.base64StringFunc, data, mimeType)
+	if err != nil {
+		return err
+	}
 	c.dest.Contents = append(c.dest.Contents, binaryResourceContent{
 		resourceContentBase: resourceContentBase{
-			uri:      c.uri,
-			mimeType: mimeType,
+			uri:         c.uri,
+			name:        c.name,
+			description: c.description,
+			mimeType:    mimeType,
+			checksum:    c.checksum.of(data),
 		},
-		blob:    enc,
-		marshal: c.jsonMarshalFunc,
+		blob:      enc,
+		size:      size,
+		blobRange: blobRange,
+		marshal:   c.jsonMarshalFunc,
 	})
 	return nil
 }
@@ -351,8 +1223,12 @@ func (c *resourceContext) Blob(data []byte, mimeType string) error {
 func (c *resourceContext) reset() {
 	c._context.reset()
 	c.uri = weak.Pointer[url.URL]{}
+	c.name = ""
+	c.description = ""
 	c.mimeType = ""
 	c.pathParams = nil
+	c.blobRange = nil
+	c.lastModified = time.Time{}
 	c.dest = nil
 }
 
@@ -447,8 +1323,11 @@ type promptContext struct {
 	promptName       string
 	rawArgs          json.RawMessage
 	args             map[string]string
+	arguments        []PromptArgument
+	strictArguments  bool
 	dest             *getPromptResult
 	base64StringFunc Base64StringFunc
+	contentEncoders  map[string]ContentEncoderFunc
 }
 
 func (c *promptContext) PromptName() string {
@@ -459,11 +1338,45 @@ func (c *promptContext) Param(name string) string {
 	return c.args[name]
 }
 
+// Bind binds the arguments into the provided type `i`.
+//
+// As the arguments of the prompt are sent as strings, the string-encoded numbers and booleans
+// are coerced into the number and boolean fields.
+// If the prompt is registered with PromptWithStrictArguments, the arguments not declared are rejected with InvalidParamsError.
 func (c *promptContext) Bind(i any) error {
 	if len(c.rawArgs) == 0 {
 		return nil
 	}
-	return c.jsonUnmarshalFunc(c.rawArgs, i)
+	if c.strictArguments {
+		if err := validateDeclaredPromptArguments(c.arguments, c.rawArgs); err != nil {
+			return err
+		}
+	}
+	args := c.rawArgs
+	if rv := reflect.ValueOf(i); rv.Kind() == reflect.Pointer {
+		args = coerceArguments(args, rv.Type().Elem())
+	}
+	return c.jsonUnmarshalFunc(args, i)
+}
+
+// validateDeclaredPromptArguments reports the arguments not declared in the prompt.
+func validateDeclaredPromptArguments(arguments []PromptArgument, args json.RawMessage) error {
+	var props map[string]json.RawMessage
+	if err := json.Unmarshal(args, &props); err != nil {
+		return &InvalidParamsError{
+			Fields: []InvalidParamsField{{Name: "arguments", Reason: "must be an object"}},
+		}
+	}
+	var fields []InvalidParamsField
+	for _, name := range slices.Sorted(maps.Keys(props)) {
+		if !slices.ContainsFunc(arguments, func(v PromptArgument) bool { return v.Name == name }) {
+			fields = append(fields, InvalidParamsField{Name: name, Reason: "is not declared"})
+		}
+	}
+	if len(fields) > 0 {
+		return &InvalidParamsError{Fields: fields}
+	}
+	return nil
 }
 
 func (c *promptContext) String(role PromptRole, text string) error {
@@ -484,7 +1397,7 @@ func (c *promptContext) JSON(role PromptRole, i any) error {
 	if err := role.Validate(); err != nil {
 		return err
 	}
-	b, err := c.jsonMarshalFunc(i)
+	b, err := safeMarshal(c.jsonMarshalFunc, i)
 	if err != nil {
 		return err
 	}
@@ -502,7 +1415,10 @@ func (c *promptContext) Image(role PromptRole, data []byte, mimeType string) err
 	if err := role.Validate(); err != nil {
 		return err
 	}
-	enc := c.base64StringFunc(data)
+	enc, err := encodeContent(c.contentEncoders, c.base64StringFunc, data, mimeType)
+	if err != nil {
+		return err
+	}
 	c.dest.Messages = append(c.dest.Messages, promptMessage{
 		Role: role.String(),
 		Content: &imagePromptContent{
@@ -532,6 +1448,8 @@ func (c *promptContext) reset() {
 	c._context.reset()
 	c.promptName = ""
 	c.args = nil
+	c.arguments = nil
+	c.strictArguments = false
 	c.dest = nil
 }
 
@@ -560,6 +1478,8 @@ type ResourceChangeSubscriber interface {
 	LastReceived() time.Time
 	// Publish publishes the resource change event
 	Publish(uri *url.URL)
+	// PublishDeleted publishes the resource deletion event. the subscription ends after it is notified.
+	PublishDeleted(uri *url.URL)
 }
 
 // compatibility check
@@ -572,6 +1492,13 @@ type resourceChangeSubscriber struct {
 	ch            chan *url.URL
 	mu            sync.RWMutex
 	nowFunc       func() time.Time
+	// minInterval is the minimum interval between the published changes. zero means no throttling.
+	minInterval   time.Duration
+	lastPublished time.Time
+	// deleted indicates the subscribed resource has been deleted.
+	deleted bool
+	// stopped is closed when the subscriber is replaced by the one of the same ID.
+	stopped chan struct{}
 }
 
 func (r *resourceChangeSubscriber) ID() string {
@@ -595,20 +1522,56 @@ func (r *resourceChangeSubscriber) LastReceived() time.Time {
 func (r *resourceChangeSubscriber) Publish(uri *url.URL) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.lastReceived = r.nowFunc()
+	now := r.nowFunc()
+	r.lastReceived = now
+	if r.minInterval > 0 && !r.lastPublished.IsZero() && now.Sub(r.lastPublished) < r.minInterval {
+		return
+	}
+	r.lastPublished = now
+	r.ch <- uri
+}
+
+func (r *resourceChangeSubscriber) PublishDeleted(uri *url.URL) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.nowFunc()
+	r.lastReceived = now
+	r.lastPublished = now
+	r.deleted = true
 	r.ch <- uri
 }
 
+// stop signals that the subscriber has been replaced by the one of the same ID.
+func (r *resourceChangeSubscriber) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped != nil {
+		close(r.stopped)
+		r.stopped = nil
+	}
+}
+
+// isDeleted reports whether the subscribed resource has been deleted.
+func (r *resourceChangeSubscriber) isDeleted() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.deleted
+}
+
 func (r *resourceChangeSubscriber) reset() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.id = ""
 	r.lastReceived = time.Time{}
+	r.minInterval = 0
+	r.lastPublished = time.Time{}
+	r.deleted = false
 	if r.ch != nil {
 		close(r.ch)
 	}
 	r.subscribedURI = nil
 	r.ch = nil
+	r.stopped = nil
 }
 
 // ResourceChangeContext is the context for resource change publish handlers.
@@ -618,8 +1581,12 @@ type ResourceChangeContext interface {
 
 	// Publish publishes the resource change event
 	Publish(uri *url.URL, modifiedAt time.Time)
-	subscribe(subscriber ResourceChangeSubscriber)
-	unsubscribe(id string)
+
+	// PublishDeleted publishes the resource deletion event.
+	// The subscribers are notified for the last time and unsubscribed, then the resource list change is published.
+	PublishDeleted(uri *url.URL, deletedAt time.Time)
+	subscribe(subscriber ResourceChangeSubscriber) (replaced bool)
+	unsubscribe(subscriber ResourceChangeSubscriber) bool
 }
 
 // compatibility check
@@ -629,6 +1596,10 @@ type resourceChangeContext struct {
 	ctx        context.Context
 	mu         sync.RWMutex
 	subscriber map[string]ResourceChangeSubscriber
+	// listChangeCtx publishes the resource list change on deletion. nil if not available.
+	listChangeCtx ResourceListChangeContext
+	// readCache is invalidated on the changes. nil if not available.
+	readCache *resourceReadCache
 }
 
 func (r *resourceChangeContext) Context() context.Context {
@@ -636,20 +1607,59 @@ func (r *resourceChangeContext) Context() context.Context {
 }
 
 func (r *resourceChangeContext) Publish(uri *url.URL, modifiedAt time.Time) {
+	r.readCache.invalidate(uri)
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	for _, subscriber := range r.subscriber {
-		if !uriMatches(uri, subscriber.SubscribedURI()) {
+		subscribedURI := subscriber.SubscribedURI()
+		if !uriMatches(uri, subscribedURI) {
 			continue
 		}
 		if subscriber.LastReceived().After(modifiedAt) {
 			continue
 		}
+		// a change published against a template is notified with the concrete URI the client subscribed to.
+		if isTemplateURI(uri) && !isTemplateURI(subscribedURI) {
+			subscriber.Publish(subscribedURI)
+			continue
+		}
 		subscriber.Publish(uri)
 	}
 }
 
-// uriMatches checks if the uri matches the subscribed URI
+func (r *resourceChangeContext) PublishDeleted(uri *url.URL, deletedAt time.Time) {
+	r.readCache.invalidate(uri)
+	r.mu.Lock()
+	deleted := make(map[ResourceChangeSubscriber]*url.URL)
+	for id, subscriber := range r.subscriber {
+		subscribedURI := subscriber.SubscribedURI()
+		if !uriMatches(uri, subscribedURI) {
+			continue
+		}
+		if subscriber.LastReceived().After(deletedAt) {
+			continue
+		}
+		deleted[subscriber] = uri
+		if isTemplateURI(uri) && !isTemplateURI(subscribedURI) {
+			deleted[subscriber] = subscribedURI
+		}
+		delete(r.subscriber, id)
+	}
+	r.mu.Unlock()
+
+	// notify outside the lock, as the subscribers unsubscribe themselves once notified.
+	for subscriber, v := range deleted {
+		subscriber.PublishDeleted(v)
+	}
+	if r.listChangeCtx != nil {
+		r.listChangeCtx.Publish(deletedAt)
+	}
+}
+
+// uriMatches checks if the uri matches the subscribed URI.
+//
+// Path parameters on either side match any segment,
+// so a concrete subscription matches a change published against a template and vice versa.
 func uriMatches(uri *url.URL, subscribedURI *url.URL) bool {
 	if uri == nil || subscribedURI == nil {
 		return false
@@ -660,13 +1670,13 @@ func uriMatches(uri *url.URL, subscribedURI *url.URL) bool {
 	if subscribedURI.Host != uri.Host {
 		return false
 	}
-	actualPath := strings.Split(uri.Path, "/")
-	subscribedPath := strings.Split(subscribedURI.Path, "/")
+	actualPath := resourcePathSegments(uri.Path)
+	subscribedPath := resourcePathSegments(subscribedURI.Path)
 	if len(actualPath) != len(subscribedPath) {
 		return false
 	}
-	for i, v := range strings.Split(subscribedURI.Path, "/") {
-		if strings.HasPrefix(v, "{") && strings.HasSuffix(v, "}") {
+	for i, v := range subscribedPath {
+		if isPathParam(v) || isPathParam(actualPath[i]) {
 			continue
 		}
 		if actualPath[i] != v {
@@ -676,19 +1686,34 @@ func uriMatches(uri *url.URL, subscribedURI *url.URL) bool {
 	return true
 }
 
-func (r *resourceChangeContext) subscribe(subscriber ResourceChangeSubscriber) {
+// subscribe adds the subscriber.
+// If the subscriber with the same ID is already subscribed, it is stopped and replaced, and subscribe reports true.
+func (r *resourceChangeContext) subscribe(subscriber ResourceChangeSubscriber) (replaced bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	if r.subscriber == nil {
 		r.subscriber = make(map[string]ResourceChangeSubscriber)
 	}
+	existing, ok := r.subscriber[subscriber.ID()]
+	if ok {
+		if v, ok := existing.(*resourceChangeSubscriber); ok {
+			v.stop()
+		}
+	}
 	r.subscriber[subscriber.ID()] = subscriber
+	return ok
 }
 
-func (r *resourceChangeContext) unsubscribe(id string) {
+// unsubscribe removes the subscriber.
+// It reports false if the subscriber is no longer subscribed, such as when it has been replaced.
+func (r *resourceChangeContext) unsubscribe(subscriber ResourceChangeSubscriber) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	delete(r.subscriber, id)
+	if r.subscriber[subscriber.ID()] != subscriber {
+		return false
+	}
+	delete(r.subscriber, subscriber.ID())
+	return true
 }
 
 // ResourceListChangeSubscriber sunscribe resource list changes
@@ -757,6 +1782,7 @@ type resourceListChangeContext struct {
 	ctx        context.Context
 	mu         sync.RWMutex
 	subscriber map[string]ResourceListChangeSubscriber
+	cache      *resourceListCache
 }
 
 func (r *resourceListChangeContext) Context() context.Context {
@@ -764,6 +1790,7 @@ func (r *resourceListChangeContext) Context() context.Context {
 }
 
 func (r *resourceListChangeContext) Publish(modifiedAt time.Time) {
+	r.cache.invalidate()
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	for _, subscriber := range r.subscriber {
@@ -788,3 +1815,52 @@ func (r *resourceListChangeContext) unsubscribe(id string) {
 	defer r.mu.Unlock()
 	delete(r.subscriber, id)
 }
+
+// unsubscribeSubscriber unsubscribes the subscriber, unless another subscriber of the same ID took it over.
+func (r *resourceListChangeContext) unsubscribeSubscriber(subscriber ResourceListChangeSubscriber) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.subscriber[subscriber.ID()] == subscriber {
+		delete(r.subscriber, subscriber.ID())
+	}
+}
+
+// safeMarshal marshals v with the marshal func, converting a panic of it into ErrEncoderPanicked.
+func safeMarshal(marshal JSONMarshalFunc, v any) (b []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrEncoderPanicked, r)
+		}
+	}()
+	return marshal(v)
+}
+
+// encodeContent encodes the binary data of the content with the encoder registered for the mime type,
+// falling back to the base64 func. A panic of the encoder is converted into ErrEncoderPanicked.
+func encodeContent(
+	encoders map[string]ContentEncoderFunc,
+	base64String Base64StringFunc,
+	data []byte,
+	mimeType string,
+) (s string, err error) {
+	encoder, ok := encoders[mimeType]
+	if !ok {
+		return safeBase64String(base64String, data)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrEncoderPanicked, r)
+		}
+	}()
+	return encoder(data)
+}
+
+// safeBase64String encodes data with the base64 func, converting a panic of it into ErrEncoderPanicked.
+func safeBase64String(base64String Base64StringFunc, data []byte) (s string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrEncoderPanicked, r)
+		}
+	}()
+	return base64String(data), nil
+}
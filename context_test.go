@@ -7,12 +7,18 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 	"weak"
 
+	"github.com/invopop/jsonschema"
 	"golang.org/x/exp/jsonrpc2"
 )
 
@@ -99,6 +105,33 @@ func Test_context_SetContext(t *testing.T) {
 	})
 }
 
+func Test_context_ConnectionDone(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		connectionCtx, cancel := context.WithCancel(t.Context())
+		c := &_context{
+			connectionCtx: connectionCtx,
+		}
+		done := c.ConnectionDone()
+		select {
+		case <-done:
+			t.Fatalf("expected the channel to be open")
+		default:
+		}
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("expected the channel to be closed")
+		}
+	})
+	t.Run("no connection", func(t *testing.T) {
+		c := &_context{}
+		if got := c.ConnectionDone(); got != nil {
+			t.Fatalf("expected nil, got %v", got)
+		}
+	})
+}
+
 func Test_context_reset(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		c := &_context{
@@ -108,6 +141,9 @@ func Test_context_reset(t *testing.T) {
 				Method: "method",
 				Params: json.RawMessage(`{"x": 1.5, "y": 2.5}`),
 			},
+			principal:         "alice",
+			connectionCtx:     t.Context(),
+			transport:         TransportKindStdio,
 			jsonMarshalFunc:   json.Marshal,
 			jsonUnmarshalFunc: json.Unmarshal,
 		}
@@ -119,6 +155,15 @@ func Test_context_reset(t *testing.T) {
 		if c.jsonrpcRequest != nil {
 			t.Fatalf("expected nil jsonrpcRequest, got %v", c.jsonrpcRequest)
 		}
+		if c.Principal() != nil {
+			t.Fatalf("expected nil principal, got %v", c.Principal())
+		}
+		if c.connectionCtx != nil {
+			t.Fatalf("expected nil connection context, got %v", c.connectionCtx)
+		}
+		if c.Transport() != TransportKindUnknown {
+			t.Fatalf("expected unknown transport, got %v", c.Transport())
+		}
 		if c.jsonMarshalFunc == nil {
 			t.Fatalf("expected jsonMarshalFunc  to be not null, but null.")
 		}
@@ -189,6 +234,60 @@ func TestToolContext_Arguments(t *testing.T) {
 }
 
 func TestToolContext_Bind(t *testing.T) {
+	t.Run("string-encoded number", func(t *testing.T) {
+		type Req struct {
+			X float64 `json:"x"`
+		}
+		c := newToolContext(json.Unmarshal, nil, nil)
+		c.args = json.RawMessage(`{"x":"1.5"}`)
+		var req Req
+		if err := c.Bind(&req); err == nil {
+			t.Fatalf("expected an error without lenient binding")
+		}
+	})
+	t.Run("string-encoded number with lenient binding", func(t *testing.T) {
+		type Req struct {
+			X float64 `json:"x"`
+		}
+		c := newToolContext(json.Unmarshal, nil, nil)
+		c.lenientBinding = true
+		c.args = json.RawMessage(`{"x":"1.5"}`)
+		var req Req
+		if err := c.Bind(&req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if req.X != 1.5 {
+			t.Fatalf("expected x=1.5, got x=%v", req.X)
+		}
+	})
+	t.Run("lenient binding keeps strings", func(t *testing.T) {
+		type Item struct {
+			Count int  `json:"count"`
+			Gift  bool `json:"gift"`
+		}
+		type Req struct {
+			Code  string  `json:"code"`
+			Limit *int    `json:"limit"`
+			Items []Item  `json:"items"`
+			Note  *string `json:"note"`
+		}
+		c := newToolContext(json.Unmarshal, nil, nil)
+		c.lenientBinding = true
+		c.args = json.RawMessage(`{"code":"007","limit":"10","items":[{"count":"2","gift":"true"}],"note":"1"}`)
+		var req Req
+		if err := c.Bind(&req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if req.Code != "007" || req.Note == nil || *req.Note != "1" {
+			t.Fatalf("expected the strings to be kept, got code=%v, note=%v", req.Code, req.Note)
+		}
+		if req.Limit == nil || *req.Limit != 10 {
+			t.Fatalf("expected limit=10, got %v", req.Limit)
+		}
+		if len(req.Items) != 1 || req.Items[0].Count != 2 || !req.Items[0].Gift {
+			t.Fatalf("expected items=[{2 true}], got %v", req.Items)
+		}
+	})
 	t.Run("happy path", func(t *testing.T) {
 		type Req struct {
 			X float64 `json:"x" jsonschema:"title=X"`
@@ -220,6 +319,97 @@ func TestToolContext_Bind(t *testing.T) {
 			t.Fatalf("expected x=0, y=0, got x=%v, y=%v", req.X, req.Y)
 		}
 	})
+	t.Run("unmarshal only once", func(t *testing.T) {
+		type Req struct {
+			X float64 `json:"x" jsonschema:"title=X"`
+			Y float64 `json:"y" jsonschema:"title=Y"`
+		}
+		var calls int
+		c := newToolContext(func(data []byte, v any) error {
+			calls++
+			return json.Unmarshal(data, v)
+		}, nil, nil)
+		c.args = json.RawMessage(`{"x": 1.5, "y": 2.5}`)
+		var inMiddleware, inHandler Req
+		if err := c.Bind(&inMiddleware); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := c.Bind(&inHandler); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if calls != 1 {
+			t.Fatalf("expected unmarshal to be called once, got %d", calls)
+		}
+		if inHandler.X != 1.5 || inHandler.Y != 2.5 {
+			t.Fatalf("expected x=1.5, y=2.5, got x=%v, y=%v", inHandler.X, inHandler.Y)
+		}
+	})
+	t.Run("not sharing the references", func(t *testing.T) {
+		type Req struct {
+			Tags   []string          `json:"tags"`
+			Labels map[string]string `json:"labels"`
+			Unit   *string           `json:"unit"`
+		}
+		c := newToolContext(json.Unmarshal, nil, nil)
+		c.args = json.RawMessage(`{"tags": ["a"], "labels": {"k": "v"}, "unit": "c"}`)
+		var inMiddleware, inHandler Req
+		if err := c.Bind(&inMiddleware); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		inMiddleware.Tags[0] = "changed"
+		inMiddleware.Labels["k"] = "changed"
+		*inMiddleware.Unit = "changed"
+		if err := c.Bind(&inHandler); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if inHandler.Tags[0] != "a" || inHandler.Labels["k"] != "v" || *inHandler.Unit != "c" {
+			t.Fatalf("expected the values decoded from the arguments, got %+v", inHandler)
+		}
+	})
+	t.Run("keep the defaults", func(t *testing.T) {
+		type Req struct {
+			X    float64 `json:"x"`
+			Unit string  `json:"unit"`
+		}
+		c := newToolContext(json.Unmarshal, nil, nil)
+		c.args = json.RawMessage(`{"x": 1.5}`)
+		var inMiddleware Req
+		if err := c.Bind(&inMiddleware); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		inHandler := Req{Unit: "celsius"}
+		if err := c.Bind(&inHandler); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if inHandler.X != 1.5 || inHandler.Unit != "celsius" {
+			t.Fatalf("expected x=1.5, unit=celsius, got x=%v, unit=%v", inHandler.X, inHandler.Unit)
+		}
+	})
+	t.Run("different types", func(t *testing.T) {
+		type Req struct {
+			X float64 `json:"x" jsonschema:"title=X"`
+		}
+		var calls int
+		c := newToolContext(func(data []byte, v any) error {
+			calls++
+			return json.Unmarshal(data, v)
+		}, nil, nil)
+		c.args = json.RawMessage(`{"x": 1.5, "y": 2.5}`)
+		var req Req
+		if err := c.Bind(&req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var raw map[string]any
+		if err := c.Bind(&raw); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if calls != 2 {
+			t.Fatalf("expected unmarshal to be called twice, got %d", calls)
+		}
+		if raw["y"] != 2.5 {
+			t.Fatalf("expected y=2.5, got y=%v", raw["y"])
+		}
+	})
 }
 
 func TestToolContext_String(t *testing.T) {
@@ -284,6 +474,36 @@ func TestToolContext_JSON(t *testing.T) {
 	})
 }
 
+func TestToolContext_Raw(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		var dest CallToolContent
+		c := newToolContext(nil, json.Marshal, nil)
+		c.dest = &dest
+		raw := json.RawMessage(`{"z": 1, "a": [1, 2], "m": {"y": null, "b": "\u00e9"}}`)
+		if err := c.Raw(raw); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		v, ok := (dest).(*textCallToolContent)
+		if !ok {
+			t.Fatalf("expected *textCallToolContent, got %T", dest)
+		}
+		if v.Text != string(raw) {
+			t.Fatalf("expected `%s`, got %v", raw, v.Text)
+		}
+	})
+	t.Run("invalid JSON", func(t *testing.T) {
+		var dest CallToolContent
+		c := newToolContext(nil, json.Marshal, nil)
+		c.dest = &dest
+		if err := c.Raw(json.RawMessage(`{"z": `)); !errors.Is(err, ErrInvalidRawJSON) {
+			t.Fatalf("expected error %v, got %v", ErrInvalidRawJSON, err)
+		}
+		if dest != nil {
+			t.Fatalf("expected no content, got %v", dest)
+		}
+	})
+}
+
 func TestToolContext_Audio(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		var dest CallToolContent
@@ -307,6 +527,69 @@ func TestToolContext_Audio(t *testing.T) {
 			t.Fatalf("expected '%s', got %v", mime, v.MimeType)
 		}
 	})
+	t.Run("not supported by the negotiated protocol version", func(t *testing.T) {
+		var dest CallToolContent
+		c := newToolContext(nil, nil, func(data []byte) string {
+			return "test"
+		})
+		c.dest = &dest
+		c.protocolVersion = ProtocolVersion20241105
+		if err := c.Audio(nil, "audio/wav"); !errors.Is(err, ErrAudioNotSupported) {
+			t.Fatalf("expected ErrAudioNotSupported, got %v", err)
+		}
+		if dest != nil {
+			t.Fatalf("expected no content, got %T", dest)
+		}
+	})
+	t.Run("downgraded to an embedded resource", func(t *testing.T) {
+		var dest CallToolContent
+		c := newToolContext(nil, nil, func(data []byte) string {
+			return "test"
+		})
+		c.dest = &dest
+		c.toolName = "speak"
+		c.protocolVersion = ProtocolVersion20241105
+		c.audioDowngrade = func(c ToolContext) *url.URL {
+			return &url.URL{Scheme: "recordings", Host: "greeting.wav"}
+		}
+		if err := c.Audio([]byte("audio"), "audio/wav"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		v, ok := (dest).(*embedResourceCallToolContent)
+		if !ok {
+			t.Fatalf("expected *embedResourceCallToolContent, got %T", dest)
+		}
+		resource, ok := v.Resource.(*binaryResourceContent)
+		if !ok {
+			t.Fatalf("expected *binaryResourceContent, got %T", v.Resource)
+		}
+		if resource.blob != "test" {
+			t.Fatalf("expected 'test', got %v", resource.blob)
+		}
+		if got := resource.resourceURI().String(); got != "recordings://greeting.wav" {
+			t.Fatalf("expected 'recordings://greeting.wav', got %v", got)
+		}
+		if resource.mimeType != "audio/wav" {
+			t.Fatalf("expected 'audio/wav', got %v", resource.mimeType)
+		}
+	})
+	t.Run("downgraded without the uri", func(t *testing.T) {
+		var dest CallToolContent
+		c := newToolContext(nil, nil, func(data []byte) string {
+			return "test"
+		})
+		c.dest = &dest
+		c.protocolVersion = ProtocolVersion20241105
+		c.audioDowngrade = func(c ToolContext) *url.URL {
+			return nil
+		}
+		if err := c.Audio([]byte("audio"), "audio/wav"); !errors.Is(err, ErrInvalidResourceURI) {
+			t.Fatalf("expected ErrInvalidResourceURI, got %v", err)
+		}
+		if dest != nil {
+			t.Fatalf("expected no content, got %T", dest)
+		}
+	})
 }
 
 func TestToolContext_Image(t *testing.T) {
@@ -351,8 +634,8 @@ func TestToolContext_StringResource(t *testing.T) {
 		if !ok {
 			t.Fatalf("expected *textResourceContent, got %T", v.Resource)
 		}
-		if !reflect.DeepEqual(resource.uri.Value(), uri) {
-			t.Fatalf("expected '%v', got %v", uri, resource.uri.Value())
+		if !reflect.DeepEqual(resource.GetURI(), uri) {
+			t.Fatalf("expected '%v', got %v", uri, resource.GetURI())
 		}
 		if resource.mimeType != "text/plain" {
 			t.Fatalf("expected 'text/plain', got %v", resource.mimeType)
@@ -383,6 +666,59 @@ func TestToolContext_StringResource(t *testing.T) {
 	})
 }
 
+func TestToolContext_embedResourceOutlivesCallerURI(t *testing.T) {
+	type test struct {
+		send   func(c *toolContext, uri *url.URL) error
+		expect string
+	}
+	tests := map[string]test{
+		"string resource": {
+			send: func(c *toolContext, uri *url.URL) error {
+				return c.StringResource(uri, "test", "")
+			},
+			expect: `{"type":"resource","resource":{"uri":"example://example.com/gc","mimeType":"text/plain","text":"test"}}`,
+		},
+		"json resource": {
+			send: func(c *toolContext, uri *url.URL) error {
+				return c.JSONResource(uri, map[string]string{"key": "value"}, "")
+			},
+			expect: `{"type":"resource","resource":{"uri":"example://example.com/gc","mimeType":"application/json","text":"{\"key\":\"value\"}"}}`,
+		},
+		"binary resource": {
+			send: func(c *toolContext, uri *url.URL) error {
+				return c.BinaryResource(uri, []byte("test"), "")
+			},
+			expect: `{"type":"resource","resource":{"uri":"example://example.com/gc","mimeType":"application/octet-stream","blob":"dGVzdA==","_meta":{"size":4}}}`,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var dest CallToolContent
+			c := newToolContext(json.Unmarshal, json.Marshal, base64.StdEncoding.EncodeToString)
+			c.dest = &dest
+			func() {
+				// the caller's reference is dropped when this function returns
+				uri, err := url.Parse("example://example.com/gc")
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if err := tc.send(c, uri); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}()
+			runtime.GC()
+			runtime.GC()
+			b, err := json.Marshal(dest)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != tc.expect {
+				t.Fatalf("expected %s, got %s", tc.expect, b)
+			}
+		})
+	}
+}
+
 func TestToolContext_JSONResource(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		var dest CallToolContent
@@ -506,10 +842,17 @@ func TestToolContext_reset(t *testing.T) {
 		c.toolName = "test"
 		c.args = json.RawMessage(`{"x": 1.5, "y": 2.5}`)
 		c.dest = &dest
-		c.annotation = &ToolAnnotations{}
+		c.annotation = &ContentAnnotations{}
 		c.jsonrpcRequest = &jsonrpc2.Request{}
 		c.store.Store("key", "value")
+		var req map[string]any
+		if err := c.Bind(&req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
 		c.reset()
+		if len(c.boundArgs) != 0 {
+			t.Fatalf("expected empty bound args, got %v", c.boundArgs)
+		}
 		if c.toolName != "" {
 			t.Fatalf("expected empty string, got %v", c.toolName)
 		}
@@ -541,6 +884,148 @@ func TestToolContext_reset(t *testing.T) {
 	})
 }
 
+func TestToolContext_Table(t *testing.T) {
+	var dest CallToolContent
+	c := newToolContext(nil, json.Marshal, nil)
+	c.dest = &dest
+	headers := []string{"city", "forecast"}
+	rows := [][]string{
+		{"Tokyo", "sunny"},
+		{"Osaka", "rain | wind"},
+		{"Nagoya"},
+	}
+	if err := c.Table(headers, rows); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := dest.GetType(); got != "text" {
+		t.Fatalf("expected 'text', got %v", got)
+	}
+	b, err := json.Marshal(dest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		StructuredContent struct {
+			Headers []string   `json:"headers"`
+			Rows    [][]string `json:"rows"`
+		} `json:"structuredContent"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantText := "| city | forecast |\n" +
+		"| --- | --- |\n" +
+		"| Tokyo | sunny |\n" +
+		"| Osaka | rain \\| wind |\n" +
+		"| Nagoya |  |\n"
+	if len(got.Content) != 1 || got.Content[0].Type != "text" {
+		t.Fatalf("expected a text content, got %s", b)
+	}
+	if got.Content[0].Text != wantText {
+		t.Fatalf("expected %q, got %q", wantText, got.Content[0].Text)
+	}
+	if !reflect.DeepEqual(got.StructuredContent.Headers, headers) {
+		t.Fatalf("expected %v, got %v", headers, got.StructuredContent.Headers)
+	}
+	if !reflect.DeepEqual(got.StructuredContent.Rows, rows) {
+		t.Fatalf("expected %v, got %v", rows, got.StructuredContent.Rows)
+	}
+}
+
+func TestToolContext_ResourceLink(t *testing.T) {
+	type test struct {
+		description string
+		mimeType    string
+		expect      string
+	}
+	tests := map[string]test{
+		"happy path": {
+			description: "Full-resolution satellite image",
+			mimeType:    "image/tiff",
+			expect:      `{"type":"resource_link","uri":"weather://satellite/tokyo","name":"satellite","description":"Full-resolution satellite image","mimeType":"image/tiff"}`,
+		},
+		"without description and mime type": {
+			expect: `{"type":"resource_link","uri":"weather://satellite/tokyo","name":"satellite"}`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var dest CallToolContent
+			c := newToolContext(nil, json.Marshal, nil)
+			c.dest = &dest
+			uri := MustURL(t, "weather://satellite/tokyo")
+			if err := c.ResourceLink(uri, "satellite", tt.description, tt.mimeType); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := dest.GetType(); got != "resource_link" {
+				t.Fatalf("expected 'resource_link', got %v", got)
+			}
+			b, err := json.Marshal(dest)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != tt.expect {
+				t.Fatalf("expected %s, got %s", tt.expect, b)
+			}
+		})
+	}
+}
+
+func TestToolContext_ResourceLink_nilURI(t *testing.T) {
+	t.Run("ResourceLink", func(t *testing.T) {
+		var dest CallToolContent
+		c := newToolContext(nil, json.Marshal, nil)
+		c.dest = &dest
+		if err := c.ResourceLink(nil, "satellite", "", ""); !errors.Is(err, ErrInvalidResourceURI) {
+			t.Fatalf("expected ErrInvalidResourceURI, got %v", err)
+		}
+		if dest != nil {
+			t.Fatalf("expected no content, got %v", dest)
+		}
+	})
+	t.Run("ResourceLinkContent", func(t *testing.T) {
+		var dest CallToolContent
+		c := newToolContext(nil, json.Marshal, nil)
+		c.dest = &dest
+		if err := c.Fail(TextContent("see the satellite image"), ResourceLinkContent(nil, "satellite", "", "")); !errors.Is(err, ErrInvalidResourceURI) {
+			t.Fatalf("expected ErrInvalidResourceURI, got %v", err)
+		}
+		if dest != nil {
+			t.Fatalf("expected no content, got %v", dest)
+		}
+	})
+}
+
+func TestToolContext_Fail_sharedContents(t *testing.T) {
+	// the contents shared between the calls, such as the package-level vars, are left as is.
+	remediation := TextContent("the deploy key has expired")
+	link := ResourceLinkContent(&url.URL{Scheme: "docs", Host: "rotate-keys"}, "rotate-keys", "", "")
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var dest CallToolContent
+			c := newToolContext(nil, json.Marshal, nil)
+			c.dest = &dest
+			if err := c.Fail(remediation, link); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if remediation.(*textCallToolContent).marshal != nil {
+		t.Fatal("expected the text content not to be modified")
+	}
+	if link.(*resourceLinkCallToolContent).marshal != nil {
+		t.Fatal("expected the resource link content not to be modified")
+	}
+}
+
 func TestResourceContext_ResourceURI(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		uri := MustURL(t, "example://example.com")
@@ -591,6 +1076,59 @@ func TestResourceContext_Param(t *testing.T) {
 	})
 }
 
+func TestResourceContext_BindParams(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		type Params struct {
+			Region string `param:"region"`
+			City   string `param:"city"`
+		}
+		c := newResourceContext(nil, nil, nil)
+		c.pathParams = map[string]string{"region": "kanto", "city": "tokyo"}
+		var params Params
+		if err := c.BindParams(&params); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if params.Region != "kanto" || params.City != "tokyo" {
+			t.Fatalf("expected region=kanto, city=tokyo, got region=%v, city=%v", params.Region, params.City)
+		}
+	})
+	t.Run("numeric params", func(t *testing.T) {
+		type Params struct {
+			ID    int     `param:"id"`
+			Ratio float64 `param:"ratio"`
+			Other string
+		}
+		c := newResourceContext(nil, nil, nil)
+		c.pathParams = map[string]string{"id": "123", "ratio": "0.5"}
+		var params Params
+		if err := c.BindParams(&params); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if params.ID != 123 || params.Ratio != 0.5 || params.Other != "" {
+			t.Fatalf("expected id=123, ratio=0.5, other='', got %+v", params)
+		}
+	})
+	t.Run("invalid value", func(t *testing.T) {
+		type Params struct {
+			ID int `param:"id"`
+		}
+		c := newResourceContext(nil, nil, nil)
+		c.pathParams = map[string]string{"id": "abc"}
+		var params Params
+		if err := c.BindParams(&params); err == nil {
+			t.Fatalf("expected error, got nil")
+		}
+	})
+	t.Run("not a pointer to struct", func(t *testing.T) {
+		c := newResourceContext(nil, nil, nil)
+		c.pathParams = map[string]string{"id": "123"}
+		var params map[string]string
+		if err := c.BindParams(params); !errors.Is(err, ErrInvalidBindParamsTarget) {
+			t.Fatalf("expected error %v, got %v", ErrInvalidBindParamsTarget, err)
+		}
+	})
+}
+
 func TestResourceContext_String(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		c := newResourceContext(nil, nil, nil)
@@ -676,65 +1214,321 @@ func TestResourceContext_JSON(t *testing.T) {
 		if v.mimeType != mime {
 			t.Fatalf("expected '%s', got %v", mime, v.mimeType)
 		}
-	})
-	t.Run("unmarshal error", func(t *testing.T) {
-		type Res struct {
-			Result float64 `json:"result"`
+	})
+	t.Run("unmarshal error", func(t *testing.T) {
+		type Res struct {
+			Result float64 `json:"result"`
+		}
+		errTest := fmt.Errorf("test error")
+		c := newResourceContext(nil, func(v interface{}) ([]byte, error) {
+			return nil, errTest
+		}, nil)
+		c.dest = &readResourceResult{}
+		res := Res{Result: 3.0}
+		if err := c.JSON(res); !errors.Is(err, errTest) {
+			t.Fatalf("expected error %v, got %v", errTest, err)
+		}
+	})
+}
+
+func TestResourceContext_Raw(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		c := newResourceContext(nil, json.Marshal, nil)
+		c.dest = &readResourceResult{}
+		raw := json.RawMessage(`{"z": 1, "a": [1, 2], "m": {"y": null, "b": "\u00e9"}}`)
+		if err := c.Raw(raw); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(c.dest.Contents) != 1 {
+			t.Fatalf("expected 1 content, got %d", len(c.dest.Contents))
+		}
+		v, ok := (c.dest.Contents[0]).(textResourceContent)
+		if !ok {
+			t.Fatalf("expected textResourceContent, got %T", c.dest.Contents[0])
+		}
+		if v.text != string(raw) {
+			t.Fatalf("expected `%s`, got %v", raw, v.text)
+		}
+		if v.mimeType != "application/json" {
+			t.Fatalf("expected 'application/json', got %v", v.mimeType)
+		}
+	})
+	t.Run("invalid JSON", func(t *testing.T) {
+		c := newResourceContext(nil, json.Marshal, nil)
+		c.dest = &readResourceResult{}
+		if err := c.Raw(json.RawMessage(`not json`)); !errors.Is(err, ErrInvalidRawJSON) {
+			t.Fatalf("expected error %v, got %v", ErrInvalidRawJSON, err)
+		}
+		if len(c.dest.Contents) != 0 {
+			t.Fatalf("expected no content, got %d", len(c.dest.Contents))
+		}
+	})
+}
+
+func TestResourceContext_JSONWithSchema(t *testing.T) {
+	type Forecast struct {
+		City        string  `json:"city"`
+		Temperature float64 `json:"temperature"`
+	}
+	declared := &jsonschema.Schema{Type: "object", Title: "forecast"}
+	type test struct {
+		schema *jsonschema.Schema
+		expect string
+	}
+	tests := map[string]test{
+		"declared schema": {
+			schema: declared,
+			expect: `{"uri":"weather://forecast/paris","mimeType":"application/json","text":"{\"city\":\"paris\",\"temperature\":21.5}","_meta":{"schema":{"type":"object","title":"forecast"}}}`,
+		},
+		"reflected schema": {
+			expect: `{"uri":"weather://forecast/paris","mimeType":"application/json","text":"{\"city\":\"paris\",\"temperature\":21.5}","_meta":{"schema":{"properties":{"city":{"type":"string"},"temperature":{"type":"number"}},"additionalProperties":false,"type":"object","required":["city","temperature"]}}}`,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			uri := MustURL(t, "weather://forecast/paris")
+			c := newResourceContext(nil, json.Marshal, nil)
+			c.uri = weak.Make(uri)
+			c.dest = &readResourceResult{}
+			if err := c.JSONWithSchema(Forecast{City: "paris", Temperature: 21.5}, tc.schema); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(c.dest.Contents) != 1 {
+				t.Fatalf("expected 1 content, got %d", len(c.dest.Contents))
+			}
+			b, err := json.Marshal(c.dest.Contents[0])
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != tc.expect {
+				t.Fatalf("expected %s, got %s", tc.expect, b)
+			}
+		})
+	}
+	t.Run("JSON without schema", func(t *testing.T) {
+		c := newResourceContext(nil, json.Marshal, nil)
+		c.dest = &readResourceResult{}
+		if err := c.JSON(Forecast{City: "paris"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b, err := json.Marshal(c.dest.Contents[0])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Contains(string(b), "_meta") {
+			t.Fatalf("expected no schema, got %s", b)
+		}
+	})
+}
+
+func TestResourceContext_Blob(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		c := newResourceContext(nil, nil, func(data []byte) string {
+			return "test"
+		})
+		c.dest = &readResourceResult{}
+		var blob []byte
+		mime := "application/octet-stream"
+		if err := c.Blob(blob, ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		v, ok := (c.dest.Contents[0]).(binaryResourceContent)
+		if !ok {
+			t.Fatalf("expected binaryResourceContent, got %T", c.dest.Contents[0])
+		}
+		if v.mimeType != mime {
+			t.Fatalf("expected '%s', got %v", mime, v.mimeType)
+		}
+		if v.blob != "test" {
+			t.Fatalf("expected 'test', got %v", v.blob)
+		}
+	})
+	t.Run("mime type set in field", func(t *testing.T) {
+		c := newResourceContext(nil, nil, func(data []byte) string {
+			return "test"
+		})
+		c.dest = &readResourceResult{}
+		c.mimeType = "application/pdf"
+		var blob []byte
+		mime := "application/pdf"
+		if err := c.Blob(blob, ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		v, ok := (c.dest.Contents[0]).(binaryResourceContent)
+		if !ok {
+			t.Fatalf("expected binaryResourceContent, got %T", c.dest.Contents[0])
+		}
+		if v.mimeType != mime {
+			t.Fatalf("expected '%s', got %v", mime, v.mimeType)
+		}
+	})
+	t.Run("full read", func(t *testing.T) {
+		c := newResourceContext(nil, nil, func(data []byte) string {
+			return string(data)
+		})
+		c.dest = &readResourceResult{}
+		if err := c.Blob([]byte("0123456789"), ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		v, ok := (c.dest.Contents[0]).(binaryResourceContent)
+		if !ok {
+			t.Fatalf("expected binaryResourceContent, got %T", c.dest.Contents[0])
+		}
+		if v.blob != "0123456789" {
+			t.Fatalf("expected '0123456789', got %v", v.blob)
+		}
+		if v.size != 10 {
+			t.Fatalf("expected 10, got %v", v.size)
+		}
+		if v.blobRange != nil {
+			t.Fatalf("expected nil, got %v", v.blobRange)
+		}
+	})
+	t.Run("ranged read", func(t *testing.T) {
+		c := newResourceContext(nil, nil, func(data []byte) string {
+			return string(data)
+		})
+		c.dest = &readResourceResult{}
+		c.blobRange = &BlobRange{Start: 2, End: 5}
+		if err := c.Blob([]byte("0123456789"), ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		v, ok := (c.dest.Contents[0]).(binaryResourceContent)
+		if !ok {
+			t.Fatalf("expected binaryResourceContent, got %T", c.dest.Contents[0])
+		}
+		if v.blob != "234" {
+			t.Fatalf("expected '234', got %v", v.blob)
+		}
+		if v.size != 10 {
+			t.Fatalf("expected 10, got %v", v.size)
+		}
+		if *v.blobRange != (BlobRange{Start: 2, End: 5}) {
+			t.Fatalf("expected {2 5}, got %v", v.blobRange)
+		}
+	})
+	t.Run("ranged read beyond the end", func(t *testing.T) {
+		c := newResourceContext(nil, nil, func(data []byte) string {
+			return string(data)
+		})
+		c.dest = &readResourceResult{}
+		c.blobRange = &BlobRange{Start: 8}
+		if err := c.Blob([]byte("0123456789"), ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		v := (c.dest.Contents[0]).(binaryResourceContent)
+		if v.blob != "89" {
+			t.Fatalf("expected '89', got %v", v.blob)
+		}
+		if *v.blobRange != (BlobRange{Start: 8, End: 10}) {
+			t.Fatalf("expected {8 10}, got %v", v.blobRange)
+		}
+	})
+}
+
+func TestResourceContext_PartialBlob(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		c := newResourceContext(nil, json.Marshal, func(data []byte) string {
+			return string(data)
+		})
+		c.uri = weak.Make(MustURL(t, "example://example.com"))
+		c.dest = &readResourceResult{}
+		c.blobRange = &BlobRange{Start: 2, End: 5}
+		if err := c.PartialBlob([]byte("234"), 10, ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b, err := c.dest.Contents[0].MarshalJSON()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expect := `{"uri":"example://example.com","mimeType":"application/octet-stream","blob":"234","_meta":{"size":10,"range":{"start":2,"end":5}}}`
+		if string(b) != expect {
+			t.Fatalf("expected %s, got %s", expect, b)
+		}
+	})
+}
+
+type customResourceContent struct {
+	uri *url.URL
+}
+
+func (c customResourceContent) GetURI() *url.URL {
+	return c.uri
+}
+
+func (c customResourceContent) GetMimeType() string {
+	return "text/markdown"
+}
+
+func (c customResourceContent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"uri":      c.uri.String(),
+		"mimeType": "text/markdown",
+		"text":     "# custom",
+	})
+}
+
+func TestResourceContext_Add(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		c := newResourceContext(nil, nil, nil)
+		c.dest = &readResourceResult{}
+		uri := MustURL(t, "example://example.com/readme")
+		got := c.Add(customResourceContent{uri: uri}).Add(customResourceContent{uri: uri})
+		if got != c {
+			t.Fatalf("expected the context itself, got %v", got)
+		}
+		if len(c.dest.Contents) != 2 {
+			t.Fatalf("expected 2 contents, got %v", len(c.dest.Contents))
+		}
+		if c.dest.Contents[0].GetURI() != uri {
+			t.Fatalf("expected %v, got %v", uri, c.dest.Contents[0].GetURI())
 		}
-		errTest := fmt.Errorf("test error")
-		c := newResourceContext(nil, func(v interface{}) ([]byte, error) {
-			return nil, errTest
-		}, nil)
+	})
+	t.Run("nil content", func(t *testing.T) {
+		c := newResourceContext(nil, nil, nil)
 		c.dest = &readResourceResult{}
-		res := Res{Result: 3.0}
-		if err := c.JSON(res); !errors.Is(err, errTest) {
-			t.Fatalf("expected error %v, got %v", errTest, err)
+		c.Add(nil)
+		if len(c.dest.Contents) != 0 {
+			t.Fatalf("expected no contents, got %v", c.dest.Contents)
 		}
 	})
 }
 
-func TestResourceContext_Blob(t *testing.T) {
+func TestResourceContext_multipleContents(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
-		c := newResourceContext(nil, nil, func(data []byte) string {
-			return "test"
-		})
+		c := newResourceContext(json.Unmarshal, json.Marshal, base64.StdEncoding.EncodeToString)
+		uri := MustURL(t, "example://example.com/report")
+		c.uri = weak.Make(uri)
 		c.dest = &readResourceResult{}
-		var blob []byte
-		mime := "application/octet-stream"
-		if err := c.Blob(blob, ""); err != nil {
+		if err := c.String("summary"); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
-		v, ok := (c.dest.Contents[0]).(binaryResourceContent)
-		if !ok {
-			t.Fatalf("expected binaryResourceContent, got %T", c.dest.Contents[0])
-		}
-		if v.mimeType != mime {
-			t.Fatalf("expected '%s', got %v", mime, v.mimeType)
-		}
-		if v.blob != "test" {
-			t.Fatalf("expected 'test', got %v", v.blob)
+		if err := c.JSON(map[string]int{"total": 1}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
 		}
-	})
-	t.Run("mime type set in field", func(t *testing.T) {
-		c := newResourceContext(nil, nil, func(data []byte) string {
-			return "test"
-		})
-		c.dest = &readResourceResult{}
-		c.mimeType = "application/pdf"
-		var blob []byte
-		mime := "application/pdf"
-		if err := c.Blob(blob, ""); err != nil {
+		if err := c.Blob([]byte("raw"), "image/png"); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
-		v, ok := (c.dest.Contents[0]).(binaryResourceContent)
-		if !ok {
-			t.Fatalf("expected binaryResourceContent, got %T", c.dest.Contents[0])
+		c.Add(customResourceContent{uri: uri})
+		b, err := json.Marshal(c.dest)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
 		}
-		if v.mimeType != mime {
-			t.Fatalf("expected '%s', got %v", mime, v.mimeType)
+		expect := `{"contents":[` +
+			`{"uri":"example://example.com/report","mimeType":"text/plain","text":"summary"},` +
+			`{"uri":"example://example.com/report","mimeType":"application/json","text":"{\"total\":1}"},` +
+			`{"uri":"example://example.com/report","mimeType":"image/png","blob":"cmF3","_meta":{"size":3}},` +
+			`{"mimeType":"text/markdown","text":"# custom","uri":"example://example.com/report"}` +
+			`]}`
+		if string(b) != expect {
+			t.Fatalf("expected %s, got %s", expect, b)
+		}
+		for i, v := range c.dest.Contents {
+			if v.GetURI().String() != uri.String() {
+				t.Fatalf("contents[%d]: expected %v, got %v", i, uri, v.GetURI())
+			}
 		}
 	})
-	t.Run("", func(t *testing.T) {})
 }
 
 func TestResourceContext_reset(t *testing.T) {
@@ -749,6 +1543,7 @@ func TestResourceContext_reset(t *testing.T) {
 		c.store.Store("key", "value")
 		c.mimeType = "application/json"
 		c.pathParams = map[string]string{"id": "123"}
+		c.blobRange = &BlobRange{Start: 1}
 		c.dest = &readResourceResult{}
 		c.reset()
 		if c.mimeType != "" {
@@ -757,6 +1552,9 @@ func TestResourceContext_reset(t *testing.T) {
 		if len(c.pathParams) != 0 {
 			t.Fatalf("expected nil, got %v", c.pathParams)
 		}
+		if c.blobRange != nil {
+			t.Fatalf("expected nil, got %v", c.blobRange)
+		}
 		if c.dest != nil {
 			t.Fatalf("expected nil, got %v", c.dest)
 		}
@@ -774,7 +1572,7 @@ func TestResourceListContext_Resources(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		resources := map[string]Resource{
 			"example://example.com/1": {
-				URI:         (*ResourceURI)(MustURL(t, "example://example.com/1")),
+				URI:         ResourceURIFromURL(MustURL(t, "example://example.com/1")),
 				MimeType:    "application/json",
 				Name:        "example",
 				Description: "example description",
@@ -796,7 +1594,7 @@ func TestResourceListContext_SetResource(t *testing.T) {
 		dest := map[string]Resource{}
 		c.dest = &dest
 		resource := Resource{
-			URI:         (*ResourceURI)(uri),
+			URI:         ResourceURIFromURL(uri),
 			MimeType:    "application/json",
 			Name:        "example",
 			Description: "example description",
@@ -908,6 +1706,29 @@ func TestResourceChangeSubscriber_Publish(t *testing.T) {
 			}
 		}
 	})
+	t.Run("throttled", func(t *testing.T) {
+		ch := make(chan *url.URL, 10)
+		defer close(ch)
+		uri := MustURL(t, "example://example.com")
+		now := MustTime(t, "2023-10-01T00:00:00Z")
+		s := resourceChangeSubscriber{
+			subscribedURI: uri,
+			ch:            ch,
+			minInterval:   time.Second,
+			nowFunc: func() time.Time {
+				return now
+			},
+		}
+		// published at 0ms, 400ms, 800ms, 1200ms, 1600ms, 2000ms and 2400ms
+		for range 7 {
+			s.Publish(uri)
+			now = now.Add(400 * time.Millisecond)
+		}
+		// only 0ms, 1200ms and 2400ms are notified
+		if got := len(ch); got != 3 {
+			t.Fatalf("expected 3 notifications, got %d", got)
+		}
+	})
 }
 
 func TestResourceChangeSubscriber_reset(t *testing.T) {
@@ -1025,6 +1846,42 @@ func TestResourceChangeContext_Publish(t *testing.T) {
 			}
 		}
 	})
+	t.Run("published against template", func(t *testing.T) {
+		ch := make(chan *url.URL, 1)
+		defer close(ch)
+		subscribeURI := MustURL(t, "example://example.com/123")
+		now := MustTime(t, "2023-10-01T00:00:00Z")
+
+		c := resourceChangeContext{
+			ctx: t.Context(),
+			subscriber: map[string]ResourceChangeSubscriber{
+				"1": &resourceChangeSubscriber{
+					id:            "1",
+					subscribedURI: subscribeURI,
+					lastReceived:  now,
+					ch:            ch,
+					nowFunc: func() time.Time {
+						return now.Add(1)
+					},
+				},
+			},
+		}
+		ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+		defer cancel()
+
+		c.Publish(MustURL(t, "example://example.com/{id}"), now.Add(1))
+		select {
+		case <-ctx.Done():
+			t.Fatalf("expected to receive message, got timeout")
+		case msg := <-ch:
+			if msg == nil {
+				t.Fatalf("expected non-nil message, got nil")
+			}
+			if msg.String() != subscribeURI.String() {
+				t.Fatalf("expected %v, got %v", subscribeURI, msg)
+			}
+		}
+	})
 	t.Run("uri unmatched", func(t *testing.T) {
 		ch := make(chan *url.URL, 1)
 		defer close(ch)
@@ -1089,6 +1946,75 @@ func TestResourceChangeContext_Publish(t *testing.T) {
 	})
 }
 
+type testResourceListChangeContext struct {
+	ResourceListChangeContext
+	published []time.Time
+}
+
+func (c *testResourceListChangeContext) Publish(modifiedAt time.Time) {
+	c.published = append(c.published, modifiedAt)
+}
+
+func TestResourceChangeContext_PublishDeleted(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		ch := make(chan *url.URL, 1)
+		defer close(ch)
+		uri := MustURL(t, "example://example.com")
+		now := MustTime(t, "2023-10-01T00:00:00Z")
+		subscriber := &resourceChangeSubscriber{
+			id:            "1",
+			subscribedURI: uri,
+			lastReceived:  now,
+			ch:            ch,
+			nowFunc: func() time.Time {
+				return now.Add(1)
+			},
+		}
+		listChangeCtx := &testResourceListChangeContext{}
+		c := resourceChangeContext{
+			ctx: t.Context(),
+			subscriber: map[string]ResourceChangeSubscriber{
+				"1": subscriber,
+			},
+			listChangeCtx: listChangeCtx,
+		}
+
+		c.PublishDeleted(uri, now.Add(1))
+		select {
+		case msg := <-ch:
+			if msg.String() != uri.String() {
+				t.Fatalf("expected 'example://example.com', got %v", msg)
+			}
+		default:
+			t.Fatalf("expected to receive message")
+		}
+		if !subscriber.isDeleted() {
+			t.Fatalf("expected the subscriber to be marked as deleted")
+		}
+		if len(c.subscriber) != 0 {
+			t.Fatalf("expected the subscriber to be removed, got %v", c.subscriber)
+		}
+		if len(listChangeCtx.published) != 1 || !listChangeCtx.published[0].Equal(now.Add(1)) {
+			t.Fatalf("expected the resource list change to be published, got %v", listChangeCtx.published)
+		}
+	})
+	t.Run("not matched", func(t *testing.T) {
+		c := resourceChangeContext{
+			ctx: t.Context(),
+			subscriber: map[string]ResourceChangeSubscriber{
+				"1": &resourceChangeSubscriber{
+					id:            "1",
+					subscribedURI: MustURL(t, "example://example.com/a"),
+				},
+			},
+		}
+		c.PublishDeleted(MustURL(t, "example://example.com/b"), time.Now())
+		if len(c.subscriber) != 1 {
+			t.Fatalf("expected the subscriber to remain, got %v", c.subscriber)
+		}
+	})
+}
+
 func TestResourceChangeContext_subscribe(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		ch := make(chan *url.URL, 1)
@@ -1127,25 +2053,52 @@ func TestResourceChangeContext_unsubscribe(t *testing.T) {
 		uri := MustURL(t, "example://example.com")
 		now := MustTime(t, "2023-10-01T00:00:00Z")
 
+		subscriber := &resourceChangeSubscriber{
+			id:            "1",
+			subscribedURI: uri,
+			lastReceived:  now,
+			ch:            ch,
+			nowFunc: func() time.Time {
+				return now.Add(1)
+			},
+		}
 		c := resourceChangeContext{
 			ctx: t.Context(),
 			subscriber: map[string]ResourceChangeSubscriber{
-				"1": &resourceChangeSubscriber{
-					id:            "1",
-					subscribedURI: uri,
-					lastReceived:  now,
-					ch:            ch,
-					nowFunc: func() time.Time {
-						return now.Add(1)
-					},
-				},
+				"1": subscriber,
 			},
 		}
-		c.unsubscribe("1")
+		if !c.unsubscribe(subscriber) {
+			t.Fatalf("expected the subscriber to be unsubscribed")
+		}
 		if len(c.subscriber) != 0 {
 			t.Fatalf("expected 0 subscribers, got %d", len(c.subscriber))
 		}
 	})
+	t.Run("replaced", func(t *testing.T) {
+		uri := MustURL(t, "example://example.com")
+		c := resourceChangeContext{
+			ctx: t.Context(),
+		}
+		stopped := make(chan struct{})
+		replaced := &resourceChangeSubscriber{id: "1", subscribedURI: uri, stopped: stopped}
+		c.subscribe(replaced)
+		subscriber := &resourceChangeSubscriber{id: "1", subscribedURI: uri}
+		if !c.subscribe(subscriber) {
+			t.Fatalf("expected the existing subscriber to be replaced")
+		}
+		select {
+		case <-stopped:
+		default:
+			t.Fatalf("expected the replaced subscriber to be stopped")
+		}
+		if c.unsubscribe(replaced) {
+			t.Fatalf("expected the replaced subscriber not to unsubscribe the new one")
+		}
+		if c.subscriber["1"] != subscriber {
+			t.Fatalf("expected the new subscriber to be kept, got %v", c.subscriber["1"])
+		}
+	})
 }
 
 func Test_uriMatches(t *testing.T) {
@@ -1156,6 +2109,20 @@ func Test_uriMatches(t *testing.T) {
 			t.Fatalf("expected true, got false")
 		}
 	})
+	t.Run("template published", func(t *testing.T) {
+		subscribeURI := MustURL(t, "example://example.com/123")
+		actualURI := MustURL(t, "example://example.com/{id}")
+		if !uriMatches(actualURI, subscribeURI) {
+			t.Fatalf("expected true, got false")
+		}
+	})
+	t.Run("trailing slash", func(t *testing.T) {
+		subscribeURI := MustURL(t, "example://example.com/123/")
+		actualURI := MustURL(t, "example://example.com/123")
+		if !uriMatches(actualURI, subscribeURI) {
+			t.Fatalf("expected true, got false")
+		}
+	})
 	t.Run("no match", func(t *testing.T) {
 		subscribeURI := MustURL(t, "example://example.com/{id}")
 		actualURI := MustURL(t, "example://example.com")
@@ -1494,6 +2461,43 @@ func TestPromptContext_Bind(t *testing.T) {
 			t.Fatalf("expected name='', age='', got name=%v, age=%v", args.Name, args.Age)
 		}
 	})
+	t.Run("string-encoded integer", func(t *testing.T) {
+		type Args struct {
+			Name string `json:"name"`
+			Age  int    `json:"age"`
+		}
+		c := newPromptContext(json.Unmarshal, json.Marshal, nil)
+		c.arguments = []PromptArgument{{Name: "name"}, {Name: "age"}}
+		c.strictArguments = true
+		c.rawArgs = []byte(`{"name": "John", "age": "30"}`)
+		var args Args
+		if err := c.Bind(&args); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if args.Name != "John" || args.Age != 30 {
+			t.Fatalf("expected name=John, age=30, got name=%v, age=%v", args.Name, args.Age)
+		}
+	})
+	t.Run("unknown argument", func(t *testing.T) {
+		type Args struct {
+			Name string `json:"name"`
+		}
+		c := newPromptContext(json.Unmarshal, json.Marshal, nil)
+		c.arguments = []PromptArgument{{Name: "name"}}
+		c.rawArgs = []byte(`{"name": "John", "age": "30"}`)
+		var args Args
+		if err := c.Bind(&args); err != nil {
+			t.Fatalf("unexpected error without strict arguments: %v", err)
+		}
+		c.strictArguments = true
+		var paramsErr *InvalidParamsError
+		if err := c.Bind(&args); !errors.As(err, &paramsErr) {
+			t.Fatalf("expected InvalidParamsError, got %v", err)
+		}
+		if len(paramsErr.Fields) != 1 || paramsErr.Fields[0].Name != "age" {
+			t.Fatalf("expected the field 'age', got %v", paramsErr.Fields)
+		}
+	})
 }
 
 func TestPromptContext_String(t *testing.T) {
@@ -1699,3 +2703,184 @@ func TestPromptContext_reset(t *testing.T) {
 		})
 	})
 }
+
+func TestContext_encoderPanicked(t *testing.T) {
+	panickingMarshal := func(v any) ([]byte, error) {
+		panic("marshal")
+	}
+	panickingBase64 := func(data []byte) string {
+		panic("base64")
+	}
+	tests := map[string]func() error{
+		"ToolContext.JSON": func() error {
+			var dest CallToolContent
+			c := newToolContext(nil, panickingMarshal, nil)
+			c.dest = &dest
+			return c.JSON(map[string]any{"key": "value"})
+		},
+		"ToolContext.Image": func() error {
+			var dest CallToolContent
+			c := newToolContext(nil, nil, panickingBase64)
+			c.dest = &dest
+			return c.Image([]byte("image"), "image/png")
+		},
+		"ToolContext.BinaryResource": func() error {
+			var dest CallToolContent
+			c := newToolContext(nil, nil, panickingBase64)
+			c.dest = &dest
+			return c.BinaryResource(MustURL(t, "example://example.com/1"), []byte("blob"), "")
+		},
+		"ResourceContext.JSON": func() error {
+			c := newResourceContext(nil, panickingMarshal, nil)
+			c.dest = &readResourceResult{}
+			return c.JSON(map[string]any{"key": "value"})
+		},
+		"ResourceContext.Blob": func() error {
+			c := newResourceContext(nil, nil, panickingBase64)
+			c.dest = &readResourceResult{}
+			return c.Blob([]byte("blob"), "")
+		},
+		"PromptContext.JSON": func() error {
+			var dest getPromptResult
+			c := newPromptContext(nil, panickingMarshal, nil)
+			c.dest = &dest
+			return c.JSON(PromptRoleUser, map[string]any{"key": "value"})
+		},
+		"PromptContext.Image": func() error {
+			var dest getPromptResult
+			c := newPromptContext(nil, nil, panickingBase64)
+			c.dest = &dest
+			return c.Image(PromptRoleUser, []byte("image"), "image/png")
+		},
+	}
+	for name, call := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := call(); !errors.Is(err, ErrEncoderPanicked) {
+				t.Fatalf("expected ErrEncoderPanicked, got %v", err)
+			}
+		})
+	}
+}
+
+func TestResourceContext_File(t *testing.T) {
+	dir := t.TempDir()
+	textPath := filepath.Join(dir, "hello.txt")
+	if err := os.WriteFile(textPath, []byte("hello"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	binaryPath := filepath.Join(dir, "image.png")
+	if err := os.WriteFile(binaryPath, []byte{0x89, 'P', 'N', 'G', 0x00, 0x01}, 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	newContext := func() *resourceContext {
+		c := newResourceContext(nil, nil, base64.StdEncoding.EncodeToString)
+		c.dest = &readResourceResult{}
+		return c
+	}
+	t.Run("text file", func(t *testing.T) {
+		c := newContext()
+		if err := c.File(textPath, ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		v, ok := (c.dest.Contents[0]).(textResourceContent)
+		if !ok {
+			t.Fatalf("expected textResourceContent, got %T", c.dest.Contents[0])
+		}
+		if v.text != "hello" {
+			t.Fatalf("expected 'hello', got %v", v.text)
+		}
+		if !strings.HasPrefix(v.mimeType, "text/plain") {
+			t.Fatalf("expected 'text/plain', got %v", v.mimeType)
+		}
+	})
+	t.Run("binary file", func(t *testing.T) {
+		c := newContext()
+		if err := c.File(binaryPath, ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		v, ok := (c.dest.Contents[0]).(binaryResourceContent)
+		if !ok {
+			t.Fatalf("expected binaryResourceContent, got %T", c.dest.Contents[0])
+		}
+		if want := base64.StdEncoding.EncodeToString([]byte{0x89, 'P', 'N', 'G', 0x00, 0x01}); v.blob != want {
+			t.Fatalf("expected %v, got %v", want, v.blob)
+		}
+		if v.mimeType != "image/png" {
+			t.Fatalf("expected 'image/png', got %v", v.mimeType)
+		}
+		if v.size != 6 {
+			t.Fatalf("expected 6, got %v", v.size)
+		}
+	})
+	t.Run("binary file with range", func(t *testing.T) {
+		c := newContext()
+		c.blobRange = &BlobRange{Start: 1, End: 4}
+		if err := c.File(binaryPath, "application/octet-stream"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		v, ok := (c.dest.Contents[0]).(binaryResourceContent)
+		if !ok {
+			t.Fatalf("expected binaryResourceContent, got %T", c.dest.Contents[0])
+		}
+		if want := base64.StdEncoding.EncodeToString([]byte("PNG")); v.blob != want {
+			t.Fatalf("expected %v, got %v", want, v.blob)
+		}
+		if !reflect.DeepEqual(v.blobRange, &BlobRange{Start: 1, End: 4}) {
+			t.Fatalf("expected range 1-4, got %v", v.blobRange)
+		}
+	})
+	t.Run("not found", func(t *testing.T) {
+		c := newContext()
+		if err := c.File(filepath.Join(dir, "missing.txt"), ""); !errors.Is(err, ErrResourceNotFound) {
+			t.Fatalf("expected ErrResourceNotFound, got %v", err)
+		}
+	})
+}
+
+func TestToolContext_Stream(t *testing.T) {
+	req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodToolsCall, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Run("streamable", func(t *testing.T) {
+		var started int
+		var streamed []string
+		c := newToolContext(nil, json.Marshal, nil)
+		c.ctx = t.Context()
+		c.jsonrpcRequest = req
+		c.startStream = func() Notify {
+			started++
+			return func(_ context.Context, method string, params interface{}) error {
+				b, err := json.Marshal(params)
+				if err != nil {
+					return err
+				}
+				streamed = append(streamed, method+" "+string(b))
+				return nil
+			}
+		}
+		for _, s := range []string{"starting", "ready"} {
+			if err := c.Stream(s); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if started != 1 {
+			t.Fatalf("expected the stream to be started once, got %d", started)
+		}
+		expect := []string{
+			`notifications/tools/stream {"requestId":"1","content":{"type":"text","text":"starting"}}`,
+			`notifications/tools/stream {"requestId":"1","content":{"type":"text","text":"ready"}}`,
+		}
+		if !slices.Equal(streamed, expect) {
+			t.Fatalf("expected %v, got %v", expect, streamed)
+		}
+	})
+	t.Run("not supported", func(t *testing.T) {
+		c := newToolContext(nil, json.Marshal, nil)
+		c.ctx = t.Context()
+		c.jsonrpcRequest = req
+		if err := c.Stream("starting"); !errors.Is(err, ErrToolStreamNotSupported) {
+			t.Fatalf("expected ErrToolStreamNotSupported, got %v", err)
+		}
+	})
+}
@@ -1,6 +1,15 @@
 package qilin
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/jsonrpc2"
+)
 
 const (
 	ErrorMessageFailedToHandleTool = "failed to handle Tool (name: %s): %w"
@@ -14,4 +23,151 @@ var (
 	// ErrInvalidPromptRole occurs when an invalid prompt role is provided.
 	ErrInvalidPromptRole = errors.New(
 		"invalid prompt role, must be one of: user, assistant")
+
+	// ErrInvalidBindParamsTarget occurs when the target of ResourceContext.BindParams is not a pointer to a struct.
+	ErrInvalidBindParamsTarget = errors.New("bind params target must be a non-nil pointer to a struct")
+
+	// ErrInvalidToolName occurs when the tool name is empty or contains characters other than
+	// ASCII letters, digits, '_', '-' and '.', or is longer than 128 characters.
+	ErrInvalidToolName = errors.New("invalid tool name")
+
+	// ErrDuplicateToolName occurs when a tool with the same name is already registered.
+	ErrDuplicateToolName = errors.New("tool name is already registered")
+
+	// ErrDuplicatePromptName occurs when a prompt with the same name is already registered.
+	ErrDuplicatePromptName = errors.New("prompt name is already registered")
+
+	// ErrInvalidRawJSON occurs when the raw JSON passed to ToolContext.Raw or ResourceContext.Raw is not valid JSON.
+	ErrInvalidRawJSON = errors.New("raw JSON is invalid")
+
+	// ErrToolContentNotWritten occurs when a tool handler returns successfully without writing any content,
+	// with EmptyToolContentError.
+	ErrToolContentNotWritten = errors.New("tool handler returned without writing any content")
+
+	// ErrToolNotReadOnly is the tool error when a tool not annotated with `ReadOnlyHint` is called on the read-only server.
+	ErrToolNotReadOnly = errors.New("tool is not read-only")
+
+	// ErrEncoderPanicked occurs when JSONMarshalFunc or Base64StringFunc panics while producing a content.
+	ErrEncoderPanicked = errors.New("encoder panicked")
+
+	// ErrResourceNotFound occurs when the resource to read does not exist.
+	// It is sent to the client with the error code -32002.
+	ErrResourceNotFound = jsonrpc2.NewError(-32002, "resource not found")
+
+	// ErrNoLiveStream occurs when a notification is sent to a session without a live stream.
+	ErrNoLiveStream = errors.New("session has no live stream")
+
+	// ErrAudioNotSupported occurs when audio content is sent to a client negotiated a protocol version older than 2025-03-26.
+	ErrAudioNotSupported = errors.New("audio content is not supported by the negotiated protocol version")
+
+	// ErrToolStreamNotSupported occurs when ToolContext.Stream is called on the transport other than the Streamable HTTP,
+	// or the stream is not available, such as by WithStreamingDisabled.
+	ErrToolStreamNotSupported = errors.New("tool streaming is only supported on the streamable http transport")
+
+	// ErrElicitationNotSupported occurs when ToolContext.Elicit is called for the client not advertising the elicitation capability.
+	ErrElicitationNotSupported = errors.New("client does not support elicitation")
+
+	// ErrElicitationDeclined occurs when the user declines the elicitation.
+	ErrElicitationDeclined = errors.New("elicitation declined by the user")
+
+	// ErrElicitationCanceled occurs when the user dismisses the elicitation.
+	ErrElicitationCanceled = errors.New("elicitation canceled by the user")
+
+	// ErrResponseTooLarge occurs when the result of the tool or the resource exceeds WithMaxResponseBytes.
+	ErrResponseTooLarge = errors.New("response exceeds the maximum size")
+
+	// ErrTooManySubscriptions occurs when the session subscribes to the resources beyond WithMaxSubscriptionsPerSession.
+	ErrTooManySubscriptions = errors.New("too many resource subscriptions in the session")
+
+	// ErrAlreadyInitialized occurs when the initialize request is sent again on the initialized connection.
+	ErrAlreadyInitialized = errors.New("connection is already initialized")
+
+	// ErrUnknownTemplateParam occurs when ResourceWithParamEnum is given a parameter absent from the resource URI template.
+	ErrUnknownTemplateParam = errors.New("parameter is not in the resource uri template")
+
+	// ErrCapabilityMismatch occurs when the advertised capability lacks the handler.
+	ErrCapabilityMismatch = errors.New("advertised capability lacks the handler")
+
+	// ErrInvalidResourceURI occurs when the resource URI has no scheme or host.
+	ErrInvalidResourceURI = errors.New("resource uri must have a scheme and a host")
+
+	// ErrStreamNotAccepted occurs when the method requiring the SSE stream, such as `resources/subscribe`,
+	// is requested by the client not accepting `text/event-stream`.
+	ErrStreamNotAccepted = jsonrpc2.NewError(-32600, "client does not accept text/event-stream")
+
+	// ErrResourceNotObserved occurs when the resource has no ResourceChangeObserver to subscribe to or publish the changes.
+	ErrResourceNotObserved = errors.New("resource has no change observer")
+
+	// ErrResourceContentNotWritten occurs when ToolContext.EmbedResource reads the resource returning no content.
+	ErrResourceContentNotWritten = errors.New("resource handler returned without writing any content")
 )
+
+// InvalidParamsError is jsonrpc2.ErrInvalidParams with the details of the offending params.
+// The details are sent to the client as the `data` of the JSON-RPC error.
+type InvalidParamsError struct {
+	// Fields are the offending params.
+	Fields []InvalidParamsField `json:"fields"`
+}
+
+// InvalidParamsField is an offending param and the reason.
+type InvalidParamsField struct {
+	// Name of the param.
+	Name string `json:"name"`
+
+	// Reason why the param is invalid.
+	Reason string `json:"reason"`
+}
+
+func (e *InvalidParamsError) Error() string {
+	var b strings.Builder
+	b.WriteString(jsonrpc2.ErrInvalidParams.Error())
+	for i, v := range e.Fields {
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(v.Name)
+		b.WriteString(" ")
+		b.WriteString(v.Reason)
+	}
+	return b.String()
+}
+
+func (e *InvalidParamsError) Unwrap() error {
+	return jsonrpc2.ErrInvalidParams
+}
+
+// RetryAfterError tells the client to retry the request later, such as when the backend of the resource is rate-limited.
+//
+// It is sent to the client with the error code -32003, carrying the seconds to wait as `retryAfter` of the `data`.
+// On the Streamable HTTP transport, the seconds are also sent as the `Retry-After` header.
+//
+//	return &qilin.RetryAfterError{RetryAfter: 30 * time.Second, Err: err}
+type RetryAfterError struct {
+	// RetryAfter is the duration to wait before retrying.
+	RetryAfter time.Duration
+
+	// Err is the cause. (optional)
+	Err error
+}
+
+func (e *RetryAfterError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("retry after %s", e.RetryAfter)
+	}
+	return fmt.Sprintf("retry after %s: %s", e.RetryAfter, e.Err)
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// MarshalJSON encodes the data of the JSON-RPC error, with the duration rounded up to the seconds.
+func (e *RetryAfterError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		RetryAfter int64 `json:"retryAfter"`
+	}{
+		RetryAfter: int64(math.Ceil(e.RetryAfter.Seconds())),
+	})
+}
@@ -3,11 +3,13 @@ package qilin_test
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/url"
 	"strings"
 
 	"github.com/miyamo2/qilin"
 	"github.com/miyamo2/qilin/transport"
+	"golang.org/x/exp/jsonrpc2"
 )
 
 type Req struct {
@@ -107,6 +109,16 @@ func ExampleToolWithMiddleware() {
 	}))
 }
 
+func ExampleQilin_Use() {
+	q := qilin.New("calc")
+	q.Use(func(next qilin.MethodHandlerFunc) qilin.MethodHandlerFunc {
+		return func(ctx context.Context, req *jsonrpc2.Request) (interface{}, error) {
+			slog.InfoContext(ctx, "request", slog.String("method", req.Method))
+			return next(ctx, req)
+		}
+	})
+}
+
 type Employee struct {
 	ID   string `json:"id"   jsonschema:"title=ID"`
 	Name string `json:"name" jsonschema:"title=Name"`
@@ -162,12 +174,12 @@ func ExampleQilin_ResourceList() {
 		i := 1
 		for _, v := range c.Resources() {
 			uri, err := url.Parse(
-				strings.Replace((*url.URL)(v.URI).String(), "{id}", fmt.Sprintf("%d", i), 1))
+				strings.Replace(v.URI.String(), "{id}", fmt.Sprintf("%d", i), 1))
 			if err != nil {
 				return err
 			}
 			c.SetResource("example://example.com/1", qilin.Resource{
-				URI:         (*qilin.ResourceURI)(uri),
+				URI:         qilin.ResourceURIFromURL(uri),
 				Name:        v.Name,
 				Description: fmt.Sprintf("Employee %d", i),
 				MimeType:    "application/json",
@@ -132,12 +132,12 @@ func ResourceList(c qilin.ResourceListContext) error {
 	}
 	for _, v := range cityWeathers {
 		city := strings.ReplaceAll(strings.ToLower(v.City), " ", "_")
-		uri, err := url.Parse(fmt.Sprintf("weather://forecast/%s", city))
+		uri, err := qilin.ParseResourceURI(fmt.Sprintf("weather://forecast/%s", city))
 		if err != nil {
 			return err
 		}
-		c.SetResource(uri.String(), qilin.Resource{
-			URI:         (*qilin.ResourceURI)(uri),
+		c.SetResource(uri.URL().String(), qilin.Resource{
+			URI:         uri,
 			Name:        fmt.Sprintf("%s Weather Forecast", v.City),
 			Description: fmt.Sprintf("Current weather data for %s", v.City),
 			MimeType:    "application/json",
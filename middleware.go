@@ -0,0 +1,166 @@
+package qilin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+type tenantKey struct{}
+
+// TenantKey is the key to fetch the tenant-scoped value placed by TenantMiddleware.
+//
+//	tenant := c.Get(qilin.TenantKey)
+var TenantKey = tenantKey{}
+
+// TenantResolver resolves the tenant-scoped value from the principal.
+//
+//   - ctx: the context of the request
+//   - principal: the principal resolved by the transport.PrincipalAuthorizer. nil if not available.
+type TenantResolver func(ctx context.Context, principal any) (tenant any, err error)
+
+// TenantMiddleware returns a middleware that resolves the tenant-scoped value from the principal
+// and places it on the context. Handlers can fetch it via `c.Get(qilin.TenantKey)`.
+//
+//	q.UseInTools(qilin.TenantMiddleware[qilin.ToolMiddlewareFunc](resolver))
+//	q.UseInResources(qilin.TenantMiddleware[qilin.ResourceMiddlewareFunc](resolver))
+//	q.UseInPrompts(qilin.TenantMiddleware[qilin.PromptMiddlewareFunc](resolver))
+func TenantMiddleware[
+	T ToolMiddlewareFunc | ResourceMiddlewareFunc | PromptMiddlewareFunc,
+](resolver TenantResolver) T {
+	var middleware T
+	switch m := any(&middleware).(type) {
+	case *ToolMiddlewareFunc:
+		*m = func(next ToolHandlerFunc) ToolHandlerFunc {
+			return func(c ToolContext) error {
+				if err := setTenant(c, resolver); err != nil {
+					return err
+				}
+				return next(c)
+			}
+		}
+	case *ResourceMiddlewareFunc:
+		*m = func(next ResourceHandlerFunc) ResourceHandlerFunc {
+			return func(c ResourceContext) error {
+				if err := setTenant(c, resolver); err != nil {
+					return err
+				}
+				return next(c)
+			}
+		}
+	case *PromptMiddlewareFunc:
+		*m = func(next PromptHandlerFunc) PromptHandlerFunc {
+			return func(c PromptContext) error {
+				if err := setTenant(c, resolver); err != nil {
+					return err
+				}
+				return next(c)
+			}
+		}
+	}
+	return middleware
+}
+
+// setTenant resolves the tenant-scoped value and places it on the context
+func setTenant(c Context, resolver TenantResolver) error {
+	tenant, err := resolver(c.Context(), c.Principal())
+	if err != nil {
+		return err
+	}
+	c.Set(TenantKey, tenant)
+	return nil
+}
+
+// ReadOnlyGuardMiddleware returns a middleware that refuses to execute the tool not annotated with `ReadOnlyHint`.
+// The refused call results in the tool error, with the message of ErrToolNotReadOnly as the text content.
+//
+//	q.UseInTools(qilin.ReadOnlyGuardMiddleware())
+func ReadOnlyGuardMiddleware() ToolMiddlewareFunc {
+	return func(next ToolHandlerFunc) ToolHandlerFunc {
+		return func(c ToolContext) error {
+			if !c.ToolAnnotations().ReadOnlyHint {
+				return c.Fail(TextContent(fmt.Sprintf("%s: '%s'", ErrToolNotReadOnly, c.ToolName())))
+			}
+			return next(c)
+		}
+	}
+}
+
+// TransientError is implemented by the errors that RetryToolMiddleware can retry.
+type TransientError interface {
+	error
+	// Transient reports whether the error is transient.
+	Transient() bool
+}
+
+// RetryToolMiddleware returns a middleware that retries the tool handler on transient errors.
+//
+//   - attempts: the maximum number of attempts, including the first one
+//   - backoff: returns the wait before the given retry, starting from 1. nil means no wait.
+//   - retryable: the errors to retry in addition to the ones implementing TransientError
+//
+// Waiting between the attempts stops when the context of the request is done,
+// returning the last error of the handler.
+//
+// The content written by the failed attempt is cleared before the retry.
+// The notifications already sent by it, such as ToolContext.Stream and the progress, cannot be taken back.
+//
+//	q.UseInTools(qilin.RetryToolMiddleware(3, func(n int) time.Duration {
+//		return time.Duration(n) * 100 * time.Millisecond
+//	}, ErrUpstreamUnavailable))
+func RetryToolMiddleware(
+	attempts int,
+	backoff func(retry int) time.Duration,
+	retryable ...error,
+) ToolMiddlewareFunc {
+	isRetryable := func(err error) bool {
+		var transient TransientError
+		if errors.As(err, &transient) && transient.Transient() {
+			return true
+		}
+		return slices.ContainsFunc(retryable, func(v error) bool {
+			return errors.Is(err, v)
+		})
+	}
+	return func(next ToolHandlerFunc) ToolHandlerFunc {
+		return func(c ToolContext) error {
+			var err error
+			for i := range max(attempts, 1) {
+				if i > 0 {
+					if !waitRetry(c.Context(), backoff, i) {
+						return err
+					}
+					if tc, ok := c.(*toolContext); ok {
+						tc.clearResult()
+					}
+				}
+				err = next(c)
+				if err == nil || !isRetryable(err) {
+					return err
+				}
+			}
+			return err
+		}
+	}
+}
+
+// waitRetry waits for the backoff before the retry. It returns false if the context is done.
+func waitRetry(ctx context.Context, backoff func(retry int) time.Duration, retry int) bool {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var wait time.Duration
+	if backoff != nil {
+		wait = backoff(retry)
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
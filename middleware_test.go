@@ -0,0 +1,253 @@
+package qilin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/jsonrpc2"
+)
+
+func TestTenantMiddleware(t *testing.T) {
+	resolver := func(_ context.Context, principal any) (any, error) {
+		switch principal {
+		case "alice":
+			return "tenant-a", nil
+		case "bob":
+			return "tenant-b", nil
+		}
+		return nil, errors.New("unknown principal")
+	}
+	t.Run("tool", func(t *testing.T) {
+		q := New("test")
+		tenants := make(map[any]any)
+		q.Tool("whoami", (*struct{})(nil), func(c ToolContext) error {
+			tenants[c.Principal()] = c.Get(TenantKey)
+			return c.String("ok")
+		}, ToolWithMiddleware(TenantMiddleware[ToolMiddlewareFunc](resolver)))
+		for _, principal := range []string{"alice", "bob"} {
+			h := &handler{qilin: q, principal: principal}
+			req, err := jsonrpc2.NewCall(
+				jsonrpc2.StringID("1"),
+				MethodToolsCall,
+				map[string]any{"name": "whoami"},
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := h.handleToolsCall(t.Context(), "session", req); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if tenants["alice"] != "tenant-a" {
+			t.Fatalf("expected 'tenant-a', got %v", tenants["alice"])
+		}
+		if tenants["bob"] != "tenant-b" {
+			t.Fatalf("expected 'tenant-b', got %v", tenants["bob"])
+		}
+	})
+	t.Run("prompt", func(t *testing.T) {
+		q := New("test")
+		var tenant any
+		q.Prompt("greeting", func(c PromptContext) error {
+			tenant = c.Get(TenantKey)
+			return c.String(PromptRoleUser, "Hello")
+		}, PromptWithMiddleware(TenantMiddleware[PromptMiddlewareFunc](resolver)))
+		h := &handler{qilin: q, principal: "bob"}
+		req, err := jsonrpc2.NewCall(
+			jsonrpc2.StringID("1"),
+			MethodPromptsGet,
+			map[string]any{"name": "greeting"},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := h.handlePromptsGet(t.Context(), req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tenant != "tenant-b" {
+			t.Fatalf("expected 'tenant-b', got %v", tenant)
+		}
+	})
+	t.Run("resolver error", func(t *testing.T) {
+		c := newResourceContext(nil, nil, nil)
+		c.principal = "eve"
+		middleware := TenantMiddleware[ResourceMiddlewareFunc](resolver)
+		called := false
+		err := middleware(func(c ResourceContext) error {
+			called = true
+			return nil
+		})(c)
+		if err == nil {
+			t.Fatalf("expected error, got nil")
+		}
+		if called {
+			t.Fatalf("expected the handler not to be called")
+		}
+	})
+}
+
+func TestReadOnlyGuardMiddleware(t *testing.T) {
+	call := func(t *testing.T, q *Qilin, name string) (interface{}, error) {
+		t.Helper()
+		h := &handler{qilin: q}
+		req, err := jsonrpc2.NewCall(
+			jsonrpc2.StringID("1"),
+			MethodToolsCall,
+			map[string]any{"name": name},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return h.handleToolsCall(t.Context(), "session", req)
+	}
+	register := func(q *Qilin, called map[string]bool, options ...ToolOption) {
+		q.Tool("read", (*struct{})(nil), func(c ToolContext) error {
+			called["read"] = true
+			return c.String("ok")
+		}, append(options, ToolWithAnnotations(ToolAnnotations{ReadOnlyHint: true}))...)
+		q.Tool("write", (*struct{})(nil), func(c ToolContext) error {
+			called["write"] = true
+			return c.String("ok")
+		}, options...)
+	}
+	tests := map[string]struct {
+		q       *Qilin
+		options []ToolOption
+	}{
+		"middleware": {
+			q:       New("test"),
+			options: []ToolOption{ToolWithMiddleware(ReadOnlyGuardMiddleware())},
+		},
+		"WithReadOnly": {
+			q: New("test", WithReadOnly()),
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			called := make(map[string]bool)
+			register(tt.q, called, tt.options...)
+			if _, err := call(t, tt.q, "read"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !called["read"] {
+				t.Fatalf("expected the read-only tool to be called")
+			}
+			res, err := call(t, tt.q, "write")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := json.Marshal(res)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expect := `{"content":[{"type":"text","text":"tool is not read-only: 'write'"}],"isError":true}`; string(b) != expect {
+				t.Fatalf("expected %s, got %s", expect, b)
+			}
+			if called["write"] {
+				t.Fatalf("expected the non read-only tool not to be called")
+			}
+		})
+	}
+}
+
+type testTransientError bool
+
+func (e testTransientError) Error() string   { return "transient" }
+func (e testTransientError) Transient() bool { return bool(e) }
+
+func TestRetryToolMiddleware(t *testing.T) {
+	errRetryable := errors.New("retryable")
+	errOther := errors.New("other")
+	tests := map[string]struct {
+		errs      []error
+		cancel    bool
+		wantCalls int
+		wantErr   error
+	}{
+		"success on first attempt": {
+			errs:      []error{nil},
+			wantCalls: 1,
+		},
+		"success after retries": {
+			errs:      []error{errRetryable, testTransientError(true), nil},
+			wantCalls: 3,
+		},
+		"attempts exhausted": {
+			errs:      []error{errRetryable, errRetryable, errRetryable, nil},
+			wantCalls: 3,
+			wantErr:   errRetryable,
+		},
+		"not retryable": {
+			errs:      []error{errOther, nil},
+			wantCalls: 1,
+			wantErr:   errOther,
+		},
+		"not transient": {
+			errs:      []error{testTransientError(false), nil},
+			wantCalls: 1,
+			wantErr:   testTransientError(false),
+		},
+		"context canceled": {
+			errs:      []error{errRetryable, nil},
+			cancel:    true,
+			wantCalls: 1,
+			wantErr:   errRetryable,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(t.Context())
+			defer cancel()
+			var calls int
+			next := func(c ToolContext) error {
+				err := tt.errs[calls]
+				calls++
+				if tt.cancel {
+					cancel()
+				}
+				return err
+			}
+			backoff := func(int) time.Duration {
+				if tt.cancel {
+					return time.Hour
+				}
+				return time.Millisecond
+			}
+			c := &toolContext{}
+			c.SetContext(ctx)
+			err := RetryToolMiddleware(3, backoff, errRetryable)(next)(c)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			if calls != tt.wantCalls {
+				t.Fatalf("expected %d calls, got %d", tt.wantCalls, calls)
+			}
+		})
+	}
+}
+
+func TestRetryToolMiddleware_clearResult(t *testing.T) {
+	var calls int
+	next := func(c ToolContext) error {
+		calls++
+		if calls == 1 {
+			if err := c.String("partial"); err != nil {
+				return err
+			}
+			return testTransientError(true)
+		}
+		return nil
+	}
+	c := newToolContext(nil, json.Marshal, nil)
+	c.dest = &c.result
+	c.SetContext(t.Context())
+	if err := RetryToolMiddleware(2, nil)(next)(c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.result != nil {
+		t.Fatalf("expected the content of the failed attempt to be cleared, got %v", c.result)
+	}
+}
@@ -1,22 +1,30 @@
 package qilin
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"iter"
 	"log/slog"
 	"maps"
+	"net/http"
 	"net/url"
 	"reflect"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"weak"
 
+	"github.com/google/uuid"
 	"github.com/invopop/jsonschema"
 	internaltransport "github.com/miyamo2/qilin/internal/transport"
 	"github.com/miyamo2/qilin/transport"
@@ -31,6 +39,14 @@ type Qilin struct {
 	// version of the server
 	version string
 
+	// instructions describe how to use the server and its features.
+	instructions string
+	// instructionsFunc returns the instructions for the capabilities of the connecting client.
+	instructionsFunc func(ClientCapabilities) string
+
+	// selfDescriptionURI is the URI of the resource describing the server. empty means not served.
+	selfDescriptionURI string
+
 	// startupMutex is mutex to lock Qilin instance access during server configuration and startup.
 	startupMutex sync.RWMutex
 
@@ -48,6 +64,23 @@ type Qilin struct {
 
 	// base64StringFunc is the function to encode binary data to a base64 string
 	base64StringFunc Base64StringFunc
+	// contentEncoders is the functions to encode binary data, keyed by the mime type
+	contentEncoders map[string]ContentEncoderFunc
+	// maxResponseBytes is the maximum size of the tool and resource results in bytes. zero means unlimited
+	maxResponseBytes int
+	// responseLimitMode is the way to handle the results exceeding maxResponseBytes
+	responseLimitMode ResponseLimitMode
+	// resourceChecksum computes the checksums of the resource contents. nil if disabled
+	resourceChecksum *resourceChecksum
+
+	// methodMiddleware is the list of middleware functions to be applied to every JSON-RPC method
+	methodMiddleware []MethodMiddlewareFunc
+
+	// errorRedaction hides the errors without the JSON-RPC error code from the clients
+	errorRedaction bool
+
+	// responseInterceptor inspects and replaces the result of every JSON-RPC method before it is sent
+	responseInterceptor func(method string, result any) any
 
 	// toolMiddleware is the list of toolMiddleware functions to be applied to each Tool handler
 	toolMiddleware []ToolMiddlewareFunc
@@ -65,11 +98,14 @@ type Qilin struct {
 	promptContextPool sync.Pool
 
 	// prompts is the map of prompt names to prompt instances
-	prompts map[string]prompt
+	prompts map[string]Prompt
 
 	// resourceMiddleware is the list of resourceMiddleware functions to be applied to each resource handler
 	resourceMiddleware []ResourceMiddlewareFunc
 
+	// resourceMiddlewareApplied reports whether Start has applied resourceMiddleware to the registered handlers
+	resourceMiddlewareApplied bool
+
 	// resourceContextPool pools ResourceContext
 	resourceContextPool sync.Pool
 
@@ -79,12 +115,51 @@ type Qilin struct {
 	// resourceNode is the root node of the resource routing tree
 	resourceNode resourceNode
 
-	// resourceTemplates is the map of resource templates
+	// resourceTemplates is the map of resource templates keyed by the URI template
 	resourceTemplates map[string]resourceTemplate
 
+	// resourceTemplatesPageSize is the number of resource templates per page of `resources/templates/list`
+	resourceTemplatesPageSize int
+
+	// resourcesMu guards resources, resourceNode, resourceTemplates, capabilities and resourceMiddlewareApplied against the changes while serving.
+	resourcesMu sync.RWMutex
+
 	// resourceListHandler is the resource list handler
 	resourceListHandler ResourceListHandlerFunc
 
+	// sessionStreams holds the live stream of each session to send notifications from the application
+	sessionStreams sessionStreams
+
+	// inFlightRequests holds the cancel funcs of the in-flight requests of each session
+	inFlightRequests inFlightRequests
+
+	// maxSubscriptionsPerSession is the maximum number of the resource subscriptions per session. zero means unlimited.
+	maxSubscriptionsPerSession int
+
+	// sessionSubscriptions counts the live resource subscriptions of each session
+	sessionSubscriptions subscriptionCounter
+
+	// protocolVersions holds the negotiated protocol version of each session
+	protocolVersions sync.Map
+
+	// clientCapabilities holds the capabilities advertised by the client of each session
+	clientCapabilities sync.Map
+
+	// locales holds the locale passed by the client of each session
+	locales sync.Map
+
+	// experimentalNegotiator decides the experimental capabilities advertised to the client. nil means no negotiation.
+	experimentalNegotiator func(clientExperimental map[string]any) map[string]any
+
+	// initializeProbe detects the initialize probing the capabilities. nil means no probe.
+	initializeProbe InitializeProbeFunc
+
+	// experimentalCapabilities holds the experimental capabilities negotiated with the client of each session
+	experimentalCapabilities sync.Map
+
+	// audioDowngrade returns the URI of the embedded resource the audio content is sent as to the clients not supporting audio content
+	audioDowngrade func(c ToolContext) *url.URL
+
 	// resourceListContextPool pools ResourceListContext
 	resourceListContextPool sync.Pool
 
@@ -123,8 +198,80 @@ type Qilin struct {
 	// nowFunc is the function to get the current time
 	nowFunc NowFunc
 
+	// observerRestart restarts the panicked observers
+	observerRestart bool
+
+	// observerRestartBackoff returns the wait before restarting the panicked observer
+	observerRestartBackoff func(restart int) time.Duration
+
+	// defaultMimeTypes is the MIME types of the contents not specified by the handler or the resource
+	defaultMimeTypes defaultMimeTypes
+
+	// argumentHasher is the function to hash the arguments into the cache keys
+	argumentHasher ArgumentHashFunc
+
+	// schemaValidator validates the arguments of tools/call against the input schema
+	schemaValidator SchemaValidator
+
+	// requiredArgumentsChecked reports whether the required arguments of tools and prompts are checked
+	requiredArgumentsChecked bool
+
 	// rootCtx is the root context of the Qilin instance
 	rootCtx context.Context
+
+	// jsonIndent is the indentation applied to outgoing messages. nil means compact output.
+	jsonIndent *jsonIndent
+
+	// wireTrace is the writer of the trace of the incoming and outgoing messages. nil means no trace.
+	wireTrace io.Writer
+
+	// streamingDisabled indicates the server responds in request/response only, without subscriptions.
+	streamingDisabled bool
+
+	// resourceListCache caches the result of the resource list handler. nil means no caching.
+	resourceListCache *resourceListCache
+
+	// resourceReadCache caches the results of reading the resources. nil means no caching.
+	resourceReadCache *resourceReadCache
+
+	// toolIdempotencyCache caches the results of idempotent tools. nil means no caching.
+	toolIdempotencyCache *toolIdempotencyCache
+
+	// toolNameNormalizer normalizes the tool name at registration. nil means no normalization.
+	toolNameNormalizer ToolNameNormalizerFunc
+
+	// toolSchemaVersion is the `$schema` of the tool input schemas. empty means omitted.
+	toolSchemaVersion string
+
+	// readOnly indicates only the tools annotated with `ReadOnlyHint` can be called.
+	readOnly bool
+
+	// strictCapabilities makes Start fail when the advertised capabilities lack the handlers.
+	strictCapabilities bool
+
+	// observedResourceURIs are the URIs registered with ResourceChangeObserver.
+	observedResourceURIs []*url.URL
+
+	// prewarmPools is the number of objects seeded into each pool on Start.
+	prewarmPools int
+
+	// maxRequestTimeout bounds the timeout requested by the client. zero means unbounded.
+	maxRequestTimeout time.Duration
+
+	// uriRewriter rewrites the resource URIs requested by the client before matching. nil if not available.
+	uriRewriter func(*url.URL) *url.URL
+
+	// initializeTimeout is the window in which a connection without the session must be initialized. zero means no limit.
+	initializeTimeout time.Duration
+
+	// emptyToolContentMode is the way `tools/call` handles a tool handler writing no content
+	emptyToolContentMode EmptyToolContentMode
+}
+
+// jsonIndent is the prefix and indent passed to json.Indent.
+type jsonIndent struct {
+	prefix string
+	indent string
 }
 
 // resourcesSubscriptionOptions is the options for resource subscription.
@@ -144,9 +291,18 @@ type sessionManagerOptions struct {
 	store SessionStore
 }
 
+// MethodHandlerFunc defines a function to serve JSON-RPC method requests.
+type MethodHandlerFunc func(ctx context.Context, req *jsonrpc2.Request) (interface{}, error)
+
+// MethodMiddlewareFunc defines a function to process middleware of every JSON-RPC method.
+type MethodMiddlewareFunc func(next MethodHandlerFunc) MethodHandlerFunc
+
 // ToolMiddlewareFunc defines a function to process Tool middleware.
 type ToolMiddlewareFunc func(next ToolHandlerFunc) ToolHandlerFunc
 
+// ToolNameNormalizerFunc defines a function to normalize the tool name at registration.
+type ToolNameNormalizerFunc func(name string) string
+
 // ToolHandlerFunc defines a function to serve Tool requests.
 type ToolHandlerFunc func(c ToolContext) error
 
@@ -168,10 +324,7 @@ type PromptMiddlewareFunc func(next PromptHandlerFunc) PromptHandlerFunc
 // DefaultResourceListHandler is the default resource list handler.
 func DefaultResourceListHandler(c ResourceListContext) error {
 	for k, v := range c.Resources() {
-		paths := strings.Split(v.URI.Path, "/")
-		if slices.ContainsFunc(paths, func(s string) bool {
-			return strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}")
-		}) {
+		if isTemplateURI(v.URI.URL()) {
 			continue
 		}
 		c.SetResource(k, v)
@@ -201,9 +354,32 @@ type JSONMarshalFunc func(v any) ([]byte, error)
 // Base64StringFunc defines a function to encode binary data to a base64 string.
 type Base64StringFunc func(data []byte) string
 
+// ContentEncoderFunc defines a function to encode the binary data of a content into a string.
+type ContentEncoderFunc func(data []byte) (string, error)
+
+// ChecksumFunc defines a function to compute the checksum of the raw bytes of a content into a string.
+type ChecksumFunc func(data []byte) string
+
 // NowFunc defines a function to get the current time.
 type NowFunc func() time.Time
 
+// ArgumentHashFunc defines a function to hash the compacted JSON arguments into a string.
+type ArgumentHashFunc func(args []byte) string
+
+// SchemaValidator validates the arguments of a tool call against the input schema of the tool.
+//
+// The input schema is only generated by invopop/jsonschema, so a validator such as santhosh-tekuri/jsonschema
+// can be plugged in with WithSchemaValidator to enforce the whole schema.
+type SchemaValidator interface {
+	// Validate reports whether args conforms to schema.
+	// The error is sent to the client as InvalidParamsError, unless it already wraps jsonrpc2.ErrInvalidParams.
+	Validate(schema *jsonschema.Schema, args json.RawMessage) error
+}
+
+// InitializeProbeFunc defines a function to detect the initialize only probing the capabilities,
+// from the name of the client and the `_meta` of the request.
+type InitializeProbeFunc func(clientName string, meta map[string]any) bool
+
 // Option configures the Qilin instance.
 type Option func(*Qilin)
 
@@ -213,6 +389,72 @@ func WithVersion(version string) Option {
 	}
 }
 
+// WithInstructions sets the instructions describing how to use the server, sent on initialization.
+func WithInstructions(instructions string) Option {
+	return func(q *Qilin) {
+		q.instructions = instructions
+	}
+}
+
+// WithInstructionsFunc sets the function returning the instructions for the capabilities of the connecting client.
+// It takes precedence over WithInstructions on initialization.
+//
+//	q := qilin.New("example", qilin.WithInstructionsFunc(func(c qilin.ClientCapabilities) string {
+//		if c.Sampling != nil {
+//			return "Use the summarize tool to let the client summarize the documents"
+//		}
+//		return "Use the search tool to find the documents"
+//	}))
+func WithInstructionsFunc(f func(ClientCapabilities) string) Option {
+	return func(q *Qilin) {
+		q.instructionsFunc = f
+	}
+}
+
+// WithAudioDowngrade sends the audio content of ToolContext.Audio as an embedded blob resource at the URI returned by uri
+// to the clients negotiated a protocol version older than 2025-03-26, which does not support audio content.
+// ToolContext.Audio fails with ErrInvalidResourceURI if uri returns nil.
+//
+// By default, ToolContext.Audio fails with ErrAudioNotSupported for such clients.
+func WithAudioDowngrade(uri func(c ToolContext) *url.URL) Option {
+	return func(q *Qilin) {
+		q.audioDowngrade = uri
+	}
+}
+
+// WithResponseInterceptor sets the function to inspect and replace the result of every JSON-RPC method
+// except `initialize` before it is sent, such as to redact fields or to add `_meta`.
+//
+// Unlike the middlewares, it sees the result already built by the handler and the middlewares.
+// It is not called when the method fails.
+func WithResponseInterceptor(f func(method string, result any) any) Option {
+	return func(q *Qilin) {
+		q.responseInterceptor = f
+	}
+}
+
+// WithErrorRedaction hides the details of the errors without the JSON-RPC error code,
+// such as the one returned by the handlers, from the clients.
+//
+// The client receives the internal error with a correlation ID instead,
+// and the full error is logged via slog with the same correlation ID.
+// The errors with the JSON-RPC error code, such as jsonrpc2.ErrInvalidParams, are sent as is.
+func WithErrorRedaction() Option {
+	return func(q *Qilin) {
+		q.errorRedaction = true
+	}
+}
+
+// WithSelfDescriptionResource serves the resource describing the server at the given URI.
+// It returns the name, the version, the instructions and the inventory of the tools, the resources and the prompts as JSON.
+//
+//	q := qilin.New("weather", qilin.WithSelfDescriptionResource("self://description"))
+func WithSelfDescriptionResource(uri string) Option {
+	return func(q *Qilin) {
+		q.selfDescriptionURI = uri
+	}
+}
+
 // WithJSONUnmarshalFunc sets the JSON unmarshal function.
 func WithJSONUnmarshalFunc(f JSONUnmarshalFunc) Option {
 	return func(q *Qilin) {
@@ -221,12 +463,73 @@ func WithJSONUnmarshalFunc(f JSONUnmarshalFunc) Option {
 }
 
 // WithJSONMarshalFunc sets the JSON marshal function.
+//
+// A panic of the function while producing a content is returned from the content method as ErrEncoderPanicked.
 func WithJSONMarshalFunc(f JSONMarshalFunc) Option {
 	return func(q *Qilin) {
 		q.jsonMarshalFunc = f
 	}
 }
 
+// WithContentEncoder sets the function to encode the binary data of the contents of the mime type,
+// such as a specialized encoding for a proprietary format, instead of base64.
+// It applies to the image, the audio and the blob contents, and the other mime types are still encoded with base64.
+//
+// A panic of the function while producing a content is returned from the content method as ErrEncoderPanicked.
+func WithContentEncoder(mimeType string, enc ContentEncoderFunc) Option {
+	return func(q *Qilin) {
+		if q.contentEncoders == nil {
+			q.contentEncoders = make(map[string]ContentEncoderFunc)
+		}
+		q.contentEncoders[mimeType] = enc
+	}
+}
+
+// WithResourceChecksum attaches the checksum of the raw bytes of each resource content read,
+// computed by f, to `_meta.checksum` of the content along with the algorithm, so that the clients caching the contents can verify the integrity.
+//
+// The checksum is computed before the encoding, over the text or the binary data; for the range reads, over the bytes of the range.
+//
+//	q := qilin.New("example", qilin.WithResourceChecksum("sha256", qilin.SHA256Hex))
+func WithResourceChecksum(algorithm string, f ChecksumFunc) Option {
+	return func(q *Qilin) {
+		q.resourceChecksum = &resourceChecksum{algorithm: algorithm, f: f}
+	}
+}
+
+// SHA256Hex is the ChecksumFunc returning the hex-encoded SHA-256 of the data.
+func SHA256Hex(data []byte) string {
+	return sha256Hex(data)
+}
+
+// WithDefaultTextMimeType sets the MIME type of the plain text contents, unless specified by the handler or the resource.
+// Default is "text/plain".
+func WithDefaultTextMimeType(mimeType string) Option {
+	return func(q *Qilin) {
+		q.defaultMimeTypes.text = mimeType
+	}
+}
+
+// WithDefaultJSONMimeType sets the MIME type of the JSON contents, unless specified by the handler or the resource.
+// Default is "application/json".
+func WithDefaultJSONMimeType(mimeType string) Option {
+	return func(q *Qilin) {
+		q.defaultMimeTypes.json = mimeType
+	}
+}
+
+// WithObserverRestart restarts the resource change observers and the resource list change observers
+// after they panicked, such as on a panic of the API client.
+// Without it, the panicked observers are logged and stop observing.
+//
+//   - backoff: returns the wait before the given restart, starting from 1. nil means no wait.
+func WithObserverRestart(backoff func(restart int) time.Duration) Option {
+	return func(q *Qilin) {
+		q.observerRestart = true
+		q.observerRestartBackoff = backoff
+	}
+}
+
 // WithNowFunc sets the function to get the current time.
 func WithNowFunc(f NowFunc) Option {
 	return func(q *Qilin) {
@@ -234,6 +537,261 @@ func WithNowFunc(f NowFunc) Option {
 	}
 }
 
+// WithArgumentHasher sets the function to hash the arguments into the cache keys, such as of WithToolIdempotencyCache.
+// The function receives the compacted JSON arguments, and must return the same hash for the same input.
+//
+// The default is the hex-encoded SHA-256. A fast non-cryptographic hash such as xxhash can be used
+// if the collisions are acceptable.
+func WithArgumentHasher(f ArgumentHashFunc) Option {
+	return func(q *Qilin) {
+		q.argumentHasher = f
+	}
+}
+
+// WithSchemaValidator sets the validator of the tool arguments, called in tools/call before the tool handler.
+//
+// By default, the arguments are not validated.
+// The tools without the input schema are not validated.
+func WithSchemaValidator(v SchemaValidator) Option {
+	return func(q *Qilin) {
+		q.schemaValidator = v
+	}
+}
+
+// WithRequiredArgumentsCheck makes tools/call and prompts/get fail with InvalidParamsError
+// when the required arguments are missing.
+//
+// For tools, the required properties of the input schema are checked unless WithSchemaValidator is set.
+// Note that invopop/jsonschema marks every field without `omitempty` as required.
+func WithRequiredArgumentsCheck() Option {
+	return func(q *Qilin) {
+		q.requiredArgumentsChecked = true
+	}
+}
+
+// WithJSONIndent makes outgoing JSON-RPC messages pretty-printed with the given prefix and indent.
+//
+// This is intended for debugging.
+// It only takes effect on the streamable transport, since stdio messages must not contain embedded newlines.
+// Contents such as text and blob are string values, so they are not affected.
+func WithJSONIndent(prefix, indent string) Option {
+	return func(q *Qilin) {
+		q.jsonIndent = &jsonIndent{
+			prefix: prefix,
+			indent: indent,
+		}
+	}
+}
+
+// WithWireTrace writes the trace of every incoming and outgoing JSON-RPC message to w,
+// with the timestamp given by NowFunc and the direction (`<--` for incoming, `-->` for outgoing), followed by the indented message.
+//
+// This is intended for debugging, such as diagnosing the quirks of a client. It takes effect on any transport.
+// Writes to w are serialized, and the errors are ignored.
+func WithWireTrace(w io.Writer) Option {
+	return func(q *Qilin) {
+		q.wireTrace = w
+	}
+}
+
+// WithExperimentalNegotiator sets the function deciding the experimental capabilities advertised to the client on initialize,
+// from the experimental capabilities of the client.
+//
+// The negotiated capabilities are held per session, and available via Qilin.ExperimentalCapabilities.
+func WithExperimentalNegotiator(f func(clientExperimental map[string]any) map[string]any) Option {
+	return func(q *Qilin) {
+		q.experimentalNegotiator = f
+	}
+}
+
+// WithInitializeProbe sets the function to detect the initialize only probing the capabilities, such as by the capability-discovery clients.
+//
+// The probe is answered with the capabilities as usual, but without starting the session, so the response has no session ID.
+// The connection is left uninitialized, and the client initializes it again to use the server.
+//
+//	q := qilin.New("example", qilin.WithInitializeProbe(qilin.MetaProbe))
+func WithInitializeProbe(f InitializeProbeFunc) Option {
+	return func(q *Qilin) {
+		q.initializeProbe = f
+	}
+}
+
+// MetaProbe is the InitializeProbeFunc detecting the initialize with `_meta.probe` set to true.
+func MetaProbe(_ string, meta map[string]any) bool {
+	probe, _ := meta["probe"].(bool)
+	return probe
+}
+
+// WithStrictCapabilities makes Start fail with ErrCapabilityMismatch when the advertised capabilities lack the handlers,
+// such as a resource change observer for the URI no resource is registered at.
+// Without it, the mismatches are logged as warnings.
+func WithStrictCapabilities() Option {
+	return func(q *Qilin) {
+		q.strictCapabilities = true
+	}
+}
+
+// WithStreamingDisabled disables resource subscriptions and list change notifications,
+// so that the server never switches the connection to the stream.
+//
+// It is intended for stateless HTTP deployments behind a gateway that doesn't support SSE.
+// `Subscribe` and `ListChanged` are omitted from the advertised capabilities,
+// and `resources/subscribe` fails with jsonrpc2.ErrMethodNotFound.
+// On the Streamable HTTP, ToolContext.Stream fails with ErrToolStreamNotSupported and the progress is dropped.
+// ToolContext.Elicit fails with ErrElicitationNotSupported.
+func WithStreamingDisabled() Option {
+	return func(q *Qilin) {
+		q.streamingDisabled = true
+	}
+}
+
+// WithResourceTemplatesPageSize paginates `resources/templates/list` by the given number of resource templates.
+// By default, all the resource templates are returned in a single page.
+func WithResourceTemplatesPageSize(n int) Option {
+	return func(q *Qilin) {
+		q.resourceTemplatesPageSize = n
+	}
+}
+
+// WithMaxResponseBytes limits the size of the result of `tools/call` and `resources/read` to n bytes of JSON,
+// so that an enormous payload does not overwhelm the client.
+// The result exceeding the limit fails with ErrResponseTooLarge, or is truncated with ResponseLimitTruncate.
+// By default, the results are unlimited.
+func WithMaxResponseBytes(n int, mode ResponseLimitMode) Option {
+	return func(q *Qilin) {
+		q.maxResponseBytes = n
+		q.responseLimitMode = mode
+	}
+}
+
+// WithMaxSubscriptionsPerSession limits the number of the live resource subscriptions per session.
+// `resources/subscribe` beyond the limit fails with ErrTooManySubscriptions.
+// By default, the subscriptions are unlimited.
+func WithMaxSubscriptionsPerSession(n int) Option {
+	return func(q *Qilin) {
+		q.maxSubscriptionsPerSession = n
+	}
+}
+
+// WithResourceListCache caches the result of the resource list handler for the given TTL,
+// so that repeated `resources/list` requests within the window reuse a snapshot.
+//
+// The snapshot is taken per session, since the resource list handler may filter the resources by the principal.
+// It is invalidated on ResourceListChangeContext.Publish.
+func WithResourceListCache(ttl time.Duration) Option {
+	return func(q *Qilin) {
+		q.resourceListCache = &resourceListCache{
+			ttl:       ttl,
+			snapshots: make(map[string]resourceListSnapshot),
+		}
+	}
+}
+
+// WithResourceReadCache caches the result of reading each resource for the given TTL,
+// so that repeated `resources/read` requests within the window skip the resource handler.
+//
+// The cache is keyed by the session and the URI read, such that `weather://forecast/tokyo` and `weather://forecast/london` are cached separately,
+// and never shared across sessions, since the handler and the resource middleware may depend on the principal.
+// It is invalidated on ResourceChangeContext.Publish of the matching URIs.
+// The range reads are never cached.
+func WithResourceReadCache(ttl time.Duration) Option {
+	return func(q *Qilin) {
+		q.resourceReadCache = &resourceReadCache{
+			ttl:     ttl,
+			entries: make(map[resourceReadCacheKey]resourceReadCacheEntry),
+		}
+	}
+}
+
+// WithToolIdempotencyCache caches the results of the tools annotated with `IdempotentHint` for the given TTL,
+// so that repeated identical calls within a session are served from the cache.
+//
+// The cache is keyed by the session, the tool name and the hash of the arguments. Non-idempotent tools are never cached.
+func WithToolIdempotencyCache(ttl time.Duration) Option {
+	return func(q *Qilin) {
+		q.toolIdempotencyCache = &toolIdempotencyCache{
+			ttl:     ttl,
+			entries: make(map[string]toolIdempotencyCacheEntry),
+		}
+	}
+}
+
+// WithPrewarmPools seeds each context pool with n objects on Start,
+// so the first burst of requests does not pay for the allocations.
+func WithPrewarmPools(n int) Option {
+	return func(q *Qilin) {
+		q.prewarmPools = n
+	}
+}
+
+// WithToolNameNormalizer sets the function to normalize the tool name at registration.
+// The normalized name is validated and used as the tool name.
+func WithToolNameNormalizer(f ToolNameNormalizerFunc) Option {
+	return func(q *Qilin) {
+		q.toolNameNormalizer = f
+	}
+}
+
+// JSON Schema drafts for WithToolSchemaVersion.
+const (
+	// SchemaDraft202012 is the `$schema` of JSON Schema draft 2020-12.
+	SchemaDraft202012 = "https://json-schema.org/draft/2020-12/schema"
+	// SchemaDraft07 is the `$schema` of JSON Schema draft-07.
+	SchemaDraft07 = "http://json-schema.org/draft-07/schema#"
+)
+
+// WithToolSchemaVersion sets the `$schema` of the generated input schemas of the tools,
+// such as SchemaDraft202012, for the clients requiring a specific draft. By default, `$schema` is omitted.
+func WithToolSchemaVersion(version string) Option {
+	return func(q *Qilin) {
+		q.toolSchemaVersion = version
+	}
+}
+
+// WithReadOnly makes the server read-only, applying ReadOnlyGuardMiddleware to every tool registered afterward.
+//
+// It lets a dry-run server expose a safe subset of tools without deleting the others.
+func WithReadOnly() Option {
+	return func(q *Qilin) {
+		q.readOnly = true
+	}
+}
+
+// WithURIRewriter rewrites the resource URIs requested by `resources/read`, `resources/subscribe` and
+// `resources/unsubscribe` before matching them with the registered resources,
+// such as to migrate `old://` to `new://` for backward compatibility.
+// If the rewriter returns nil, the URI is used as is.
+func WithURIRewriter(rewriter func(*url.URL) *url.URL) Option {
+	return func(q *Qilin) {
+		q.uriRewriter = rewriter
+	}
+}
+
+// WithInitializeTimeout closes the connection without the session if no valid `initialize` arrives within the timeout,
+// so that a client opening a connection and sending nothing, such as a slow-loris attack, does not tie up the resources.
+// zero means no limit.
+func WithInitializeTimeout(timeout time.Duration) Option {
+	return func(q *Qilin) {
+		q.initializeTimeout = timeout
+	}
+}
+
+// WithEmptyToolContent sets the way `tools/call` handles a tool handler returning without writing any content.
+// By default, an empty text content is sent with EmptyToolContentText.
+func WithEmptyToolContent(mode EmptyToolContentMode) Option {
+	return func(q *Qilin) {
+		q.emptyToolContentMode = mode
+	}
+}
+
+// WithMaxRequestTimeout bounds the timeout the client requests via `_meta.timeoutMs`.
+// A longer timeout is clamped to the max. zero means unbounded.
+func WithMaxRequestTimeout(timeout time.Duration) Option {
+	return func(q *Qilin) {
+		q.maxRequestTimeout = timeout
+	}
+}
+
 // WithResourceSubscriptionHealthCheckInterval sets the health check interval for the resource subscription.
 func WithResourceSubscriptionHealthCheckInterval(interval time.Duration) Option {
 	return func(q *Qilin) {
@@ -276,7 +834,7 @@ func New(name string, options ...Option) *Qilin {
 		name:              name,
 		version:           "1.0.0",
 		tools:             make(map[string]Tool),
-		prompts:           make(map[string]prompt),
+		prompts:           make(map[string]Prompt),
 		jsonMarshalFunc:   json.Marshal,
 		jsonUnmarshalFunc: json.Unmarshal,
 		base64StringFunc:  base64.StdEncoding.EncodeToString,
@@ -289,9 +847,10 @@ func New(name string, options ...Option) *Qilin {
 			ctx:        context.Background(),
 			subscriber: make(map[string]ResourceListChangeSubscriber),
 		},
-		cold:    cold,
-		warming: warming,
-		nowFunc: time.Now,
+		cold:           cold,
+		warming:        warming,
+		nowFunc:        time.Now,
+		argumentHasher: sha256Hex,
 		resourceListChangeSubscriptionOptions: resourceListChangeSubscriptionOptions{
 			healthCheckInterval: time.Minute,
 		},
@@ -313,19 +872,29 @@ func New(name string, options ...Option) *Qilin {
 	for _, opt := range options {
 		opt(q)
 	}
+	q.resourceListChangeCtx.cache = q.resourceListCache
 	q.toolContextPool = sync.Pool{
 		New: func() any {
-			return newToolContext(q.jsonUnmarshalFunc, q.jsonMarshalFunc, q.base64StringFunc)
+			c := newToolContext(q.jsonUnmarshalFunc, q.jsonMarshalFunc, q.base64StringFunc)
+			c.contentEncoders = q.contentEncoders
+			c.mimeTypes = q.defaultMimeTypes
+			return c
 		},
 	}
 	q.promptContextPool = sync.Pool{
 		New: func() any {
-			return newPromptContext(q.jsonUnmarshalFunc, q.jsonMarshalFunc, q.base64StringFunc)
+			c := newPromptContext(q.jsonUnmarshalFunc, q.jsonMarshalFunc, q.base64StringFunc)
+			c.contentEncoders = q.contentEncoders
+			return c
 		},
 	}
 	q.resourceContextPool = sync.Pool{
 		New: func() any {
-			return newResourceContext(q.jsonUnmarshalFunc, q.jsonMarshalFunc, q.base64StringFunc)
+			c := newResourceContext(q.jsonUnmarshalFunc, q.jsonMarshalFunc, q.base64StringFunc)
+			c.contentEncoders = q.contentEncoders
+			c.checksum = q.resourceChecksum
+			c.mimeTypes = q.defaultMimeTypes
+			return c
 		},
 	}
 	q.resourceListContextPool = sync.Pool{
@@ -374,44 +943,173 @@ func New(name string, options ...Option) *Qilin {
 		subscriptionHealthInterval: q.resourcesSubscriptionOptions.healthCheckInterval,
 		store:                      q.resourcesSubscriptionOptions.store,
 	}
+	if q.selfDescriptionURI != "" {
+		q.resource(
+			"self_description",
+			q.selfDescriptionURI,
+			q.selfDescriptionHandler,
+			ResourceWithDescription("Describes the server and its tools, resources and prompts"),
+			ResourceWithMimeType("application/json"),
+		)
+	}
 	return q
 }
 
-type toolOptions struct {
-	description string
-	annotation  ToolAnnotations
-	middlewares []ToolMiddlewareFunc
+// selfDescriptionHandler handles reading the resource describing the server.
+func (q *Qilin) selfDescriptionHandler(c ResourceContext) error {
+	q.resourcesMu.RLock()
+	resources := make([]describedResource, 0, len(q.resources))
+	for _, v := range valuesSortedByKey(q.resources) {
+		resources = append(resources, describedResource{
+			Resource:     v,
+			Subscribable: q.subscribable(v.URI.URL()),
+		})
+	}
+	resourceTemplates := make([]describedResourceTemplate, 0, len(q.resourceTemplates))
+	for _, v := range valuesSortedByKey(q.resourceTemplates) {
+		resourceTemplates = append(resourceTemplates, describedResourceTemplate{
+			resourceTemplate: v,
+			Subscribable:     q.subscribable(v.URITemplate.URL()),
+		})
+	}
+	q.resourcesMu.RUnlock()
+	return c.JSON(selfDescription{
+		Name:              q.name,
+		Version:           q.version,
+		Instructions:      q.instructions,
+		Tools:             valuesSortedByKey(q.tools),
+		Resources:         resources,
+		ResourceTemplates: resourceTemplates,
+		Prompts:           valuesSortedByKey(q.prompts),
+	})
 }
 
-// ToolOption configures the Tool options.
-type ToolOption func(*toolOptions)
-
-// ToolWithDescription configures the Tool description.
-func ToolWithDescription(description string) ToolOption {
-	return func(o *toolOptions) {
-		o.description = description
+// subscribable reports whether the resource at the given URI has a change observer,
+// so that clients can subscribe to it. the caller must hold resourcesMu.
+func (q *Qilin) subscribable(uri *url.URL) bool {
+	if uri == nil || q.streamingDisabled {
+		return false
+	}
+	n, _, err := q.resourceNode.matching(uri)
+	if err != nil {
+		return false
 	}
+	return n.resourceChangeCtx != nil
 }
 
-// ToolWithAnnotations configures the Tool annotations.
-func ToolWithAnnotations(annotations ToolAnnotations) ToolOption {
-	return func(o *toolOptions) {
-		o.annotation = annotations
+// Tools returns the copies of the registered tools, sorted by the name.
+//
+// It is safe to call before Start, such as for generating the documentation or validating the registrations.
+func (q *Qilin) Tools() []Tool {
+	tools := valuesSortedByKey(q.tools)
+	for i, v := range tools {
+		tools[i].InputSchema = cloneSchema(v.InputSchema)
+		if v.Annotations != nil {
+			annotations := *v.Annotations
+			tools[i].Annotations = &annotations
+		}
 	}
+	return tools
 }
 
-// ToolWithMiddleware configures the Tool middleware.
-func ToolWithMiddleware(middlewares ...ToolMiddlewareFunc) ToolOption {
-	return func(o *toolOptions) {
-		slices.Reverse(middlewares)
-		o.middlewares = slices.Concat(middlewares, o.middlewares)
-	}
+// Resources returns the copies of the registered resources, including the resource templates, sorted by the URI.
+//
+// It is safe to call before Start, such as for generating the documentation or validating the registrations.
+func (q *Qilin) Resources() []Resource {
+	q.resourcesMu.RLock()
+	defer q.resourcesMu.RUnlock()
+	resources := valuesSortedByKey(q.resources)
+	for i, v := range resources {
+		resources[i].URI = v.URI.clone()
+	}
+	return resources
 }
 
-// Tool registers a new Tool with the given name and description.
+// Prompts returns the copies of the registered prompts, sorted by the name.
 //
-//   - name: the name of the Tool
-//   - req: the request schema for the Tool
+// It is safe to call before Start, such as for generating the documentation or validating the registrations.
+func (q *Qilin) Prompts() []Prompt {
+	prompts := valuesSortedByKey(q.prompts)
+	for i, v := range prompts {
+		prompts[i].Arguments = slices.Clone(v.Arguments)
+		prompts[i].Meta = maps.Clone(v.Meta)
+	}
+	return prompts
+}
+
+// cloneSchema returns a deep copy of the schema, by marshaling and unmarshaling it.
+// The schema failing the round trip is copied shallowly.
+func cloneSchema(schema *jsonschema.Schema) *jsonschema.Schema {
+	if schema == nil {
+		return nil
+	}
+	clone := &jsonschema.Schema{}
+	b, err := json.Marshal(schema)
+	if err == nil {
+		err = json.Unmarshal(b, clone)
+	}
+	if err != nil {
+		*clone = *schema
+	}
+	return clone
+}
+
+// valuesSortedByKey returns the values of the map sorted by the keys.
+func valuesSortedByKey[V any](m map[string]V) []V {
+	values := make([]V, 0, len(m))
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		values = append(values, m[k])
+	}
+	return values
+}
+
+type toolOptions struct {
+	description    string
+	annotation     ToolAnnotations
+	middlewares    []ToolMiddlewareFunc
+	lenientBinding bool
+}
+
+// ToolOption configures the Tool options.
+type ToolOption func(*toolOptions)
+
+// ToolWithDescription configures the Tool description.
+func ToolWithDescription(description string) ToolOption {
+	return func(o *toolOptions) {
+		o.description = description
+	}
+}
+
+// ToolWithLenientBinding makes ToolContext.Bind coerce the string-encoded numbers and booleans
+// into the number and boolean fields, as LLM clients often send them as strings.
+// The string fields are bound as is.
+func ToolWithLenientBinding() ToolOption {
+	return func(o *toolOptions) {
+		o.lenientBinding = true
+	}
+}
+
+// ToolWithAnnotations configures the Tool annotations.
+func ToolWithAnnotations(annotations ToolAnnotations) ToolOption {
+	return func(o *toolOptions) {
+		o.annotation = annotations
+	}
+}
+
+// ToolWithMiddleware configures the Tool middleware.
+func ToolWithMiddleware(middlewares ...ToolMiddlewareFunc) ToolOption {
+	return func(o *toolOptions) {
+		slices.Reverse(middlewares)
+		o.middlewares = slices.Concat(middlewares, o.middlewares)
+	}
+}
+
+// Tool registers a new Tool with the given name and description.
+//
+// It panics if the name is invalid or already registered. See: ErrInvalidToolName, ErrDuplicateToolName
+//
+//   - name: the name of the Tool
+//   - req: the request schema for the Tool
 //   - handler: the handler function for the Tool
 //   - options: (optional) the options for the Tool
 func (q *Qilin) Tool(name string, req any, handler ToolHandlerFunc, options ...ToolOption) {
@@ -421,6 +1119,16 @@ func (q *Qilin) Tool(name string, req any, handler ToolHandlerFunc, options ...T
 	}
 	defer q.startupMutex.Unlock()
 
+	if q.toolNameNormalizer != nil {
+		name = q.toolNameNormalizer(name)
+	}
+	if !isValidToolName(name) {
+		panic(fmt.Errorf("%w: '%s'", ErrInvalidToolName, name))
+	}
+	if _, ok := q.tools[name]; ok {
+		panic(fmt.Errorf("%w: '%s'", ErrDuplicateToolName, name))
+	}
+
 	if q.capabilities.Tools == nil {
 		q.capabilities.Tools = &ToolCapability{}
 	}
@@ -435,29 +1143,80 @@ func (q *Qilin) Tool(name string, req any, handler ToolHandlerFunc, options ...T
 	for _, m := range opts.middlewares {
 		f = m(f)
 	}
-	ref := jsonschema.Reflector{
-		Anonymous:      true,
-		DoNotReference: true,
+	if q.readOnly {
+		f = ReadOnlyGuardMiddleware()(f)
 	}
-	schema := ref.Reflect(req)
-	schema.Version = ""
-	q.tools[name] = Tool{
-		Name:        name,
-		Description: opts.description,
-		InputSchema: schema,
-		handler:     f,
+	schema := reflectSchema(req)
+	schema.Version = q.toolSchemaVersion
+	tool := Tool{
+		Name:           name,
+		Description:    opts.description,
+		InputSchema:    schema,
+		handler:        f,
+		lenientBinding: opts.lenientBinding,
+	}
+	if opts.annotation != (ToolAnnotations{}) {
+		tool.Annotations = &opts.annotation
+	}
+	q.tools[name] = tool
+}
+
+// isValidToolName reports whether the name consists of 1 to 128 ASCII letters, digits, '_', '-' and '.'.
+func isValidToolName(name string) bool {
+	if len(name) == 0 || len(name) > 128 {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '_', r == '-', r == '.':
+		default:
+			return false
+		}
 	}
+	return true
+}
+
+// SnakeCaseToolName is a ToolNameNormalizerFunc that lowercases the name and
+// replaces the characters not allowed in the tool name with '_'.
+func SnakeCaseToolName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'A' <= r && r <= 'Z':
+			return r + ('a' - 'A')
+		case 'a' <= r && r <= 'z', '0' <= r && r <= '9', r == '_', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(name))
 }
 
 type promptOptions struct {
+	title       string
 	description string
 	arguments   []PromptArgument
+	meta        map[string]any
 	middlewares []PromptMiddlewareFunc
+
+	strictArguments bool
 }
 
 // PromptOption configures the Prompt options.
 type PromptOption func(*promptOptions)
 
+// PromptWithTitle configures the Prompt title, shown to users distinctly from the name.
+func PromptWithTitle(title string) PromptOption {
+	return func(o *promptOptions) {
+		o.title = title
+	}
+}
+
+// PromptWithMeta configures the Prompt metadata, surfaced as `_meta` in prompts/list.
+func PromptWithMeta(meta map[string]any) PromptOption {
+	return func(o *promptOptions) {
+		o.meta = maps.Clone(meta)
+	}
+}
+
 // PromptWithDescription configures the Prompt description.
 func PromptWithDescription(description string) PromptOption {
 	return func(o *promptOptions) {
@@ -472,6 +1231,14 @@ func PromptWithArguments(arguments ...PromptArgument) PromptOption {
 	}
 }
 
+// PromptWithStrictArguments makes PromptContext.Bind reject the arguments not declared by PromptWithArguments
+// with InvalidParamsError.
+func PromptWithStrictArguments() PromptOption {
+	return func(o *promptOptions) {
+		o.strictArguments = true
+	}
+}
+
 // PromptWithMiddleware configures the Prompt middleware.
 func PromptWithMiddleware(middlewares ...PromptMiddlewareFunc) PromptOption {
 	return func(o *promptOptions) {
@@ -483,6 +1250,8 @@ func PromptWithMiddleware(middlewares ...PromptMiddlewareFunc) PromptOption {
 
 // Prompt registers a new prompt template with the given name and description.
 //
+// It panics if the name is already registered. See: ErrDuplicatePromptName
+//
 //   - name: the name of the prompt
 //   - handler: the handler function for the prompt
 //   - options: (optional) the options for the prompt
@@ -493,6 +1262,10 @@ func (q *Qilin) Prompt(name string, handler PromptHandlerFunc, options ...Prompt
 	}
 	defer q.startupMutex.Unlock()
 
+	if _, ok := q.prompts[name]; ok {
+		panic(fmt.Errorf("%w: '%s'", ErrDuplicatePromptName, name))
+	}
+
 	if q.capabilities.Prompts == nil {
 		q.capabilities.Prompts = &PromptCapability{}
 	}
@@ -508,20 +1281,154 @@ func (q *Qilin) Prompt(name string, handler PromptHandlerFunc, options ...Prompt
 		f = m(f)
 	}
 
-	q.prompts[name] = prompt{
+	q.prompts[name] = Prompt{
 		Name:        name,
+		Title:       opts.title,
 		Description: opts.description,
 		Arguments:   opts.arguments,
+		Meta:        opts.meta,
 		handler:     f,
+
+		strictArguments: opts.strictArguments,
+	}
+}
+
+type groupOptions struct {
+	namePrefix          string
+	uriPrefix           string
+	toolMiddlewares     []ToolMiddlewareFunc
+	promptMiddlewares   []PromptMiddlewareFunc
+	resourceMiddlewares []ResourceMiddlewareFunc
+}
+
+// GroupOption configures the Group options.
+type GroupOption func(*groupOptions)
+
+// GroupWithNamePrefix configures the prefix of the names of the tools, the resources and the prompts, such as `billing.`.
+// The prefix is prepended as is, so it should end with the separator.
+func GroupWithNamePrefix(prefix string) GroupOption {
+	return func(o *groupOptions) {
+		o.namePrefix = prefix
+	}
+}
+
+// GroupWithURIPrefix configures the prefix of the URIs of the resources, such as `billing://`.
+// The prefix is prepended as is.
+func GroupWithURIPrefix(prefix string) GroupOption {
+	return func(o *groupOptions) {
+		o.uriPrefix = prefix
+	}
+}
+
+// GroupWithToolMiddleware configures the middleware shared by the tools of the Group.
+// It runs inside the middleware added by UseInTools, and outside the one configured by ToolWithMiddleware.
+func GroupWithToolMiddleware(middlewares ...ToolMiddlewareFunc) GroupOption {
+	return func(o *groupOptions) {
+		o.toolMiddlewares = append(o.toolMiddlewares, middlewares...)
+	}
+}
+
+// GroupWithPromptMiddleware configures the middleware shared by the prompts of the Group.
+// It runs inside the middleware added by UseInPrompts, and outside the one configured by PromptWithMiddleware.
+func GroupWithPromptMiddleware(middlewares ...PromptMiddlewareFunc) GroupOption {
+	return func(o *groupOptions) {
+		o.promptMiddlewares = append(o.promptMiddlewares, middlewares...)
+	}
+}
+
+// GroupWithResourceMiddleware configures the middleware shared by the resources of the Group.
+// It runs inside the middleware added by UseInResources, and outside the one configured by ResourceWithMiddleware.
+func GroupWithResourceMiddleware(middlewares ...ResourceMiddlewareFunc) GroupOption {
+	return func(o *groupOptions) {
+		o.resourceMiddlewares = append(o.resourceMiddlewares, middlewares...)
+	}
+}
+
+// Group registers a set of the related tools, resources and prompts with the shared prefixes and middleware,
+// like the router groups in web frameworks, so the servers composed from modules do not collide.
+type Group struct {
+	qilin *Qilin
+	// parent is the Group g is nested in. nil if g is created by Qilin.Group.
+	parent *Group
+	opts   groupOptions
+}
+
+// Group creates a Group registering the tools, the resources and the prompts with the shared options.
+//
+//   - options: (optional) the options for the Group
+func (q *Qilin) Group(options ...GroupOption) *Group {
+	g := &Group{qilin: q}
+	for _, o := range options {
+		o(&g.opts)
+	}
+	return g
+}
+
+// Group creates a nested Group. Its prefixes follow the ones of g, and its middleware runs inside the one of g.
+func (g *Group) Group(options ...GroupOption) *Group {
+	child := g.qilin.Group(options...)
+	child.parent = g
+	return child
+}
+
+// Tool registers a new Tool with the prefixed name and the middleware of the Group. See: Qilin.Tool
+func (g *Group) Tool(name string, req any, handler ToolHandlerFunc, options ...ToolOption) {
+	name = g.opts.namePrefix + name
+	if len(g.opts.toolMiddlewares) > 0 {
+		options = append(slices.Clone(options), ToolWithMiddleware(slices.Clone(g.opts.toolMiddlewares)...))
+	}
+	if g.parent != nil {
+		g.parent.Tool(name, req, handler, options...)
+		return
+	}
+	g.qilin.Tool(name, req, handler, options...)
+}
+
+// Prompt registers a new prompt template with the prefixed name and the middleware of the Group. See: Qilin.Prompt
+func (g *Group) Prompt(name string, handler PromptHandlerFunc, options ...PromptOption) {
+	name = g.opts.namePrefix + name
+	if len(g.opts.promptMiddlewares) > 0 {
+		options = append(slices.Clone(options), PromptWithMiddleware(g.opts.promptMiddlewares...))
+	}
+	if g.parent != nil {
+		g.parent.Prompt(name, handler, options...)
+		return
+	}
+	g.qilin.Prompt(name, handler, options...)
+}
+
+// Resource registers a new resource with the prefixed name and URI, and the middleware of the Group. See: Qilin.Resource
+func (g *Group) Resource(name, uri string, handler ResourceHandlerFunc, options ...ResourceOption) {
+	name, uri = g.opts.namePrefix+name, g.opts.uriPrefix+uri
+	if len(g.opts.resourceMiddlewares) > 0 {
+		options = append(slices.Clone(options), ResourceWithMiddleware(slices.Clone(g.opts.resourceMiddlewares)...))
+	}
+	if g.parent != nil {
+		g.parent.Resource(name, uri, handler, options...)
+		return
 	}
+	g.qilin.Resource(name, uri, handler, options...)
 }
 
 type resourceOptions struct {
 	description string
 	mimeType    string
+	size        int64
+	timeout     time.Duration
 	middlewares []ResourceMiddlewareFunc
+
+	lastModified        time.Time
+	reportsLastModified bool
+
+	paramEnums map[string][]string
+
+	subscribeGuard ResourceSubscribeGuardFunc
 }
 
+// ResourceSubscribeGuardFunc decides whether the session may subscribe to the resource at the uri.
+// A non-nil error rejects the subscription, and is returned to the client.
+type ResourceSubscribeGuardFunc func(ctx context.Context, uri *url.URL, sessionID string) error
+
 // ResourceOption configures the resource options.
 type ResourceOption func(*resourceOptions)
 
@@ -539,6 +1446,55 @@ func ResourceWithMimeType(mimeType string) ResourceOption {
 	}
 }
 
+// ResourceWithSize configures the resource size in bytes.
+func ResourceWithSize(size int64) ResourceOption {
+	return func(o *resourceOptions) {
+		o.size = size
+	}
+}
+
+// ResourceWithTimeout configures the timeout of reading the resource.
+// The context of the handler is canceled when the timeout elapses.
+func ResourceWithTimeout(timeout time.Duration) ResourceOption {
+	return func(o *resourceOptions) {
+		o.timeout = timeout
+	}
+}
+
+// ResourceWithLastModified makes reading and listing the resource report `lastModified`.
+// If t is zero, the current time given by NowFunc is reported.
+//
+// The time set by ResourceContext.SetLastModified takes precedence on reading.
+func ResourceWithLastModified(t time.Time) ResourceOption {
+	return func(o *resourceOptions) {
+		o.lastModified = t
+		o.reportsLastModified = true
+	}
+}
+
+// ResourceWithParamEnum declares the allowed values of the path parameter of the resource template, such as `{unit}`.
+//
+// The values are listed in `_meta.paramEnums` of the template, and offered by `completion/complete`.
+// Reading the resource with the other value fails with jsonrpc2.ErrInvalidParams.
+// It panics with ErrUnknownTemplateParam on registration if the URI template has no such parameter.
+func ResourceWithParamEnum(param string, values ...string) ResourceOption {
+	return func(o *resourceOptions) {
+		if o.paramEnums == nil {
+			o.paramEnums = make(map[string][]string)
+		}
+		o.paramEnums[param] = values
+	}
+}
+
+// ResourceWithSubscribeGuard configures the guard consulted before subscribing to the resource,
+// such as to reject the subscription for the principal not allowed to observe the resource.
+// The rejected subscription sets up no subscriber.
+func ResourceWithSubscribeGuard(guard ResourceSubscribeGuardFunc) ResourceOption {
+	return func(o *resourceOptions) {
+		o.subscribeGuard = guard
+	}
+}
+
 // ResourceWithMiddleware configures the resource middleware.
 func ResourceWithMiddleware(middlewares ...ResourceMiddlewareFunc) ResourceOption {
 	return func(o *resourceOptions) {
@@ -560,7 +1516,108 @@ func (q *Qilin) Resource(name, uri string, handler ResourceHandlerFunc, options
 		panic(ErrQilinLockingConflicts)
 	}
 	defer q.startupMutex.Unlock()
+	q.resourcesMu.Lock()
+	defer q.resourcesMu.Unlock()
+	q.resource(name, uri, handler, options...)
+}
+
+// AddResource registers a resource while serving, and publishes the resource list change.
+// It takes the same parameters as Resource, and is safe to call concurrently with the requests.
+// The middleware registered by UseInResources is applied to the handler as in Start.
+func (q *Qilin) AddResource(name, uri string, handler ResourceHandlerFunc, options ...ResourceOption) {
+	q.resourcesMu.Lock()
+	q.resource(name, uri, handler, options...)
+	q.resourcesMu.Unlock()
+	if resourceURI, err := url.Parse(uri); err == nil {
+		q.resourceReadCache.invalidate(resourceURI)
+	}
+	q.resourceListChangeCtx.Publish(q.nowFunc())
+}
+
+// RemoveResource unregisters the resource while serving, and publishes the resource list change.
+// The subscribers of the resource are notified of the deletion. The reads already in flight are not affected.
+//
+// The uri must be the one registered, such as the URI template itself. It does nothing if no resource is registered at the uri.
+func (q *Qilin) RemoveResource(uri string) error {
+	resourceURI, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	q.resourcesMu.Lock()
+	// the concrete uri must not remove the template matching it.
+	n := q.resourceNode.route(resourceURI)
+	if n == nil || n.handler == nil {
+		q.resourcesMu.Unlock()
+		return nil
+	}
+	n.handler = nil
+	n.name = ""
+	n.description = ""
+	n.timeout = 0
+	n.lastModified = time.Time{}
+	n.reportsLastModified = false
+	n.paramEnums = nil
+	n.subscribeGuard = nil
+	delete(q.resources, resourceURI.String())
+	if isTemplateURI(resourceURI) {
+		delete(q.resourceTemplates, resourceURI.String())
+	}
+	changeCtx := n.resourceChangeCtx
+	q.resourcesMu.Unlock()
+	q.resourceReadCache.invalidate(resourceURI)
+
+	if changeCtx != nil {
+		// PublishDeleted also publishes the resource list change.
+		changeCtx.PublishDeleted(resourceURI, q.nowFunc())
+		return nil
+	}
+	q.resourceListChangeCtx.Publish(q.nowFunc())
+	return nil
+}
+
+// applyResourceMiddleware binds the resource change contexts to the root context,
+// and applies resourceMiddleware to the registered handlers.
+// The resources registered after this are wrapped by resource.
+func (q *Qilin) applyResourceMiddleware() {
+	q.resourcesMu.Lock()
+	defer q.resourcesMu.Unlock()
+	for v := range q.resourceNode.flattenIter() {
+		switch rcCtx := v.resourceChangeCtx.(type) {
+		case *resourceChangeContext:
+			rcCtx.ctx = q.rootCtx
+		}
+		if v.handler == nil {
+			continue
+		}
+		for _, middleware := range q.resourceMiddleware {
+			v.handler = middleware(v.handler)
+		}
+	}
+	q.resourceMiddlewareApplied = true
+}
+
+// currentCapabilities returns the server capabilities, which AddResource may change while serving.
+func (q *Qilin) currentCapabilities() ServerCapabilities {
+	q.resourcesMu.RLock()
+	defer q.resourcesMu.RUnlock()
+	return q.capabilities
+}
+
+// matchResource finds the resource route matching the uri.
+// It returns a copy of the route, so that the route can be used after the resource is changed.
+func (q *Qilin) matchResource(uri *url.URL) (resourceNode, map[string]string, error) {
+	q.resourcesMu.RLock()
+	defer q.resourcesMu.RUnlock()
+	n, params, err := q.resourceNode.matching(uri)
+	if err != nil {
+		return resourceNode{}, nil, err
+	}
+	return *n, params, nil
+}
 
+// resource registers a resource. the caller must hold resourcesMu.
+// Once Start has applied resourceMiddleware, it is applied to the handler here as well.
+func (q *Qilin) resource(name, uri string, handler ResourceHandlerFunc, options ...ResourceOption) {
 	if q.capabilities.Resources == nil {
 		q.capabilities.Resources = &ResourceCapability{}
 	}
@@ -571,43 +1628,112 @@ func (q *Qilin) Resource(name, uri string, handler ResourceHandlerFunc, options
 	}
 
 	f := handler
+	slices.Reverse(opts.middlewares)
 	for _, m := range opts.middlewares {
 		f = m(f)
 	}
+	if q.resourceMiddlewareApplied {
+		for _, m := range q.resourceMiddleware {
+			f = m(f)
+		}
+	}
 	resourceURI, err := url.Parse(uri)
 	if err != nil {
 		panic(err)
 	}
-	if slices.ContainsFunc(strings.Split(resourceURI.Path, "/"), func(s string) bool {
-		return strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}")
-	}) {
-		q.resourceTemplates[resourceURI.Path] = resourceTemplate{
-			URITemplate: (*ResourceURI)(resourceURI),
+	segments := resourcePathSegments(resourceURI.Path)
+	for param := range opts.paramEnums {
+		if !slices.Contains(segments, "{"+param+"}") {
+			panic(fmt.Errorf("%w: '%s' in '%s'", ErrUnknownTemplateParam, param, uri))
+		}
+	}
+	if isTemplateURI(resourceURI) {
+		var meta *resourceTemplateMeta
+		if len(opts.paramEnums) > 0 {
+			meta = &resourceTemplateMeta{ParamEnums: opts.paramEnums}
+			q.capabilities.Completions = &CompletionsCapability{}
+		}
+		q.resourceTemplates[resourceURI.String()] = resourceTemplate{
+			URITemplate: resourceURIFromRaw(resourceURI, uri),
 			Name:        name,
 			Description: opts.description,
 			MimeType:    opts.mimeType,
+			Meta:        meta,
 		}
 	}
 	n, _, _ := q.resourceNode.matching(resourceURI)
 	if n != nil {
-		n.handler = handler
+		n.handler = f
+		n.name = name
+		n.mimeType = opts.mimeType
+		n.description = opts.description
+		n.timeout = opts.timeout
+		n.lastModified = opts.lastModified
+		n.reportsLastModified = opts.reportsLastModified
+		n.paramEnums = opts.paramEnums
+		n.subscribeGuard = opts.subscribeGuard
 		r := q.resources[resourceURI.String()]
-		r.URI = (*ResourceURI)(resourceURI)
+		r.URI = resourceURIFromRaw(resourceURI, uri)
 		r.Name = name
 		r.Description = opts.description
 		r.MimeType = opts.mimeType
+		r.Size = opts.size
+		r.LastModified = opts.lastModified
+		r.reportsLastModified = opts.reportsLastModified
 		q.resources[resourceURI.String()] = r
 		return
 	}
-	q.resourceNode.addRoute(resourceURI, handler, opts.mimeType)
+	n = q.resourceNode.addRoute(resourceURI, f, opts.mimeType)
+	n.name = name
+	n.description = opts.description
+	n.timeout = opts.timeout
+	n.lastModified = opts.lastModified
+	n.reportsLastModified = opts.reportsLastModified
+	n.paramEnums = opts.paramEnums
+	n.subscribeGuard = opts.subscribeGuard
 	q.resources[resourceURI.String()] = Resource{
-		URI:         (*ResourceURI)(resourceURI),
-		Name:        name,
-		Description: opts.description,
-		MimeType:    opts.mimeType,
+		URI:                 resourceURIFromRaw(resourceURI, uri),
+		Name:                name,
+		Description:         opts.description,
+		MimeType:            opts.mimeType,
+		Size:                opts.size,
+		LastModified:        opts.lastModified,
+		reportsLastModified: opts.reportsLastModified,
 	}
 }
 
+// ResourceFunc registers a new resource with the typed handler.
+// If the URI contains path parameters, it will be registered as a template resource.
+//
+// The path parameters are bound into the struct P via ResourceContext.BindParams,
+// and the result of the handler is sent via ResourceContext.JSON.
+// If the path parameters cannot be bound, the request fails with jsonrpc2.ErrInvalidParams.
+// The error returned from the handler is passed through as is.
+//
+//   - q: the Qilin instance
+//   - name: the name of the resource
+//   - uri: the URI of the resource
+//   - handler: the typed handler function for the resource
+//   - options: (optional) the options for the resource
+func ResourceFunc[P, R any](
+	q *Qilin,
+	name, uri string,
+	handler func(ctx context.Context, params P) (R, error),
+	options ...ResourceOption,
+) {
+	q.Resource(name, uri, func(c ResourceContext) error {
+		var params P
+		if err := c.BindParams(&params); err != nil {
+			return fmt.Errorf("%w: %w", jsonrpc2.ErrInvalidParams, err)
+		}
+		res, err := handler(c.Context(), params)
+		if err != nil {
+			return err
+		}
+		return c.JSON(res)
+	}, options...)
+}
+
 // ResourceList registers a new resource list handler.
 func (q *Qilin) ResourceList(
 	handler ResourceListHandlerFunc,
@@ -627,6 +1753,10 @@ func (q *Qilin) ResourceList(
 }
 
 // ResourceChangeObserver registers a resource change observer for the given URI and runs the observer function.
+//
+// The observers registered for the same URI share the ResourceChangeContext, so that each can publish the changes.
+// A nil observer only makes the resource at the URI subscribable, with the changes published by Qilin.PublishResourceChange.
+// The URI is neither subscribable nor published until it is registered by Resource, either before or after the observer.
 func (q *Qilin) ResourceChangeObserver(uri string, observer ResourceChangeObserverFunc) {
 	ok := q.startupMutex.TryLock()
 	if !ok {
@@ -636,7 +1766,7 @@ func (q *Qilin) ResourceChangeObserver(uri string, observer ResourceChangeObserv
 	if q.capabilities.Resources == nil {
 		q.capabilities.Resources = &ResourceCapability{}
 	}
-	if !q.capabilities.Resources.Subscribe {
+	if !q.capabilities.Resources.Subscribe && !q.streamingDisabled {
 		q.capabilities.Resources.Subscribe = true
 	}
 	resourceURI, err := url.Parse(uri)
@@ -645,19 +1775,22 @@ func (q *Qilin) ResourceChangeObserver(uri string, observer ResourceChangeObserv
 	}
 
 	n, _, _ := q.resourceNode.matching(resourceURI)
-	resourceChangeCtx := &resourceChangeContext{
-		ctx:        context.Background(),
-		subscriber: make(map[string]ResourceChangeSubscriber),
-	}
-	if n != nil {
-		n.resourceChangeCtx = resourceChangeCtx
-	} else {
-		q.resourceNode.addRoute(resourceURI, nil, "")
-		q.resources[resourceURI.String()] = Resource{
-			URI: (*ResourceURI)(resourceURI),
+	if n == nil {
+		// the route is only for the subscriptions. it is listed once registered as a resource.
+		n = q.resourceNode.addRoute(resourceURI, nil, "")
+	}
+	if n.resourceChangeCtx == nil {
+		n.resourceChangeCtx = &resourceChangeContext{
+			ctx:           context.Background(),
+			subscriber:    make(map[string]ResourceChangeSubscriber),
+			listChangeCtx: q.resourceListChangeCtx,
+			readCache:     q.resourceReadCache,
 		}
 	}
-	q.handleResourceChangeObserver(observer, resourceChangeCtx)
+	q.observedResourceURIs = append(q.observedResourceURIs, resourceURI)
+	if observer != nil {
+		q.handleResourceChangeObserver(observer, n.resourceChangeCtx)
+	}
 }
 
 // ResourceListChangeObserver registers a resource list change observer and runs the observer function.
@@ -670,12 +1803,61 @@ func (q *Qilin) ResourceListChangeObserver(observer ResourceListChangeObserverFu
 	if q.capabilities.Resources == nil {
 		q.capabilities.Resources = &ResourceCapability{}
 	}
-	if !q.capabilities.Resources.ListChanged {
+	if !q.capabilities.Resources.ListChanged && !q.streamingDisabled {
 		q.capabilities.Resources.ListChanged = true
 	}
 	q.handleResourceListChangeObserver(observer, q.resourceListChangeCtx)
 }
 
+// capabilityMismatches reports the advertised capabilities lacking the handlers, each wrapping ErrCapabilityMismatch.
+func (q *Qilin) capabilityMismatches() []error {
+	var errs []error
+	if q.capabilities.Resources != nil && len(q.resources) == 0 {
+		errs = append(errs, fmt.Errorf("%w: resources are advertised, but no resource is registered", ErrCapabilityMismatch))
+	}
+	for _, uri := range q.observedResourceURIs {
+		if _, _, err := q.resourceNode.matching(uri); err != nil {
+			errs = append(errs, fmt.Errorf("%w: changes of '%s' are observed, but no resource is registered at the uri", ErrCapabilityMismatch, uri))
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(q.resources)) {
+		resourceURI, err := url.Parse(key)
+		if err != nil {
+			continue
+		}
+		// the resource without the handler is never matched.
+		if _, _, err := q.resourceNode.matching(resourceURI); err != nil {
+			errs = append(errs, fmt.Errorf("%w: resource '%s' is listed, but has no handler", ErrCapabilityMismatch, key))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(q.prompts)) {
+		if q.prompts[name].handler == nil {
+			errs = append(errs, fmt.Errorf("%w: prompt '%s' is listed, but has no handler", ErrCapabilityMismatch, name))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(q.tools)) {
+		if q.tools[name].handler == nil {
+			errs = append(errs, fmt.Errorf("%w: tool '%s' is listed, but has no handler", ErrCapabilityMismatch, name))
+		}
+	}
+	return errs
+}
+
+// Use adds middleware to the handler chain of every JSON-RPC method except `initialize`,
+// such as `ping`, `tools/call` and `resources/subscribe`.
+//
+// The method name and the raw request are available via jsonrpc2.Request.
+// It runs before the Tool, resource and prompt middlewares.
+func (q *Qilin) Use(middleware ...MethodMiddlewareFunc) {
+	ok := q.startupMutex.TryLock()
+	if !ok {
+		panic(ErrQilinLockingConflicts)
+	}
+	defer q.startupMutex.Unlock()
+	slices.Reverse(middleware)
+	q.methodMiddleware = slices.Concat(middleware, q.methodMiddleware)
+}
+
 // UseInTools adds middleware to the Tool handler chain.
 func (q *Qilin) UseInTools(middleware ...ToolMiddlewareFunc) {
 	ok := q.startupMutex.TryLock()
@@ -715,7 +1897,7 @@ func (q *Qilin) handleResourceChangeObserver(
 ) {
 	go func() {
 		<-q.cold.Done()
-		fn(c)
+		q.runObserver(c.Context, func() { fn(c) })
 	}()
 }
 
@@ -725,8 +1907,33 @@ func (q *Qilin) handleResourceListChangeObserver(
 ) {
 	go func() {
 		<-q.cold.Done()
-		fn(c)
+		q.runObserver(c.Context, func() { fn(c) })
+	}()
+}
+
+// runObserver runs the observer, recovering from its panic so that it does not bring down the server.
+// With WithObserverRestart, the panicked observer is restarted after the backoff, until the context is done.
+func (q *Qilin) runObserver(ctx func() context.Context, observe func()) {
+	for restart := 0; ; restart++ {
+		if restart > 0 && !waitRetry(ctx(), q.observerRestartBackoff, restart) {
+			return
+		}
+		if !observeRecovering(observe) || !q.observerRestart {
+			return
+		}
+	}
+}
+
+// observeRecovering runs the observer, and reports whether it panicked.
+func observeRecovering(observe func()) (panicked bool) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			panicked = true
+			slog.Error("[qilin] the observer panicked", slog.Any("recover", rec))
+		}
 	}()
+	observe()
+	return false
 }
 
 type startOptions struct {
@@ -803,6 +2010,15 @@ func (q *Qilin) Start(options ...StartOption) error {
 		opt(o)
 	}
 
+	if errs := q.capabilityMismatches(); len(errs) > 0 {
+		if q.strictCapabilities {
+			return errors.Join(errs...)
+		}
+		for _, err := range errs {
+			slog.Warn("[qilin] the advertised capability lacks the handler", "error", err)
+		}
+	}
+
 	if len(o.onWarm) != 0 {
 		context.AfterFunc(q.cold, func() {
 			for _, fn := range o.onWarm {
@@ -820,15 +2036,29 @@ func (q *Qilin) Start(options ...StartOption) error {
 	if o.listener == nil {
 		o.listener = transport.NewStdio(ctx)
 	}
+	if q.jsonIndent != nil {
+		switch o.listener.(type) {
+		case *transport.Streamable:
+			o.framer = newIndentFramer(o.framer, q.jsonIndent.prefix, q.jsonIndent.indent)
+		default:
+			slog.Warn("[qilin] JSON indent is only supported on the streamable transport")
+		}
+	}
+	if q.wireTrace != nil {
+		o.framer = newTraceFramer(o.framer, &wireTracer{w: q.wireTrace, now: q.nowFunc})
+	}
 	context.AfterFunc(ctx, func() {
 		_ = o.listener.Close()
 	})
 
-	for name, tool := range q.tools {
-		for _, middleware := range q.toolMiddleware {
-			tool.handler = middleware(tool.handler)
+	// skip rewriting the tools unless the middleware is registered
+	if len(q.toolMiddleware) > 0 {
+		for name, tool := range q.tools {
+			for _, middleware := range q.toolMiddleware {
+				tool.handler = middleware(tool.handler)
+			}
+			q.tools[name] = tool
 		}
-		q.tools[name] = tool
 	}
 
 	for name, prompt := range q.prompts {
@@ -838,18 +2068,7 @@ func (q *Qilin) Start(options ...StartOption) error {
 		q.prompts[name] = prompt
 	}
 
-	for v := range q.resourceNode.flattenIter() {
-		switch rcCtx := v.resourceChangeCtx.(type) {
-		case *resourceChangeContext:
-			rcCtx.ctx = q.rootCtx
-		}
-		if v.handler != nil {
-			continue
-		}
-		for _, middleware := range q.resourceMiddleware {
-			v.handler = middleware(v.handler)
-		}
-	}
+	q.applyResourceMiddleware()
 	var (
 		enabledResourceListChange bool
 		enabledResourceChange     bool
@@ -869,6 +2088,17 @@ func (q *Qilin) Start(options ...StartOption) error {
 			}
 		},
 	}
+	for _, p := range []*sync.Pool{
+		&q.toolContextPool,
+		&q.promptContextPool,
+		&q.resourceContextPool,
+		&q.resourceListContextPool,
+		&q.resourceChangeSubscriberPool,
+		&q.resourceListChangeSubscriberPool,
+		&q.handlerPool,
+	} {
+		prewarmPool(p, q.prewarmPools)
+	}
 
 	srv, err := jsonrpc2.Serve(q.rootCtx, o.listener, newBinder(q, o.preempter, o.framer))
 	if err != nil {
@@ -880,6 +2110,450 @@ func (q *Qilin) Start(options ...StartOption) error {
 
 type Notify func(ctx context.Context, method string, params interface{}) error
 
+// maxPendingNotifications is the number of the notifications notificationBuffer holds at most.
+const maxPendingNotifications = 256
+
+// notificationBuffer holds the notifications until the stream connection is ready, then sends them in order.
+// The notifications beyond maxPendingNotifications are dropped, as the response may never switch to the stream.
+type notificationBuffer struct {
+	mu      sync.Mutex
+	notify  Notify
+	ready   bool
+	pending []pendingNotification
+}
+
+// pendingNotification is a notification held by notificationBuffer.
+type pendingNotification struct {
+	ctx    context.Context
+	method string
+	params interface{}
+}
+
+// Notify See: Notify
+func (b *notificationBuffer) Notify(ctx context.Context, method string, params interface{}) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.ready {
+		if len(b.pending) < maxPendingNotifications {
+			b.pending = append(b.pending, pendingNotification{ctx: ctx, method: method, params: params})
+		}
+		return nil
+	}
+	return b.notify(ctx, method, params)
+}
+
+// release marks the stream connection as ready and sends the held notifications.
+func (b *notificationBuffer) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ready {
+		return
+	}
+	b.ready = true
+	for _, v := range b.pending {
+		if err := b.notify(v.ctx, v.method, v.params); err != nil {
+			break
+		}
+	}
+	b.pending = nil
+}
+
+// streamKeepAlive is the keep-alive of the stream the server switches the connection or the response to.
+const streamKeepAlive = 5 * time.Second
+
+// discardNotify drops the notification.
+func discardNotify(context.Context, string, interface{}) error {
+	return nil
+}
+
+// sessionStreams holds the live stream of each session.
+type sessionStreams struct {
+	mu      sync.Mutex
+	streams map[string]*sessionStream
+}
+
+// sessionStream is a live stream of a session.
+type sessionStream struct {
+	ctx    context.Context
+	notify Notify
+	calls  *serverCalls
+}
+
+// add registers the stream of the session until the ctx is done. The latest stream takes over the previous one.
+func (s *sessionStreams) add(ctx context.Context, sessionID string, notify Notify, calls *serverCalls) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.streams[sessionID]; ok && v.ctx == ctx {
+		return
+	}
+	if s.streams == nil {
+		s.streams = make(map[string]*sessionStream)
+	}
+	stream := &sessionStream{ctx: ctx, notify: notify, calls: calls}
+	s.streams[sessionID] = stream
+	context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.streams[sessionID] == stream {
+			delete(s.streams, sessionID)
+		}
+	})
+}
+
+// get returns the live stream of the session.
+func (s *sessionStreams) get(sessionID string) (*sessionStream, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.streams[sessionID]
+	return v, ok
+}
+
+// inFlightRequests holds the cancel funcs of the in-flight requests of each session.
+// On the streamable HTTP transport, the cancellation arrives on another connection than the request,
+// so it cannot be reached through the connection.
+type inFlightRequests struct {
+	mu       sync.Mutex
+	requests map[string]map[jsonrpc2.ID]*inFlightRequest
+}
+
+// inFlightRequest is an in-flight request.
+type inFlightRequest struct {
+	cancel context.CancelFunc
+}
+
+// add registers the cancel func of the request. The returned func removes it.
+func (r *inFlightRequests) add(sessionID string, id jsonrpc2.ID, cancel context.CancelFunc) (remove func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.requests == nil {
+		r.requests = make(map[string]map[jsonrpc2.ID]*inFlightRequest)
+	}
+	requests, ok := r.requests[sessionID]
+	if !ok {
+		requests = make(map[jsonrpc2.ID]*inFlightRequest)
+		r.requests[sessionID] = requests
+	}
+	req := &inFlightRequest{cancel: cancel}
+	requests[id] = req
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if requests[id] != req {
+			return
+		}
+		delete(requests, id)
+		if len(requests) == 0 {
+			delete(r.requests, sessionID)
+		}
+	}
+}
+
+// cancel cancels the in-flight request of the session.
+func (r *inFlightRequests) cancel(sessionID string, id jsonrpc2.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req, ok := r.requests[sessionID][id]; ok {
+		req.cancel()
+	}
+}
+
+// subscriptionCounter counts the live resource subscriptions of each session.
+type subscriptionCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// acquire counts up the subscriptions of the session. It returns false if the session already reaches the limit.
+// zero or less limit means unlimited.
+func (c *subscriptionCounter) acquire(sessionID string, limit int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if limit > 0 && c.counts[sessionID] >= limit {
+		return false
+	}
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+	}
+	c.counts[sessionID]++
+	return true
+}
+
+// release counts down the subscriptions of the session.
+func (c *subscriptionCounter) release(sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[sessionID] <= 1 {
+		delete(c.counts, sessionID)
+		return
+	}
+	c.counts[sessionID]--
+}
+
+// protocolVersion returns the negotiated protocol version of the session. LatestProtocolVersion if unknown.
+func (q *Qilin) protocolVersion(sessionID string) string {
+	if v, ok := q.protocolVersions.Load(sessionID); ok {
+		return v.(string)
+	}
+	return LatestProtocolVersion
+}
+
+// locale returns the locale passed by the client of the session. empty if not passed.
+func (q *Qilin) locale(sessionID string) string {
+	if v, ok := q.locales.Load(sessionID); ok {
+		return v.(string)
+	}
+	return ""
+}
+
+// Notify sends the notification to the client of the session over its live stream,
+// such as a custom experimental notification.
+//
+// The live stream is the stdio connection, or the SSE stream on the streamable HTTP transport.
+// It returns ErrNoLiveStream if the session has no live stream.
+func (q *Qilin) Notify(ctx context.Context, sessionID string, method string, params any) error {
+	stream, ok := q.sessionStreams.get(sessionID)
+	if !ok {
+		return fmt.Errorf("%w: '%s'", ErrNoLiveStream, sessionID)
+	}
+	return stream.notify(ctx, method, params)
+}
+
+// ExperimentalCapabilities returns the experimental capabilities negotiated with the client of the session
+// by WithExperimentalNegotiator. It returns false if not negotiated.
+func (q *Qilin) ExperimentalCapabilities(sessionID string) (map[string]any, bool) {
+	v, ok := q.experimentalCapabilities.Load(sessionID)
+	if !ok {
+		return nil, false
+	}
+	return v.(map[string]any), true
+}
+
+// PublishResourceChange notifies the subscribers of the resource at the uri of the change,
+// from anywhere other than the observer, such as the request handlers.
+//
+// It returns ErrResourceNotFound if no resource route matches the uri,
+// and ErrResourceNotObserved if no ResourceChangeObserver is registered for it.
+func (q *Qilin) PublishResourceChange(uri *url.URL, modifiedAt time.Time) error {
+	route, _, err := q.matchResource(uri)
+	if err != nil {
+		return fmt.Errorf("%w: '%s': %w", ErrResourceNotFound, uri, err)
+	}
+	if route.resourceChangeCtx == nil {
+		return fmt.Errorf("%w: '%s'", ErrResourceNotObserved, uri)
+	}
+	route.resourceChangeCtx.Publish(uri, modifiedAt)
+	return nil
+}
+
+// UnsubscribeAll cancels all the resource subscriptions and the resource list change subscription of the session,
+// such as on the downgrade of the authorization. The observing goroutines of the subscriptions stop.
+func (q *Qilin) UnsubscribeAll(ctx context.Context, sessionID string) error {
+	// the session without the resource subscriptions has nothing to cancel.
+	err := q.resourcesSubscriptionOptions.store.DeleteBySessionID(ctx, sessionID)
+	if err != nil && !errors.Is(err, ErrResourceModificationSubscriptionNotFound) {
+		return err
+	}
+	return q.resourceListChangeSubscriptionManager.UnsubscribeToResourceListChanges(ctx, sessionID)
+}
+
+// Ping sends the ping request to the client of the session over its live stream, and waits for the response.
+//
+// It returns ErrNoLiveStream if the session has no live stream.
+func (q *Qilin) Ping(ctx context.Context, sessionID string) error {
+	stream, ok := q.sessionStreams.get(sessionID)
+	if !ok || stream.calls == nil {
+		return fmt.Errorf("%w: '%s'", ErrNoLiveStream, sessionID)
+	}
+	_, err := stream.calls.call(ctx, MethodPing, nil)
+	return err
+}
+
+// elicit sends the elicitation request to the client of the session over its live stream, and waits for the user's response.
+func (q *Qilin) elicit(ctx context.Context, sessionID string, params elicitRequestParams) (map[string]any, error) {
+	if q.streamingDisabled {
+		return nil, fmt.Errorf("%w: streaming is disabled", ErrElicitationNotSupported)
+	}
+	v, ok := q.clientCapabilities.Load(sessionID)
+	if !ok || v.(ClientCapabilities).Elicitation == nil {
+		return nil, ErrElicitationNotSupported
+	}
+	stream, ok := q.sessionStreams.get(sessionID)
+	if !ok || stream.calls == nil {
+		return nil, fmt.Errorf("%w: '%s'", ErrNoLiveStream, sessionID)
+	}
+	raw, err := stream.calls.call(ctx, MethodElicitationCreate, params)
+	if err != nil {
+		return nil, err
+	}
+	var result elicitResult
+	if err := q.jsonUnmarshalFunc(raw, &result); err != nil {
+		return nil, err
+	}
+	switch result.Action {
+	case elicitActionAccept:
+		if result.Content == nil {
+			return map[string]any{}, nil
+		}
+		return result.Content, nil
+	case elicitActionDecline:
+		return nil, ErrElicitationDeclined
+	default:
+		return nil, ErrElicitationCanceled
+	}
+}
+
+// serverRequestIDPrefix prefixes the IDs of the server-initiated requests,
+// so that they never collide with the IDs chosen by the client.
+const serverRequestIDPrefix = "qilin-"
+
+// compatibility check
+var _ jsonrpc2.Writer = (*serverCalls)(nil)
+
+// serverCalls allocates the IDs of the server-initiated requests on a connection,
+// and routes the responses back to the waiting requests.
+type serverCalls struct {
+	seq atomic.Int64
+
+	// mu protects pending
+	mu      sync.Mutex
+	pending map[jsonrpc2.ID]chan *jsonrpc2.Response
+
+	// writeMu serializes the writes of the server-initiated requests and the other messages.
+	writeMu sync.Mutex
+	writer  jsonrpc2.Writer
+}
+
+// newServerCalls returns a new serverCalls.
+func newServerCalls() *serverCalls {
+	return &serverCalls{
+		pending: make(map[jsonrpc2.ID]chan *jsonrpc2.Response),
+	}
+}
+
+// nextID allocates the ID of the next server-initiated request.
+func (c *serverCalls) nextID() jsonrpc2.ID {
+	return jsonrpc2.StringID(serverRequestIDPrefix + strconv.FormatInt(c.seq.Add(1), 10))
+}
+
+// Write See: jsonrpc2.Writer#Write
+func (c *serverCalls) Write(ctx context.Context, msg jsonrpc2.Message) (int64, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.writer == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return c.writer.Write(ctx, msg)
+}
+
+// call sends the request to the client and waits for the result.
+func (c *serverCalls) call(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	id := c.nextID()
+	req, err := jsonrpc2.NewCall(id, method, params)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan *jsonrpc2.Response, 1)
+	c.mu.Lock()
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if _, err := c.Write(ctx, req); err != nil {
+		return nil, err
+	}
+	select {
+	case res := <-ch:
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		return res.Result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// deliver routes the response to the waiting request. It returns false if no request waits for it.
+func (c *serverCalls) deliver(res *jsonrpc2.Response) bool {
+	c.mu.Lock()
+	ch, ok := c.pending[res.ID]
+	if ok {
+		delete(c.pending, res.ID)
+	}
+	c.mu.Unlock()
+	if ok {
+		ch <- res
+	}
+	return ok
+}
+
+// compatibility check
+var _ jsonrpc2.Framer = (*serverCallsFramer)(nil)
+
+// serverCallsFramer wraps jsonrpc2.Framer to write the server-initiated requests
+// and to take the responses to them out of the incoming messages.
+type serverCallsFramer struct {
+	jsonrpc2.Framer
+	calls *serverCalls
+	route func(*jsonrpc2.Response) bool
+}
+
+// Reader See: jsonrpc2.Framer#Reader
+func (f *serverCallsFramer) Reader(r io.Reader) jsonrpc2.Reader {
+	return &serverCallsReader{
+		Reader: f.Framer.Reader(r),
+		route:  f.route,
+	}
+}
+
+// Writer See: jsonrpc2.Framer#Writer
+func (f *serverCallsFramer) Writer(w io.Writer) jsonrpc2.Writer {
+	f.calls.writeMu.Lock()
+	defer f.calls.writeMu.Unlock()
+	f.calls.writer = f.Framer.Writer(w)
+	return f.calls
+}
+
+// compatibility check
+var _ jsonrpc2.Reader = (*serverCallsReader)(nil)
+
+// serverCallsReader skips the responses routed to the server-initiated requests.
+type serverCallsReader struct {
+	jsonrpc2.Reader
+	route func(*jsonrpc2.Response) bool
+}
+
+// Read See: jsonrpc2.Reader#Read
+func (r *serverCallsReader) Read(ctx context.Context) (jsonrpc2.Message, int64, error) {
+	for {
+		msg, n, err := r.Reader.Read(ctx)
+		if err != nil {
+			return msg, n, err
+		}
+		if res, ok := msg.(*jsonrpc2.Response); ok && r.route(res) {
+			continue
+		}
+		return msg, n, nil
+	}
+}
+
+// prewarmPool seeds the pool with n objects.
+func prewarmPool(p *sync.Pool, n int) {
+	if n <= 0 {
+		return
+	}
+	objs := make([]any, n)
+	for i := range objs {
+		objs[i] = p.New()
+	}
+	for _, v := range objs {
+		p.Put(v)
+	}
+}
+
 // compatibility check
 var _ jsonrpc2.Binder = (*binder)(nil)
 
@@ -895,6 +2569,7 @@ func (b *binder) Bind(
 ) (jsonrpc2.ConnectionOptions, error) {
 	h := b.qilin.handlerPool.Get().(*handler)
 	h.runningMu.Lock()
+	h.resetOnce = &sync.Once{}
 	h.notify = conn.Notify
 
 	rv := reflect.ValueOf(conn).Elem()
@@ -904,31 +2579,133 @@ func (b *binder) Bind(
 	if !ok {
 		return jsonrpc2.ConnectionOptions{}, errors.New("failed to convert to QilinIO")
 	}
+	h.transportKind = transportKindOf(qilinIO.Inner)
+	calls := newServerCalls()
+	h.calls = calls
+	sessionID := func() string { return "" }
 	switch inner := qilinIO.Inner.(type) {
 	case *transport.Stdio:
+		sessionID = inner.SessionID
 		h.getSessionID = inner.SessionID
 		h.setSessionID = inner.SetSessionID
 		h.connectionCtx = inner.Context()
 		h.noticeTransportError = inner.NoticeError
+		// the stdio session lives as long as the stdio, so discard it once the stdio ends.
+		context.AfterFunc(h.connectionCtx, func() {
+			if sessionID := inner.SessionID(); sessionID != "" {
+				_ = b.qilin.sessionManager.Discard(context.WithoutCancel(b.qilin.rootCtx), sessionID)
+			}
+		})
+		// the connection ends on EOF without closing the stdio, so close it to end the stdio.
+		go func() {
+			_ = conn.Wait()
+			_ = inner.Close()
+		}()
 	case *transport.StreamableReadWriteCloser:
+		sessionID = inner.SessionID
 		h.getSessionID = inner.SessionID
 		h.setSessionID = inner.SetSessionID
-		h.switchToStreamConnection = inner.SwitchStreamConnection
+		h.streamUnavailable = !inner.AcceptsEventStream() || b.qilin.streamingDisabled
+		// the notifications cannot be carried by the JSON response, so drop them.
+		h.notify = discardNotify
+		if !h.streamUnavailable {
+			// notifications sent before the switch to SSE would be written as the plain response, so hold them.
+			buf := &notificationBuffer{notify: conn.Notify}
+			h.notify = buf.Notify
+			h.switchToStreamResponse = func(keepAlive time.Duration) {
+				inner.SwitchStreamResponse(keepAlive)
+				buf.release()
+			}
+			h.switchToStreamConnection = func(keepAlive time.Duration) {
+				inner.SwitchStreamConnection(keepAlive)
+				buf.release()
+				if sessionID := inner.SessionID(); sessionID != "" {
+					b.qilin.sessionStreams.add(inner.Context(), sessionID, buf.Notify, calls)
+				}
+			}
+		}
+		h.requestHeader = inner.RequestHeader
+		h.principal = inner.Principal()
 		h.connectionCtx = inner.Context()
 		h.noticeTransportError = inner.NoticeError
+		if inner.Accepted() {
+			// nothing is written in reply, so close the connection once the messages are handled.
+			go func() {
+				_ = conn.Wait()
+				_ = inner.Close()
+			}()
+		}
+	}
+	if h.connectionCtx != nil {
+		// Connection.Close waits for the handlers, so close the transport directly.
+		h.abort = func(reason string) {
+			slog.Warn("[qilin] the connection is aborted", "reason", reason)
+			if sessionID := sessionID(); sessionID != "" {
+				_ = b.qilin.sessionManager.Discard(context.WithoutCancel(b.qilin.rootCtx), sessionID)
+			}
+			_ = qilinIO.Close()
+		}
+		if timeout := b.qilin.initializeTimeout; timeout > 0 && sessionID() == "" {
+			connectionCtx := h.connectionCtx
+			timer := time.AfterFunc(timeout, func() {
+				// the handler is reset and reused once the connection ends.
+				if connectionCtx.Err() != nil || h.initialized.Load() {
+					return
+				}
+				slog.Warn("[qilin] the connection is closed without initialize", "timeout", timeout)
+				_ = qilinIO.Close()
+			})
+			context.AfterFunc(connectionCtx, func() {
+				timer.Stop()
+			})
+		}
+		h.resetOnConnectionEnd()
 	}
 
 	context.AfterFunc(b.qilin.rootCtx, func() {
 		conn.Close()
 	})
 
+	preempter := b.preempter
+	if p, ok := preempter.(*cancellationPreempter); ok {
+		preempter = p.bind(conn, func(id jsonrpc2.ID) {
+			if sessionID := sessionID(); sessionID != "" {
+				b.qilin.inFlightRequests.cancel(sessionID, id)
+			}
+		})
+	}
+
+	framer := &serverCallsFramer{
+		Framer: b.framer,
+		calls:  calls,
+		route: func(res *jsonrpc2.Response) bool {
+			if calls.deliver(res) {
+				return true
+			}
+			// on the streamable HTTP transport, the response arrives apart from the live stream.
+			stream, ok := b.qilin.sessionStreams.get(sessionID())
+			return ok && stream.calls != nil && stream.calls.deliver(res)
+		},
+	}
+
 	return jsonrpc2.ConnectionOptions{
-		Preempter: b.preempter,
-		Framer:    b.framer,
+		Preempter: preempter,
+		Framer:    framer,
 		Handler:   h,
 	}, nil
 }
 
+// transportKindOf returns the kind of the transport.
+func transportKindOf(rwc io.ReadWriteCloser) TransportKind {
+	switch rwc.(type) {
+	case *transport.Stdio:
+		return TransportKindStdio
+	case *transport.StreamableReadWriteCloser:
+		return TransportKindStreamable
+	}
+	return TransportKindUnknown
+}
+
 func convertToQilinIO(elem reflect.Value) (_ *internaltransport.QilinIO, ok bool) {
 	defer func() {
 		if rec := recover(); rec != nil {
@@ -948,6 +2725,74 @@ func newBinder(q *Qilin, preempter jsonrpc2.Preempter, framer jsonrpc2.Framer) *
 	}
 }
 
+// compatibility check
+var _ jsonrpc2.Preempter = (*cancellationPreempter)(nil)
+
+// cancellationPreempter cancels in-flight requests before they reach the main handler.
+type cancellationPreempter struct {
+	conn *jsonrpc2.Connection
+	// cancelInFlight cancels the in-flight request of the session, which may be on another connection
+	cancelInFlight func(id jsonrpc2.ID)
+}
+
+// CancellationPreempter returns a jsonrpc2.Preempter that handles `notifications/cancelled`
+// and `$/cancelRequest` out of band, so that queued requests are cancelled without waiting for the main handler.
+// On the streamable HTTP transport, the cancellation sent in another POST cancels the in-flight request of the session.
+//
+//	q.Start(qilin.StartWithPreempter(qilin.CancellationPreempter()))
+func CancellationPreempter() jsonrpc2.Preempter {
+	return &cancellationPreempter{}
+}
+
+// bind returns a copy of the preempter bound to the connection
+func (p *cancellationPreempter) bind(
+	conn *jsonrpc2.Connection,
+	cancelInFlight func(id jsonrpc2.ID),
+) *cancellationPreempter {
+	return &cancellationPreempter{conn: conn, cancelInFlight: cancelInFlight}
+}
+
+// Preempt See: jsonrpc2.Preempter
+func (p *cancellationPreempter) Preempt(_ context.Context, req *jsonrpc2.Request) (interface{}, error) {
+	if p.conn == nil {
+		return nil, jsonrpc2.ErrNotHandled
+	}
+	var requestID any
+	switch req.Method {
+	case MethodNotificationCancelled:
+		var params NotificationsCancelledRequestParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, jsonrpc2.ErrInvalidParams
+		}
+		requestID = params.RequestID
+	case "$/cancelRequest":
+		var params struct {
+			ID any `json:"id"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, jsonrpc2.ErrInvalidParams
+		}
+		requestID = params.ID
+	default:
+		return nil, jsonrpc2.ErrNotHandled
+	}
+	var id jsonrpc2.ID
+	switch v := requestID.(type) {
+	case string:
+		id = jsonrpc2.StringID(v)
+	case float64:
+		id = jsonrpc2.Int64ID(int64(v))
+	default:
+		slog.Warn("[qilin] the request id to cancel is neither string nor number", slog.Any("requestID", requestID))
+		return nil, nil
+	}
+	p.conn.Cancel(id)
+	if p.cancelInFlight != nil {
+		p.cancelInFlight(id)
+	}
+	return nil, nil
+}
+
 // compatibility check
 var _ jsonrpc2.Handler = (*handler)(nil)
 
@@ -973,28 +2818,57 @@ type handler struct {
 	// switchToStreamConnection switches the connection to a stream connection
 	switchToStreamConnection func(keepAlive time.Duration)
 
+	// switchToStreamResponse switches the connection to a stream connection closed once the response is written.
+	// nil if the transport does not support it.
+	switchToStreamResponse func(keepAlive time.Duration)
+
+	// streamUnavailable reports whether the stream cannot be used, such as by the Accept header or WithStreamingDisabled.
+	streamUnavailable bool
+
+	// requestHeader returns the header of the HTTP request. nil if the transport is not HTTP.
+	requestHeader func() http.Header
+
+	// principal is the principal of the connection. nil if the transport does not resolve it.
+	principal any
+
+	// transportKind is the kind of the transport of the connection
+	transportKind TransportKind
+
 	// connectionCtx is the context of the connection
 	connectionCtx context.Context
 
+	// abort discards the session and closes the transport of the connection. nil if not available.
+	abort func(reason string)
+
 	// runningMu is a mutex to protect the running state of the handler
 	runningMu sync.Mutex
 
+	// resetOnce resets the handler once per binding to the connection
+	resetOnce *sync.Once
+
 	// wg wait for all subscriptions to finish
 	wg sync.WaitGroup
 
 	// noticeTransportError is a function to notify error to the transport layer
 	noticeTransportError func(error)
+
+	// initialized reports whether the connection is already initialized
+	initialized atomic.Bool
+
+	// calls sends the server-initiated requests on the connection
+	calls *serverCalls
 }
 
 // Handle See: jsonrpc2.Handler.Handle
 func (h *handler) Handle(ctx context.Context, req *jsonrpc2.Request) (interface{}, error) {
 	var sessionID string
+	endHandle := h.beginHandle()
 
 	defer func() {
 		if req.Method != MethodInitialize {
 			h.afterHandle(ctx, sessionID)
 		}
-		go h.reset()
+		endHandle()
 	}()
 
 	if req.Method == MethodInitialize {
@@ -1006,11 +2880,113 @@ func (h *handler) Handle(ctx context.Context, req *jsonrpc2.Request) (interface{
 		h.noticeTransportError(transport.ErrMissingSessionID)
 		return nil, jsonrpc2.ErrUnknown
 	}
-	return h.invokeMethod(ctx, req, sessionID)
+	if req.IsCall() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		defer h.qilin.inFlightRequests.add(sessionID, req.ID, cancel)()
+	}
+	result, err := h.invokeMethod(ctx, req, sessionID)
+	return result, h.redactError(ctx, req.Method, withErrorData(err))
+}
+
+// redactError replaces the error without the JSON-RPC error code, such as the one returned by the handlers,
+// with the opaque internal error carrying a correlation ID, and logs the full error.
+// It returns the error as is unless WithErrorRedaction is set.
+func (h *handler) redactError(ctx context.Context, method string, err error) error {
+	if err == nil || !h.qilin.errorRedaction || errorCode(err) != 0 {
+		return err
+	}
+	correlationID := uuid.NewString()
+	slog.ErrorContext(
+		ctx,
+		"[qilin] failed to handle the request",
+		slog.String("method", method),
+		slog.String("correlationID", correlationID),
+		slog.Any("error", err),
+	)
+	return fmt.Errorf("%w: correlation id '%s'", jsonrpc2.ErrInternal, correlationID)
+}
+
+// errorCode returns the JSON-RPC error code the error is sent with. zero if the error has no code.
+func errorCode(err error) int64 {
+	// jsonrpc2 does not export the way to get the code, so decode it from the wire format.
+	res, rerr := jsonrpc2.NewResponse(jsonrpc2.Int64ID(1), nil, err)
+	if rerr != nil {
+		return 0
+	}
+	b, merr := jsonrpc2.EncodeMessage(res)
+	if merr != nil {
+		return 0
+	}
+	var wire struct {
+		Error struct {
+			Code int64 `json:"code"`
+		} `json:"error"`
+	}
+	if derr := json.Unmarshal(b, &wire); derr != nil {
+		return 0
+	}
+	return wire.Error.Code
+}
+
+// invalidParamsCode is the JSON-RPC error code of jsonrpc2.ErrInvalidParams.
+const invalidParamsCode = -32602
+
+// retryAfterCode is the JSON-RPC error code of RetryAfterError.
+const retryAfterCode = -32003
+
+// withErrorData converts InvalidParamsError and RetryAfterError into the wire error carrying the details as the `data`.
+// jsonrpc2 only sends the code and the message of other errors.
+func withErrorData(err error) error {
+	var paramsErr *InvalidParamsError
+	if errors.As(err, &paramsErr) {
+		return wireErrorWithData(err, invalidParamsCode, paramsErr)
+	}
+	var retryErr *RetryAfterError
+	if errors.As(err, &retryErr) {
+		return wireErrorWithData(err, retryAfterCode, retryErr)
+	}
+	return err
+}
+
+// wireErrorWithData converts the error into the wire error with the code and the data. err as is if failed.
+func wireErrorWithData(err error, code int64, data any) error {
+	// jsonrpc2 does not export the way to set the data, so decode it from the wire format.
+	type wireError struct {
+		Code    int64  `json:"code"`
+		Message string `json:"message"`
+		Data    any    `json:"data"`
+	}
+	b, merr := json.Marshal(struct {
+		JSONRPC string    `json:"jsonrpc"`
+		ID      int64     `json:"id"`
+		Error   wireError `json:"error"`
+	}{
+		JSONRPC: "2.0",
+		ID:      1,
+		Error: wireError{
+			Code:    code,
+			Message: err.Error(),
+			Data:    data,
+		},
+	})
+	if merr != nil {
+		return err
+	}
+	msg, derr := jsonrpc2.DecodeMessage(b)
+	if derr != nil {
+		return err
+	}
+	res, ok := msg.(*jsonrpc2.Response)
+	if !ok || res.Error == nil {
+		return err
+	}
+	return res.Error
 }
 
 func (h *handler) afterHandle(ctx context.Context, sessionID string) {
-	if !h.enabledResourceListChange && !h.enabledResourceChange {
+	if !h.enabledResourceListChange && !h.enabledResourceChange || h.streamUnavailable {
 		return
 	}
 	unhealthySubscriptionUris, err := h.qilin.resourcesSubscriptionManager.UnhealthSubscriptions(
@@ -1021,7 +2997,7 @@ func (h *handler) afterHandle(ctx context.Context, sessionID string) {
 		return
 	}
 	for _, v := range unhealthySubscriptionUris {
-		_ = h.setupResourceSubscription(ctx, sessionID, v)
+		_ = h.setupResourceSubscription(ctx, sessionID, v, 0)
 	}
 
 	health, err := h.qilin.resourceListChangeSubscriptionManager.Health(ctx, sessionID)
@@ -1037,6 +3013,14 @@ func (h *handler) afterHandle(ctx context.Context, sessionID string) {
 	}
 }
 
+// locale returns the locale of the session the handler serves. empty if unknown.
+func (h *handler) locale() string {
+	if h.getSessionID == nil {
+		return ""
+	}
+	return h.qilin.locale(h.getSessionID())
+}
+
 // invokeMethod invokes the method specified in the request.
 func (h *handler) invokeMethod(
 	ctx context.Context,
@@ -1058,15 +3042,39 @@ func (h *handler) invokeMethod(
 	default:
 		// no-op
 	}
+	if timeout := h.qilin.clientRequestTimeout(req.Params); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	next := func(ctx context.Context, req *jsonrpc2.Request) (interface{}, error) {
+		return h.dispatchMethod(ctx, req, sessionID)
+	}
+	for _, middleware := range h.qilin.methodMiddleware {
+		next = middleware(next)
+	}
+	result, err := next(ctx, req)
+	if err != nil || h.qilin.responseInterceptor == nil {
+		return result, err
+	}
+	return h.qilin.responseInterceptor(req.Method, result), nil
+}
+
+// dispatchMethod dispatches the request to the handler of the method.
+func (h *handler) dispatchMethod(
+	ctx context.Context,
+	req *jsonrpc2.Request,
+	sessionID string,
+) (interface{}, error) {
 	switch req.Method {
 	case MethodPing:
 		return struct{}{}, nil
 	case MethodResourcesList:
-		return h.handleResourcesList(ctx, req)
+		return h.handleResourcesList(ctx, sessionID, req)
 	case MethodResourcesTemplatesList:
-		return h.handleResourcesTemplatesList()
+		return h.handleResourcesTemplatesList(req)
 	case MethodResourcesRead:
-		return h.handleResourcesRead(ctx, req)
+		return h.handleResourcesRead(ctx, sessionID, req)
 	case MethodPromptsList:
 		return h.handlePromptsList()
 	case MethodPromptsGet:
@@ -1074,19 +3082,49 @@ func (h *handler) invokeMethod(
 	case MethodToolsList:
 		return h.handleToolsList()
 	case MethodToolsCall:
-		return h.handleToolsCall(ctx, req)
+		return h.handleToolsCall(ctx, sessionID, req)
 	case MethodResourceSubscribe:
 		if !h.enabledResourceChange {
 			return nil, jsonrpc2.ErrMethodNotFound
 		}
+		if h.streamUnavailable {
+			return nil, ErrStreamNotAccepted
+		}
 		return h.handleResourceSubscribe(ctx, sessionID, req)
 	case MethodResourceUnsubscribe:
+		if !h.enabledResourceChange {
+			return nil, jsonrpc2.ErrMethodNotFound
+		}
 		return h.handleResourceUnsubscribe(ctx, sessionID, req)
+	case MethodCompletionComplete:
+		if h.qilin.currentCapabilities().Completions == nil {
+			return nil, jsonrpc2.ErrMethodNotFound
+		}
+		return h.handleCompletionComplete(req)
 	default:
 		return nil, jsonrpc2.ErrMethodNotFound
 	}
 }
 
+// clientRequestTimeout returns the timeout requested by the client via `_meta.timeoutMs`, bounded by maxRequestTimeout.
+// zero if not requested.
+func (q *Qilin) clientRequestTimeout(params json.RawMessage) time.Duration {
+	if len(params) == 0 {
+		return 0
+	}
+	var v struct {
+		Meta *requestMeta `json:"_meta"`
+	}
+	if err := q.jsonUnmarshalFunc(params, &v); err != nil || v.Meta == nil || v.Meta.TimeoutMs <= 0 {
+		return 0
+	}
+	timeout := time.Duration(v.Meta.TimeoutMs) * time.Millisecond
+	if q.maxRequestTimeout > 0 && timeout > q.maxRequestTimeout {
+		return q.maxRequestTimeout
+	}
+	return timeout
+}
+
 // handleInitialize handles the initialization request.
 func (h *handler) handleInitialize(
 	ctx context.Context,
@@ -1097,36 +3135,83 @@ func (h *handler) handleInitialize(
 	if err := json.Unmarshal(req.Params, &params); err != nil {
 		return nil, jsonrpc2.ErrInvalidParams
 	}
+	if h.qilin.initializeProbe != nil {
+		var probe struct {
+			Meta map[string]any `json:"_meta"`
+		}
+		_ = json.Unmarshal(req.Params, &probe)
+		if h.qilin.initializeProbe(params.ClientInfo.Name, probe.Meta) {
+			// the session is not started for the probe, so the result is returned as is.
+			return h.negotiateInitialize(&params), nil
+		}
+	}
+	// a connection is initialized only once, so that it is bound to a single session.
+	if !h.initialized.CompareAndSwap(false, true) {
+		return nil, fmt.Errorf("%w: %w", jsonrpc2.ErrInvalidRequest, ErrAlreadyInitialized)
+	}
 
 	id, err := h.qilin.sessionManager.Start(ctx)
 	if err != nil {
+		h.initialized.Store(false)
 		return nil, err
 	}
 	h.setSessionID(id)
 	*sessionID = id
-
-	protocolVersion := params.ProtocolVersion
-	if support := SupportedProtocolVersions[protocolVersion]; !support {
-		protocolVersion = LatestProtocolVersion
+	if h.transportKind == TransportKindStdio {
+		h.qilin.sessionStreams.add(h.connectionCtx, id, h.notify, h.calls)
 	}
 
 	sessionCtx, err := h.qilin.sessionManager.Context(ctx, *sessionID)
 	if err != nil {
 		return nil, err
 	}
+	q := h.qilin
+	result := h.negotiateInitialize(&params)
+	if q.experimentalNegotiator != nil {
+		q.experimentalCapabilities.Store(id, result.Capabilities.Experimental)
+	}
+	q.protocolVersions.Store(id, result.ProtocolVersion)
+	q.clientCapabilities.Store(id, params.Capabilities)
+	if params.Meta.Locale != "" {
+		q.locales.Store(id, params.Meta.Locale)
+	}
+	context.AfterFunc(sessionCtx, func() {
+		q.protocolVersions.Delete(id)
+		q.clientCapabilities.Delete(id)
+		q.locales.Delete(id)
+		q.experimentalCapabilities.Delete(id)
+	})
 
-	if h.enabledResourceListChange {
+	if h.enabledResourceListChange && !h.streamUnavailable {
 		_ = h.resourceListChangeSubscription(ctx, sessionCtx, *sessionID)
 	}
+	return result, nil
+}
 
+// negotiateInitialize returns the result of the initialize, with the capabilities negotiated for the client.
+func (h *handler) negotiateInitialize(params *initializeRequestParams) *initializeResult {
+	q := h.qilin
+	protocolVersion := params.ProtocolVersion
+	if support := SupportedProtocolVersions[protocolVersion]; !support {
+		protocolVersion = LatestProtocolVersion
+	}
+	capabilities := q.currentCapabilities()
+	if q.experimentalNegotiator != nil {
+		capabilities.Experimental = q.experimentalNegotiator(params.Capabilities.Experimental)
+	}
+	instructions := q.instructions
+	if q.instructionsFunc != nil {
+		instructions = q.instructionsFunc(params.Capabilities)
+	}
 	return &initializeResult{
 		ProtocolVersion: protocolVersion,
-		Capabilities:    h.qilin.capabilities,
+		Capabilities:    capabilities,
 		ServerInfo: implementation{
-			Name:    h.qilin.name,
-			Version: h.qilin.version,
+			Name:    q.name,
+			Version: q.version,
 		},
-	}, nil
+		Instructions: instructions,
+	}
 }
 
 // resourceListChangeSubscription observes changes in the resource list and notifies the client.
@@ -1149,13 +3234,16 @@ func (h *handler) resourceListChangeSubscription(
 	if err != nil {
 		return err
 	}
-	h.switchToStreamConnection(5 * time.Second)
+	h.switchToStreamConnection(streamKeepAlive)
 	h.wg.Add(1)
 
 	go func() {
 		defer h.wg.Done()
-		defer h.qilin.resourceListChangeSubscriberPool.Put(_resourceListChangeSubscriber)
-		defer _resourceListChangeSubscriber.reset()
+		defer func() {
+			h.qilin.resourceListChangeCtx.unsubscribeSubscriber(_resourceListChangeSubscriber)
+			_resourceListChangeSubscriber.reset()
+			h.qilin.resourceListChangeSubscriberPool.Put(_resourceListChangeSubscriber)
+		}()
 
 		ticker := time.NewTicker(h.qilin.resourceListChangeSubscriptionOptions.healthCheckInterval)
 		defer ticker.Stop()
@@ -1164,8 +3252,11 @@ func (h *handler) resourceListChangeSubscription(
 			case <-ticker.C:
 				subscription.SignalAlive()
 			case <-sessionCtx.Done():
+				return
 			case <-subscription.Unsubscribed():
+				return
 			case <-h.connectionCtx.Done():
+				return
 			case <-h.qilin.rootCtx.Done():
 				return
 			case <-listChangeCh:
@@ -1182,14 +3273,29 @@ func (h *handler) resourceListChangeSubscription(
 // handleResourceList handles the request to list resources.
 func (h *handler) handleResourcesList(
 	ctx context.Context,
+	sessionID string,
 	req *jsonrpc2.Request,
 ) (interface{}, error) {
+	resources, generation, ok := h.qilin.resourceListCache.get(sessionID, h.qilin.nowFunc())
+	if ok {
+		return &listResourcesResult{
+			Resources: resources,
+		}, nil
+	}
+
 	dest := make(map[string]Resource)
 	c := h.qilin.resourceListContextPool.Get().(*resourceListContext)
 	c.ctx = ctx
 	c.jsonrpcRequest = req
+	c.principal = h.principal
+	c.transport = h.transportKind
+	c.connectionCtx = h.connectionCtx
+	c.abort = h.abort
+	c.locale = h.locale()
 	c.dest = &dest
-	c.resources = h.qilin.resources
+	h.qilin.resourcesMu.RLock()
+	c.resources = maps.Clone(h.qilin.resources)
+	h.qilin.resourcesMu.RUnlock()
 	defer func() {
 		c.reset()
 		h.qilin.resourceListContextPool.Put(c)
@@ -1199,64 +3305,488 @@ func (h *handler) handleResourcesList(
 	if err != nil {
 		return nil, err
 	}
-
-	return &listResourcesResult{
-		Resources: slices.Collect(maps.Values(dest)),
-	}, nil
+
+	for k, r := range dest {
+		if r.reportsLastModified && r.LastModified.IsZero() {
+			r.LastModified = h.qilin.nowFunc()
+			dest[k] = r
+		}
+	}
+	// sorted by URI, so that the order is stable across calls
+	resources = make([]Resource, 0, len(dest))
+	for _, k := range slices.Sorted(maps.Keys(dest)) {
+		resources = append(resources, dest[k])
+	}
+	h.qilin.resourceListCache.set(sessionID, resources, h.qilin.nowFunc(), generation)
+	return &listResourcesResult{
+		Resources: resources,
+	}, nil
+}
+
+// resourceListCache holds the snapshots of the resource list per session until they expire or are invalidated.
+type resourceListCache struct {
+	ttl       time.Duration
+	mu        sync.RWMutex
+	snapshots map[string]resourceListSnapshot
+
+	// generation is incremented on each invalidation, so that the lists taken before it are not stored.
+	generation uint64
+}
+
+// resourceListSnapshot is the snapshot of the resource list of a session.
+type resourceListSnapshot struct {
+	resources []Resource
+	expiresAt time.Time
+}
+
+// get returns the snapshot of the session if it is still valid at now,
+// along with the current generation to pass to set.
+func (c *resourceListCache) get(sessionID string, now time.Time) ([]Resource, uint64, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	snapshot, ok := c.snapshots[sessionID]
+	if !ok || !now.Before(snapshot.expiresAt) {
+		return nil, c.generation, false
+	}
+	return snapshot.resources, c.generation, true
+}
+
+// set stores the snapshot of the session taken at now, and sweeps the expired snapshots.
+// The snapshot is not stored if the cache has been invalidated since the generation returned by get.
+func (c *resourceListCache) set(sessionID string, resources []Resource, now time.Time, generation uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return
+	}
+	maps.DeleteFunc(c.snapshots, func(_ string, snapshot resourceListSnapshot) bool {
+		return !now.Before(snapshot.expiresAt)
+	})
+	c.snapshots[sessionID] = resourceListSnapshot{
+		resources: resources,
+		expiresAt: now.Add(c.ttl),
+	}
+}
+
+// invalidate discards the snapshots.
+func (c *resourceListCache) invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	clear(c.snapshots)
+}
+
+// resourceReadCache holds the results of reading the resources until they expire or are invalidated.
+type resourceReadCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[resourceReadCacheKey]resourceReadCacheEntry
+
+	// generation is incremented on each invalidation, so that the reads started before it are not stored.
+	generation uint64
+}
+
+// resourceReadCacheKey is the key of the cached result of reading a resource.
+type resourceReadCacheKey struct {
+	sessionID string
+	uri       string
+}
+
+// resourceReadCacheEntry is the cached result of reading a resource.
+type resourceReadCacheEntry struct {
+	uri       *url.URL
+	result    *readResourceResult
+	expiresAt time.Time
+}
+
+// get returns the cached result of reading the uri in the session if it is still valid at now,
+// along with the current generation to pass to set.
+func (c *resourceReadCache) get(sessionID string, uri *url.URL, now time.Time) (*readResourceResult, uint64, bool) {
+	if c == nil || uri == nil {
+		return nil, 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := resourceReadCacheKey{sessionID: sessionID, uri: uri.String()}
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, c.generation, false
+	}
+	if !now.Before(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, c.generation, false
+	}
+	return entry.result, c.generation, true
+}
+
+// set stores the result of reading the uri in the session taken at now, and sweeps the expired results.
+// It returns the cached result, whose contents hold the URI strongly since it outlives the request.
+// The result is not stored if the cache has been invalidated since the generation returned by get.
+func (c *resourceReadCache) set(
+	sessionID string,
+	uri *url.URL,
+	result *readResourceResult,
+	now time.Time,
+	generation uint64,
+) (*readResourceResult, bool) {
+	if c == nil || uri == nil {
+		return nil, false
+	}
+	cached := &readResourceResult{Contents: make([]ResourceContent, 0, len(result.Contents))}
+	for _, content := range result.Contents {
+		cached.Contents = append(cached.Contents, withStrongURI(content, uri))
+	}
+	clone := *uri
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return cached, true
+	}
+	maps.DeleteFunc(c.entries, func(_ resourceReadCacheKey, entry resourceReadCacheEntry) bool {
+		return !now.Before(entry.expiresAt)
+	})
+	c.entries[resourceReadCacheKey{sessionID: sessionID, uri: uri.String()}] = resourceReadCacheEntry{
+		uri:       &clone,
+		result:    cached,
+		expiresAt: now.Add(c.ttl),
+	}
+	return cached, true
+}
+
+// invalidate discards the results of reading the URIs matching the uri, which may be a template.
+func (c *resourceReadCache) invalidate(uri *url.URL) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	maps.DeleteFunc(c.entries, func(_ resourceReadCacheKey, entry resourceReadCacheEntry) bool {
+		return uriMatches(uri, entry.uri)
+	})
+}
+
+// handleResourcesTemplatesList handles the request to list resource templates.
+func (h *handler) handleResourcesTemplatesList(req *jsonrpc2.Request) (interface{}, error) {
+	var params paginatedRequestParams
+	if len(req.Params) > 0 {
+		if err := h.qilin.jsonUnmarshalFunc(req.Params, &params); err != nil {
+			return nil, jsonrpc2.ErrInvalidParams
+		}
+	}
+
+	h.qilin.resourcesMu.RLock()
+	defer h.qilin.resourcesMu.RUnlock()
+	keys := slices.Sorted(maps.Keys(h.qilin.resourceTemplates))
+	start := 0
+	if params.Cursor != "" {
+		key, err := base64.RawURLEncoding.DecodeString(params.Cursor)
+		if err != nil {
+			return nil, &InvalidParamsError{Fields: []InvalidParamsField{{Name: "cursor", Reason: "is invalid"}}}
+		}
+		// the templates removed since the previous page are skipped.
+		start, _ = slices.BinarySearch(keys, string(key))
+	}
+	end := len(keys)
+	result := &listResourceTemplatesResult{}
+	if size := h.qilin.resourceTemplatesPageSize; size > 0 && start+size < end {
+		end = start + size
+		result.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(keys[end]))
+	}
+	result.ResourceTemplates = make([]resourceTemplate, 0, end-start)
+	for _, key := range keys[start:end] {
+		result.ResourceTemplates = append(result.ResourceTemplates, h.qilin.resourceTemplates[key])
+	}
+	return result, nil
+}
+
+// handleResourcesRead handles the request to read a resource.
+func (h *handler) handleResourcesRead(
+	ctx context.Context,
+	sessionID string,
+	req *jsonrpc2.Request,
+) (interface{}, error) {
+	var params readResourceRequestParams
+	if err := h.qilin.jsonUnmarshalFunc(req.Params, &params); err != nil {
+		return nil, jsonrpc2.ErrInvalidParams
+	}
+
+	blobRange := params.blobRange()
+	if blobRange == nil && h.requestHeader != nil {
+		blobRange = parseRangeHeader(h.requestHeader().Get("range"))
+	}
+	uri := h.qilin.rewriteURI(params.URI.URL())
+	var generation uint64
+	if blobRange == nil {
+		result, gen, ok := h.qilin.resourceReadCache.get(sessionID, uri, h.qilin.nowFunc())
+		if ok {
+			return result, nil
+		}
+		generation = gen
+	}
+	result, err := h.readResource(ctx, req, uri, blobRange)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.qilin.limitResourceResult(result); err != nil {
+		return nil, err
+	}
+	if blobRange == nil {
+		if cached, ok := h.qilin.resourceReadCache.set(sessionID, uri, result, h.qilin.nowFunc(), generation); ok {
+			return cached, nil
+		}
+	}
+	return result, nil
+}
+
+// readResource reads the resource at the uri by running the handler of the matching resource.
+func (h *handler) readResource(
+	ctx context.Context,
+	req *jsonrpc2.Request,
+	uri *url.URL,
+	blobRange *BlobRange,
+) (*readResourceResult, error) {
+	route, pathParam, err := h.qilin.matchResource(uri)
+	if err != nil {
+		return nil, fmt.Errorf("%w: '%s': %w", ErrResourceNotFound, uri, err)
+	}
+	if err := validateParamEnums(route.paramEnums, pathParam); err != nil {
+		return nil, err
+	}
+
+	// cancel the read when the client disconnects, so slow backends don't keep working for nobody.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if h.connectionCtx != nil {
+		stop := context.AfterFunc(h.connectionCtx, cancel)
+		defer stop()
+	}
+	if route.timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, route.timeout)
+		defer cancelTimeout()
+	}
+
+	c := h.qilin.resourceContextPool.Get().(*resourceContext)
+	var dest readResourceResult
+	c.ctx = ctx
+	c.uri = weak.Make(uri)
+	c.name = route.name
+	c.description = route.description
+	c.mimeType = route.mimeType
+	c.jsonrpcRequest = req
+	c.principal = h.principal
+	c.transport = h.transportKind
+	c.connectionCtx = h.connectionCtx
+	c.abort = h.abort
+	c.locale = h.locale()
+	c.pathParams = pathParam
+	c.blobRange = blobRange
+	c.dest = &dest
+
+	defer func() {
+		c.reset()
+		h.qilin.resourceContextPool.Put(c)
+	}()
+
+	err = route.handler(c)
+	if err != nil {
+		return nil, err
+	}
+
+	lastModified := c.lastModified
+	if lastModified.IsZero() && route.reportsLastModified {
+		lastModified = route.lastModified
+		if lastModified.IsZero() {
+			lastModified = h.qilin.nowFunc()
+		}
+	}
+	if !lastModified.IsZero() {
+		for i, content := range dest.Contents {
+			dest.Contents[i] = withLastModified(content, lastModified)
+		}
+	}
+	return &dest, nil
+}
+
+// limitToolResult enforces WithMaxResponseBytes on the tool result.
+func (q *Qilin) limitToolResult(dest CallToolContent) (CallToolContent, error) {
+	if q.maxResponseBytes <= 0 {
+		return dest, nil
+	}
+	excess, err := q.responseExcess(dest)
+	if err != nil || excess <= 0 {
+		return dest, err
+	}
+	if text, ok := dest.(*textCallToolContent); ok && q.responseLimitMode == ResponseLimitTruncate && len(text.Text) > excess {
+		return &textCallToolContent{
+			Text:        truncateText(text.Text, len(text.Text)-excess),
+			Annotations: text.Annotations,
+			marshal:     text.marshal,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %d bytes exceeds %d bytes", ErrResponseTooLarge, q.maxResponseBytes+excess, q.maxResponseBytes)
+}
+
+// limitResourceResult enforces WithMaxResponseBytes on the result of reading the resource.
+func (q *Qilin) limitResourceResult(result *readResourceResult) error {
+	if q.maxResponseBytes <= 0 {
+		return nil
+	}
+	excess, err := q.responseExcess(result)
+	if err != nil || excess <= 0 {
+		return err
+	}
+	tooLarge := fmt.Errorf("%w: %d bytes exceeds %d bytes", ErrResponseTooLarge, q.maxResponseBytes+excess, q.maxResponseBytes)
+	if q.responseLimitMode != ResponseLimitTruncate {
+		return tooLarge
+	}
+	// each cut at least removes the excess or a content, so the loop ends.
+	for excess > 0 {
+		last := len(result.Contents) - 1
+		if last < 0 {
+			return tooLarge
+		}
+		if truncated, ok := withTruncatedText(result.Contents[last], excess, q.resourceChecksum); ok {
+			result.Contents[last] = truncated
+		} else if last > 0 {
+			result.Contents = result.Contents[:last]
+		} else {
+			// dropping the only content leaves nothing to read.
+			return tooLarge
+		}
+		if excess, err = q.responseExcess(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// responseExcess returns the bytes of the marshaled result beyond WithMaxResponseBytes.
+func (q *Qilin) responseExcess(result any) (int, error) {
+	b, err := q.jsonMarshalFunc(result)
+	if err != nil {
+		return 0, err
+	}
+	return len(b) - q.maxResponseBytes, nil
 }
 
-// handleResourcesTemplatesList handles the request to list resource templates.
-func (h *handler) handleResourcesTemplatesList() (interface{}, error) {
-	return &listResourceTemplatesResult{
-		ResourceTemplates: slices.Collect(maps.Values(h.qilin.resourceTemplates)),
-	}, nil
+// validateParamEnums reports the path parameters whose values are not allowed by the enums.
+func validateParamEnums(enums map[string][]string, pathParams map[string]string) error {
+	var fields []InvalidParamsField
+	for _, name := range slices.Sorted(maps.Keys(enums)) {
+		values := enums[name]
+		if v, ok := pathParams[name]; ok && !slices.Contains(values, v) {
+			fields = append(fields, InvalidParamsField{
+				Name:   name,
+				Reason: "must be one of " + strings.Join(values, ", "),
+			})
+		}
+	}
+	if len(fields) > 0 {
+		return &InvalidParamsError{Fields: fields}
+	}
+	return nil
 }
 
-// handleResourcesRead handles the request to read a resource.
-func (h *handler) handleResourcesRead(
-	ctx context.Context,
-	req *jsonrpc2.Request,
-) (interface{}, error) {
-	var params readResourceRequestParams
+// handleCompletionComplete handles the request to complete the argument.
+//
+// The path parameters of the resource templates are completed from the values declared with ResourceWithParamEnum.
+// The others have no completion values.
+func (h *handler) handleCompletionComplete(req *jsonrpc2.Request) (interface{}, error) {
+	var params completeRequestParams
 	if err := h.qilin.jsonUnmarshalFunc(req.Params, &params); err != nil {
 		return nil, jsonrpc2.ErrInvalidParams
 	}
-
-	uri := (*url.URL)(params.URI)
-	route, pathParam, err := h.qilin.resourceNode.matching(uri)
+	result := &completeResult{
+		Completion: completion{Values: []string{}},
+	}
+	switch params.Ref.Type {
+	case completeRefResource:
+	case completeRefPrompt:
+		return result, nil
+	default:
+		return nil, &InvalidParamsError{Fields: []InvalidParamsField{{Name: "ref.type", Reason: "must be one of ref/resource, ref/prompt"}}}
+	}
+	uri, err := url.Parse(params.Ref.URI)
 	if err != nil {
-		return nil, err
+		return nil, &InvalidParamsError{Fields: []InvalidParamsField{{Name: "ref.uri", Reason: "is invalid"}}}
 	}
 
-	c := h.qilin.resourceContextPool.Get().(*resourceContext)
-	var dest readResourceResult
-	c.ctx = ctx
-	c.uri = weak.Make(uri)
-	c.jsonrpcRequest = req
-	c.pathParams = pathParam
-	c.dest = &dest
-
-	defer func() {
-		c.reset()
-		h.qilin.resourceContextPool.Put(c)
-	}()
+	h.qilin.resourcesMu.RLock()
+	template, ok := h.qilin.resourceTemplates[uri.String()]
+	h.qilin.resourcesMu.RUnlock()
+	if !ok || template.Meta == nil {
+		return result, nil
+	}
+	for _, v := range template.Meta.ParamEnums[params.Argument.Name] {
+		if strings.HasPrefix(v, params.Argument.Value) {
+			result.Completion.Values = append(result.Completion.Values, v)
+		}
+	}
+	result.Completion.Total = len(result.Completion.Values)
+	if result.Completion.Total > maxCompletionValues {
+		result.Completion.Values = result.Completion.Values[:maxCompletionValues]
+		result.Completion.HasMore = true
+	}
+	return result, nil
+}
 
-	err = route.handler(c)
-	if err != nil {
-		return nil, err
+// parseRangeHeader parses the HTTP Range header in the form of `bytes=<start>-[<end>]`.
+// returns nil if the header is absent or unsupported.
+func parseRangeHeader(v string) *BlobRange {
+	spec, ok := strings.CutPrefix(v, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil
 	}
-	return &dest, nil
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok || first == "" {
+		return nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil
+	}
+	r := &BlobRange{Start: start}
+	if last == "" {
+		return r
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return nil
+	}
+	// the end of the HTTP Range header is inclusive
+	r.End = end + 1
+	return r
 }
 
 // handleToolsList handles the request to list tools.
 func (h *handler) handleToolsList() (interface{}, error) {
+	// sorted by name, so that the order is stable across calls
+	tools := make([]Tool, 0, len(h.qilin.tools))
+	for _, name := range slices.Sorted(maps.Keys(h.qilin.tools)) {
+		tools = append(tools, h.qilin.tools[name])
+	}
 	return &listToolsResponse{
-		Tools: slices.Collect(maps.Values(h.qilin.tools)),
+		Tools: tools,
 	}, nil
 }
 
 // handleToolsCall handles the request to call a tool.
-func (h *handler) handleToolsCall(ctx context.Context, req *jsonrpc2.Request) (interface{}, error) {
+func (h *handler) handleToolsCall(
+	ctx context.Context,
+	sessionID string,
+	req *jsonrpc2.Request,
+) (interface{}, error) {
 	var params callToolRequestParams
 	if err := h.qilin.jsonUnmarshalFunc(req.Params, &params); err != nil {
 		return nil, jsonrpc2.ErrInvalidParams
@@ -1266,14 +3796,43 @@ func (h *handler) handleToolsCall(ctx context.Context, req *jsonrpc2.Request) (i
 	if !toolAvailable {
 		return nil, jsonrpc2.ErrInvalidParams
 	}
+	if err := h.qilin.validateToolArguments(tool.InputSchema, params.Arguments); err != nil {
+		return nil, err
+	}
+
+	var cacheKey string
+	if h.qilin.toolIdempotencyCache != nil && tool.Annotations != nil && tool.Annotations.IdempotentHint {
+		cacheKey = toolIdempotencyCacheKey(h.qilin.argumentHasher, sessionID, params.Name, params.Arguments)
+		if v, ok := h.qilin.toolIdempotencyCache.get(cacheKey, h.qilin.nowFunc()); ok {
+			return v, nil
+		}
+	}
 
 	c := h.qilin.toolContextPool.Get().(*toolContext)
-	var dest CallToolContent
 	c.toolName = params.Name
+	c.lenientBinding = tool.lenientBinding
+	c.protocolVersion = h.qilin.protocolVersion(sessionID)
+	c.audioDowngrade = h.qilin.audioDowngrade
 	c.ctx = ctx
 	c.jsonrpcRequest = req
+	c.principal = h.principal
+	c.transport = h.transportKind
+	c.connectionCtx = h.connectionCtx
+	c.abort = h.abort
+	c.locale = h.qilin.locale(sessionID)
 	c.args = params.Arguments
-	c.dest = &dest
+	// the content and the handler are held in the pooled context, saving the allocations per call.
+	c.dest = &c.result
+	c.notify = h.notify
+	c.progressToken = params.Meta.ProgressToken
+	if switchToStreamResponse, notify := h.switchToStreamResponse, h.notify; switchToStreamResponse != nil {
+		c.startStream = func() Notify {
+			switchToStreamResponse(streamKeepAlive)
+			return notify
+		}
+	}
+	c.caller = h
+	c.sessionID = sessionID
 
 	defer func() {
 		c.reset()
@@ -1283,13 +3842,159 @@ func (h *handler) handleToolsCall(ctx context.Context, req *jsonrpc2.Request) (i
 	if err := tool.handler(c); err != nil {
 		return nil, fmt.Errorf(ErrorMessageFailedToHandleTool, params.Name, err)
 	}
+	dest := c.result
+	if dest == nil {
+		if h.qilin.emptyToolContentMode == EmptyToolContentError {
+			return nil, fmt.Errorf(ErrorMessageFailedToHandleTool, params.Name, ErrToolContentNotWritten)
+		}
+		dest = &textCallToolContent{marshal: h.qilin.jsonMarshalFunc}
+	}
+	dest, err := h.qilin.limitToolResult(dest)
+	if err != nil {
+		return nil, fmt.Errorf(ErrorMessageFailedToHandleTool, params.Name, err)
+	}
+	if _, failed := dest.(*errorCallToolContent); cacheKey != "" && !failed {
+		h.qilin.toolIdempotencyCache.set(cacheKey, dest, h.qilin.nowFunc())
+	}
 	return dest, nil
 }
 
+// reflectSchema reflects the JSON Schema of v, with the definitions inlined and without the `$schema` keyword.
+func reflectSchema(v any) *jsonschema.Schema {
+	ref := jsonschema.Reflector{
+		Anonymous:      true,
+		DoNotReference: true,
+	}
+	schema := ref.Reflect(v)
+	schema.Version = ""
+	return schema
+}
+
+// validateToolArguments validates the arguments against the input schema with the SchemaValidator.
+// Without the SchemaValidator, only the required properties are checked if WithRequiredArgumentsCheck is set.
+func (q *Qilin) validateToolArguments(schema *jsonschema.Schema, args json.RawMessage) error {
+	if schema == nil {
+		return nil
+	}
+	validator := q.schemaValidator
+	if validator == nil {
+		if !q.requiredArgumentsChecked {
+			return nil
+		}
+		validator = requiredPropertiesValidator{}
+	}
+	err := validator.Validate(schema, args)
+	if err == nil || errors.Is(err, jsonrpc2.ErrInvalidParams) {
+		return err
+	}
+	return &InvalidParamsError{
+		Fields: []InvalidParamsField{{Name: "arguments", Reason: err.Error()}},
+	}
+}
+
+// requiredPropertiesValidator is the SchemaValidator of WithRequiredArgumentsCheck, reporting the required properties missing from the arguments.
+type requiredPropertiesValidator struct{}
+
+// Validate implements SchemaValidator.
+func (requiredPropertiesValidator) Validate(schema *jsonschema.Schema, args json.RawMessage) error {
+	if len(schema.Required) == 0 {
+		return nil
+	}
+	var props map[string]json.RawMessage
+	if len(args) > 0 {
+		if err := json.Unmarshal(args, &props); err != nil {
+			return &InvalidParamsError{
+				Fields: []InvalidParamsField{{Name: "arguments", Reason: "must be an object"}},
+			}
+		}
+	}
+	var fields []InvalidParamsField
+	for _, v := range schema.Required {
+		if _, ok := props[v]; !ok {
+			fields = append(fields, InvalidParamsField{Name: v, Reason: "is required"})
+		}
+	}
+	if len(fields) > 0 {
+		return &InvalidParamsError{Fields: fields}
+	}
+	return nil
+}
+
+// toolIdempotencyCacheKey returns the key of the idempotency cache from the session, the tool name and the arguments.
+func toolIdempotencyCacheKey(hasher ArgumentHashFunc, sessionID, name string, args json.RawMessage) string {
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, args); err != nil {
+		compacted.Reset()
+		compacted.Write(args)
+	}
+	return sessionID + "\x00" + name + "\x00" + hasher(compacted.Bytes())
+}
+
+// resourceChecksum computes the checksums of the resource contents with the algorithm.
+type resourceChecksum struct {
+	algorithm string
+	f         ChecksumFunc
+}
+
+// of returns the checksum of the data. nil if the checksum is disabled.
+func (r *resourceChecksum) of(data []byte) *contentChecksum {
+	if r == nil {
+		return nil
+	}
+	return &contentChecksum{Algorithm: r.algorithm, Value: r.f(data)}
+}
+
+// sha256Hex returns the hex-encoded SHA-256 of the data. it is the default ArgumentHashFunc.
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// toolIdempotencyCache holds the results of idempotent tools until they expire.
+type toolIdempotencyCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]toolIdempotencyCacheEntry
+}
+
+// toolIdempotencyCacheEntry is the cached result of an idempotent tool.
+type toolIdempotencyCacheEntry struct {
+	result    CallToolContent
+	expiresAt time.Time
+}
+
+// get returns the cached result if it is still valid at now.
+func (c *toolIdempotencyCache) get(key string, now time.Time) (CallToolContent, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !now.Before(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.result, true
+}
+
+// set stores the result taken at now, and sweeps the expired results.
+func (c *toolIdempotencyCache) set(key string, result CallToolContent, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	maps.DeleteFunc(c.entries, func(_ string, entry toolIdempotencyCacheEntry) bool {
+		return !now.Before(entry.expiresAt)
+	})
+	c.entries[key] = toolIdempotencyCacheEntry{
+		result:    result,
+		expiresAt: now.Add(c.ttl),
+	}
+}
+
 // handlePromptsList handles the request to list prompts.
 func (h *handler) handlePromptsList() (interface{}, error) {
 	prompts := slices.Collect(maps.Values(h.qilin.prompts))
-	slices.SortFunc(prompts, func(a, b prompt) int {
+	slices.SortFunc(prompts, func(a, b Prompt) int {
 		if a.Name < b.Name {
 			return -1
 		}
@@ -1323,8 +4028,20 @@ func (h *handler) handlePromptsGet(
 	c.promptName = params.Name
 	c.ctx = ctx
 	c.jsonrpcRequest = req
+	c.principal = h.principal
+	c.transport = h.transportKind
+	c.connectionCtx = h.connectionCtx
+	c.abort = h.abort
+	c.locale = h.locale()
 	c.rawArgs = params.Arguments
-	h.qilin.jsonUnmarshalFunc(params.Arguments, &c.args)
+	c.arguments = prompt.Arguments
+	c.strictArguments = prompt.strictArguments
+	err := h.qilin.jsonUnmarshalFunc(params.Arguments, &c.args)
+	if applyPromptArgumentDefaults(prompt.Arguments, &c.args) && (err == nil || len(c.rawArgs) == 0) {
+		if b, err := h.qilin.jsonMarshalFunc(c.args); err == nil {
+			c.rawArgs = b
+		}
+	}
 	c.dest = &dest
 	c.dest.Description = prompt.Description
 
@@ -1333,12 +4050,55 @@ func (h *handler) handlePromptsGet(
 		h.qilin.promptContextPool.Put(c)
 	}()
 
+	if h.qilin.requiredArgumentsChecked {
+		var fields []InvalidParamsField
+		for _, v := range prompt.Arguments {
+			if _, ok := c.args[v.Name]; v.Required && !ok {
+				fields = append(fields, InvalidParamsField{Name: v.Name, Reason: "is required"})
+			}
+		}
+		if len(fields) > 0 {
+			return nil, &InvalidParamsError{Fields: fields}
+		}
+	}
+
 	if err := prompt.handler(c); err != nil {
 		return nil, fmt.Errorf("failed to handle prompt '%s': %w", params.Name, err)
 	}
 	return &dest, nil
 }
 
+// applyPromptArgumentDefaults fills the omitted arguments with their default values.
+// It reports whether any default value was applied.
+func applyPromptArgumentDefaults(arguments []PromptArgument, args *map[string]string) bool {
+	var applied bool
+	for _, v := range arguments {
+		if v.Default == "" {
+			continue
+		}
+		if _, ok := (*args)[v.Name]; ok {
+			continue
+		}
+		if *args == nil {
+			*args = make(map[string]string)
+		}
+		(*args)[v.Name] = v.Default
+		applied = true
+	}
+	return applied
+}
+
+// rewriteURI rewrites the requested resource URI by the rewriter configured with WithURIRewriter.
+func (q *Qilin) rewriteURI(uri *url.URL) *url.URL {
+	if q.uriRewriter == nil || uri == nil {
+		return uri
+	}
+	if rewritten := q.uriRewriter(uri); rewritten != nil {
+		return rewritten
+	}
+	return uri
+}
+
 // handleResourceSubscribe handles the request to subscribe to resource changes.
 func (h *handler) handleResourceSubscribe(
 	ctx context.Context,
@@ -1349,9 +4109,14 @@ func (h *handler) handleResourceSubscribe(
 	if err := h.qilin.jsonUnmarshalFunc(req.Params, &params); err != nil {
 		return nil, jsonrpc2.ErrInvalidParams
 	}
+	if params.URI == nil {
+		return nil, &InvalidParamsError{
+			Fields: []InvalidParamsField{{Name: "uri", Reason: "is required"}},
+		}
+	}
 
-	uri := (*url.URL)(params.URI)
-	err := h.setupResourceSubscription(ctx, sessionID, uri)
+	uri := h.qilin.rewriteURI(params.URI.URL())
+	err := h.setupResourceSubscription(ctx, sessionID, uri, params.minInterval())
 	if err != nil {
 		return nil, err
 	}
@@ -1363,58 +4128,95 @@ func (h *handler) setupResourceSubscription(
 	ctx context.Context,
 	sessionID string,
 	uri *url.URL,
+	minInterval time.Duration,
 ) error {
-	n, _, err := h.qilin.resourceNode.matching(uri)
+	route, _, err := h.qilin.matchResource(uri)
 	if err != nil {
 		return err
 	}
+	n := &route
+	if n.resourceChangeCtx == nil {
+		return fmt.Errorf("%w: '%s'", ErrResourceNotObserved, uri)
+	}
+	if n.subscribeGuard != nil {
+		if err := n.subscribeGuard(ctx, uri, sessionID); err != nil {
+			return err
+		}
+	}
 
 	resourceUpdateCh := make(chan *url.URL, 1)
 	subscriber := h.qilin.resourceChangeSubscriberPool.Get().(*resourceChangeSubscriber)
 	subscriber.ch = resourceUpdateCh
 	subscriber.subscribedURI = uri
 	subscriber.lastReceived = time.Now()
+	subscriber.minInterval = minInterval
 	subscriber.id = fmt.Sprintf("%s#%s", uri.String(), sessionID)
+	subscriber.stopped = make(chan struct{})
+	stopped := subscriber.stopped
+	discard := func() {
+		subscriber.reset()
+		h.qilin.resourceChangeSubscriberPool.Put(subscriber)
+	}
 
-	n.resourceChangeCtx.subscribe(subscriber)
+	// re-subscribing to the same URI within the session, such as on reconnects, replaces the existing subscriber
+	// with the one bound to this connection. the replaced one hands over its slot of the session.
+	replaced := n.resourceChangeCtx.subscribe(subscriber)
+	if !replaced && !h.qilin.sessionSubscriptions.acquire(sessionID, h.qilin.maxSubscriptionsPerSession) {
+		n.resourceChangeCtx.unsubscribe(subscriber)
+		discard()
+		return fmt.Errorf("%w: '%s'", ErrTooManySubscriptions, sessionID)
+	}
 	subscription, err := h.qilin.resourcesSubscriptionManager.SubscribeToResourceModification(
 		ctx,
 		sessionID,
 		uri,
 	)
 	if err != nil {
+		if n.resourceChangeCtx.unsubscribe(subscriber) {
+			h.qilin.sessionSubscriptions.release(sessionID)
+		}
+		discard()
 		return err
 	}
 
-	h.resourceSubscription(ctx, n, subscriber, subscription, resourceUpdateCh)
+	h.resourceSubscription(sessionID, n, subscriber, subscription, resourceUpdateCh, stopped)
 	return nil
 }
 
 // resourceSubscription observes changes to a resource and notifies subscribers.
 func (h *handler) resourceSubscription(
-	ctx context.Context,
+	sessionID string,
 	n *resourceNode,
 	subscriber *resourceChangeSubscriber,
 	subscription Subscription,
 	resourceUpdateCh chan *url.URL,
+	stopped <-chan struct{},
 ) {
-	h.switchToStreamConnection(5 * time.Second)
+	h.switchToStreamConnection(streamKeepAlive)
 	h.wg.Add(1)
 	go func() {
 		defer h.wg.Done()
+		defer func() {
+			// the slot of the session is handed over to the subscriber replacing this one.
+			if n.resourceChangeCtx.unsubscribe(subscriber) {
+				h.qilin.sessionSubscriptions.release(sessionID)
+			}
+			subscriber.reset()
+			h.qilin.resourceChangeSubscriberPool.Put(subscriber)
+		}()
 		ticker := time.NewTicker(h.qilin.resourcesSubscriptionOptions.healthCheckInterval)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
 				subscription.SignalAlive()
-			case <-ctx.Done():
 			case <-subscription.Unsubscribed():
+				return
+			case <-stopped:
+				return
 			case <-h.connectionCtx.Done():
+				return
 			case <-h.qilin.rootCtx.Done():
-				h.qilin.resourceChangeSubscriberPool.Put(subscriber)
-				subscriber.reset()
-				n.resourceChangeCtx.unsubscribe(subscriber.id)
 				return
 			case uri := <-resourceUpdateCh:
 				if uri == nil {
@@ -1430,6 +4232,14 @@ func (h *handler) resourceSubscription(
 				if err != nil {
 					return
 				}
+				if subscriber.isDeleted() {
+					_ = h.qilin.resourcesSubscriptionManager.UnsubscribeToResourceModification(
+						h.qilin.rootCtx,
+						sessionID,
+						subscriber.SubscribedURI(),
+					)
+					return
+				}
 			}
 		}
 	}()
@@ -1447,7 +4257,7 @@ func (h *handler) handleResourceUnsubscribe(
 		return nil, jsonrpc2.ErrInvalidParams
 	}
 
-	uri := (*url.URL)(params.URI)
+	uri := h.qilin.rewriteURI(params.URI.URL())
 	err := h.qilin.resourcesSubscriptionManager.UnsubscribeToResourceModification(
 		ctx,
 		sessionID,
@@ -1459,19 +4269,49 @@ func (h *handler) handleResourceUnsubscribe(
 	return struct{}{}, nil
 }
 
-func (h *handler) reset() {
-	if h.runningMu.TryLock() {
-		// if the handler is already reset, avoid double reset
-		h.runningMu.Unlock()
-		return
+// resetOnConnectionEnd resets the handler once the connection ends, since a connection may carry several requests.
+func (h *handler) resetOnConnectionEnd() {
+	resetOnce := h.resetOnce
+	context.AfterFunc(h.connectionCtx, func() {
+		resetOnce.Do(h.reset)
+	})
+}
+
+// beginHandle marks a request in flight, so that the handler is not reset while handling it.
+// The returned function ends the request. Without the connection context, the end of the connection is unknown,
+// so the handler is reset after each request.
+func (h *handler) beginHandle() (end func()) {
+	resetOnce := h.resetOnce
+	resetPerRequest := h.connectionCtx == nil
+	h.wg.Add(1)
+	return func() {
+		h.wg.Done()
+		if resetPerRequest {
+			go resetOnce.Do(h.reset)
+		}
 	}
+}
+
+// reset waits for the requests and the subscriptions in flight, then puts the handler back to the pool.
+// It must be called once per binding, through resetOnce.
+func (h *handler) reset() {
 	h.wg.Wait()
 	h.notify = nil
 	h.getSessionID = nil
 	h.setSessionID = nil
 	h.switchToStreamConnection = noopFuncWithDuration
+	h.switchToStreamResponse = nil
+	h.streamUnavailable = false
+	h.requestHeader = nil
+	h.principal = nil
+	h.transportKind = TransportKindUnknown
 	h.connectionCtx = nil
+	h.abort = nil
 	h.noticeTransportError = nil
+	h.initialized.Store(false)
+	h.calls = nil
+	h.resetOnce = nil
+	h.runningMu.Unlock()
 	h.qilin.handlerPool.Put(h)
 }
 
@@ -1485,6 +4325,27 @@ type resourceNode struct {
 
 	mimeType string
 
+	// name of the registered resource.
+	name string
+
+	// description of the registered resource.
+	description string
+
+	// timeout of reading the resource. zero means no timeout.
+	timeout time.Duration
+
+	// lastModified of the registered resource. zero means the current time, if reportsLastModified is true.
+	lastModified time.Time
+
+	// reportsLastModified reports whether reading the resource reports `lastModified`.
+	reportsLastModified bool
+
+	// paramEnums is the allowed values of each path parameter. nil means any value is allowed.
+	paramEnums map[string][]string
+
+	// subscribeGuard decides whether the session may subscribe to the resource. nil means any session may.
+	subscribeGuard ResourceSubscribeGuardFunc
+
 	// handler handles reading the resource.
 	handler ResourceHandlerFunc
 
@@ -1496,7 +4357,7 @@ type resourceNode struct {
 func (n *resourceNode) matching(uri *url.URL) (*resourceNode, map[string]string, error) {
 	schema := uri.Scheme
 	host := uri.Host
-	path := strings.Split(strings.TrimPrefix(uri.Path, "/"), "/")
+	path := resourcePathSegments(uri.Path)
 
 	params := make(map[string]string)
 	child := *n.child
@@ -1513,35 +4374,50 @@ func (n *resourceNode) matching(uri *url.URL) (*resourceNode, map[string]string,
 		if r.handler != nil {
 			return r, params, nil
 		}
-		return nil, nil, fmt.Errorf("host '%s' found, but not registered as a resource", path)
+		return nil, nil, fmt.Errorf("host '%s' found, but not registered as a resource", host)
 	}
-	for _, p := range path {
-		child = *r.child
-		r, ok = child[p]
-		if !ok {
-			for _, v := range child {
-				if v.wild {
-					params[v.paramName] = p
-					r = v
-					break
-				}
-			}
-			if r == nil {
-				return nil, nil, fmt.Errorf("path '%s' not found", p)
-			}
+	if found := r.matchingPath(path, params); found != nil {
+		return found, params, nil
+	}
+	return nil, nil, fmt.Errorf("path '%s' not registered as a resource", uri.Path)
+}
+
+// matchingPath finds the descendant node with the handler that matches the path segments.
+// the concrete route takes precedence, and the templated route is the fallback when it leads to no handler.
+func (n *resourceNode) matchingPath(path []string, params map[string]string) *resourceNode {
+	if len(path) == 0 {
+		if n.handler != nil {
+			return n
 		}
+		return nil
 	}
-	if r.handler != nil {
-		return r, params, nil
+	child := *n.child
+	if r, ok := child[path[0]]; ok && !r.wild {
+		if found := r.matchingPath(path[1:], params); found != nil {
+			return found
+		}
+	}
+	for _, v := range child {
+		if !v.wild {
+			continue
+		}
+		if found := v.matchingPath(path[1:], params); found != nil {
+			params[v.paramName] = path[0]
+			return found
+		}
 	}
-	return nil, nil, fmt.Errorf("path '%s' found, but not registered as a resource", path)
+	return nil
 }
 
-// addRoute adds a new route to the resource node
-func (n *resourceNode) addRoute(uri *url.URL, handler ResourceHandlerFunc, mimeType string) {
+// addRoute adds a new route to the resource node and returns the node of the route
+func (n *resourceNode) addRoute(
+	uri *url.URL,
+	handler ResourceHandlerFunc,
+	mimeType string,
+) *resourceNode {
 	schema := uri.Scheme
 	host := uri.Host
-	path := strings.Split(strings.TrimPrefix(uri.Path, "/"), "/")
+	path := resourcePathSegments(uri.Path)
 
 	// Handle schema node
 	schemaNode := n.getOrCreateChild(schema)
@@ -1554,7 +4430,10 @@ func (n *resourceNode) addRoute(uri *url.URL, handler ResourceHandlerFunc, mimeT
 		if handler != nil {
 			hostNode.handler = handler
 		}
-		return
+		if mimeType != "" {
+			hostNode.mimeType = mimeType
+		}
+		return hostNode
 	}
 
 	// Process path segments
@@ -1570,6 +4449,21 @@ func (n *resourceNode) addRoute(uri *url.URL, handler ResourceHandlerFunc, mimeT
 	if mimeType != "" {
 		currentNode.mimeType = mimeType
 	}
+	return currentNode
+}
+
+// route finds the node registered exactly at the uri, without falling back to the templated routes.
+func (n *resourceNode) route(uri *url.URL) *resourceNode {
+	segments := append([]string{uri.Scheme, uri.Host}, resourcePathSegments(uri.Path)...)
+	current := n
+	for _, segment := range segments {
+		next, ok := (*current.child)[segment]
+		if !ok {
+			return nil
+		}
+		current = next
+	}
+	return current
 }
 
 // getOrCreateChild gets or creates a child node for the given segment
@@ -1581,7 +4475,7 @@ func (n *resourceNode) getOrCreateChild(segment string) *resourceNode {
 			child: resourceNodeChild(),
 		}
 		// Check if this is a path parameter (with curly braces)
-		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		if isPathParam(segment) {
 			node.wild = true
 			node.paramName = strings.TrimPrefix(strings.TrimSuffix(segment, "}"), "{")
 		}
@@ -1608,12 +4502,191 @@ func (n *resourceNode) flattenIter() iter.Seq[*resourceNode] {
 	}
 }
 
+// resourcePathSegments splits the URI path into segments.
+//
+// Leading and trailing slashes are ignored, so that `x://h` and `x://h/` both yield no segments
+// and `x://h/a/` is treated the same as `x://h/a`.
+func resourcePathSegments(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// isPathParam reports whether the path segment is a path parameter such as `{id}`.
+func isPathParam(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+// isTemplateURI reports whether the URI contains path parameters.
+func isTemplateURI(uri *url.URL) bool {
+	if uri == nil {
+		return false
+	}
+	return slices.ContainsFunc(resourcePathSegments(uri.Path), isPathParam)
+}
+
 // resourceNodeChild creates a new resource node child
 func resourceNodeChild() *map[string]*resourceNode {
 	v := make(map[string]*resourceNode)
 	return &v
 }
 
+// compatibility check
+var _ jsonrpc2.Framer = (*indentFramer)(nil)
+
+// indentFramer wraps jsonrpc2.Framer to pretty-print outgoing messages.
+type indentFramer struct {
+	jsonrpc2.Framer
+	prefix string
+	indent string
+}
+
+// Writer See: jsonrpc2.Framer#Writer
+func (f *indentFramer) Writer(w io.Writer) jsonrpc2.Writer {
+	return f.Framer.Writer(&indentWriter{
+		w:      w,
+		prefix: f.prefix,
+		indent: f.indent,
+	})
+}
+
+// newIndentFramer returns a new indent framer.
+func newIndentFramer(framer jsonrpc2.Framer, prefix, indent string) jsonrpc2.Framer {
+	return &indentFramer{
+		Framer: framer,
+		prefix: prefix,
+		indent: indent,
+	}
+}
+
+// compatibility check
+var _ io.Writer = (*indentWriter)(nil)
+
+// indentWriter indents each JSON message before writing it to the underlying writer.
+type indentWriter struct {
+	w      io.Writer
+	prefix string
+	indent string
+}
+
+// Write See: io.Writer#Write
+func (w *indentWriter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, p, w.prefix, w.indent); err != nil {
+		// not a JSON message. write it as is.
+		return w.w.Write(p)
+	}
+	if _, err := w.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+const (
+	// wireTraceIn marks the incoming messages in the wire trace.
+	wireTraceIn = "<--"
+
+	// wireTraceOut marks the outgoing messages in the wire trace.
+	wireTraceOut = "-->"
+)
+
+// wireTracer writes the trace of the messages to the writer.
+type wireTracer struct {
+	// mu serializes the writes of the messages from the connections.
+	mu  sync.Mutex
+	w   io.Writer
+	now NowFunc
+}
+
+// trace writes the message with the timestamp and the direction.
+func (t *wireTracer) trace(direction string, msg jsonrpc2.Message) {
+	b, err := jsonrpc2.EncodeMessage(msg)
+	if err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	buf.WriteString(t.now().Format(time.RFC3339Nano))
+	buf.WriteByte(' ')
+	buf.WriteString(direction)
+	buf.WriteByte('\n')
+	if err := json.Indent(&buf, b, "", "  "); err != nil {
+		return
+	}
+	buf.WriteByte('\n')
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, _ = t.w.Write(buf.Bytes())
+}
+
+// compatibility check
+var _ jsonrpc2.Framer = (*traceFramer)(nil)
+
+// traceFramer wraps jsonrpc2.Framer to trace the incoming and outgoing messages.
+type traceFramer struct {
+	jsonrpc2.Framer
+	tracer *wireTracer
+}
+
+// Reader See: jsonrpc2.Framer#Reader
+func (f *traceFramer) Reader(r io.Reader) jsonrpc2.Reader {
+	return &traceReader{
+		Reader: f.Framer.Reader(r),
+		tracer: f.tracer,
+	}
+}
+
+// Writer See: jsonrpc2.Framer#Writer
+func (f *traceFramer) Writer(w io.Writer) jsonrpc2.Writer {
+	return &traceWriter{
+		Writer: f.Framer.Writer(w),
+		tracer: f.tracer,
+	}
+}
+
+// newTraceFramer returns a new trace framer.
+func newTraceFramer(framer jsonrpc2.Framer, tracer *wireTracer) jsonrpc2.Framer {
+	return &traceFramer{
+		Framer: framer,
+		tracer: tracer,
+	}
+}
+
+// compatibility check
+var _ jsonrpc2.Reader = (*traceReader)(nil)
+
+// traceReader traces the messages read from the underlying reader.
+type traceReader struct {
+	jsonrpc2.Reader
+	tracer *wireTracer
+}
+
+// Read See: jsonrpc2.Reader#Read
+func (r *traceReader) Read(ctx context.Context) (jsonrpc2.Message, int64, error) {
+	msg, n, err := r.Reader.Read(ctx)
+	if err == nil {
+		r.tracer.trace(wireTraceIn, msg)
+	}
+	return msg, n, err
+}
+
+// compatibility check
+var _ jsonrpc2.Writer = (*traceWriter)(nil)
+
+// traceWriter traces the messages before writing them to the underlying writer.
+type traceWriter struct {
+	jsonrpc2.Writer
+	tracer *wireTracer
+}
+
+// Write See: jsonrpc2.Writer#Write
+func (w *traceWriter) Write(ctx context.Context, msg jsonrpc2.Message) (int64, error) {
+	w.tracer.trace(wireTraceOut, msg)
+	return w.Writer.Write(ctx, msg)
+}
+
 var (
 	noopFuncWithDuration = func(_ time.Duration) {}
 )
@@ -0,0 +1,4722 @@
+package qilin
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/url"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/invopop/jsonschema"
+	internaltransport "github.com/miyamo2/qilin/internal/transport"
+	"github.com/miyamo2/qilin/transport"
+	"golang.org/x/exp/jsonrpc2"
+)
+
+func TestResourceNode_matching(t *testing.T) {
+	handler := func(c ResourceContext) error {
+		return nil
+	}
+	type test struct {
+		registered string
+		requested  string
+		params     map[string]string
+		wantErr    bool
+	}
+	tests := map[string]test{
+		"host only": {
+			registered: "x://h",
+			requested:  "x://h",
+			params:     map[string]string{},
+		},
+		"host only with trailing slash": {
+			registered: "x://h",
+			requested:  "x://h/",
+			params:     map[string]string{},
+		},
+		"registered with trailing slash": {
+			registered: "x://h/",
+			requested:  "x://h",
+			params:     map[string]string{},
+		},
+		"with path": {
+			registered: "x://h/a",
+			requested:  "x://h/a",
+			params:     map[string]string{},
+		},
+		"with path and trailing slash": {
+			registered: "x://h/a",
+			requested:  "x://h/a/",
+			params:     map[string]string{},
+		},
+		"with path params": {
+			registered: "x://h/{id}",
+			requested:  "x://h/123",
+			params:     map[string]string{"id": "123"},
+		},
+		"host only does not match path": {
+			registered: "x://h",
+			requested:  "x://h/a",
+			wantErr:    true,
+		},
+		"path does not match host only": {
+			registered: "x://h/a",
+			requested:  "x://h",
+			wantErr:    true,
+		},
+		"unknown schema": {
+			registered: "x://h",
+			requested:  "y://h",
+			wantErr:    true,
+		},
+		"unknown host": {
+			registered: "x://h",
+			requested:  "x://g",
+			wantErr:    true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			n := resourceNode{
+				child: resourceNodeChild(),
+			}
+			n.addRoute(MustURL(t, tc.registered), handler, "")
+			got, params, err := n.matching(MustURL(t, tc.requested))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got == nil || got.handler == nil {
+				t.Fatalf("expected node with handler, got %v", got)
+			}
+			if len(params) != len(tc.params) {
+				t.Fatalf("expected %v, got %v", tc.params, params)
+			}
+			for k, v := range tc.params {
+				if params[k] != v {
+					t.Fatalf("expected %v, got %v", tc.params, params)
+				}
+			}
+		})
+	}
+}
+
+func TestIndentFramer_Writer(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		var buf bytes.Buffer
+		framer := newIndentFramer(jsonrpc2.RawFramer(), "", "  ")
+		resp, err := jsonrpc2.NewResponse(jsonrpc2.StringID("1"), map[string]any{"key": "value"}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := framer.Writer(&buf).Write(context.Background(), resp); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expect := `{
+  "jsonrpc": "2.0",
+  "id": "1",
+  "result": {
+    "key": "value"
+  }
+}`
+		if got := buf.String(); got != expect {
+			t.Fatalf("expected `%s`, got `%s`", expect, got)
+		}
+	})
+}
+
+func TestHandler_handlePromptsGet(t *testing.T) {
+	t.Run("argument default", func(t *testing.T) {
+		q := New("test")
+		var (
+			param string
+			bound struct {
+				Name string `json:"name"`
+			}
+		)
+		q.Prompt("greeting", func(c PromptContext) error {
+			param = c.Param("name")
+			if err := c.Bind(&bound); err != nil {
+				return err
+			}
+			return c.String(PromptRoleUser, "Hello, "+param)
+		}, PromptWithArguments(PromptArgument{
+			Name:    "name",
+			Default: "World",
+		}))
+		h := &handler{qilin: q}
+		req, err := jsonrpc2.NewCall(
+			jsonrpc2.StringID("1"),
+			MethodPromptsGet,
+			map[string]any{"name": "greeting"},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := h.handlePromptsGet(t.Context(), req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if param != "World" {
+			t.Fatalf("expected 'World', got %v", param)
+		}
+		if bound.Name != "World" {
+			t.Fatalf("expected 'World', got %v", bound.Name)
+		}
+	})
+	t.Run("argument provided", func(t *testing.T) {
+		q := New("test")
+		var param string
+		q.Prompt("greeting", func(c PromptContext) error {
+			param = c.Param("name")
+			return c.String(PromptRoleUser, "Hello, "+param)
+		}, PromptWithArguments(PromptArgument{
+			Name:    "name",
+			Default: "World",
+		}))
+		h := &handler{qilin: q}
+		req, err := jsonrpc2.NewCall(
+			jsonrpc2.StringID("1"),
+			MethodPromptsGet,
+			map[string]any{"name": "greeting", "arguments": map[string]string{"name": "Alice"}},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := h.handlePromptsGet(t.Context(), req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if param != "Alice" {
+			t.Fatalf("expected 'Alice', got %v", param)
+		}
+	})
+	t.Run("strict arguments", func(t *testing.T) {
+		q := New("test")
+		var bound struct {
+			Name  string `json:"name"`
+			Count int    `json:"count"`
+		}
+		q.Prompt("greeting", func(c PromptContext) error {
+			if err := c.Bind(&bound); err != nil {
+				return err
+			}
+			return c.String(PromptRoleUser, "Hello, "+bound.Name)
+		}, PromptWithArguments(
+			PromptArgument{Name: "name"},
+			PromptArgument{Name: "count"},
+		), PromptWithStrictArguments())
+		h := &handler{qilin: q}
+
+		req, err := jsonrpc2.NewCall(
+			jsonrpc2.StringID("1"),
+			MethodPromptsGet,
+			map[string]any{"name": "greeting", "arguments": map[string]string{"name": "Alice", "count": "3"}},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := h.handlePromptsGet(t.Context(), req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if bound.Name != "Alice" || bound.Count != 3 {
+			t.Fatalf("expected name=Alice, count=3, got name=%v, count=%v", bound.Name, bound.Count)
+		}
+
+		req, err = jsonrpc2.NewCall(
+			jsonrpc2.StringID("2"),
+			MethodPromptsGet,
+			map[string]any{"name": "greeting", "arguments": map[string]string{"name": "Alice", "mood": "happy"}},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = h.handlePromptsGet(t.Context(), req)
+		var paramsErr *InvalidParamsError
+		if !errors.As(err, &paramsErr) {
+			t.Fatalf("expected InvalidParamsError, got %v", err)
+		}
+		if len(paramsErr.Fields) != 1 || paramsErr.Fields[0].Name != "mood" {
+			t.Fatalf("expected the field 'mood', got %v", paramsErr.Fields)
+		}
+	})
+	t.Run("required argument", func(t *testing.T) {
+		get := func(t *testing.T, opts ...Option) error {
+			t.Helper()
+			q := New("test", opts...)
+			q.Prompt("greeting", func(c PromptContext) error {
+				return c.String(PromptRoleUser, "Hello")
+			}, PromptWithArguments(PromptArgument{Name: "name", Required: true}))
+			h := &handler{qilin: q}
+			req, err := jsonrpc2.NewCall(
+				jsonrpc2.StringID("1"),
+				MethodPromptsGet,
+				map[string]any{"name": "greeting"},
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, err = h.handlePromptsGet(t.Context(), req)
+			return err
+		}
+		t.Run("not checked by default", func(t *testing.T) {
+			if err := get(t); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+		t.Run("checked", func(t *testing.T) {
+			err := get(t, WithRequiredArgumentsCheck())
+			var paramsErr *InvalidParamsError
+			if !errors.As(err, &paramsErr) {
+				t.Fatalf("expected InvalidParamsError, got %v", err)
+			}
+			if len(paramsErr.Fields) != 1 || paramsErr.Fields[0].Name != "name" {
+				t.Fatalf("expected the field 'name', got %v", paramsErr.Fields)
+			}
+		})
+	})
+}
+
+func TestHandler_handlePromptsList(t *testing.T) {
+	t.Run("title and meta", func(t *testing.T) {
+		q := New("test")
+		q.Prompt("greeting", func(c PromptContext) error {
+			return c.String(PromptRoleUser, "Hello")
+		},
+			PromptWithTitle("Greeting"),
+			PromptWithMeta(map[string]any{"category": "chat"}),
+		)
+		h := &handler{qilin: q}
+		result, err := h.handlePromptsList()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := json.Marshal(result)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expect := `{"prompts":[{"name":"greeting","title":"Greeting","_meta":{"category":"chat"}}]}`
+		if string(got) != expect {
+			t.Fatalf("expected `%s`, got `%s`", expect, got)
+		}
+	})
+}
+
+type testBinderFunc func(ctx context.Context, conn *jsonrpc2.Connection) (jsonrpc2.ConnectionOptions, error)
+
+func (f testBinderFunc) Bind(ctx context.Context, conn *jsonrpc2.Connection) (jsonrpc2.ConnectionOptions, error) {
+	return f(ctx, conn)
+}
+
+type testPreempterFunc func(ctx context.Context, req *jsonrpc2.Request) (interface{}, error)
+
+func (f testPreempterFunc) Preempt(ctx context.Context, req *jsonrpc2.Request) (interface{}, error) {
+	return f(ctx, req)
+}
+
+type testHandlerFunc func(ctx context.Context, req *jsonrpc2.Request) (interface{}, error)
+
+func (f testHandlerFunc) Handle(ctx context.Context, req *jsonrpc2.Request) (interface{}, error) {
+	return f(ctx, req)
+}
+
+func TestCancellationPreempter(t *testing.T) {
+	type test struct {
+		method string
+		params any
+	}
+	tests := map[string]test{
+		"notifications/cancelled": {
+			method: MethodNotificationCancelled,
+			params: NotificationsCancelledRequestParams{RequestID: 2, Reason: "test"},
+		},
+		"$/cancelRequest": {
+			method: "$/cancelRequest",
+			params: map[string]any{"id": 2},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := t.Context()
+			listener, err := jsonrpc2.NetPipe(ctx)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			started := make(chan struct{})
+			release := make(chan struct{})
+			preempted := make(chan struct{})
+			var queuedCalled atomic.Bool
+			srv, err := jsonrpc2.Serve(ctx, listener, testBinderFunc(
+				func(_ context.Context, conn *jsonrpc2.Connection) (jsonrpc2.ConnectionOptions, error) {
+					preempter := CancellationPreempter().(*cancellationPreempter).bind(conn, nil)
+					return jsonrpc2.ConnectionOptions{
+						Preempter: testPreempterFunc(func(ctx context.Context, req *jsonrpc2.Request) (interface{}, error) {
+							result, err := preempter.Preempt(ctx, req)
+							if req.Method == tc.method {
+								close(preempted)
+							}
+							return result, err
+						}),
+						Handler: testHandlerFunc(func(ctx context.Context, req *jsonrpc2.Request) (interface{}, error) {
+							switch req.Method {
+							case "slow":
+								close(started)
+								<-release
+								return "slow", nil
+							case "queued":
+								queuedCalled.Store(true)
+								return "queued", nil
+							}
+							return nil, jsonrpc2.ErrNotHandled
+						}),
+					}, nil
+				}))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer func() {
+				listener.Close()
+				srv.Wait()
+			}()
+			client, err := jsonrpc2.Dial(ctx, listener.Dialer(), jsonrpc2.ConnectionOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer client.Close()
+
+			slow := client.Call(ctx, "slow", nil)
+			<-started
+			queued := client.Call(ctx, "queued", nil)
+			if err := client.Notify(ctx, tc.method, tc.params); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			<-preempted
+			close(release)
+			var result string
+			if err := queued.Await(ctx, &result); err == nil || err.Error() != context.Canceled.Error() {
+				t.Fatalf("expected canceled error, got %v", err)
+			}
+			if err := slow.Await(ctx, &result); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result != "slow" {
+				t.Fatalf("expected 'slow', got %v", result)
+			}
+			if queuedCalled.Load() {
+				t.Fatalf("expected queued request to be preempted")
+			}
+		})
+	}
+}
+
+func Test_parseRangeHeader(t *testing.T) {
+	tests := map[string]struct {
+		header string
+		want   *BlobRange
+	}{
+		"empty":         {header: "", want: nil},
+		"start and end": {header: "bytes=2-4", want: &BlobRange{Start: 2, End: 5}},
+		"start only":    {header: "bytes=2-", want: &BlobRange{Start: 2}},
+		"suffix":        {header: "bytes=-4", want: nil},
+		"multiple":      {header: "bytes=0-1,4-5", want: nil},
+		"invalid unit":  {header: "items=0-1", want: nil},
+		"end < start":   {header: "bytes=4-2", want: nil},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := parseRangeHeader(tc.header)
+			if (got == nil) != (tc.want == nil) || (got != nil && *got != *tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestQilin_capabilityMismatches(t *testing.T) {
+	readBeer := func(c ResourceContext) error {
+		return c.String("beer")
+	}
+	type test struct {
+		setup  func(q *Qilin)
+		expect []string
+	}
+	tests := map[string]test{
+		"consistent": {
+			setup: func(q *Qilin) {
+				q.Resource("beer", "beer://list", readBeer)
+				q.ResourceChangeObserver("beer://list", func(c ResourceChangeContext) {})
+				q.ResourceChangeObserver("beer://detail/1", func(c ResourceChangeContext) {})
+				q.Resource("beer_detail", "beer://detail/{id}", readBeer)
+				q.Prompt("greeting", func(c PromptContext) error {
+					return nil
+				})
+			},
+		},
+		"observer without resources": {
+			setup: func(q *Qilin) {
+				q.ResourceChangeObserver("beer://list", func(c ResourceChangeContext) {})
+			},
+			expect: []string{
+				"advertised capability lacks the handler: resources are advertised, but no resource is registered",
+				"advertised capability lacks the handler: changes of 'beer://list' are observed, but no resource is registered at the uri",
+			},
+		},
+		"list change observer without resources": {
+			setup: func(q *Qilin) {
+				q.ResourceListChangeObserver(func(c ResourceListChangeContext) {})
+			},
+			expect: []string{
+				"advertised capability lacks the handler: resources are advertised, but no resource is registered",
+			},
+		},
+		"observer for another resource": {
+			setup: func(q *Qilin) {
+				q.Resource("beer", "beer://list", readBeer)
+				q.ResourceChangeObserver("beer://detail/1", func(c ResourceChangeContext) {})
+			},
+			expect: []string{
+				"advertised capability lacks the handler: changes of 'beer://detail/1' are observed, but no resource is registered at the uri",
+			},
+		},
+		"handlers not wired": {
+			setup: func(q *Qilin) {
+				q.Resource("beer", "beer://list", nil)
+				q.Prompt("greeting", nil)
+				q.Tool("order", (*struct{})(nil), nil)
+			},
+			expect: []string{
+				"advertised capability lacks the handler: resource 'beer://list' is listed, but has no handler",
+				"advertised capability lacks the handler: prompt 'greeting' is listed, but has no handler",
+				"advertised capability lacks the handler: tool 'order' is listed, but has no handler",
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := New("test")
+			tc.setup(q)
+			var got []string
+			for _, err := range q.capabilityMismatches() {
+				if !errors.Is(err, ErrCapabilityMismatch) {
+					t.Errorf("expected ErrCapabilityMismatch, got %v", err)
+				}
+				got = append(got, err.Error())
+			}
+			if !slices.Equal(got, tc.expect) {
+				t.Fatalf("expected %q, got %q", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestWithStrictCapabilities(t *testing.T) {
+	q := New("test", WithStrictCapabilities())
+	q.ResourceListChangeObserver(func(c ResourceListChangeContext) {})
+	if err := q.Start(StartWithContext(t.Context())); !errors.Is(err, ErrCapabilityMismatch) {
+		t.Fatalf("expected ErrCapabilityMismatch, got %v", err)
+	}
+}
+
+func TestWithStreamingDisabled(t *testing.T) {
+	q := New("test", WithStreamingDisabled())
+	q.Resource("beer", "beer://list", func(c ResourceContext) error {
+		return c.String("beer")
+	})
+	q.ResourceChangeObserver("beer://list", func(c ResourceChangeContext) {})
+	q.ResourceListChangeObserver(func(c ResourceListChangeContext) {})
+	if q.capabilities.Resources == nil {
+		t.Fatalf("expected resources capability, got nil")
+	}
+	if q.capabilities.Resources.Subscribe {
+		t.Fatalf("expected subscribe to be false")
+	}
+	if q.capabilities.Resources.ListChanged {
+		t.Fatalf("expected listChanged to be false")
+	}
+
+	q.rootCtx = t.Context()
+	switched := false
+	h := &handler{
+		qilin:                     q,
+		enabledResourceChange:     q.capabilities.Resources.Subscribe,
+		enabledResourceListChange: q.capabilities.Resources.ListChanged,
+		connectionCtx:             t.Context(),
+		switchToStreamConnection: func(time.Duration) {
+			switched = true
+		},
+	}
+	sessionID, err := q.sessionManager.Start(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, method := range []string{MethodResourceSubscribe, MethodResourceUnsubscribe} {
+		req, err := jsonrpc2.NewCall(
+			jsonrpc2.StringID("1"),
+			method,
+			map[string]any{"uri": "beer://list"},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := h.invokeMethod(t.Context(), req, sessionID); !errors.Is(err, jsonrpc2.ErrMethodNotFound) {
+			t.Fatalf("%s: expected %v, got %v", method, jsonrpc2.ErrMethodNotFound, err)
+		}
+	}
+	if switched {
+		t.Fatalf("expected the connection not to be switched to the stream")
+	}
+}
+
+func TestWithResourceListCache(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	q := New("test", WithResourceListCache(time.Minute), WithNowFunc(func() time.Time {
+		return now
+	}))
+	q.Resource("beer", "beer://list", func(c ResourceContext) error {
+		return c.String("beer")
+	})
+	calls := 0
+	q.ResourceList(func(c ResourceListContext) error {
+		calls++
+		return DefaultResourceListHandler(c)
+	})
+	h := &handler{qilin: q}
+	list := func() {
+		t.Helper()
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesList, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := h.handleResourcesList(t.Context(), "session", req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resources := got.(*listResourcesResult).Resources; len(resources) != 1 {
+			t.Fatalf("expected 1 resource, got %v", resources)
+		}
+	}
+
+	list()
+	list()
+	if calls != 1 {
+		t.Fatalf("expected the handler to run once within the TTL, got %d", calls)
+	}
+
+	q.resourceListChangeCtx.Publish(now)
+	list()
+	if calls != 2 {
+		t.Fatalf("expected the handler to run again after invalidation, got %d", calls)
+	}
+
+	now = now.Add(time.Minute)
+	list()
+	if calls != 3 {
+		t.Fatalf("expected the handler to run again after the TTL, got %d", calls)
+	}
+
+	// the snapshot is not shared across sessions
+	req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesList, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := h.handleResourcesList(t.Context(), "other", req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 4 {
+		t.Fatalf("expected the handler to run for the other session, got %d", calls)
+	}
+
+	// the list taken before the invalidation is not stored
+	_, generation, _ := q.resourceListCache.get("stale", now)
+	q.resourceListChangeCtx.Publish(now)
+	q.resourceListCache.set("stale", nil, now, generation)
+	if _, _, ok := q.resourceListCache.get("stale", now); ok {
+		t.Fatalf("expected the stale list not to be cached")
+	}
+}
+
+func TestResourceFunc(t *testing.T) {
+	type employeeParams struct {
+		ID int `param:"id"`
+	}
+	type employee struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	errNotFound := fmt.Errorf("%w: employee not found", jsonrpc2.ErrInvalidParams)
+	errUnexpected := errors.New("unexpected")
+	q := New("test")
+	ResourceFunc(q, "employee", "example://example.com/{id}",
+		func(ctx context.Context, params employeeParams) (employee, error) {
+			switch params.ID {
+			case 1:
+				return employee{ID: 1, Name: "Bob"}, nil
+			case 2:
+				return employee{}, errUnexpected
+			}
+			return employee{}, errNotFound
+		}, ResourceWithMimeType("application/json"))
+	h := &handler{qilin: q}
+	read := func(uri string) (interface{}, error) {
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": uri})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return h.handleResourcesRead(t.Context(), "session", req)
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		got, err := read("example://example.com/1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b, err := json.Marshal(got)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expect := `{"contents":[{"uri":"example://example.com/1","name":"employee","mimeType":"application/json","text":"{\"id\":1,\"name\":\"Bob\"}"}]}`
+		if string(b) != expect {
+			t.Fatalf("expected %s, got %s", expect, b)
+		}
+	})
+	t.Run("invalid path parameter", func(t *testing.T) {
+		if _, err := read("example://example.com/abc"); !errors.Is(err, jsonrpc2.ErrInvalidParams) {
+			t.Fatalf("expected %v, got %v", jsonrpc2.ErrInvalidParams, err)
+		}
+	})
+	t.Run("error from the handler", func(t *testing.T) {
+		if _, err := read("example://example.com/3"); !errors.Is(err, errNotFound) ||
+			!errors.Is(err, jsonrpc2.ErrInvalidParams) {
+			t.Fatalf("expected %v, got %v", errNotFound, err)
+		}
+		if _, err := read("example://example.com/2"); !errors.Is(err, errUnexpected) {
+			t.Fatalf("expected %v, got %v", errUnexpected, err)
+		}
+	})
+}
+
+func TestWithToolIdempotencyCache(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	q := New("test", WithToolIdempotencyCache(time.Minute), WithNowFunc(func() time.Time {
+		return now
+	}))
+	type req struct {
+		ID int `json:"id"`
+	}
+	idempotentCalls := 0
+	q.Tool("idempotent", (*req)(nil), func(c ToolContext) error {
+		idempotentCalls++
+		return c.String("ok")
+	}, ToolWithAnnotations(ToolAnnotations{IdempotentHint: true}))
+	nonIdempotentCalls := 0
+	q.Tool("non_idempotent", (*req)(nil), func(c ToolContext) error {
+		nonIdempotentCalls++
+		return c.String("ok")
+	})
+	h := &handler{qilin: q}
+	call := func(sessionID, name, args string) {
+		t.Helper()
+		req := &jsonrpc2.Request{
+			ID:     jsonrpc2.StringID("1"),
+			Method: MethodToolsCall,
+			Params: json.RawMessage(fmt.Sprintf(`{"name":%q,"arguments":%s}`, name, args)),
+		}
+		got, err := h.handleToolsCall(t.Context(), sessionID, req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got == nil {
+			t.Fatalf("expected result, got nil")
+		}
+	}
+
+	t.Run("idempotent", func(t *testing.T) {
+		call("s1", "idempotent", `{"id":1}`)
+		call("s1", "idempotent", `{ "id": 1 }`)
+		if idempotentCalls != 1 {
+			t.Fatalf("expected the second identical call to be served from the cache, got %d calls", idempotentCalls)
+		}
+		call("s1", "idempotent", `{"id":2}`)
+		if idempotentCalls != 2 {
+			t.Fatalf("expected a call with different arguments not to be cached, got %d calls", idempotentCalls)
+		}
+		call("s2", "idempotent", `{"id":1}`)
+		if idempotentCalls != 3 {
+			t.Fatalf("expected a call from another session not to be cached, got %d calls", idempotentCalls)
+		}
+		now = now.Add(time.Minute)
+		call("s1", "idempotent", `{"id":1}`)
+		if idempotentCalls != 4 {
+			t.Fatalf("expected the cache to expire after the TTL, got %d calls", idempotentCalls)
+		}
+	})
+	t.Run("non idempotent", func(t *testing.T) {
+		call("s1", "non_idempotent", `{"id":1}`)
+		call("s1", "non_idempotent", `{"id":1}`)
+		if nonIdempotentCalls != 2 {
+			t.Fatalf("expected non-idempotent tool not to be cached, got %d calls", nonIdempotentCalls)
+		}
+	})
+}
+
+func TestWithToolSchemaVersion(t *testing.T) {
+	type test struct {
+		options []Option
+		expect  string
+	}
+	tests := map[string]test{
+		"draft 2020-12": {
+			options: []Option{WithToolSchemaVersion(SchemaDraft202012)},
+			expect:  `"$schema":"https://json-schema.org/draft/2020-12/schema"`,
+		},
+		"draft-07": {
+			options: []Option{WithToolSchemaVersion(SchemaDraft07)},
+			expect:  `"$schema":"http://json-schema.org/draft-07/schema#"`,
+		},
+		"omitted by default": {},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := New("test", tc.options...)
+			q.Tool("greet", (*struct {
+				Name string `json:"name"`
+			})(nil), func(c ToolContext) error {
+				return c.String("hello")
+			})
+			h := &handler{qilin: q}
+			got, err := h.handleToolsList()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.expect == "" {
+				if strings.Contains(string(b), `"$schema"`) {
+					t.Fatalf("expected $schema to be omitted, got %s", b)
+				}
+				return
+			}
+			if !strings.Contains(string(b), tc.expect) {
+				t.Fatalf("expected %s in %s", tc.expect, b)
+			}
+		})
+	}
+}
+
+func TestWithContentEncoder(t *testing.T) {
+	type test struct {
+		mimeType string
+		expect   string
+	}
+	tests := map[string]test{
+		"registered mime type": {
+			mimeType: "application/x-proprietary",
+			expect:   `"blob":"716c6e"`,
+		},
+		"other mime type": {
+			mimeType: "application/octet-stream",
+			expect:   `"blob":"cWxu"`,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := New("test", WithContentEncoder("application/x-proprietary", func(data []byte) (string, error) {
+				return hex.EncodeToString(data), nil
+			}))
+			q.Resource("blob", "example://blob", func(c ResourceContext) error {
+				return c.Blob([]byte("qln"), tc.mimeType)
+			})
+			h := &handler{qilin: q}
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": "example://blob"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := h.handleResourcesRead(t.Context(), "session", req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(string(b), tc.expect) {
+				t.Fatalf("expected %s in %s", tc.expect, b)
+			}
+		})
+	}
+}
+
+func TestWithResourceChecksum(t *testing.T) {
+	data := []byte("qilin brews the blob")
+	type test struct {
+		params map[string]any
+		expect []byte
+	}
+	tests := map[string]test{
+		"whole blob": {
+			params: map[string]any{"uri": "example://blob"},
+			expect: data,
+		},
+		"range read": {
+			params: map[string]any{"uri": "example://blob", "_meta": map[string]any{"range": map[string]any{"start": 6, "end": 11}}},
+			expect: data[6:11],
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := New("test", WithResourceChecksum("sha256", SHA256Hex))
+			q.Resource("blob", "example://blob", func(c ResourceContext) error {
+				return c.Blob(data, "application/octet-stream")
+			})
+			h := &handler{qilin: q}
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, tc.params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := h.handleResourcesRead(t.Context(), "session", req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var result struct {
+				Contents []struct {
+					Blob string `json:"blob"`
+					Meta struct {
+						Checksum struct {
+							Algorithm string `json:"algorithm"`
+							Value     string `json:"value"`
+						} `json:"checksum"`
+					} `json:"_meta"`
+				} `json:"contents"`
+			}
+			if err := json.Unmarshal(b, &result); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result.Contents) != 1 {
+				t.Fatalf("expected 1 content, got %s", b)
+			}
+			content := result.Contents[0]
+			blob, err := base64.StdEncoding.DecodeString(content.Blob)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(blob, tc.expect) {
+				t.Fatalf("expected the blob %q, got %q", tc.expect, blob)
+			}
+			sum := sha256.Sum256(blob)
+			if expect := hex.EncodeToString(sum[:]); content.Meta.Checksum.Value != expect {
+				t.Fatalf("expected the checksum %s, got %s", expect, content.Meta.Checksum.Value)
+			}
+			if content.Meta.Checksum.Algorithm != "sha256" {
+				t.Fatalf("expected the algorithm sha256, got %s", content.Meta.Checksum.Algorithm)
+			}
+		})
+	}
+	t.Run("text with custom algorithm", func(t *testing.T) {
+		q := New("test", WithResourceChecksum("len", func(data []byte) string {
+			return strconv.Itoa(len(data))
+		}))
+		q.Resource("text", "example://text", func(c ResourceContext) error {
+			return c.String("qilin")
+		})
+		h := &handler{qilin: q}
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": "example://text"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := h.handleResourcesRead(t.Context(), "session", req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b, err := json.Marshal(got)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expect := `{"contents":[{"uri":"example://text","name":"text","mimeType":"text/plain","text":"qilin","_meta":{"checksum":{"algorithm":"len","value":"5"}}}]}`
+		if string(b) != expect {
+			t.Fatalf("expected %s, got %s", expect, b)
+		}
+	})
+}
+
+func TestWithMaxResponseBytes(t *testing.T) {
+	// `{"type":"text","text":"麒麟 brews"}` is 37 bytes.
+	const text = "麒麟 brews"
+	type test struct {
+		limit     int
+		mode      ResponseLimitMode
+		expectErr error
+		expect    string
+	}
+	t.Run("tool", func(t *testing.T) {
+		tests := map[string]test{
+			"under limit": {
+				limit:  37,
+				mode:   ResponseLimitError,
+				expect: `{"type":"text","text":"麒麟 brews"}`,
+			},
+			"truncate": {
+				limit:  31,
+				mode:   ResponseLimitTruncate,
+				expect: `{"type":"text","text":"麒麟"}`,
+			},
+			"truncate at the rune boundary": {
+				limit:  30,
+				mode:   ResponseLimitTruncate,
+				expect: `{"type":"text","text":"麒"}`,
+			},
+			"error": {
+				limit:     36,
+				mode:      ResponseLimitError,
+				expectErr: ErrResponseTooLarge,
+			},
+			"too large to truncate": {
+				limit:     20,
+				mode:      ResponseLimitTruncate,
+				expectErr: ErrResponseTooLarge,
+			},
+		}
+		for name, tc := range tests {
+			t.Run(name, func(t *testing.T) {
+				q := New("test", WithMaxResponseBytes(tc.limit, tc.mode))
+				q.Tool("brew", (*struct{})(nil), func(c ToolContext) error {
+					return c.String(text)
+				})
+				h := &handler{qilin: q}
+				req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodToolsCall, map[string]any{"name": "brew"})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				got, err := h.handleToolsCall(t.Context(), "session", req)
+				if tc.expectErr != nil {
+					if !errors.Is(err, tc.expectErr) {
+						t.Fatalf("expected %v, got %v", tc.expectErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				b, err := json.Marshal(got)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(b) != tc.expect {
+					t.Fatalf("expected %s, got %s", tc.expect, b)
+				}
+				if len(b) > tc.limit {
+					t.Fatalf("expected at most %d bytes, got %d", tc.limit, len(b))
+				}
+			})
+		}
+	})
+	t.Run("resource", func(t *testing.T) {
+		// the result with the blob and the text is 209 bytes, and 124 bytes without the text.
+		tests := map[string]test{
+			"under limit": {
+				limit:  209,
+				mode:   ResponseLimitError,
+				expect: `{"contents":[{"uri":"example://brew","name":"brew","mimeType":"application/octet-stream","blob":"cWxu","_meta":{"size":3}},{"uri":"example://brew","name":"brew","mimeType":"text/plain","text":"麒麟 brews"}]}`,
+			},
+			"truncate the text": {
+				limit:  203,
+				mode:   ResponseLimitTruncate,
+				expect: `{"contents":[{"uri":"example://brew","name":"brew","mimeType":"application/octet-stream","blob":"cWxu","_meta":{"size":3}},{"uri":"example://brew","name":"brew","mimeType":"text/plain","text":"麒麟"}]}`,
+			},
+			"drop the trailing content": {
+				limit:  150,
+				mode:   ResponseLimitTruncate,
+				expect: `{"contents":[{"uri":"example://brew","name":"brew","mimeType":"application/octet-stream","blob":"cWxu","_meta":{"size":3}}]}`,
+			},
+			"error": {
+				limit:     208,
+				mode:      ResponseLimitError,
+				expectErr: ErrResponseTooLarge,
+			},
+			"too large to truncate": {
+				limit:     100,
+				mode:      ResponseLimitTruncate,
+				expectErr: ErrResponseTooLarge,
+			},
+		}
+		for name, tc := range tests {
+			t.Run(name, func(t *testing.T) {
+				q := New("test", WithMaxResponseBytes(tc.limit, tc.mode))
+				q.Resource("brew", "example://brew", func(c ResourceContext) error {
+					if err := c.Blob([]byte("qln"), "application/octet-stream"); err != nil {
+						return err
+					}
+					return c.String(text)
+				})
+				h := &handler{qilin: q}
+				req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": "example://brew"})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				got, err := h.handleResourcesRead(t.Context(), "session", req)
+				if tc.expectErr != nil {
+					if !errors.Is(err, tc.expectErr) {
+						t.Fatalf("expected %v, got %v", tc.expectErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				b, err := json.Marshal(got)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(b) != tc.expect {
+					t.Fatalf("expected %s, got %s", tc.expect, b)
+				}
+				if len(b) > tc.limit {
+					t.Fatalf("expected at most %d bytes, got %d", tc.limit, len(b))
+				}
+			})
+		}
+	})
+}
+
+func TestWithURIRewriter(t *testing.T) {
+	type test struct {
+		uri    string
+		expect string
+	}
+	tests := map[string]test{
+		"rewritten": {
+			uri:    "old://beers/1",
+			expect: `{"contents":[{"uri":"new://beers/1","name":"beer","mimeType":"text/plain","text":"beer 1"}]}`,
+		},
+		"as is": {
+			uri:    "new://beers/2",
+			expect: `{"contents":[{"uri":"new://beers/2","name":"beer","mimeType":"text/plain","text":"beer 2"}]}`,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := New("test", WithURIRewriter(func(uri *url.URL) *url.URL {
+				if uri.Scheme != "old" {
+					return nil
+				}
+				rewritten := *uri
+				rewritten.Scheme = "new"
+				return &rewritten
+			}))
+			q.Resource("beer", "new://beers/{id}", func(c ResourceContext) error {
+				return c.String("beer " + c.Param("id"))
+			})
+			h := &handler{qilin: q}
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": tc.uri})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := h.handleResourcesRead(t.Context(), "session", req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != tc.expect {
+				t.Fatalf("expected %s, got %s", tc.expect, b)
+			}
+		})
+	}
+}
+
+func TestHandler_handleResourcesRead_mimeType(t *testing.T) {
+	q := New("test")
+	q.Resource("menu", "example://menu", func(c ResourceContext) error {
+		return c.String("# menu")
+	}, ResourceWithMimeType("text/markdown"))
+	q.Resource("beer", "example://beers/{id}", func(c ResourceContext) error {
+		return c.JSON(map[string]string{"id": c.Param("id")})
+	}, ResourceWithMimeType("application/vnd.beer+json"))
+	// registering again at the same URI updates the MIME type.
+	q.Resource("menu", "example://menu", func(c ResourceContext) error {
+		return c.String("<h1>menu</h1>")
+	}, ResourceWithMimeType("text/html"))
+	h := &handler{qilin: q}
+	tests := map[string]string{
+		"example://menu":    "text/html",
+		"example://beers/1": "application/vnd.beer+json",
+	}
+	for uri, expect := range tests {
+		t.Run(uri, func(t *testing.T) {
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": uri})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := h.handleResourcesRead(t.Context(), "session", req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if mimeType := got.(*readResourceResult).Contents[0].GetMimeType(); mimeType != expect {
+				t.Fatalf("expected '%s', got '%s'", expect, mimeType)
+			}
+		})
+	}
+}
+
+func TestWithDefaultMimeTypes(t *testing.T) {
+	type test struct {
+		options    []Option
+		expectText string
+		expectJSON string
+	}
+	tests := map[string]test{
+		"default": {
+			expectText: "text/plain",
+			expectJSON: "application/json",
+		},
+		"overridden": {
+			options: []Option{
+				WithDefaultTextMimeType("text/plain; charset=utf-8"),
+				WithDefaultJSONMimeType("application/json; charset=utf-8"),
+			},
+			expectText: "text/plain; charset=utf-8",
+			expectJSON: "application/json; charset=utf-8",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := New("test", tc.options...)
+			q.Resource("text", "example://text", func(c ResourceContext) error {
+				return c.String("text")
+			})
+			q.Resource("json", "example://json", func(c ResourceContext) error {
+				return c.JSON(map[string]string{"k": "v"})
+			})
+			q.Resource("specified", "example://specified", func(c ResourceContext) error {
+				return c.String("text")
+			}, ResourceWithMimeType("text/markdown"))
+			q.Tool("text", (*struct{})(nil), func(c ToolContext) error {
+				return c.StringResource(MustURL(t, "example://text"), "text", "")
+			})
+			q.Tool("json", (*struct{})(nil), func(c ToolContext) error {
+				return c.JSONResource(MustURL(t, "example://json"), map[string]string{"k": "v"}, "")
+			})
+			h := &handler{qilin: q}
+			readMimeType := func(uri string) string {
+				req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": uri})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				got, err := h.handleResourcesRead(t.Context(), "session", req)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return got.(*readResourceResult).Contents[0].GetMimeType()
+			}
+			callMimeType := func(name string) string {
+				req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodToolsCall, map[string]any{"name": name})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				got, err := h.handleToolsCall(t.Context(), "session", req)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return got.(*embedResourceCallToolContent).Resource.GetMimeType()
+			}
+			for _, v := range []struct{ got, expect string }{
+				{readMimeType("example://text"), tc.expectText},
+				{readMimeType("example://json"), tc.expectJSON},
+				{readMimeType("example://specified"), "text/markdown"},
+				{callMimeType("text"), tc.expectText},
+				{callMimeType("json"), tc.expectJSON},
+			} {
+				if v.got != v.expect {
+					t.Fatalf("expected '%s', got '%s'", v.expect, v.got)
+				}
+			}
+		})
+	}
+}
+
+func TestWithArgumentHasher(t *testing.T) {
+	var hashed []string
+	q := New("test", WithToolIdempotencyCache(time.Minute), WithArgumentHasher(func(args []byte) string {
+		hashed = append(hashed, string(args))
+		return "fixed"
+	}))
+	calls := 0
+	q.Tool("idempotent", (*struct {
+		ID int `json:"id"`
+	})(nil), func(c ToolContext) error {
+		calls++
+		return c.String("ok")
+	}, ToolWithAnnotations(ToolAnnotations{IdempotentHint: true}))
+	h := &handler{qilin: q}
+	for _, args := range []string{`{ "id": 1 }`, `{"id":2}`} {
+		req := &jsonrpc2.Request{
+			ID:     jsonrpc2.StringID("1"),
+			Method: MethodToolsCall,
+			Params: json.RawMessage(fmt.Sprintf(`{"name":"idempotent","arguments":%s}`, args)),
+		}
+		if _, err := h.handleToolsCall(t.Context(), "s1", req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if expect := []string{`{"id":1}`, `{"id":2}`}; !slices.Equal(hashed, expect) {
+		t.Fatalf("expected the compacted arguments %v to be hashed, got %v", expect, hashed)
+	}
+	// the colliding hash serves the second call from the cache.
+	if calls != 1 {
+		t.Fatalf("expected the cache key to be made from the configured hasher, got %d calls", calls)
+	}
+}
+
+func TestHandler_resetLifecycle(t *testing.T) {
+	// newHandler returns the handler bound to a connection, as binder.Bind does.
+	newHandler := func(connectionCtx context.Context) *handler {
+		h := &handler{qilin: New("test"), connectionCtx: connectionCtx}
+		h.runningMu.Lock()
+		h.resetOnce = &sync.Once{}
+		return h
+	}
+	// waitReset waits for the handler to be reset, which unlocks the running state.
+	waitReset := func(t *testing.T, h *handler, within time.Duration) bool {
+		t.Helper()
+		deadline := time.Now().Add(within)
+		for time.Now().Before(deadline) {
+			if h.runningMu.TryLock() {
+				h.runningMu.Unlock()
+				return true
+			}
+			time.Sleep(time.Millisecond)
+		}
+		return false
+	}
+
+	t.Run("reset once the connection ends", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		h := newHandler(ctx)
+		h.resetOnConnectionEnd()
+		for range 2 {
+			end := h.beginHandle()
+			end()
+		}
+		if waitReset(t, h, 50*time.Millisecond) {
+			t.Fatalf("expected the handler not to be reset while the connection is alive")
+		}
+		cancel()
+		if !waitReset(t, h, time.Second) {
+			t.Fatalf("expected the handler to be reset once the connection ends")
+		}
+	})
+	t.Run("reset after each request without the connection context", func(t *testing.T) {
+		h := newHandler(nil)
+		end := h.beginHandle()
+		end()
+		if !waitReset(t, h, time.Second) {
+			t.Fatalf("expected the handler to be reset after the request")
+		}
+	})
+	t.Run("not reset while handling the request", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		h := newHandler(ctx)
+		h.resetOnConnectionEnd()
+		end := h.beginHandle()
+		cancel()
+		if waitReset(t, h, 50*time.Millisecond) {
+			t.Fatalf("expected the handler not to be reset while handling the request")
+		}
+		end()
+		if !waitReset(t, h, time.Second) {
+			t.Fatalf("expected the handler to be reset once the request is handled")
+		}
+	})
+}
+
+func TestHandler_connectionDone(t *testing.T) {
+	q := New("test")
+	var done <-chan struct{}
+	q.Tool("background", (*struct{})(nil), func(c ToolContext) error {
+		done = c.ConnectionDone()
+		return c.String("ok")
+	})
+	connectionCtx, cancel := context.WithCancel(t.Context())
+	h := &handler{qilin: q, connectionCtx: connectionCtx}
+	req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodToolsCall, map[string]any{"name": "background"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := h.handleToolsCall(t.Context(), "session", req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case <-done:
+		t.Fatalf("expected the channel to be open while the connection is alive")
+	default:
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the channel to be closed when the connection ends")
+	}
+}
+
+func TestQilin_Tool_name(t *testing.T) {
+	handler := func(c ToolContext) error {
+		return c.String("ok")
+	}
+	register := func(q *Qilin, name string) (err error) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				err = rec.(error)
+			}
+		}()
+		q.Tool(name, (*struct{})(nil), handler)
+		return nil
+	}
+	type test struct {
+		names   []string
+		want    string
+		wantErr error
+	}
+	tests := map[string]test{
+		"happy path": {
+			names: []string{"get_weather-v1.0"},
+			want:  "get_weather-v1.0",
+		},
+		"empty": {
+			names:   []string{""},
+			wantErr: ErrInvalidToolName,
+		},
+		"whitespace": {
+			names:   []string{"get weather"},
+			wantErr: ErrInvalidToolName,
+		},
+		"reserved character": {
+			names:   []string{"weather/get"},
+			wantErr: ErrInvalidToolName,
+		},
+		"too long": {
+			names:   []string{strings.Repeat("a", 129)},
+			wantErr: ErrInvalidToolName,
+		},
+		"duplicate": {
+			names:   []string{"get_weather", "get_weather"},
+			wantErr: ErrDuplicateToolName,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := New("test")
+			var err error
+			for _, v := range tc.names {
+				if err = register(q, v); err != nil {
+					break
+				}
+			}
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, ok := q.tools[tc.want]; !ok {
+				t.Fatalf("expected '%s' to be registered, got %v", tc.want, q.tools)
+			}
+		})
+	}
+	t.Run("normalized", func(t *testing.T) {
+		q := New("test", WithToolNameNormalizer(SnakeCaseToolName))
+		if err := register(q, " Get Weather "); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok := q.tools["get_weather"]; !ok {
+			t.Fatalf("expected 'get_weather' to be registered, got %v", q.tools)
+		}
+		if err := register(q, "get weather"); !errors.Is(err, ErrDuplicateToolName) {
+			t.Fatalf("expected %v, got %v", ErrDuplicateToolName, err)
+		}
+	})
+}
+
+func TestQilin_inventory(t *testing.T) {
+	q := New("test")
+	q.Tool("weather", (*struct {
+		City string `json:"city"`
+	})(nil), func(c ToolContext) error {
+		return c.String("sunny")
+	}, ToolWithDescription("Get the weather"), ToolWithAnnotations(ToolAnnotations{ReadOnlyHint: true}))
+	q.Tool("alert", (*struct{})(nil), func(c ToolContext) error {
+		return c.String("ok")
+	})
+	q.Resource("forecast", "weather://forecast/{city}", func(c ResourceContext) error {
+		return c.String("sunny")
+	})
+	q.Resource("cities", "weather://cities", func(c ResourceContext) error {
+		return c.String("tokyo")
+	}, ResourceWithDescription("The list of the cities"))
+	q.Prompt("greeting", func(c PromptContext) error {
+		return c.String(PromptRoleUser, "hello")
+	}, PromptWithArguments(PromptArgument{Name: "name", Required: true}))
+
+	tools := q.Tools()
+	if got := []string{tools[0].Name, tools[1].Name}; len(tools) != 2 || !slices.Equal(got, []string{"alert", "weather"}) {
+		t.Fatalf("expected the tools [alert weather], got %v", tools)
+	}
+	if tools[1].Description != "Get the weather" || !tools[1].Annotations.ReadOnlyHint {
+		t.Fatalf("expected the metadata of the tool, got %+v", tools[1])
+	}
+	if _, ok := tools[1].InputSchema.Properties.Get("city"); !ok {
+		t.Fatalf("expected the input schema of the tool, got %+v", tools[1].InputSchema)
+	}
+
+	resources := q.Resources()
+	if len(resources) != 2 || resources[0].URI.String() != "weather://cities" || resources[1].URI.String() != "weather://forecast/{city}" {
+		t.Fatalf("expected the resources [weather://cities weather://forecast/{city}], got %v", resources)
+	}
+	if resources[0].Description != "The list of the cities" {
+		t.Fatalf("expected the description of the resource, got %+v", resources[0])
+	}
+
+	prompts := q.Prompts()
+	if len(prompts) != 1 || prompts[0].Name != "greeting" || len(prompts[0].Arguments) != 1 || prompts[0].Arguments[0].Name != "name" {
+		t.Fatalf("expected the prompt greeting with the argument name, got %+v", prompts)
+	}
+
+	// the copies do not affect the registrations
+	tools[1].Annotations.ReadOnlyHint = false
+	tools[1].InputSchema.Properties.Delete("city")
+	resources[0].URI.URL().Host = "changed"
+	prompts[0].Arguments[0].Name = "changed"
+	if !q.Tools()[1].Annotations.ReadOnlyHint {
+		t.Fatal("expected the registered annotations to be kept")
+	}
+	if _, ok := q.Tools()[1].InputSchema.Properties.Get("city"); !ok {
+		t.Fatal("expected the registered input schema to be kept")
+	}
+	if got := q.Resources()[0].URI.URL().Host; got != "cities" {
+		t.Fatalf("expected the registered uri to be kept, got %s", got)
+	}
+	if got := q.Prompts()[0].Arguments[0].Name; got != "name" {
+		t.Fatalf("expected the registered argument to be kept, got %s", got)
+	}
+}
+
+func TestQilin_Group(t *testing.T) {
+	t.Run("prefixed names", func(t *testing.T) {
+		q := New("test")
+		billing := q.Group(GroupWithNamePrefix("billing."))
+		billing.Tool("charge", (*struct{})(nil), func(c ToolContext) error {
+			return c.String("ok")
+		})
+		billing.Group(GroupWithNamePrefix("v2.")).Tool("charge", (*struct{})(nil), func(c ToolContext) error {
+			return c.String("ok")
+		})
+		billing.Prompt("summary", func(c PromptContext) error {
+			return c.String(PromptRoleUser, "summary")
+		})
+		billing.Resource("invoices", "billing://invoices", func(c ResourceContext) error {
+			return c.String("[]")
+		})
+		q.Prompt("summary", func(c PromptContext) error {
+			return c.String(PromptRoleUser, "summary")
+		})
+		h := &handler{qilin: q}
+
+		tools, err := h.handleToolsList()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var toolNames []string
+		for _, v := range tools.(*listToolsResponse).Tools {
+			toolNames = append(toolNames, v.Name)
+		}
+		slices.Sort(toolNames)
+		if expect := []string{"billing.charge", "billing.v2.charge"}; !slices.Equal(toolNames, expect) {
+			t.Fatalf("expected %v, got %v", expect, toolNames)
+		}
+
+		prompts, err := h.handlePromptsList()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var promptNames []string
+		for _, v := range prompts.(*listPromptsResult).Prompts {
+			promptNames = append(promptNames, v.Name)
+		}
+		slices.Sort(promptNames)
+		if expect := []string{"billing.summary", "summary"}; !slices.Equal(promptNames, expect) {
+			t.Fatalf("expected %v, got %v", expect, promptNames)
+		}
+
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesList, map[string]any{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resources, err := h.handleResourcesList(t.Context(), "session", req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		listed := resources.(*listResourcesResult).Resources
+		if len(listed) != 1 || listed[0].Name != "billing.invoices" || listed[0].URI.String() != "billing://invoices" {
+			t.Fatalf("expected 'billing.invoices' at 'billing://invoices', got %v", listed)
+		}
+	})
+	t.Run("shared middleware and URI prefix", func(t *testing.T) {
+		q := New("test")
+		var calls []string
+		trace := func(name string) ToolMiddlewareFunc {
+			return func(next ToolHandlerFunc) ToolHandlerFunc {
+				return func(c ToolContext) error {
+					calls = append(calls, name)
+					return next(c)
+				}
+			}
+		}
+		billing := q.Group(
+			GroupWithNamePrefix("billing."),
+			GroupWithURIPrefix("billing://"),
+			GroupWithToolMiddleware(trace("group")),
+			GroupWithResourceMiddleware(func(next ResourceHandlerFunc) ResourceHandlerFunc {
+				return func(c ResourceContext) error {
+					calls = append(calls, "resource group")
+					return next(c)
+				}
+			}),
+		)
+		billing.Group(GroupWithToolMiddleware(trace("nested group"))).
+			Tool("charge", (*struct{})(nil), func(c ToolContext) error {
+				calls = append(calls, "handler")
+				return c.String("ok")
+			}, ToolWithMiddleware(trace("tool")))
+		billing.Resource("invoice", "invoices/{id}", func(c ResourceContext) error {
+			return c.String("invoice " + c.Param("id"))
+		})
+		h := &handler{qilin: q}
+
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodToolsCall, map[string]any{"name": "billing.charge"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := h.handleToolsCall(t.Context(), "session", req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expect := []string{"group", "nested group", "tool", "handler"}; !slices.Equal(calls, expect) {
+			t.Fatalf("expected %v, got %v", expect, calls)
+		}
+
+		calls = nil
+		req, err = jsonrpc2.NewCall(jsonrpc2.StringID("2"), MethodResourcesRead, map[string]any{"uri": "billing://invoices/1"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := h.handleResourcesRead(t.Context(), "session", req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b, err := json.Marshal(got)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(string(b), `"text":"invoice 1"`) {
+			t.Fatalf("expected the invoice to be read, got %s", b)
+		}
+		if expect := []string{"resource group"}; !slices.Equal(calls, expect) {
+			t.Fatalf("expected %v, got %v", expect, calls)
+		}
+	})
+	t.Run("collision", func(t *testing.T) {
+		register := func(f func()) (err error) {
+			defer func() {
+				if rec := recover(); rec != nil {
+					err = rec.(error)
+				}
+			}()
+			f()
+			return nil
+		}
+		q := New("test")
+		q.Prompt("billing.summary", func(c PromptContext) error {
+			return c.String(PromptRoleUser, "summary")
+		})
+		err := register(func() {
+			q.Group(GroupWithNamePrefix("billing.")).Prompt("summary", func(c PromptContext) error {
+				return c.String(PromptRoleUser, "summary")
+			})
+		})
+		if !errors.Is(err, ErrDuplicatePromptName) {
+			t.Fatalf("expected %v, got %v", ErrDuplicatePromptName, err)
+		}
+		q.Tool("billing.charge", (*struct{})(nil), func(c ToolContext) error {
+			return c.String("ok")
+		})
+		err = register(func() {
+			q.Group(GroupWithNamePrefix("billing.")).Tool("charge", (*struct{})(nil), func(c ToolContext) error {
+				return c.String("ok")
+			})
+		})
+		if !errors.Is(err, ErrDuplicateToolName) {
+			t.Fatalf("expected %v, got %v", ErrDuplicateToolName, err)
+		}
+	})
+}
+
+func TestHandler_handleResourcesRead_nameAndDescription(t *testing.T) {
+	q := New("test")
+	q.Resource("beer_list", "beer://list", func(c ResourceContext) error {
+		return c.String("beer")
+	}, ResourceWithDescription("List of beers"))
+	q.Resource("beer_image", "beer://image", func(c ResourceContext) error {
+		return c.Blob([]byte("raw"), "image/png")
+	})
+	h := &handler{qilin: q}
+	type test struct {
+		uri    string
+		expect string
+	}
+	tests := map[string]test{
+		"with description": {
+			uri:    "beer://list",
+			expect: `{"contents":[{"uri":"beer://list","name":"beer_list","description":"List of beers","mimeType":"text/plain","text":"beer"}]}`,
+		},
+		"without description": {
+			uri:    "beer://image",
+			expect: `{"contents":[{"uri":"beer://image","name":"beer_image","mimeType":"image/png","blob":"cmF3","_meta":{"size":3}}]}`,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": tc.uri})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := h.handleResourcesRead(t.Context(), "session", req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != tc.expect {
+				t.Fatalf("expected %s, got %s", tc.expect, b)
+			}
+		})
+	}
+}
+
+func TestHandler_handleResourcesRead_templateFallback(t *testing.T) {
+	q := New("test")
+	// the concrete routes registered only for observing changes have no handler.
+	q.ResourceChangeObserver("weather://forecast/paris", func(c ResourceChangeContext) {})
+	q.ResourceChangeObserver("weather://alerts/{region}", func(c ResourceChangeContext) {})
+	q.Resource("city_forecast", "weather://forecast/{city}", func(c ResourceContext) error {
+		return c.String("sunny in " + c.Param("city"))
+	})
+	h := &handler{qilin: q}
+	type test struct {
+		uri     string
+		expect  string
+		wantErr error
+	}
+	tests := map[string]test{
+		"concrete route without handler falls back to the template": {
+			uri:    "weather://forecast/paris",
+			expect: `{"contents":[{"uri":"weather://forecast/paris","name":"city_forecast","mimeType":"text/plain","text":"sunny in paris"}]}`,
+		},
+		"no concrete route": {
+			uri:    "weather://forecast/tokyo",
+			expect: `{"contents":[{"uri":"weather://forecast/tokyo","name":"city_forecast","mimeType":"text/plain","text":"sunny in tokyo"}]}`,
+		},
+		"templated route without handler": {
+			uri:     "weather://alerts/europe",
+			wantErr: ErrResourceNotFound,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": tc.uri})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := h.handleResourcesRead(t.Context(), "session", req)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != tc.expect {
+				t.Fatalf("expected %s, got %s", tc.expect, b)
+			}
+		})
+	}
+}
+
+func TestHandler_handleResourcesRead_lastModified(t *testing.T) {
+	now := time.Date(2025, 4, 1, 9, 0, 0, 0, time.UTC)
+	registered := time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC)
+	set := time.Date(2025, 3, 15, 8, 0, 0, 0, time.UTC)
+	q := New("test", WithNowFunc(func() time.Time { return now }))
+	q.Resource("plain", "file:///plain", func(c ResourceContext) error {
+		return c.String("plain")
+	})
+	q.Resource("clock", "file:///clock", func(c ResourceContext) error {
+		return c.String("clock")
+	}, ResourceWithLastModified(time.Time{}))
+	q.Resource("registered", "file:///registered", func(c ResourceContext) error {
+		return c.Blob([]byte("registered"), "application/octet-stream")
+	}, ResourceWithLastModified(registered))
+	q.Resource("set", "file:///set", func(c ResourceContext) error {
+		c.SetLastModified(set)
+		return c.String("set")
+	}, ResourceWithLastModified(registered))
+	h := &handler{qilin: q}
+	type test struct {
+		uri    string
+		expect string
+	}
+	tests := map[string]test{
+		"not reported": {
+			uri:    "file:///plain",
+			expect: `{"contents":[{"uri":"file:///plain","name":"plain","mimeType":"text/plain","text":"plain"}]}`,
+		},
+		"defaults to the clock": {
+			uri:    "file:///clock",
+			expect: `{"contents":[{"uri":"file:///clock","name":"clock","mimeType":"text/plain","lastModified":"2025-04-01T09:00:00Z","text":"clock"}]}`,
+		},
+		"registration-time annotation": {
+			uri:    "file:///registered",
+			expect: `{"contents":[{"uri":"file:///registered","name":"registered","mimeType":"application/octet-stream","lastModified":"2025-03-01T12:30:00Z","blob":"cmVnaXN0ZXJlZA==","_meta":{"size":10}}]}`,
+		},
+		"set by the handler": {
+			uri:    "file:///set",
+			expect: `{"contents":[{"uri":"file:///set","name":"set","mimeType":"text/plain","lastModified":"2025-03-15T08:00:00Z","text":"set"}]}`,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": tc.uri})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := h.handleResourcesRead(t.Context(), "session", req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != tc.expect {
+				t.Fatalf("expected %s, got %s", tc.expect, b)
+			}
+		})
+	}
+}
+
+func TestHandler_handleResourcesRead_children(t *testing.T) {
+	q := New("test")
+	q.Resource("directory", "file://docs/{dir}", func(c ResourceContext) error {
+		var children []Resource
+		for _, name := range []string{"intro.md", "usage.md"} {
+			uri, err := ParseResourceURI("file://docs/" + c.Param("dir") + "/" + name)
+			if err != nil {
+				return err
+			}
+			children = append(children, Resource{URI: uri, Name: name, MimeType: "text/markdown"})
+		}
+		return c.Children(children)
+	})
+	q.Resource("broken", "file://broken", func(c ResourceContext) error {
+		return c.Children([]Resource{{Name: "no uri"}})
+	})
+	h := &handler{qilin: q}
+	type test struct {
+		uri       string
+		expect    string
+		expectErr error
+	}
+	tests := map[string]test{
+		"collection": {
+			uri:    "file://docs/guides",
+			expect: `{"contents":[{"type":"resource_link","uri":"file://docs/guides/intro.md","name":"intro.md","mimeType":"text/markdown"},{"type":"resource_link","uri":"file://docs/guides/usage.md","name":"usage.md","mimeType":"text/markdown"}]}`,
+		},
+		"child without uri": {
+			uri:       "file://broken",
+			expectErr: ErrInvalidResourceURI,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": tc.uri})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := h.handleResourcesRead(t.Context(), "session", req)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != tc.expect {
+				t.Fatalf("expected %s, got %s", tc.expect, b)
+			}
+		})
+	}
+}
+
+func TestWithResourceReadCache(t *testing.T) {
+	now := time.Date(2025, 4, 1, 9, 0, 0, 0, time.UTC)
+	q := New("test", WithResourceReadCache(time.Minute), WithNowFunc(func() time.Time { return now }))
+	reads := make(map[string]int)
+	q.Resource("forecast", "weather://forecast/{city}", func(c ResourceContext) error {
+		reads[c.Param("city")]++
+		return c.String(fmt.Sprintf("%s #%d", c.Param("city"), reads[c.Param("city")]))
+	})
+	q.ResourceChangeObserver("weather://forecast/{city}", nil)
+	h := &handler{qilin: q}
+	read := func(uri string) string {
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": uri})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := h.handleResourcesRead(t.Context(), "session", req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		runtime.GC()
+		b, err := json.Marshal(got)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return string(b)
+	}
+	expect := func(uri, text string) {
+		t.Helper()
+		want := fmt.Sprintf(`{"contents":[{"uri":"%s","name":"forecast","mimeType":"text/plain","text":"%s"}]}`, uri, text)
+		if got := read(uri); got != want {
+			t.Fatalf("expected %s, got %s", want, got)
+		}
+	}
+
+	expect("weather://forecast/tokyo", "tokyo #1")
+	expect("weather://forecast/tokyo", "tokyo #1")
+	expect("weather://forecast/london", "london #1")
+
+	now = now.Add(30 * time.Second)
+	expect("weather://forecast/tokyo", "tokyo #1")
+
+	if err := q.PublishResourceChange(MustURL(t, "weather://forecast/tokyo"), now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect("weather://forecast/tokyo", "tokyo #2")
+	expect("weather://forecast/london", "london #1")
+
+	now = now.Add(time.Minute)
+	expect("weather://forecast/london", "london #2")
+
+	if err := q.PublishResourceChange(MustURL(t, "weather://forecast/{city}"), now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect("weather://forecast/tokyo", "tokyo #3")
+	expect("weather://forecast/london", "london #3")
+
+	// the results are not shared across sessions
+	req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": "weather://forecast/tokyo"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := h.handleResourcesRead(t.Context(), "other", req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reads["tokyo"] != 4 {
+		t.Fatalf("expected the handler to run for the other session, got %d", reads["tokyo"])
+	}
+	expect("weather://forecast/tokyo", "tokyo #3")
+}
+
+func TestResourceReadCache_staleSet(t *testing.T) {
+	now := time.Date(2025, 4, 1, 9, 0, 0, 0, time.UTC)
+	c := &resourceReadCache{
+		ttl:     time.Minute,
+		entries: make(map[resourceReadCacheKey]resourceReadCacheEntry),
+	}
+	uri := MustURL(t, "weather://forecast/tokyo")
+	_, generation, ok := c.get("session", uri, now)
+	if ok {
+		t.Fatalf("expected no cached result")
+	}
+	// the resource changes while reading it
+	c.invalidate(uri)
+	result := &readResourceResult{Contents: []ResourceContent{textResourceContent{text: "stale"}}}
+	if _, ok := c.set("session", uri, result, now, generation); !ok {
+		t.Fatalf("expected the result to be returned")
+	}
+	if _, _, ok := c.get("session", uri, now); ok {
+		t.Fatalf("expected the read started before the invalidation not to be cached")
+	}
+}
+
+func TestHandler_handleResourcesList_lastModified(t *testing.T) {
+	now := time.Date(2025, 4, 1, 9, 0, 0, 0, time.UTC)
+	registered := time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC)
+	q := New("test", WithNowFunc(func() time.Time { return now }))
+	read := func(c ResourceContext) error {
+		return c.String("")
+	}
+	q.Resource("plain", "file:///plain", read)
+	q.Resource("clock", "file:///clock", read, ResourceWithLastModified(time.Time{}))
+	q.Resource("registered", "file:///registered", read, ResourceWithLastModified(registered))
+	h := &handler{qilin: q}
+	req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesList, map[string]any{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := h.handleResourcesList(t.Context(), "session", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var listed struct {
+		Resources []struct {
+			Name         string `json:"name"`
+			LastModified string `json:"lastModified"`
+		} `json:"resources"`
+	}
+	if err := json.Unmarshal(b, &listed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect := map[string]string{
+		"plain":      "",
+		"clock":      "2025-04-01T09:00:00Z",
+		"registered": "2025-03-01T12:30:00Z",
+	}
+	if len(listed.Resources) != len(expect) {
+		t.Fatalf("expected %d resources, got %s", len(expect), b)
+	}
+	for _, r := range listed.Resources {
+		if r.LastModified != expect[r.Name] {
+			t.Errorf("expected lastModified of %s to be %q, got %q", r.Name, expect[r.Name], r.LastModified)
+		}
+	}
+}
+
+func TestResourceWithParamEnum(t *testing.T) {
+	q := New("test")
+	q.Resource("forecast", "weather://forecast/{city}/{unit}", func(c ResourceContext) error {
+		return c.String(c.Param("city") + " in " + c.Param("unit"))
+	}, ResourceWithParamEnum("unit", "celsius", "fahrenheit", "kelvin"))
+	h := &handler{qilin: q}
+	if q.capabilities.Completions == nil {
+		t.Fatal("expected the completions capability to be advertised")
+	}
+
+	t.Run("template list", func(t *testing.T) {
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesTemplatesList, map[string]any{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := h.handleResourcesTemplatesList(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b, err := json.Marshal(got)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expect := `{"resourceTemplates":[{"uriTemplate":"weather://forecast/{city}/{unit}","name":"forecast","_meta":{"paramEnums":{"unit":["celsius","fahrenheit","kelvin"]}}}]}`
+		if string(b) != expect {
+			t.Fatalf("expected %s, got %s", expect, b)
+		}
+	})
+	t.Run("completion", func(t *testing.T) {
+		type test struct {
+			params map[string]any
+			expect string
+		}
+		tests := map[string]test{
+			"enum values matching the prefix": {
+				params: map[string]any{
+					"ref":      map[string]any{"type": "ref/resource", "uri": "weather://forecast/{city}/{unit}"},
+					"argument": map[string]any{"name": "unit", "value": "c"},
+				},
+				expect: `{"completion":{"values":["celsius"],"total":1,"hasMore":false}}`,
+			},
+			"all enum values": {
+				params: map[string]any{
+					"ref":      map[string]any{"type": "ref/resource", "uri": "weather://forecast/{city}/{unit}"},
+					"argument": map[string]any{"name": "unit", "value": ""},
+				},
+				expect: `{"completion":{"values":["celsius","fahrenheit","kelvin"],"total":3,"hasMore":false}}`,
+			},
+			"parameter without enum": {
+				params: map[string]any{
+					"ref":      map[string]any{"type": "ref/resource", "uri": "weather://forecast/{city}/{unit}"},
+					"argument": map[string]any{"name": "city", "value": "pa"},
+				},
+				expect: `{"completion":{"values":[],"total":0,"hasMore":false}}`,
+			},
+			"unknown template": {
+				params: map[string]any{
+					"ref":      map[string]any{"type": "ref/resource", "uri": "weather://alerts/{region}"},
+					"argument": map[string]any{"name": "region", "value": ""},
+				},
+				expect: `{"completion":{"values":[],"total":0,"hasMore":false}}`,
+			},
+		}
+		for name, tc := range tests {
+			t.Run(name, func(t *testing.T) {
+				req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodCompletionComplete, tc.params)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				got, err := h.dispatchMethod(t.Context(), req, "")
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				b, err := json.Marshal(got)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(b) != tc.expect {
+					t.Fatalf("expected %s, got %s", tc.expect, b)
+				}
+			})
+		}
+	})
+	t.Run("read", func(t *testing.T) {
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{
+			"uri": "weather://forecast/paris/celsius",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := h.handleResourcesRead(t.Context(), "session", req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		req, err = jsonrpc2.NewCall(jsonrpc2.StringID("2"), MethodResourcesRead, map[string]any{
+			"uri": "weather://forecast/paris/rankine",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = h.handleResourcesRead(t.Context(), "session", req)
+		var invalidParams *InvalidParamsError
+		if !errors.As(err, &invalidParams) {
+			t.Fatalf("expected InvalidParamsError, got %v", err)
+		}
+		expect := []InvalidParamsField{{Name: "unit", Reason: "must be one of celsius, fahrenheit, kelvin"}}
+		if !slices.Equal(invalidParams.Fields, expect) {
+			t.Fatalf("expected %v, got %v", expect, invalidParams.Fields)
+		}
+	})
+	t.Run("unknown parameter", func(t *testing.T) {
+		defer func() {
+			err, _ := recover().(error)
+			if !errors.Is(err, ErrUnknownTemplateParam) {
+				t.Fatalf("expected ErrUnknownTemplateParam, got %v", err)
+			}
+		}()
+		New("test").Resource("forecast", "weather://forecast/{city}", func(c ResourceContext) error {
+			return nil
+		}, ResourceWithParamEnum("unit", "celsius"))
+	})
+}
+
+func TestHandler_dispatchMethod_completionWithoutCapability(t *testing.T) {
+	h := &handler{qilin: New("test")}
+	req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodCompletionComplete, map[string]any{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := h.dispatchMethod(t.Context(), req, ""); !errors.Is(err, jsonrpc2.ErrMethodNotFound) {
+		t.Fatalf("expected ErrMethodNotFound, got %v", err)
+	}
+}
+
+func TestHandler_handleResourcesRead_cancellation(t *testing.T) {
+	slowHandler := func(started chan<- struct{}) ResourceHandlerFunc {
+		return func(c ResourceContext) error {
+			close(started)
+			select {
+			case <-c.Context().Done():
+				return c.Context().Err()
+			case <-time.After(5 * time.Second):
+				return c.String("too late")
+			}
+		}
+	}
+	read := func(t *testing.T, h *handler, uri string) <-chan error {
+		t.Helper()
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": uri})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		done := make(chan error, 1)
+		go func() {
+			_, err := h.handleResourcesRead(t.Context(), "session", req)
+			done <- err
+		}()
+		return done
+	}
+	t.Run("client disconnect", func(t *testing.T) {
+		q := New("test")
+		started := make(chan struct{})
+		q.Resource("weather", "weather://forecast", slowHandler(started))
+		connectionCtx, disconnect := context.WithCancel(t.Context())
+		h := &handler{qilin: q, connectionCtx: connectionCtx}
+		done := read(t, h, "weather://forecast")
+		<-started
+		disconnect()
+		select {
+		case err := <-done:
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected context.Canceled, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected the handler to be canceled")
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		q := New("test")
+		started := make(chan struct{})
+		q.Resource("weather", "weather://forecast", slowHandler(started), ResourceWithTimeout(10*time.Millisecond))
+		h := &handler{qilin: q, connectionCtx: t.Context()}
+		done := read(t, h, "weather://forecast")
+		select {
+		case err := <-done:
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected the handler to time out")
+		}
+	})
+}
+
+func TestHandler_handleToolsCall_invalidParamsData(t *testing.T) {
+	q := New("test", WithRequiredArgumentsCheck())
+	type request struct {
+		City string `json:"city"`
+		Unit string `json:"unit,omitempty"`
+	}
+	called := false
+	q.Tool("weather", (*request)(nil), func(c ToolContext) error {
+		called = true
+		return c.String("sunny")
+	})
+	h := &handler{qilin: q}
+	req, err := jsonrpc2.NewCall(
+		jsonrpc2.StringID("1"),
+		MethodToolsCall,
+		map[string]any{"name": "weather", "arguments": map[string]any{"unit": "celsius"}},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = h.handleToolsCall(t.Context(), "session", req)
+	if !errors.Is(err, jsonrpc2.ErrInvalidParams) {
+		t.Fatalf("expected jsonrpc2.ErrInvalidParams, got %v", err)
+	}
+	if called {
+		t.Fatalf("expected the handler not to be called")
+	}
+	res, err := jsonrpc2.NewResponse(jsonrpc2.StringID("1"), nil, withErrorData(err))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := jsonrpc2.EncodeMessage(res)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect := `{"jsonrpc":"2.0","id":"1","error":{"code":-32602,"message":"JSON RPC invalid params: city is required","data":{"fields":[{"name":"city","reason":"is required"}]}}}`
+	if string(b) != expect {
+		t.Fatalf("expected %s, got %s", expect, b)
+	}
+}
+
+func TestHandler_handleToolsCall_requiredArgumentsNotChecked(t *testing.T) {
+	q := New("test")
+	type request struct {
+		City string `json:"city"`
+	}
+	called := false
+	q.Tool("weather", (*request)(nil), func(c ToolContext) error {
+		called = true
+		return c.String("sunny")
+	})
+	h := &handler{qilin: q}
+	req, err := jsonrpc2.NewCall(
+		jsonrpc2.StringID("1"),
+		MethodToolsCall,
+		map[string]any{"name": "weather", "arguments": map[string]any{}},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := h.handleToolsCall(t.Context(), "session", req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !called {
+		t.Fatalf("expected the handler to be called")
+	}
+}
+
+// testSchemaValidatorFunc is a fake SchemaValidator for testing.
+type testSchemaValidatorFunc func(schema *jsonschema.Schema, args json.RawMessage) error
+
+func (f testSchemaValidatorFunc) Validate(schema *jsonschema.Schema, args json.RawMessage) error {
+	return f(schema, args)
+}
+
+func TestHandler_handleToolsCall_schemaValidator(t *testing.T) {
+	type request struct {
+		City string `json:"city"`
+		Days int    `json:"days,omitempty"`
+	}
+	var validated []string
+	validator := testSchemaValidatorFunc(func(schema *jsonschema.Schema, args json.RawMessage) error {
+		validated = append(validated, string(args))
+		if _, ok := schema.Properties.Get("days"); !ok {
+			return errors.New("unexpected schema")
+		}
+		var v request
+		if err := json.Unmarshal(args, &v); err != nil {
+			return err
+		}
+		if v.Days > 7 {
+			return errors.New("/days: must be <= 7")
+		}
+		return nil
+	})
+	q := New("test", WithSchemaValidator(validator))
+	called := 0
+	q.Tool("forecast", (*request)(nil), func(c ToolContext) error {
+		called++
+		return c.String("sunny")
+	})
+	h := &handler{qilin: q}
+	call := func(args map[string]any) (interface{}, error) {
+		t.Helper()
+		req, err := jsonrpc2.NewCall(
+			jsonrpc2.StringID("1"),
+			MethodToolsCall,
+			map[string]any{"name": "forecast", "arguments": args},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return h.handleToolsCall(t.Context(), "session", req)
+	}
+
+	t.Run("rejected", func(t *testing.T) {
+		_, err := call(map[string]any{"city": "tokyo", "days": 10})
+		if !errors.Is(err, jsonrpc2.ErrInvalidParams) {
+			t.Fatalf("expected jsonrpc2.ErrInvalidParams, got %v", err)
+		}
+		if called != 0 {
+			t.Fatalf("expected the handler not to be called")
+		}
+		res, err := jsonrpc2.NewResponse(jsonrpc2.StringID("1"), nil, withErrorData(err))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b, err := jsonrpc2.EncodeMessage(res)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expect := `{"jsonrpc":"2.0","id":"1","error":{"code":-32602,"message":"JSON RPC invalid params: arguments /days: must be \u003c= 7","data":{"fields":[{"name":"arguments","reason":"/days: must be \u003c= 7"}]}}}`
+		if string(b) != expect {
+			t.Fatalf("expected %s, got %s", expect, b)
+		}
+	})
+	t.Run("invalid params passed through", func(t *testing.T) {
+		want := &InvalidParamsError{Fields: []InvalidParamsField{{Name: "city", Reason: "is unknown"}}}
+		q.schemaValidator = testSchemaValidatorFunc(func(*jsonschema.Schema, json.RawMessage) error {
+			return want
+		})
+		t.Cleanup(func() { q.schemaValidator = validator })
+		_, err := call(map[string]any{"city": "atlantis"})
+		if err != want {
+			t.Fatalf("expected %v, got %v", want, err)
+		}
+	})
+	t.Run("accepted", func(t *testing.T) {
+		// the missing city is left to the custom validator
+		if _, err := call(map[string]any{"days": 3}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if called != 1 {
+			t.Fatalf("expected the handler to be called once, got %d", called)
+		}
+	})
+	if expect := []string{`{"city":"tokyo","days":10}`, `{"days":3}`}; !slices.Equal(validated, expect) {
+		t.Fatalf("expected %v, got %v", expect, validated)
+	}
+}
+
+func TestNotificationBuffer(t *testing.T) {
+	var sent []string
+	b := &notificationBuffer{
+		notify: func(_ context.Context, method string, _ interface{}) error {
+			sent = append(sent, method)
+			return nil
+		},
+	}
+	if err := b.Notify(t.Context(), "first", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := b.Notify(t.Context(), "second", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sent) != 0 {
+		t.Fatalf("expected notifications to be held until released, got %v", sent)
+	}
+	b.release()
+	if err := b.Notify(t.Context(), "third", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b.release()
+	expect := []string{"first", "second", "third"}
+	if !slices.Equal(sent, expect) {
+		t.Fatalf("expected %v, got %v", expect, sent)
+	}
+}
+
+func TestNotificationBuffer_limit(t *testing.T) {
+	var sent int
+	b := &notificationBuffer{
+		notify: func(context.Context, string, interface{}) error {
+			sent++
+			return nil
+		},
+	}
+	for range maxPendingNotifications + 10 {
+		if err := b.Notify(t.Context(), MethodNotificationProgress, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got := len(b.pending); got != maxPendingNotifications {
+		t.Fatalf("expected %d notifications to be held, got %d", maxPendingNotifications, got)
+	}
+	b.release()
+	if sent != maxPendingNotifications {
+		t.Fatalf("expected %d notifications to be sent, got %d", maxPendingNotifications, sent)
+	}
+}
+
+func TestPrewarmPool(t *testing.T) {
+	var allocated atomic.Int32
+	p := sync.Pool{
+		New: func() any {
+			allocated.Add(1)
+			return new(toolContext)
+		},
+	}
+	prewarmPool(&p, 8)
+	if got := allocated.Load(); got != 8 {
+		t.Fatalf("expected 8 objects to be seeded, got %d", got)
+	}
+	if _, ok := p.Get().(*toolContext); !ok {
+		t.Fatalf("expected *toolContext")
+	}
+	if got := allocated.Load(); got != 8 {
+		t.Fatalf("expected a pre-seeded object to be returned, got %d allocations", got)
+	}
+}
+
+func BenchmarkHandler_handleToolsCall(b *testing.B) {
+	benchmarks := map[string]int{
+		"cold":      0,
+		"prewarmed": 1,
+	}
+	for name, n := range benchmarks {
+		b.Run(name, func(b *testing.B) {
+			q := New("bench")
+			q.Tool("echo", (*struct{})(nil), func(c ToolContext) error {
+				return c.String("ok")
+			})
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodToolsCall, map[string]any{"name": "echo"})
+			if err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+			h := &handler{qilin: q}
+			b.ReportAllocs()
+			for b.Loop() {
+				b.StopTimer()
+				runtime.GC()
+				runtime.GC()
+				prewarmPool(&q.toolContextPool, n)
+				b.StartTimer()
+				// the first call after GC emptied the pool
+				if _, err := h.handleToolsCall(b.Context(), "session", req); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkHandler_handleToolsCall_steady(b *testing.B) {
+	q := New("bench")
+	q.Tool("echo", (*struct{})(nil), func(c ToolContext) error {
+		return c.String("ok")
+	})
+	req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodToolsCall, map[string]any{"name": "echo"})
+	if err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+	h := &handler{qilin: q}
+	b.ReportAllocs()
+	// the pooled context is reused across the calls, so only the arguments and the content are allocated
+	for b.Loop() {
+		if _, err := h.handleToolsCall(b.Context(), "session", req); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestHandler_resourceSubscription_deleted(t *testing.T) {
+	q := New("test")
+	q.rootCtx = t.Context()
+	q.Resource("forecast", "weather://forecast/{city}", func(c ResourceContext) error {
+		return c.String("sunny")
+	})
+	q.ResourceChangeObserver("weather://forecast/{city}", func(c ResourceChangeContext) {})
+	n, _, err := q.resourceNode.matching(MustURL(t, "weather://forecast/tokyo"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	changeCtx := n.resourceChangeCtx.(*resourceChangeContext)
+
+	notified := make(chan string, 1)
+	h := &handler{
+		qilin:                    q,
+		connectionCtx:            t.Context(),
+		switchToStreamConnection: noopFuncWithDuration,
+		notify: func(_ context.Context, method string, params interface{}) error {
+			notified <- params.(resourceUpdatedNotificationParam).URI
+			return nil
+		},
+	}
+	uri := MustURL(t, "weather://forecast/tokyo")
+	if err := h.setupResourceSubscription(t.Context(), "session", uri, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	changeCtx.PublishDeleted(MustURL(t, "weather://forecast/{city}"), time.Now().Add(time.Second))
+	select {
+	case got := <-notified:
+		if got != "weather://forecast/tokyo" {
+			t.Fatalf("expected 'weather://forecast/tokyo', got %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the deletion to be notified")
+	}
+	h.wg.Wait()
+
+	changeCtx.mu.RLock()
+	remaining := len(changeCtx.subscriber)
+	changeCtx.mu.RUnlock()
+	if remaining != 0 {
+		t.Fatalf("expected the subscriber to be removed, got %d", remaining)
+	}
+	if subscription, _ := q.resourcesSubscriptionOptions.store.Get(t.Context(), "session", uri); subscription != nil {
+		t.Fatalf("expected the subscription to be deleted")
+	}
+}
+
+func TestWithMaxSubscriptionsPerSession(t *testing.T) {
+	q := New("test", WithMaxSubscriptionsPerSession(2))
+	q.rootCtx = t.Context()
+	q.Resource("forecast", "weather://forecast/{city}", func(c ResourceContext) error {
+		return c.String("sunny")
+	})
+	q.ResourceChangeObserver("weather://forecast/{city}", func(c ResourceChangeContext) {})
+	n, _, err := q.resourceNode.matching(MustURL(t, "weather://forecast/tokyo"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	changeCtx := n.resourceChangeCtx.(*resourceChangeContext)
+
+	connectionCtx, disconnect := context.WithCancel(t.Context())
+	defer disconnect()
+	notified := make(chan string, 1)
+	h := &handler{
+		qilin:                    q,
+		connectionCtx:            connectionCtx,
+		switchToStreamConnection: noopFuncWithDuration,
+		notify: func(_ context.Context, method string, params interface{}) error {
+			notified <- params.(resourceUpdatedNotificationParam).URI
+			return nil
+		},
+	}
+	for _, city := range []string{"tokyo", "osaka"} {
+		if err := h.setupResourceSubscription(t.Context(), "session", MustURL(t, "weather://forecast/"+city), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	err = h.setupResourceSubscription(t.Context(), "session", MustURL(t, "weather://forecast/paris"), 0)
+	if !errors.Is(err, ErrTooManySubscriptions) {
+		t.Fatalf("expected ErrTooManySubscriptions, got %v", err)
+	}
+	if err := h.setupResourceSubscription(t.Context(), "another", MustURL(t, "weather://forecast/paris"), 0); err != nil {
+		t.Fatalf("expected the other session not to be limited, got %v", err)
+	}
+
+	changeCtx.Publish(MustURL(t, "weather://forecast/tokyo"), time.Now().Add(time.Second))
+	select {
+	case got := <-notified:
+		if got != "weather://forecast/tokyo" {
+			t.Fatalf("expected 'weather://forecast/tokyo', got %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the existing subscription to be notified")
+	}
+
+	disconnect()
+	h.wg.Wait()
+	if err := h.setupResourceSubscription(t.Context(), "session", MustURL(t, "weather://forecast/paris"), 0); err != nil {
+		t.Fatalf("expected the ended subscriptions to be released, got %v", err)
+	}
+}
+
+func TestResourceWithSubscribeGuard(t *testing.T) {
+	errForbidden := errors.New("forbidden")
+	q := New("test")
+	q.rootCtx = t.Context()
+	q.Resource("forecast", "weather://forecast/{city}", func(c ResourceContext) error {
+		return c.String("sunny")
+	}, ResourceWithSubscribeGuard(func(ctx context.Context, uri *url.URL, sessionID string) error {
+		if sessionID == "guest" && uri.Path == "/paris" {
+			return errForbidden
+		}
+		return nil
+	}))
+	q.ResourceChangeObserver("weather://forecast/{city}", func(c ResourceChangeContext) {})
+	n, _, err := q.resourceNode.matching(MustURL(t, "weather://forecast/tokyo"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	changeCtx := n.resourceChangeCtx.(*resourceChangeContext)
+	h := &handler{
+		qilin:                    q,
+		connectionCtx:            t.Context(),
+		switchToStreamConnection: noopFuncWithDuration,
+		notify: func(context.Context, string, interface{}) error {
+			return nil
+		},
+	}
+	type test struct {
+		sessionID string
+		uri       string
+		expectErr error
+	}
+	tests := map[string]test{
+		"allowed": {
+			sessionID: "guest",
+			uri:       "weather://forecast/tokyo",
+		},
+		"allowed for the other session": {
+			sessionID: "member",
+			uri:       "weather://forecast/paris",
+		},
+		"denied": {
+			sessionID: "guest",
+			uri:       "weather://forecast/paris",
+			expectErr: errForbidden,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			uri := MustURL(t, tc.uri)
+			err := h.setupResourceSubscription(t.Context(), tc.sessionID, uri, 0)
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatalf("expected %v, got %v", tc.expectErr, err)
+			}
+			changeCtx.mu.RLock()
+			_, subscribed := changeCtx.subscriber[fmt.Sprintf("%s#%s", uri, tc.sessionID)]
+			changeCtx.mu.RUnlock()
+			if subscribed != (tc.expectErr == nil) {
+				t.Fatalf("expected subscribed to be %v, got %v", tc.expectErr == nil, subscribed)
+			}
+		})
+	}
+}
+
+func TestQilin_PublishResourceChange(t *testing.T) {
+	q := New("test")
+	q.rootCtx = t.Context()
+	read := func(c ResourceContext) error {
+		return c.String("sunny")
+	}
+	q.Resource("forecast", "weather://forecast/{city}", read)
+	q.Resource("alerts", "weather://alerts", read)
+	q.ResourceChangeObserver("weather://forecast/{city}", nil)
+	notified := make(chan string, 1)
+	h := &handler{
+		qilin:                    q,
+		connectionCtx:            t.Context(),
+		switchToStreamConnection: noopFuncWithDuration,
+		notify: func(_ context.Context, method string, params interface{}) error {
+			notified <- params.(resourceUpdatedNotificationParam).URI
+			return nil
+		},
+	}
+	if err := h.setupResourceSubscription(t.Context(), "session", MustURL(t, "weather://forecast/tokyo"), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := q.PublishResourceChange(MustURL(t, "weather://forecast/tokyo"), time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case got := <-notified:
+		if got != "weather://forecast/tokyo" {
+			t.Fatalf("expected 'weather://forecast/tokyo', got %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the subscriber to be notified")
+	}
+
+	if err := q.PublishResourceChange(MustURL(t, "weather://alerts"), time.Now()); !errors.Is(err, ErrResourceNotObserved) {
+		t.Fatalf("expected ErrResourceNotObserved, got %v", err)
+	}
+	if err := q.PublishResourceChange(MustURL(t, "weather://unknown/tokyo/today"), time.Now()); !errors.Is(err, ErrResourceNotFound) {
+		t.Fatalf("expected ErrResourceNotFound, got %v", err)
+	}
+}
+
+func TestQilin_ResourceChangeObserver_nil(t *testing.T) {
+	q := New("test")
+	q.rootCtx = t.Context()
+	// the observer may be registered before the resource.
+	q.ResourceChangeObserver("weather://forecast/{city}", nil)
+	q.ResourceChangeObserver("weather://alerts", nil)
+	if err := q.PublishResourceChange(MustURL(t, "weather://forecast/tokyo"), time.Now()); !errors.Is(err, ErrResourceNotFound) {
+		t.Fatalf("expected ErrResourceNotFound before the resource is registered, got %v", err)
+	}
+	q.Resource("forecast", "weather://forecast/{city}", func(c ResourceContext) error {
+		return c.String("sunny")
+	})
+	notified := make(chan string, 1)
+	h := &handler{
+		qilin:                    q,
+		connectionCtx:            t.Context(),
+		switchToStreamConnection: noopFuncWithDuration,
+		notify: func(_ context.Context, method string, params interface{}) error {
+			notified <- params.(resourceUpdatedNotificationParam).URI
+			return nil
+		},
+	}
+	if err := h.setupResourceSubscription(t.Context(), "session", MustURL(t, "weather://forecast/tokyo"), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := q.PublishResourceChange(MustURL(t, "weather://forecast/tokyo"), time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case got := <-notified:
+		if got != "weather://forecast/tokyo" {
+			t.Fatalf("expected 'weather://forecast/tokyo', got %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the subscriber to be notified")
+	}
+
+	// the URI observed without the resource is neither subscribable nor published.
+	if err := h.setupResourceSubscription(t.Context(), "session", MustURL(t, "weather://alerts"), 0); err == nil {
+		t.Fatalf("expected the subscription to fail")
+	}
+	if err := q.PublishResourceChange(MustURL(t, "weather://alerts"), time.Now()); !errors.Is(err, ErrResourceNotFound) {
+		t.Fatalf("expected ErrResourceNotFound, got %v", err)
+	}
+}
+
+func TestHandler_setupResourceSubscription_resubscribe(t *testing.T) {
+	q := New("test", WithMaxSubscriptionsPerSession(1))
+	q.rootCtx = t.Context()
+	q.Resource("forecast", "weather://forecast/{city}", func(c ResourceContext) error {
+		return c.String("sunny")
+	})
+	q.ResourceChangeObserver("weather://forecast/{city}", nil)
+	subscribe := func(connectionCtx context.Context) <-chan string {
+		t.Helper()
+		notified := make(chan string, 4)
+		h := &handler{
+			qilin:                    q,
+			connectionCtx:            connectionCtx,
+			switchToStreamConnection: noopFuncWithDuration,
+			notify: func(_ context.Context, method string, params interface{}) error {
+				notified <- params.(resourceUpdatedNotificationParam).URI
+				return nil
+			},
+		}
+		if err := h.setupResourceSubscription(t.Context(), "session", MustURL(t, "weather://forecast/tokyo"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return notified
+	}
+
+	oldCtx, closeOld := context.WithCancel(t.Context())
+	oldNotified := subscribe(oldCtx)
+	// the client reconnects, and re-subscribes on the new connection before the old one is closed.
+	newNotified := subscribe(t.Context())
+	closeOld()
+
+	for i := range 2 {
+		if err := q.PublishResourceChange(MustURL(t, "weather://forecast/tokyo"), time.Now().Add(time.Duration(i+1)*time.Second)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		select {
+		case <-newNotified:
+		case <-time.After(time.Second):
+			t.Fatalf("expected the new connection to be notified")
+		}
+		select {
+		case got := <-newNotified:
+			t.Fatalf("expected a single notification per change, got another for %v", got)
+		case got := <-oldNotified:
+			t.Fatalf("expected the old connection not to be notified, got %v", got)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestQilin_ResourceChangeObserver_shared(t *testing.T) {
+	q := New("test")
+	contexts := make(chan ResourceChangeContext, 2)
+	observer := func(c ResourceChangeContext) {
+		contexts <- c
+	}
+	q.ResourceChangeObserver("weather://forecast/{city}", observer)
+	q.ResourceChangeObserver("weather://forecast/{city}", observer)
+	q.warming()
+	first, second := <-contexts, <-contexts
+	if first != second {
+		t.Fatalf("expected the observers to share the change context")
+	}
+}
+
+func TestWithObserverRestart(t *testing.T) {
+	type test struct {
+		options       []Option
+		expectRestart bool
+	}
+	tests := map[string]test{
+		"restarted": {
+			options: []Option{WithObserverRestart(func(restart int) time.Duration {
+				return time.Duration(restart) * time.Millisecond
+			})},
+			expectRestart: true,
+		},
+		"recovered without restart": {
+			expectRestart: false,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := New("test", tc.options...)
+			var runs atomic.Int32
+			restarted := make(chan struct{})
+			q.ResourceChangeObserver("weather://forecast/{city}", func(c ResourceChangeContext) {
+				if runs.Add(1) == 1 {
+					panic("the weather API client panicked")
+				}
+				close(restarted)
+			})
+			q.warming()
+			select {
+			case <-restarted:
+				if !tc.expectRestart {
+					t.Fatal("expected the observer not to be restarted")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tc.expectRestart {
+					t.Fatal("expected the observer to be restarted")
+				}
+			}
+			if got := runs.Load(); (got == 2) != tc.expectRestart {
+				t.Fatalf("unexpected number of runs: %d", got)
+			}
+		})
+	}
+}
+
+func TestQilin_UnsubscribeAll(t *testing.T) {
+	q := New("test")
+	q.rootCtx = t.Context()
+	q.Resource("forecast", "weather://forecast/{city}", func(c ResourceContext) error {
+		return c.String("sunny")
+	})
+	q.ResourceChangeObserver("weather://forecast/{city}", func(c ResourceChangeContext) {})
+	q.ResourceListChangeObserver(func(c ResourceListChangeContext) {})
+	h := &handler{
+		qilin:                    q,
+		connectionCtx:            t.Context(),
+		switchToStreamConnection: noopFuncWithDuration,
+		notify: func(context.Context, string, interface{}) error {
+			return nil
+		},
+	}
+	for _, city := range []string{"tokyo", "osaka", "paris"} {
+		if err := h.setupResourceSubscription(t.Context(), "session", MustURL(t, "weather://forecast/"+city), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := h.resourceListChangeSubscription(t.Context(), t.Context(), "session"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := q.UnsubscribeAll(t.Context(), "session"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the subscription goroutines to exit")
+	}
+
+	if subscriptions, _ := q.resourcesSubscriptionOptions.store.RetrieveBySessionID(t.Context(), "session"); len(subscriptions) != 0 {
+		t.Fatalf("expected the resource subscriptions to be deleted, got %d", len(subscriptions))
+	}
+	if subscription, _ := q.resourceListChangeSubscriptionOptions.store.Get(t.Context(), "session"); subscription != nil {
+		t.Fatal("expected the resource list change subscription to be deleted")
+	}
+	listChangeCtx := q.resourceListChangeCtx
+	listChangeCtx.mu.RLock()
+	remaining := len(listChangeCtx.subscriber)
+	listChangeCtx.mu.RUnlock()
+	if remaining != 0 {
+		t.Fatalf("expected the resource list change subscriber to be removed, got %d", remaining)
+	}
+	if err := q.UnsubscribeAll(t.Context(), "session"); err != nil {
+		t.Fatalf("expected unsubscribing again to succeed, got %v", err)
+	}
+	if err := q.resourcesSubscriptionOptions.store.DeleteBySessionID(t.Context(), "session"); !errors.Is(err, ErrResourceModificationSubscriptionNotFound) {
+		t.Fatalf("expected ErrResourceModificationSubscriptionNotFound, got %v", err)
+	}
+}
+
+func TestHandler_invokeMethod_clientTimeout(t *testing.T) {
+	type test struct {
+		options   []Option
+		timeoutMs int64
+		expect    time.Duration
+	}
+	tests := map[string]test{
+		"client timeout": {
+			timeoutMs: 50,
+			expect:    50 * time.Millisecond,
+		},
+		"clamped to the server max": {
+			options:   []Option{WithMaxRequestTimeout(50 * time.Millisecond)},
+			timeoutMs: 60_000,
+			expect:    50 * time.Millisecond,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := New("test", tc.options...)
+			q.rootCtx = t.Context()
+			var elapsed time.Duration
+			q.Tool("slow", (*struct{})(nil), func(c ToolContext) error {
+				start := time.Now()
+				select {
+				case <-c.Context().Done():
+					elapsed = time.Since(start)
+					return c.Context().Err()
+				case <-time.After(5 * time.Second):
+					return c.String("too late")
+				}
+			})
+			h := &handler{qilin: q, connectionCtx: t.Context()}
+			sessionID, err := q.sessionManager.Start(t.Context())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			req, err := jsonrpc2.NewCall(
+				jsonrpc2.StringID("1"),
+				MethodToolsCall,
+				map[string]any{"name": "slow", "_meta": map[string]any{"timeoutMs": tc.timeoutMs}},
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := h.invokeMethod(t.Context(), req, sessionID); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+			}
+			if elapsed > tc.expect+time.Second {
+				t.Fatalf("expected the handler to be canceled after about %v, got %v", tc.expect, elapsed)
+			}
+		})
+	}
+}
+
+func TestWithSelfDescriptionResource(t *testing.T) {
+	q := New(
+		"weather",
+		WithVersion("2.0.0"),
+		WithInstructions("Ask for the forecast by city"),
+		WithSelfDescriptionResource("self://description"),
+	)
+	q.Tool("forecast", (*struct{})(nil), func(c ToolContext) error {
+		return c.String("sunny")
+	}, ToolWithDescription("Get the forecast"))
+	q.Resource("city_forecast", "weather://forecast/{city}", func(c ResourceContext) error {
+		return c.String("sunny")
+	})
+	q.Prompt("greeting", func(c PromptContext) error {
+		return c.String(PromptRoleUser, "Hello")
+	})
+	h := &handler{qilin: q}
+	req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": "self://description"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := h.handleResourcesRead(t.Context(), "session", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var result struct {
+		Contents []struct {
+			MimeType string `json:"mimeType"`
+			Text     string `json:"text"`
+		} `json:"contents"`
+	}
+	if err := json.Unmarshal(b, &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Contents) != 1 || result.Contents[0].MimeType != "application/json" {
+		t.Fatalf("expected a JSON content, got %s", b)
+	}
+	var description struct {
+		Name         string `json:"name"`
+		Version      string `json:"version"`
+		Instructions string `json:"instructions"`
+		Tools        []struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		} `json:"tools"`
+		Resources []struct {
+			URI string `json:"uri"`
+		} `json:"resources"`
+		ResourceTemplates []struct {
+			URITemplate string `json:"uriTemplate"`
+		} `json:"resourceTemplates"`
+		Prompts []struct {
+			Name string `json:"name"`
+		} `json:"prompts"`
+	}
+	if err := json.Unmarshal([]byte(result.Contents[0].Text), &description); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if description.Name != "weather" || description.Version != "2.0.0" {
+		t.Fatalf("unexpected server info: %+v", description)
+	}
+	if description.Instructions != "Ask for the forecast by city" {
+		t.Fatalf("unexpected instructions: %v", description.Instructions)
+	}
+	if len(description.Tools) != 1 || description.Tools[0].Name != "forecast" || description.Tools[0].Description != "Get the forecast" {
+		t.Fatalf("unexpected tools: %+v", description.Tools)
+	}
+	if !slices.ContainsFunc(description.Resources, func(v struct {
+		URI string `json:"uri"`
+	}) bool {
+		return v.URI == "self://description"
+	}) {
+		t.Fatalf("unexpected resources: %+v", description.Resources)
+	}
+	if len(description.ResourceTemplates) != 1 || description.ResourceTemplates[0].URITemplate != "weather://forecast/{city}" {
+		t.Fatalf("unexpected resource templates: %+v", description.ResourceTemplates)
+	}
+	if len(description.Prompts) != 1 || description.Prompts[0].Name != "greeting" {
+		t.Fatalf("unexpected prompts: %+v", description.Prompts)
+	}
+}
+
+func TestHandler_handleList_stableOrder(t *testing.T) {
+	q := New("test")
+	names := []string{"delta", "alpha", "echo", "charlie", "bravo"}
+	for _, name := range names {
+		q.Tool(name, (*struct{})(nil), func(c ToolContext) error {
+			return c.String(name)
+		})
+		q.Resource(name, "example://example.com/"+name, func(c ResourceContext) error {
+			return c.String(name)
+		})
+	}
+	sorted := slices.Sorted(slices.Values(names))
+	h := &handler{qilin: q}
+	for range 10 {
+		res, err := h.handleToolsList()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var gotTools []string
+		for _, v := range res.(*listToolsResponse).Tools {
+			gotTools = append(gotTools, v.Name)
+		}
+		if !slices.Equal(gotTools, sorted) {
+			t.Fatalf("expected tools %v, got %v", sorted, gotTools)
+		}
+
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesList, map[string]any{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		res, err = h.handleResourcesList(t.Context(), "session", req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var gotResources []string
+		for _, v := range res.(*listResourcesResult).Resources {
+			gotResources = append(gotResources, v.Name)
+		}
+		if !slices.Equal(gotResources, sorted) {
+			t.Fatalf("expected resources %v, got %v", sorted, gotResources)
+		}
+	}
+}
+
+func TestHandler_handleResourcesList_rawURI(t *testing.T) {
+	const (
+		uri         = "example://example.com/caf%C3%A9;v=1?z=2&a=%2F"
+		uriTemplate = "example://example.com/{id}?lang=ja%2Djp"
+	)
+	q := New("test")
+	q.Resource("cafe", uri, func(c ResourceContext) error {
+		return c.String("cafe")
+	})
+	q.Resource("item", uriTemplate, func(c ResourceContext) error {
+		return c.String("item")
+	})
+	h := &handler{qilin: q}
+	req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesList, map[string]any{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resources, err := h.handleResourcesList(t.Context(), "session", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := json.Marshal(resources)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var listed struct {
+		Resources []struct {
+			URI string `json:"uri"`
+		} `json:"resources"`
+	}
+	if err := json.Unmarshal(b, &listed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(listed.Resources) != 1 || listed.Resources[0].URI != uri {
+		t.Fatalf("expected %s to be listed verbatim, got %s", uri, b)
+	}
+	req, err = jsonrpc2.NewCall(jsonrpc2.StringID("2"), MethodResourcesTemplatesList, map[string]any{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	templates, err := h.handleResourcesTemplatesList(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err = json.Marshal(templates)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var listedTemplates struct {
+		ResourceTemplates []struct {
+			URITemplate string `json:"uriTemplate"`
+		} `json:"resourceTemplates"`
+	}
+	if err := json.Unmarshal(b, &listedTemplates); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(listedTemplates.ResourceTemplates) != 1 || listedTemplates.ResourceTemplates[0].URITemplate != uriTemplate {
+		t.Fatalf("expected %s to be listed verbatim, got %s", uriTemplate, b)
+	}
+}
+
+func TestQilin_AddResource(t *testing.T) {
+	q := New("test")
+	q.Resource("beer_list", "beer://list", func(c ResourceContext) error {
+		return c.String("beer")
+	})
+	h := &handler{qilin: q}
+	read := func(uri string) error {
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": uri})
+		if err != nil {
+			return err
+		}
+		_, err = h.handleResourcesRead(t.Context(), "session", req)
+		return err
+	}
+
+	stop := make(chan struct{})
+	var (
+		wg       sync.WaitGroup
+		readErrs atomic.Int32
+	)
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := read("beer://list"); err != nil {
+					readErrs.Add(1)
+				}
+				if _, err := h.handleResourcesList(t.Context(), "session", nil); err != nil {
+					readErrs.Add(1)
+				}
+			}
+		}()
+	}
+	for i := range 100 {
+		uri := fmt.Sprintf("beer://dynamic/%d", i)
+		q.AddResource(fmt.Sprintf("dynamic_%d", i), uri, func(c ResourceContext) error {
+			return c.String("dynamic")
+		})
+		if err := read(uri); err != nil {
+			t.Fatalf("expected the added resource to be read, got %v", err)
+		}
+		if i%2 == 0 {
+			if err := q.RemoveResource(uri); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := read(uri); err == nil {
+				t.Fatalf("expected the removed resource not to be read")
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if got := readErrs.Load(); got != 0 {
+		t.Fatalf("expected the in-flight reads to succeed, got %d errors", got)
+	}
+	if got := len(q.resources); got != 51 {
+		t.Fatalf("expected 51 resources, got %d", got)
+	}
+}
+
+func TestQilin_RemoveResource(t *testing.T) {
+	q := New("test")
+	q.Resource("beer_detail", "beer://detail/{id}", func(c ResourceContext) error {
+		return c.String("beer " + c.Param("id"))
+	})
+	h := &handler{qilin: q}
+	read := func(uri string) error {
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": uri})
+		if err != nil {
+			return err
+		}
+		_, err = h.handleResourcesRead(t.Context(), "session", req)
+		return err
+	}
+
+	// the concrete uri does not remove the template matching it.
+	if err := q.RemoveResource("beer://detail/42"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := read("beer://detail/42"); err != nil {
+		t.Fatalf("expected the template to be kept, got %v", err)
+	}
+	if got := len(q.Resources()); got != 1 {
+		t.Fatalf("expected 1 resource, got %d", got)
+	}
+
+	if err := q.RemoveResource("beer://detail/%zz"); err == nil {
+		t.Fatal("expected the malformed uri to fail")
+	}
+
+	if err := q.RemoveResource("beer://detail/{id}"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := read("beer://detail/42"); err == nil {
+		t.Fatal("expected the removed template not to be read")
+	}
+	if got := len(q.resourceTemplates); got != 0 {
+		t.Fatalf("expected the template to be removed, got %d", got)
+	}
+}
+
+func TestQilin_AddResource_middleware(t *testing.T) {
+	q := New("test")
+	var calls atomic.Int32
+	q.UseInResources(func(next ResourceHandlerFunc) ResourceHandlerFunc {
+		return func(c ResourceContext) error {
+			calls.Add(1)
+			return next(c)
+		}
+	})
+	q.Resource("beer_list", "beer://list", func(c ResourceContext) error {
+		return c.String("beer")
+	})
+	// Start applies the middleware to the resources registered before serving.
+	q.applyResourceMiddleware()
+
+	h := &handler{qilin: q}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			_ = h.negotiateInitialize(&initializeRequestParams{ProtocolVersion: LatestProtocolVersion})
+		}
+	}()
+	q.AddResource("beer_detail", "beer://detail", func(c ResourceContext) error {
+		return c.String("detail")
+	})
+	close(stop)
+	wg.Wait()
+
+	for _, uri := range []string{"beer://list", "beer://detail"} {
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": uri})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := h.handleResourcesRead(t.Context(), "session", req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("expected the middleware to run once per read, got %d", got)
+	}
+}
+
+func TestQilin_resourceMiddlewareOrder(t *testing.T) {
+	var seen []string
+	trace := func(name string) ResourceMiddlewareFunc {
+		return func(next ResourceHandlerFunc) ResourceHandlerFunc {
+			return func(c ResourceContext) error {
+				seen = append(seen, name)
+				return next(c)
+			}
+		}
+	}
+	q := New("test")
+	q.UseInResources(trace("use1"), trace("use2"))
+	g := q.Group(GroupWithResourceMiddleware(trace("group")))
+	g.Resource("beer", "beer://list", func(c ResourceContext) error {
+		seen = append(seen, "handler")
+		return c.String("beer")
+	}, ResourceWithMiddleware(trace("option1"), trace("option2")))
+	// Start applies the middleware added by UseInResources.
+	q.applyResourceMiddleware()
+	h := &handler{qilin: q}
+
+	for i := range 2 {
+		seen = nil
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID(strconv.Itoa(i)), MethodResourcesRead, map[string]any{"uri": "beer://list"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := h.handleResourcesRead(t.Context(), "session", req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// the same order as the tools: UseInTools, GroupWithToolMiddleware, then ToolWithMiddleware.
+		want := []string{"use1", "use2", "group", "option2", "option1", "handler"}
+		if !slices.Equal(seen, want) {
+			t.Fatalf("expected %v, got %v", want, seen)
+		}
+	}
+}
+
+func TestHandler_handleToolsCall_noContent(t *testing.T) {
+	type test struct {
+		options   []Option
+		expect    string
+		expectErr error
+	}
+	tests := map[string]test{
+		"default": {
+			expect: `{"type":"text","text":""}`,
+		},
+		"EmptyToolContentText": {
+			options: []Option{WithEmptyToolContent(EmptyToolContentText)},
+			expect:  `{"type":"text","text":""}`,
+		},
+		"EmptyToolContentError": {
+			options:   []Option{WithEmptyToolContent(EmptyToolContentError)},
+			expectErr: ErrToolContentNotWritten,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := New("test", tc.options...)
+			q.Tool("noop", (*struct{})(nil), func(c ToolContext) error {
+				return nil
+			})
+			h := &handler{qilin: q}
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodToolsCall, map[string]any{"name": "noop"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := h.handleToolsCall(t.Context(), "session", req)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != tc.expect {
+				t.Fatalf("expected %s, got %s", tc.expect, b)
+			}
+		})
+	}
+}
+
+func Test_transportKindOf(t *testing.T) {
+	type test struct {
+		rwc    io.ReadWriteCloser
+		expect TransportKind
+	}
+	tests := map[string]test{
+		"stdio": {
+			rwc:    &transport.Stdio{},
+			expect: TransportKindStdio,
+		},
+		"streamable": {
+			rwc:    &transport.StreamableReadWriteCloser{},
+			expect: TransportKindStreamable,
+		},
+		"other": {
+			rwc:    &internaltransport.QilinIO{},
+			expect: TransportKindUnknown,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := transportKindOf(tc.rwc); got != tc.expect {
+				t.Fatalf("expected %v, got %v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestHandler_transportKind(t *testing.T) {
+	for _, kind := range []TransportKind{TransportKindStdio, TransportKindStreamable} {
+		t.Run(kind.String(), func(t *testing.T) {
+			q := New("test")
+			var got TransportKind
+			q.Tool("transport", (*struct{})(nil), func(c ToolContext) error {
+				got = c.Transport()
+				return c.String(c.Transport().String())
+			})
+			h := &handler{qilin: q, transportKind: kind}
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodToolsCall, map[string]any{"name": "transport"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := h.handleToolsCall(t.Context(), "session", req); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != kind {
+				t.Fatalf("expected %v, got %v", kind, got)
+			}
+		})
+	}
+}
+
+func TestHandler_handleInitialize_instructionsFunc(t *testing.T) {
+	q := New("test", WithInstructions("static"), WithInstructionsFunc(func(c ClientCapabilities) string {
+		if c.Sampling != nil {
+			return "with sampling"
+		}
+		return "without sampling"
+	}))
+	tests := map[string]struct {
+		capabilities map[string]any
+		want         string
+	}{
+		"with sampling": {
+			capabilities: map[string]any{"sampling": map[string]any{}},
+			want:         "with sampling",
+		},
+		"without sampling": {
+			capabilities: map[string]any{},
+			want:         "without sampling",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &handler{qilin: q, setSessionID: func(string) {}}
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodInitialize, map[string]any{
+				"protocolVersion": LatestProtocolVersion,
+				"capabilities":    tt.capabilities,
+				"clientInfo":      map[string]any{"name": "client", "version": "1.0.0"},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var sessionID string
+			got, err := h.handleInitialize(t.Context(), req, &sessionID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := got.(*initializeResult).Instructions; got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHandler_handleResourcesTemplatesList(t *testing.T) {
+	list := func(t *testing.T, h *handler, cursor string) *listResourceTemplatesResult {
+		t.Helper()
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesTemplatesList, params)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := h.handleResourcesTemplatesList(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return got.(*listResourceTemplatesResult)
+	}
+	register := func(q *Qilin, uris ...string) {
+		for _, uri := range uris {
+			q.Resource(uri, uri, func(c ResourceContext) error {
+				return c.String(uri)
+			})
+		}
+	}
+	t.Run("same path with different schemes", func(t *testing.T) {
+		q := New("test")
+		register(q, "beer://example.com/{id}", "wine://example.com/{id}")
+		got := list(t, &handler{qilin: q}, "")
+		if len(got.ResourceTemplates) != 2 {
+			t.Fatalf("expected 2 templates, got %d", len(got.ResourceTemplates))
+		}
+		if got.NextCursor != "" {
+			t.Fatalf("expected no next cursor, got %s", got.NextCursor)
+		}
+	})
+	t.Run("paginated", func(t *testing.T) {
+		q := New("test", WithResourceTemplatesPageSize(2))
+		want := []string{
+			"example://a.example.com/{id}",
+			"example://b.example.com/{id}",
+			"example://c.example.com/{id}",
+			"example://d.example.com/{id}",
+			"example://e.example.com/{id}",
+		}
+		register(q, want...)
+		h := &handler{qilin: q}
+		var (
+			got    []string
+			cursor string
+			pages  int
+		)
+		for {
+			page := list(t, h, cursor)
+			pages++
+			for _, v := range page.ResourceTemplates {
+				got = append(got, v.URITemplate.String())
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+		if pages != 3 {
+			t.Fatalf("expected 3 pages, got %d", pages)
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	})
+	t.Run("invalid cursor", func(t *testing.T) {
+		q := New("test", WithResourceTemplatesPageSize(2))
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesTemplatesList, map[string]any{"cursor": "!"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := (&handler{qilin: q}).handleResourcesTemplatesList(req); !errors.Is(err, jsonrpc2.ErrInvalidParams) {
+			t.Fatalf("expected ErrInvalidParams, got %v", err)
+		}
+	})
+}
+
+func TestQilin_Use(t *testing.T) {
+	q := New("test")
+	q.rootCtx = t.Context()
+	var seen []string
+	q.Use(
+		func(next MethodHandlerFunc) MethodHandlerFunc {
+			return func(ctx context.Context, req *jsonrpc2.Request) (interface{}, error) {
+				seen = append(seen, "outer:"+req.Method)
+				return next(ctx, req)
+			}
+		},
+		func(next MethodHandlerFunc) MethodHandlerFunc {
+			return func(ctx context.Context, req *jsonrpc2.Request) (interface{}, error) {
+				seen = append(seen, "inner:"+req.Method)
+				return next(ctx, req)
+			}
+		},
+	)
+	q.Tool("echo", (*struct{})(nil), func(c ToolContext) error {
+		seen = append(seen, "tool")
+		return c.String("ok")
+	})
+	h := &handler{qilin: q, connectionCtx: t.Context()}
+	sessionID, err := q.sessionManager.Start(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, tc := range []struct {
+		method string
+		params any
+	}{
+		{method: MethodPing},
+		{method: MethodToolsCall, params: map[string]any{"name": "echo"}},
+	} {
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), tc.method, tc.params)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := h.invokeMethod(t.Context(), req, sessionID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	want := []string{
+		"outer:ping",
+		"inner:ping",
+		"outer:tools/call",
+		"inner:tools/call",
+		"tool",
+	}
+	if !slices.Equal(seen, want) {
+		t.Fatalf("expected %v, got %v", want, seen)
+	}
+}
+
+func TestQilin_Notify(t *testing.T) {
+	q := New("test")
+	connectionCtx, disconnect := context.WithCancel(t.Context())
+	defer disconnect()
+	var notified []string
+	h := &handler{
+		qilin:         q,
+		connectionCtx: connectionCtx,
+		transportKind: TransportKindStdio,
+		setSessionID:  func(string) {},
+		notify: func(_ context.Context, method string, _ interface{}) error {
+			notified = append(notified, method)
+			return nil
+		},
+	}
+	req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodInitialize, map[string]any{
+		"protocolVersion": LatestProtocolVersion,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var sessionID string
+	if _, err := h.handleInitialize(t.Context(), req, &sessionID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := q.Notify(t.Context(), sessionID, "notifications/experimental/greeting", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(notified, []string{"notifications/experimental/greeting"}) {
+		t.Fatalf("expected the notification to be sent, got %v", notified)
+	}
+	if err := q.Notify(t.Context(), "unknown", "notifications/experimental/greeting", nil); !errors.Is(err, ErrNoLiveStream) {
+		t.Fatalf("expected ErrNoLiveStream, got %v", err)
+	}
+
+	disconnect()
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := q.Notify(t.Context(), sessionID, "notifications/experimental/greeting", nil)
+		if errors.Is(err, ErrNoLiveStream) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected ErrNoLiveStream after the disconnection, got %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHandler_locale(t *testing.T) {
+	type test struct {
+		meta   map[string]any
+		expect string
+	}
+	tests := map[string]test{
+		"with locale": {
+			meta:   map[string]any{"locale": "ja-JP"},
+			expect: "ja-JP",
+		},
+		"without locale": {},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := New("test")
+			q.Tool("greet", (*struct{})(nil), func(c ToolContext) error {
+				return c.String(c.Locale())
+			})
+			q.Prompt("greet", func(c PromptContext) error {
+				return c.String(PromptRoleUser, c.Locale())
+			})
+			var sessionID string
+			h := &handler{
+				qilin:        q,
+				setSessionID: func(id string) { sessionID = id },
+				getSessionID: func() string { return sessionID },
+			}
+			params := map[string]any{"protocolVersion": LatestProtocolVersion}
+			if tc.meta != nil {
+				params["_meta"] = tc.meta
+			}
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodInitialize, params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := h.handleInitialize(t.Context(), req, &sessionID); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			req, err = jsonrpc2.NewCall(jsonrpc2.StringID("2"), MethodToolsCall, map[string]any{"name": "greet"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			res, err := h.handleToolsCall(t.Context(), sessionID, req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := res.(*textCallToolContent).Text; got != tc.expect {
+				t.Fatalf("expected the tool to read %q, got %q", tc.expect, got)
+			}
+
+			req, err = jsonrpc2.NewCall(jsonrpc2.StringID("3"), MethodPromptsGet, map[string]any{"name": "greet"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			res, err = h.handlePromptsGet(t.Context(), req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := json.Marshal(res)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expect := fmt.Sprintf(`"text":%q`, tc.expect); !strings.Contains(string(b), expect) {
+				t.Fatalf("expected the prompt to read %s, got %s", expect, b)
+			}
+		})
+	}
+}
+
+func TestHandler_handleToolsCall_embedResource(t *testing.T) {
+	type test struct {
+		uri       string
+		expect    string
+		expectErr error
+	}
+	tests := map[string]test{
+		"registered resource": {
+			uri:    "example://employees/1",
+			expect: `{"type":"resource","resource":{"uri":"example://employees/1","name":"employee","mimeType":"application/json","text":"{\"id\":\"1\",\"name\":\"Bob\"}"}}`,
+		},
+		"unknown resource": {
+			uri:       "example://departments/1",
+			expectErr: ErrResourceNotFound,
+		},
+		"no content": {
+			uri:       "example://empty",
+			expectErr: ErrResourceContentNotWritten,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := New("test")
+			q.Resource("employee", "example://employees/{id}", func(c ResourceContext) error {
+				return c.JSON(map[string]string{"id": c.Param("id"), "name": "Bob"})
+			}, ResourceWithMimeType("application/json"))
+			q.Resource("empty", "example://empty", func(c ResourceContext) error {
+				return nil
+			})
+			q.Tool("embed", (*struct{})(nil), func(c ToolContext) error {
+				uri, err := url.Parse(tc.uri)
+				if err != nil {
+					return err
+				}
+				return c.EmbedResource(c.Context(), uri)
+			})
+			h := &handler{qilin: q}
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodToolsCall, map[string]any{"name": "embed"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := h.handleToolsCall(t.Context(), "session", req)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			runtime.GC()
+			b, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != tc.expect {
+				t.Fatalf("expected %s, got %s", tc.expect, b)
+			}
+		})
+	}
+}
+
+func TestHandler_handleToolsCall_contentAnnotations(t *testing.T) {
+	q := New("test")
+	q.Tool("forecast", (*struct{})(nil), func(c ToolContext) error {
+		c.SetContentAnnotations(ContentAnnotations{
+			Audience: []PromptRole{PromptRoleUser},
+			Priority: 0.8,
+		})
+		return c.String("sunny")
+	}, ToolWithAnnotations(ToolAnnotations{Title: "Forecast", ReadOnlyHint: true}))
+	h := &handler{qilin: q}
+
+	list, err := h.handleToolsList()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := json.Marshal(list)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expect := `"annotations":{"title":"Forecast","readOnlyHint":true}`; !strings.Contains(string(b), expect) {
+		t.Fatalf("expected %s in %s", expect, b)
+	}
+
+	req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodToolsCall, map[string]any{"name": "forecast"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := h.handleToolsCall(t.Context(), "session", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err = json.Marshal(got)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expect := `{"type":"text","text":"sunny","annotations":{"audience":["user"],"priority":0.8}}`; string(b) != expect {
+		t.Fatalf("expected %s, got %s", expect, b)
+	}
+}
+
+func TestHandler_handleToolsCall_progressDone(t *testing.T) {
+	type test struct {
+		meta   map[string]any
+		expect []string
+	}
+	tests := map[string]test{
+		"with progress token": {
+			meta: map[string]any{"progressToken": "token-1"},
+			expect: []string{
+				`{"progressToken":"token-1","progress":1,"total":4,"message":"started"}`,
+				`{"progressToken":"token-1","progress":4,"total":4,"message":"completed"}`,
+			},
+		},
+		"without progress token": {},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := New("test")
+			q.Tool("long", (*struct{})(nil), func(c ToolContext) error {
+				if err := c.Progress(1, 4, "started"); err != nil {
+					return err
+				}
+				if err := c.ProgressDone("completed"); err != nil {
+					return err
+				}
+				// no further progress is sent once done
+				if err := c.Progress(5, 4, "too late"); err != nil {
+					return err
+				}
+				return c.String("done")
+			})
+			var got []string
+			h := &handler{
+				qilin: q,
+				notify: func(ctx context.Context, method string, params interface{}) error {
+					if method != MethodNotificationProgress {
+						t.Fatalf("expected %s, got %s", MethodNotificationProgress, method)
+					}
+					b, err := json.Marshal(params)
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					got = append(got, string(b))
+					return nil
+				},
+			}
+			params := map[string]any{"name": "long"}
+			if tc.meta != nil {
+				params["_meta"] = tc.meta
+			}
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodToolsCall, params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := h.handleToolsCall(t.Context(), "session", req); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(got, tc.expect) {
+				t.Fatalf("expected %v, got %v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestHandler_handleToolsCall_fail(t *testing.T) {
+	q := New("test")
+	q.Tool("deploy", (*struct{})(nil), func(c ToolContext) error {
+		return c.Fail(
+			TextContent("the deploy key has expired, rotate it and retry"),
+			ResourceLinkContent(MustURL(t, "docs://deploy/keys"), "deploy-keys", "How to rotate the deploy key", "text/markdown"),
+		)
+	})
+	h := &handler{qilin: q}
+	req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodToolsCall, map[string]any{"name": "deploy"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res, err := h.handleToolsCall(t.Context(), "session", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := json.Marshal(res)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect := `{"content":[` +
+		`{"type":"text","text":"the deploy key has expired, rotate it and retry"},` +
+		`{"type":"resource_link","uri":"docs://deploy/keys","name":"deploy-keys","description":"How to rotate the deploy key","mimeType":"text/markdown"}` +
+		`],"isError":true}`
+	if string(got) != expect {
+		t.Fatalf("expected %s, got %s", expect, got)
+	}
+}
+
+func TestHandler_handleToolsCall_detach(t *testing.T) {
+	type key struct{}
+	q := New("test")
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	q.Tool("background", (*struct{ ID int })(nil), func(c ToolContext) error {
+		var req struct{ ID int }
+		if err := c.Bind(&req); err != nil {
+			return err
+		}
+		c.Set(key{}, req.ID)
+		detached := c.Detach()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// run after the handler returns and the context is reused
+			time.Sleep(10 * time.Millisecond)
+			if got := detached.Get(key{}); got != req.ID {
+				errs <- fmt.Errorf("expected %d, got %v", req.ID, got)
+			}
+			if err := detached.Context().Err(); err != nil {
+				errs <- fmt.Errorf("unexpected error: %w", err)
+			}
+			if got := detached.JSONRPCRequest().Method; got != MethodToolsCall {
+				errs <- fmt.Errorf("expected %s, got %s", MethodToolsCall, got)
+			}
+		}()
+		return c.String("accepted")
+	})
+	h := &handler{qilin: q}
+	for i := range 10 {
+		ctx, cancel := context.WithCancel(t.Context())
+		req, err := jsonrpc2.NewCall(jsonrpc2.Int64ID(int64(i)), MethodToolsCall, map[string]any{
+			"name":      "background",
+			"arguments": map[string]any{"ID": i},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := h.handleToolsCall(ctx, "session", req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		cancel()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestHandler_handleToolsCall_audioProtocolVersion(t *testing.T) {
+	tests := map[string]struct {
+		protocolVersion string
+		options         []Option
+		wantType        string
+		wantErr         error
+	}{
+		"2025-03-26": {
+			protocolVersion: ProtocolVersion20250326,
+			wantType:        "audio",
+		},
+		"2024-11-05": {
+			protocolVersion: ProtocolVersion20241105,
+			wantErr:         ErrAudioNotSupported,
+		},
+		"2024-11-05 with downgrade": {
+			protocolVersion: ProtocolVersion20241105,
+			options: []Option{WithAudioDowngrade(func(c ToolContext) *url.URL {
+				return &url.URL{Scheme: "recordings", Host: "greeting.wav"}
+			})},
+			wantType: "resource",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := New("test", tt.options...)
+			q.Tool("speak", (*struct{})(nil), func(c ToolContext) error {
+				return c.Audio([]byte("audio"), "audio/wav")
+			})
+			h := &handler{qilin: q, connectionCtx: t.Context(), setSessionID: func(string) {}}
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodInitialize, map[string]any{
+				"protocolVersion": tt.protocolVersion,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var sessionID string
+			if _, err := h.handleInitialize(t.Context(), req, &sessionID); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			req, err = jsonrpc2.NewCall(jsonrpc2.StringID("2"), MethodToolsCall, map[string]any{"name": "speak"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := h.handleToolsCall(t.Context(), sessionID, req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			b, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var content struct {
+				Type string `json:"type"`
+			}
+			if err := json.Unmarshal(b, &content); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if content.Type != tt.wantType {
+				t.Fatalf("expected %s content, got %s", tt.wantType, b)
+			}
+		})
+	}
+}
+
+func TestWithResponseInterceptor(t *testing.T) {
+	var methods []string
+	q := New("test", WithResponseInterceptor(func(method string, result any) any {
+		methods = append(methods, method)
+		if method != MethodToolsList {
+			return result
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var v map[string]any
+		if err := json.Unmarshal(b, &v); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		v["_meta"] = map[string]any{"region": "ap-northeast-1"}
+		return v
+	}))
+	q.rootCtx = t.Context()
+	q.Tool("echo", (*struct{})(nil), func(c ToolContext) error {
+		return c.String("ok")
+	})
+	h := &handler{qilin: q, connectionCtx: t.Context()}
+	sessionID, err := q.sessionManager.Start(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodToolsList, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := h.invokeMethod(t.Context(), req, sessionID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var result struct {
+		Meta struct {
+			Region string `json:"region"`
+		} `json:"_meta"`
+		Tools []struct {
+			Name string `json:"name"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal(b, &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Meta.Region != "ap-northeast-1" {
+		t.Fatalf("expected the injected _meta, got %s", b)
+	}
+	if len(result.Tools) != 1 || result.Tools[0].Name != "echo" {
+		t.Fatalf("expected the tools to be kept, got %s", b)
+	}
+
+	req, err = jsonrpc2.NewCall(jsonrpc2.StringID("2"), MethodToolsCall, map[string]any{"name": "unknown"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := h.invokeMethod(t.Context(), req, sessionID); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !slices.Equal(methods, []string{MethodToolsList}) {
+		t.Fatalf("expected the interceptor not to be called on the failure, got %v", methods)
+	}
+}
+
+func TestQilin_ResourceChangeObserver_notListed(t *testing.T) {
+	q := New("test")
+	q.Resource("beer_list", "beer://list", func(c ResourceContext) error {
+		return c.String("beer")
+	})
+	q.ResourceChangeObserver("beer://list", func(c ResourceChangeContext) {})
+	q.ResourceChangeObserver("wine://list", func(c ResourceChangeContext) {})
+	h := &handler{qilin: q}
+	req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesList, map[string]any{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := h.handleResourcesList(t.Context(), "session", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var uris []string
+	for _, v := range got.(*listResourcesResult).Resources {
+		uris = append(uris, v.URI.String())
+	}
+	if !slices.Equal(uris, []string{"beer://list"}) {
+		t.Fatalf("expected only the registered resource to be listed, got %v", uris)
+	}
+}
+
+func TestHandler_handleInitialize_twice(t *testing.T) {
+	q := New("test")
+	var sessionIDs []string
+	h := &handler{qilin: q, setSessionID: func(id string) {
+		sessionIDs = append(sessionIDs, id)
+	}}
+	initialize := func() error {
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodInitialize, map[string]any{
+			"protocolVersion": LatestProtocolVersion,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var sessionID string
+		_, err = h.handleInitialize(t.Context(), req, &sessionID)
+		return err
+	}
+	if err := initialize(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := initialize()
+	if !errors.Is(err, ErrAlreadyInitialized) {
+		t.Fatalf("expected ErrAlreadyInitialized, got %v", err)
+	}
+	if !errors.Is(err, jsonrpc2.ErrInvalidRequest) {
+		t.Fatalf("expected ErrInvalidRequest, got %v", err)
+	}
+	if len(sessionIDs) != 1 {
+		t.Fatalf("expected a single session to be issued, got %v", sessionIDs)
+	}
+}
+
+func TestWithSelfDescriptionResource_subscribable(t *testing.T) {
+	q := New("weather", WithSelfDescriptionResource("self://description"))
+	q.Resource("today", "weather://today", func(c ResourceContext) error {
+		return c.String("sunny")
+	})
+	q.Resource("alerts", "weather://alerts", func(c ResourceContext) error {
+		return c.String("none")
+	})
+	q.Resource("city_forecast", "weather://forecast/{city}", func(c ResourceContext) error {
+		return c.String("sunny")
+	})
+	q.ResourceChangeObserver("weather://alerts", func(c ResourceChangeContext) {})
+	q.ResourceChangeObserver("weather://forecast/{city}", func(c ResourceChangeContext) {})
+	h := &handler{qilin: q}
+	req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": "self://description"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := h.handleResourcesRead(t.Context(), "session", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var result struct {
+		Contents []struct {
+			Text string `json:"text"`
+		} `json:"contents"`
+	}
+	if err := json.Unmarshal(b, &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var description struct {
+		Resources []struct {
+			URI          string `json:"uri"`
+			Subscribable bool   `json:"subscribable"`
+		} `json:"resources"`
+		ResourceTemplates []struct {
+			URITemplate  string `json:"uriTemplate"`
+			Subscribable bool   `json:"subscribable"`
+		} `json:"resourceTemplates"`
+	}
+	if err := json.Unmarshal([]byte(result.Contents[0].Text), &description); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	subscribable := make(map[string]bool)
+	for _, v := range description.Resources {
+		subscribable[v.URI] = v.Subscribable
+	}
+	for _, v := range description.ResourceTemplates {
+		subscribable[v.URITemplate] = v.Subscribable
+	}
+	expected := map[string]bool{
+		"self://description":        false,
+		"weather://today":           false,
+		"weather://alerts":          true,
+		"weather://forecast/{city}": true,
+	}
+	if !maps.Equal(subscribable, expected) {
+		t.Fatalf("expected %v, got %v", expected, subscribable)
+	}
+}
+
+// pingLabelKey is the context key to label the waiters of the server-initiated requests.
+type pingLabelKey struct{}
+
+type recordingWriter struct {
+	mu       sync.Mutex
+	requests map[string]jsonrpc2.ID
+	written  chan struct{}
+}
+
+func (w *recordingWriter) Write(ctx context.Context, msg jsonrpc2.Message) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.requests[ctx.Value(pingLabelKey{}).(string)] = msg.(*jsonrpc2.Request).ID
+	w.written <- struct{}{}
+	return 0, nil
+}
+
+type messagesReader struct {
+	messages []jsonrpc2.Message
+}
+
+func (r *messagesReader) Read(context.Context) (jsonrpc2.Message, int64, error) {
+	if len(r.messages) == 0 {
+		return nil, 0, io.EOF
+	}
+	msg := r.messages[0]
+	r.messages = r.messages[1:]
+	return msg, 0, nil
+}
+
+func TestServerCalls(t *testing.T) {
+	calls := newServerCalls()
+	writer := &recordingWriter{
+		requests: make(map[string]jsonrpc2.ID),
+		written:  make(chan struct{}, 2),
+	}
+	calls.writer = writer
+
+	labels := []string{"first", "second"}
+	results := make(map[string]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, label := range labels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := context.WithValue(t.Context(), pingLabelKey{}, label)
+			result, err := calls.call(ctx, MethodPing, nil)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			results[label] = string(result)
+		}()
+	}
+	for range labels {
+		<-writer.written
+	}
+
+	ids := make(map[jsonrpc2.ID]struct{})
+	for _, id := range writer.requests {
+		if !strings.HasPrefix(fmt.Sprint(id.Raw()), serverRequestIDPrefix) {
+			t.Fatalf("expected the ID to be prefixed with %q, got %v", serverRequestIDPrefix, id.Raw())
+		}
+		ids[id] = struct{}{}
+	}
+	if len(ids) != len(labels) {
+		t.Fatalf("expected unique IDs, got %v", writer.requests)
+	}
+
+	clientCall, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodPing, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reader := &messagesReader{messages: []jsonrpc2.Message{clientCall}}
+	// respond in the reverse order of the requests
+	for _, label := range slices.Backward(labels) {
+		res, err := jsonrpc2.NewResponse(writer.requests[label], json.RawMessage(`"`+label+`"`), nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		reader.messages = append(reader.messages, res)
+	}
+	unknown, err := jsonrpc2.NewResponse(jsonrpc2.StringID(serverRequestIDPrefix+"0"), json.RawMessage(`{}`), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reader.messages = append(reader.messages, unknown)
+
+	r := &serverCallsReader{Reader: reader, route: calls.deliver}
+	var passed []jsonrpc2.Message
+	for {
+		msg, _, err := r.Read(t.Context())
+		if err != nil {
+			break
+		}
+		passed = append(passed, msg)
+	}
+	wg.Wait()
+
+	if !maps.Equal(results, map[string]string{"first": `"first"`, "second": `"second"`}) {
+		t.Fatalf("expected the responses to be routed to the waiters, got %v", results)
+	}
+	if len(passed) != 2 || passed[0] != clientCall || passed[1] != unknown {
+		t.Fatalf("expected the client call and the unknown response to be passed through, got %v", passed)
+	}
+}
+
+func TestWithExperimentalNegotiator(t *testing.T) {
+	q := New("test", WithExperimentalNegotiator(func(clientExperimental map[string]any) map[string]any {
+		if _, ok := clientExperimental["toolStreaming"]; ok {
+			return map[string]any{"toolStreaming": map[string]any{"version": 1}}
+		}
+		return nil
+	}))
+	q.rootCtx = t.Context()
+	type test struct {
+		clientExperimental map[string]any
+		expect             string
+	}
+	tests := map[string]test{
+		"negotiated": {
+			clientExperimental: map[string]any{"toolStreaming": map[string]any{}},
+			expect:             `{"toolStreaming":{"version":1}}`,
+		},
+		"not supported by the client": {
+			clientExperimental: map[string]any{"other": map[string]any{}},
+			expect:             `null`,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &handler{qilin: q, connectionCtx: t.Context(), setSessionID: func(string) {}}
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodInitialize, map[string]any{
+				"protocolVersion": LatestProtocolVersion,
+				"capabilities":    map[string]any{"experimental": tc.clientExperimental},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var sessionID string
+			got, err := h.handleInitialize(t.Context(), req, &sessionID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := json.Marshal(got.(*initializeResult).Capabilities.Experimental)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != tc.expect {
+				t.Fatalf("expected %s, got %s", tc.expect, b)
+			}
+			negotiated, ok := q.ExperimentalCapabilities(sessionID)
+			if !ok {
+				t.Fatal("expected the negotiated capabilities to be stored for the session")
+			}
+			if b, _ := json.Marshal(negotiated); string(b) != tc.expect {
+				t.Fatalf("expected %s, got %s", tc.expect, b)
+			}
+		})
+	}
+	if _, ok := q.ExperimentalCapabilities("unknown"); ok {
+		t.Fatal("expected no capabilities for the unknown session")
+	}
+	if q.capabilities.Experimental != nil {
+		t.Fatalf("expected the server capabilities not to be modified, got %v", q.capabilities.Experimental)
+	}
+}
+
+func TestQilin_Ping(t *testing.T) {
+	q := New("test")
+	calls := newServerCalls()
+	h := &handler{
+		qilin:         q,
+		connectionCtx: t.Context(),
+		transportKind: TransportKindStdio,
+		setSessionID:  func(string) {},
+		calls:         calls,
+	}
+	req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodInitialize, map[string]any{
+		"protocolVersion": LatestProtocolVersion,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var sessionID string
+	if _, err := h.handleInitialize(t.Context(), req, &sessionID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writer := &recordingWriter{
+		requests: make(map[string]jsonrpc2.ID),
+		written:  make(chan struct{}, 1),
+	}
+	calls.writer = writer
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- q.Ping(context.WithValue(t.Context(), pingLabelKey{}, "ping"), sessionID)
+	}()
+	<-writer.written
+	res, err := jsonrpc2.NewResponse(writer.requests["ping"], json.RawMessage(`{}`), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !calls.deliver(res) {
+		t.Fatal("expected the response to be delivered")
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := q.Ping(t.Context(), "unknown"); !errors.Is(err, ErrNoLiveStream) {
+		t.Fatalf("expected ErrNoLiveStream, got %v", err)
+	}
+}
+
+// elicitingClient is a fake client answering the elicitation requests with the action and the content.
+type elicitingClient struct {
+	calls   *serverCalls
+	action  string
+	content map[string]any
+	params  elicitRequestParams
+}
+
+func (w *elicitingClient) Write(_ context.Context, msg jsonrpc2.Message) (int64, error) {
+	req := msg.(*jsonrpc2.Request)
+	if req.Method != MethodElicitationCreate {
+		return 0, fmt.Errorf("unexpected method: %s", req.Method)
+	}
+	if err := json.Unmarshal(req.Params, &w.params); err != nil {
+		return 0, err
+	}
+	res, err := jsonrpc2.NewResponse(req.ID, elicitResult{Action: w.action, Content: w.content}, nil)
+	if err != nil {
+		return 0, err
+	}
+	w.calls.deliver(res)
+	return 0, nil
+}
+
+func TestToolContext_Elicit(t *testing.T) {
+	schema := &jsonschema.Schema{
+		Type:       "object",
+		Properties: jsonschema.NewProperties(),
+		Required:   []string{"city"},
+	}
+	schema.Properties.Set("city", &jsonschema.Schema{Type: "string"})
+
+	type test struct {
+		capabilities map[string]any
+		action       string
+		content      map[string]any
+		expect       string
+		wantErr      error
+	}
+	tests := map[string]test{
+		"accept": {
+			capabilities: map[string]any{"elicitation": map[string]any{}},
+			action:       elicitActionAccept,
+			content:      map[string]any{"city": "paris"},
+			expect:       `{"type":"text","text":"{\"city\":\"paris\"}"}`,
+		},
+		"decline": {
+			capabilities: map[string]any{"elicitation": map[string]any{}},
+			action:       elicitActionDecline,
+			wantErr:      ErrElicitationDeclined,
+		},
+		"cancel": {
+			capabilities: map[string]any{"elicitation": map[string]any{}},
+			action:       elicitActionCancel,
+			wantErr:      ErrElicitationCanceled,
+		},
+		"client without the capability": {
+			capabilities: map[string]any{},
+			wantErr:      ErrElicitationNotSupported,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := New("test")
+			q.rootCtx = t.Context()
+			q.Tool("forecast", (*struct{})(nil), func(c ToolContext) error {
+				info, err := c.Elicit(c.Context(), schema, "Which city?")
+				if err != nil {
+					return err
+				}
+				return c.JSON(info)
+			})
+			calls := newServerCalls()
+			client := &elicitingClient{calls: calls, action: tc.action, content: tc.content}
+			calls.writer = client
+			h := &handler{
+				qilin:         q,
+				connectionCtx: t.Context(),
+				transportKind: TransportKindStdio,
+				setSessionID:  func(string) {},
+				calls:         calls,
+			}
+			req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodInitialize, map[string]any{
+				"protocolVersion": LatestProtocolVersion,
+				"capabilities":    tc.capabilities,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var sessionID string
+			if _, err := h.handleInitialize(t.Context(), req, &sessionID); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			req, err = jsonrpc2.NewCall(jsonrpc2.StringID("2"), MethodToolsCall, map[string]any{
+				"name":      "forecast",
+				"arguments": map[string]any{},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := h.handleToolsCall(t.Context(), sessionID, req)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if client.params.Message != "Which city?" {
+				t.Errorf("expected the message to be sent, got %q", client.params.Message)
+			}
+			if client.params.RequestedSchema == nil || client.params.RequestedSchema.Required[0] != "city" {
+				t.Errorf("expected the schema to be sent, got %v", client.params.RequestedSchema)
+			}
+			b, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != tc.expect {
+				t.Fatalf("expected %s, got %s", tc.expect, b)
+			}
+		})
+	}
+}
+
+func TestWithErrorRedaction(t *testing.T) {
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	t.Cleanup(func() {
+		slog.SetDefault(defaultLogger)
+	})
+
+	q := New("test", WithErrorRedaction())
+	q.rootCtx = t.Context()
+	q.Resource("city_forecast", "weather://forecast/{city}", func(c ResourceContext) error {
+		return fmt.Errorf("city '%s' not found", c.Param("city"))
+	})
+	q.Resource("city_alerts", "weather://alerts/{city}", func(c ResourceContext) error {
+		return fmt.Errorf("%w: city is required", jsonrpc2.ErrInvalidParams)
+	})
+	sessionID, err := q.sessionManager.Start(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h := &handler{
+		qilin:         q,
+		connectionCtx: t.Context(),
+		resetOnce:     &sync.Once{},
+		getSessionID: func() string {
+			return sessionID
+		},
+	}
+	read := func(t *testing.T, uri string) error {
+		t.Helper()
+		req, err := jsonrpc2.NewCall(jsonrpc2.StringID("1"), MethodResourcesRead, map[string]any{"uri": uri})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = h.Handle(t.Context(), req)
+		return err
+	}
+
+	t.Run("error without code", func(t *testing.T) {
+		logs.Reset()
+		err := read(t, "weather://forecast/paris")
+		if !errors.Is(err, jsonrpc2.ErrInternal) {
+			t.Fatalf("expected ErrInternal, got %v", err)
+		}
+		if strings.Contains(err.Error(), "paris") {
+			t.Fatalf("expected the details to be redacted, got %v", err)
+		}
+		var logged struct {
+			Method        string `json:"method"`
+			CorrelationID string `json:"correlationID"`
+			Error         string `json:"error"`
+		}
+		if err := json.Unmarshal(logs.Bytes(), &logged); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(logged.Error, "city 'paris' not found") {
+			t.Fatalf("expected the full error to be logged, got %s", logs.String())
+		}
+		if logged.Method != MethodResourcesRead {
+			t.Fatalf("expected the method to be logged, got %s", logs.String())
+		}
+		if logged.CorrelationID == "" || !strings.Contains(err.Error(), logged.CorrelationID) {
+			t.Fatalf("expected the correlation id %q in %v", logged.CorrelationID, err)
+		}
+	})
+	t.Run("error with code", func(t *testing.T) {
+		logs.Reset()
+		err := read(t, "weather://alerts/paris")
+		if !errors.Is(err, jsonrpc2.ErrInvalidParams) {
+			t.Fatalf("expected ErrInvalidParams, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "city is required") {
+			t.Fatalf("expected the error not to be redacted, got %v", err)
+		}
+		if logs.Len() != 0 {
+			t.Fatalf("expected nothing to be logged, got %s", logs.String())
+		}
+	})
+}
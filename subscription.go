@@ -52,7 +52,8 @@ type ResourceModificationSubscriptionStore interface {
 	RetrieveBySessionID(ctx context.Context, sessionID string) ([]Subscription, error)
 	// RetrieveUnhealthyURIBySessionID retrieves all unhealthy subscriptions uri by session ID
 	RetrieveUnhealthyURIBySessionID(ctx context.Context, sessionID string) ([]*url.URL, error)
-	// DeleteBySessionID deletes all subscriptions by session ID
+	// DeleteBySessionID deletes all subscriptions by session ID.
+	// It returns ErrResourceModificationSubscriptionNotFound if the session has no subscription.
 	DeleteBySessionID(ctx context.Context, sessionID string) error
 }
 
@@ -273,7 +274,7 @@ func (s *InMemoryResourceModificationSubscriptionStore) DeleteBySessionID(
 ) error {
 	untypedSessionSubscriptions, ok := s.subscriptions.LoadAndDelete(sessionID)
 	if !ok {
-		return fmt.Errorf("session subscription '%s' not found", sessionID)
+		return fmt.Errorf("%w: session subscription '%s' not found", ErrResourceModificationSubscriptionNotFound, sessionID)
 	}
 	sessionSubscriptions := untypedSessionSubscriptions.(*sync.Map)
 
@@ -38,6 +38,24 @@ func OrderHandler(c qilin.ToolContext) error {
 	return c.JSON(OrderResponse{Amount: totalAmount})
 }
 
+// TailLogsHandler streams the log lines ahead of the final result.
+func TailLogsHandler(c qilin.ToolContext) error {
+	for _, line := range logLines {
+		if err := c.Stream(line); err != nil {
+			return err
+		}
+	}
+	return c.String("done")
+}
+
+var logLines = []string{"starting", "listening", "ready"}
+
+// RevokeHandler aborts the connection as if the authorization were revoked.
+func RevokeHandler(c qilin.ToolContext) error {
+	c.Abort("authorization revoked")
+	return c.String("revoked")
+}
+
 type GreetingArgs struct {
 	Name string `json:"name"`
 }
@@ -104,7 +122,7 @@ func ResourceListHandler(c qilin.ResourceListContext) error {
 			return err
 		}
 		c.SetResource(uri.String(), qilin.Resource{
-			URI:         (*qilin.ResourceURI)(uri),
+			URI:         qilin.ResourceURIFromURL(uri),
 			Name:        beer.Name,
 			Description: fmt.Sprintf("Details of %s", beer.Name),
 			MimeType:    "application/json",
@@ -125,6 +143,23 @@ func ResourceChangeObserver(c qilin.ResourceChangeContext) {
 	return
 }
 
+func BeerDetailChangeObserver(c qilin.ResourceChangeContext) {
+	uri, err := url.Parse("beer://detail/{id}")
+	if err != nil {
+		return
+	}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Context().Done():
+			return
+		case v := <-ticker.C:
+			c.Publish(uri, v)
+		}
+	}
+}
+
 func ResourceListChangeObserver(c qilin.ResourceListChangeContext) {
 	tick := time.Tick(10 * time.Second)
 	for v := range tick {
@@ -133,11 +168,13 @@ func ResourceListChangeObserver(c qilin.ResourceListChangeContext) {
 	return
 }
 
-func NewQilin(t *testing.T) *qilin.Qilin {
+func NewQilin(t *testing.T, options ...qilin.Option) *qilin.Qilin {
 	t.Helper()
 
-	q := qilin.New("beer_hall", qilin.WithVersion("1.0.0"))
+	q := qilin.New("beer_hall", append([]qilin.Option{qilin.WithVersion("1.0.0")}, options...)...)
 	q.Tool("order", (*OrderRequest)(nil), OrderHandler)
+	q.Tool("tail_logs", (*struct{})(nil), TailLogsHandler)
+	q.Tool("revoke", (*struct{})(nil), RevokeHandler)
 	q.Prompt("greeting", GreetingPromptHandler,
 		qilin.PromptWithDescription("A greeting prompt that welcomes users"),
 		qilin.PromptWithArguments(
@@ -151,6 +188,7 @@ func NewQilin(t *testing.T) *qilin.Qilin {
 	q.Resource("beer_detail", "beer://detail/{id}", BeerDetailHandler)
 	q.ResourceList(ResourceListHandler)
 	q.ResourceChangeObserver("beer://list", ResourceChangeObserver)
+	q.ResourceChangeObserver("beer://detail/{id}", BeerDetailChangeObserver)
 	q.ResourceListChangeObserver(ResourceListChangeObserver)
 
 	return q
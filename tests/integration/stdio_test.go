@@ -1,14 +1,19 @@
 package integration
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"io"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/miyamo2/qilin"
 	"github.com/miyamo2/qilin/transport"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -379,6 +384,50 @@ func (s *StdioTestSuite) TestStdioTestSuite_PromptsGet_WithoutArguments() {
 	s.Require().Equal("Hello, World! How can I help you today?", userContent["text"])
 }
 
+// TestStdioTestSuite_ResourceSubscribe_PublishedAgainstTemplate tests that a subscription to a concrete URI
+// is notified of changes published against the template
+func (s *StdioTestSuite) TestStdioTestSuite_ResourceSubscribe_PublishedAgainstTemplate() {
+	// Initialize first
+	s.initializeConnection()
+
+	params := map[string]any{
+		"uri": "beer://detail/1",
+	}
+
+	req := NewJSONRPCRequest(s.T(), qilin.MethodResourceSubscribe, params)
+	reqBytes, err := json.Marshal(req)
+	s.Require().NoError(err)
+
+	_, err = s.clientWritePipe.Write(append(reqBytes, '\n'))
+	s.Require().NoError(err)
+
+	reader := bufio.NewReader(s.clientReadPipe)
+	line, err := reader.ReadBytes('\n')
+	s.Require().NoError(err)
+
+	response := JSONRPCResponseFromBytes(s.T(), line)
+	s.Require().Equal(req.ID, response.ID)
+	s.Require().Nil(response.Error)
+
+	for {
+		line, err = reader.ReadBytes('\n')
+		s.Require().NoError(err)
+
+		var notification struct {
+			Method string `json:"method"`
+			Params struct {
+				URI string `json:"uri"`
+			} `json:"params"`
+		}
+		s.Require().NoError(json.Unmarshal(line, &notification))
+		if notification.Method != qilin.MethodNotificationResourceUpdated {
+			continue
+		}
+		s.Require().Equal("beer://detail/1", notification.Params.URI)
+		break
+	}
+}
+
 // initializeConnection helper function to initialize connection
 func (s *StdioTestSuite) initializeConnection() {
 	params := map[string]any{
@@ -403,3 +452,172 @@ func (s *StdioTestSuite) initializeConnection() {
 	_, err = s.clientReadPipe.Read(buf)
 	s.Require().NoError(err)
 }
+
+// syncBuffer is a bytes.Buffer safe for the concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestStdio_WireTrace tests the wire trace of an initialize/ping exchange
+func TestStdio_WireTrace(t *testing.T) {
+	serverReadPipe, clientWritePipe := io.Pipe()
+	clientReadPipe, serverWritePipe := io.Pipe()
+	t.Cleanup(func() {
+		_ = clientWritePipe.Close()
+		_ = clientReadPipe.Close()
+	})
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var trace syncBuffer
+	q := NewQilin(t, qilin.WithWireTrace(&trace), qilin.WithNowFunc(func() time.Time { return now }))
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	ready := make(chan struct{}, 1)
+	go func() {
+		q.Start(
+			qilin.StartWithReadySignal(ready),
+			qilin.StartWithContext(ctx),
+			qilin.StartWithListener(
+				transport.NewStdio(ctx,
+					transport.StdioWithReadCloser(serverReadPipe),
+					transport.StdioWithWriteCloser(serverWritePipe))))
+	}()
+	<-ready
+
+	reader := bufio.NewReader(clientReadPipe)
+	exchange := func(req JSONRPCRequest) {
+		t.Helper()
+		b, err := json.Marshal(req)
+		require.NoError(t, err)
+		_, err = clientWritePipe.Write(append(b, '\n'))
+		require.NoError(t, err)
+		line, err := reader.ReadBytes('\n')
+		require.NoError(t, err)
+		require.Equal(t, req.ID, JSONRPCResponseFromBytes(t, line).ID)
+	}
+	initReq := NewJSONRPCRequest(t, qilin.MethodInitialize, map[string]any{
+		"protocolVersion": qilin.LatestProtocolVersion,
+		"clientInfo": map[string]any{
+			"name":    "test-client",
+			"version": "1.0.0",
+		},
+	})
+	exchange(initReq)
+	pingReq := NewJSONRPCRequest(t, qilin.MethodPing, nil)
+	exchange(pingReq)
+
+	type entry struct {
+		direction string
+		message   map[string]any
+	}
+	var entries []entry
+	for chunk := range strings.SplitSeq(trace.String(), "2025-01-01T00:00:00Z ") {
+		if chunk == "" {
+			continue
+		}
+		direction, body, ok := strings.Cut(chunk, "\n")
+		require.True(t, ok, "expected the direction line, got %q", chunk)
+		require.Contains(t, body, "\n  ", "expected the message to be indented")
+		var message map[string]any
+		require.NoError(t, json.Unmarshal([]byte(body), &message))
+		entries = append(entries, entry{direction: direction, message: message})
+	}
+	require.Len(t, entries, 4, "unexpected trace:\n%s", trace.String())
+	require.Equal(t, "<--", entries[0].direction)
+	require.Equal(t, qilin.MethodInitialize, entries[0].message["method"])
+	require.Equal(t, "-->", entries[1].direction)
+	require.Equal(t, initReq.ID, entries[1].message["id"])
+	require.Contains(t, entries[1].message, "result")
+	require.Equal(t, "<--", entries[2].direction)
+	require.Equal(t, qilin.MethodPing, entries[2].message["method"])
+	require.Equal(t, "-->", entries[3].direction)
+	require.Equal(t, pingReq.ID, entries[3].message["id"])
+}
+
+// recordingSessionStore records the IDs of the issued sessions.
+type recordingSessionStore struct {
+	qilin.InMemorySessionStore
+	mu  sync.Mutex
+	ids []string
+}
+
+func (s *recordingSessionStore) Issue(ctx context.Context) (string, error) {
+	id, err := s.InMemorySessionStore.Issue(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ids = append(s.ids, id)
+	return id, nil
+}
+
+// TestStdio_DiscardOnEOF tests that the stdio session is discarded once the input hits EOF
+func TestStdio_DiscardOnEOF(t *testing.T) {
+	serverReadPipe, clientWritePipe := io.Pipe()
+	clientReadPipe, serverWritePipe := io.Pipe()
+	t.Cleanup(func() {
+		_ = clientReadPipe.Close()
+	})
+
+	store := &recordingSessionStore{}
+	q := NewQilin(t, qilin.WithSessionStore(store))
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	ready := make(chan struct{}, 1)
+	go func() {
+		q.Start(
+			qilin.StartWithReadySignal(ready),
+			qilin.StartWithContext(ctx),
+			qilin.StartWithListener(
+				transport.NewStdio(ctx,
+					transport.StdioWithReadCloser(serverReadPipe),
+					transport.StdioWithWriteCloser(serverWritePipe))))
+	}()
+	<-ready
+
+	initReq := NewJSONRPCRequest(t, qilin.MethodInitialize, map[string]any{
+		"protocolVersion": qilin.LatestProtocolVersion,
+		"clientInfo": map[string]any{
+			"name":    "test-client",
+			"version": "1.0.0",
+		},
+	})
+	b, err := json.Marshal(initReq)
+	require.NoError(t, err)
+	_, err = clientWritePipe.Write(append(b, '\n'))
+	require.NoError(t, err)
+	line, err := bufio.NewReader(clientReadPipe).ReadBytes('\n')
+	require.NoError(t, err)
+	require.Nil(t, JSONRPCResponseFromBytes(t, line).Error)
+
+	store.mu.Lock()
+	require.Len(t, store.ids, 1)
+	sessionID := store.ids[0]
+	store.mu.Unlock()
+	sessionCtx, err := store.Context(t.Context(), sessionID)
+	require.NoError(t, err)
+
+	// hit EOF on the input
+	require.NoError(t, clientWritePipe.Close())
+	select {
+	case <-sessionCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the session context to be canceled on EOF")
+	}
+	_, err = store.Context(t.Context(), sessionID)
+	require.Error(t, err, "expected the session to be discarded")
+}
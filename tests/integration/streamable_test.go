@@ -4,15 +4,19 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/miyamo2/qilin"
 	"github.com/miyamo2/qilin/transport"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -21,6 +25,7 @@ type StreamableTestSuite struct {
 	mu      sync.Mutex
 	cancel  context.CancelFunc
 	address string
+	q       *qilin.Qilin
 }
 
 func (s *StreamableTestSuite) BeforeTest(_, _ string) {
@@ -28,6 +33,7 @@ func (s *StreamableTestSuite) BeforeTest(_, _ string) {
 	defer s.mu.Unlock()
 
 	q := NewQilin(s.T())
+	s.q = q
 	listener, err := net.Listen("tcp", "localhost:0")
 	s.Require().NoError(err, "failed to create tcp listener")
 
@@ -483,6 +489,394 @@ func (s *StreamableTestSuite) TestStreamableTestSuite_DeleteSession() {
 }
 
 // initializeSessionAndGetID helper function to initialize session and return session ID
+// TestStreamableTestSuite_ResourceSubscribe_ImmediatePublish tests that the notifications published right after
+// the subscription are delivered as well-formed SSE events
+func (s *StreamableTestSuite) TestStreamableTestSuite_ResourceSubscribe_ImmediatePublish() {
+	initResp := s.initializeSession()
+	defer initResp.Body.Close()
+
+	sessionID := SessionIDFromResponse(s.T(), initResp)
+	s.Require().NotEmpty(sessionID)
+
+	req := NewJSONRPCRequest(s.T(), qilin.MethodResourceSubscribe, map[string]any{
+		"uri": "beer://detail/1",
+	})
+	reqBytes, err := json.Marshal(req)
+	s.Require().NoError(err)
+
+	url := fmt.Sprintf("http://%s/mcp", s.address)
+	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(reqBytes))
+	s.Require().NoError(err)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(transport.MCPSessionID, sessionID)
+
+	client := &http.Client{}
+	resp, err := client.Do(httpReq)
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+	var (
+		responded bool
+		notified  bool
+	)
+	for line := range StreamIterFromResponse(s.T(), resp) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || bytes.HasPrefix(line, []byte(":")) {
+			continue
+		}
+		var message struct {
+			ID     any    `json:"id"`
+			Method string `json:"method"`
+			Params struct {
+				URI string `json:"uri"`
+			} `json:"params"`
+		}
+		s.Require().NoError(json.Unmarshal(line, &message), "malformed SSE event: %q", line)
+		switch {
+		case message.Method == qilin.MethodNotificationResourceUpdated:
+			s.Require().Equal("beer://detail/1", message.Params.URI)
+			notified = true
+		case message.ID != nil:
+			responded = true
+		}
+		if responded && notified {
+			break
+		}
+	}
+	s.Require().True(responded)
+	s.Require().True(notified)
+}
+
+// TestStreamableTestSuite_Notify tests that the notification sent by the application is delivered over the SSE stream
+func (s *StreamableTestSuite) TestStreamableTestSuite_Notify() {
+	initResp := s.initializeSession()
+	defer initResp.Body.Close()
+
+	sessionID := SessionIDFromResponse(s.T(), initResp)
+	s.Require().NotEmpty(sessionID)
+
+	err := s.q.Notify(s.T().Context(), sessionID, "notifications/experimental/greeting", nil)
+	s.Require().ErrorIs(err, qilin.ErrNoLiveStream)
+
+	req := NewJSONRPCRequest(s.T(), qilin.MethodResourceSubscribe, map[string]any{
+		"uri": "beer://list",
+	})
+	reqBytes, err := json.Marshal(req)
+	s.Require().NoError(err)
+
+	url := fmt.Sprintf("http://%s/mcp", s.address)
+	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(reqBytes))
+	s.Require().NoError(err)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(transport.MCPSessionID, sessionID)
+
+	client := &http.Client{}
+	resp, err := client.Do(httpReq)
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+	var notified bool
+	for line := range StreamIterFromResponse(s.T(), resp) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || bytes.HasPrefix(line, []byte(":")) {
+			continue
+		}
+		var message struct {
+			ID     any    `json:"id"`
+			Method string `json:"method"`
+			Params struct {
+				Text string `json:"text"`
+			} `json:"params"`
+		}
+		s.Require().NoError(json.Unmarshal(line, &message), "malformed SSE event: %q", line)
+		if message.ID != nil {
+			err := s.q.Notify(s.T().Context(), sessionID, "notifications/experimental/greeting", map[string]any{
+				"text": "Hello",
+			})
+			s.Require().NoError(err)
+			continue
+		}
+		if message.Method == "notifications/experimental/greeting" {
+			s.Require().Equal("Hello", message.Params.Text)
+			notified = true
+			break
+		}
+	}
+	s.Require().True(notified)
+}
+
+func (s *StreamableTestSuite) TestStreamableTestSuite_ToolsCall_Stream() {
+	initResp := s.initializeSession()
+	defer initResp.Body.Close()
+
+	sessionID := SessionIDFromResponse(s.T(), initResp)
+	s.Require().NotEmpty(sessionID)
+
+	req := NewJSONRPCRequest(s.T(), qilin.MethodToolsCall, map[string]any{
+		"name":      "tail_logs",
+		"arguments": map[string]any{},
+	})
+	reqBytes, err := json.Marshal(req)
+	s.Require().NoError(err)
+
+	url := fmt.Sprintf("http://%s/mcp", s.address)
+	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(reqBytes))
+	s.Require().NoError(err)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(transport.MCPSessionID, sessionID)
+
+	client := &http.Client{}
+	resp, err := client.Do(httpReq)
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+	var streamed []string
+	var result struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	for line := range StreamIterFromResponse(s.T(), resp) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || bytes.HasPrefix(line, []byte(":")) {
+			continue
+		}
+		var message struct {
+			ID     any             `json:"id"`
+			Method string          `json:"method"`
+			Result json.RawMessage `json:"result"`
+			Params struct {
+				RequestID string `json:"requestId"`
+				Content   struct {
+					Type string `json:"type"`
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"params"`
+		}
+		s.Require().NoError(json.Unmarshal(line, &message), "malformed SSE event: %q", line)
+		if message.ID != nil {
+			s.Require().Equal(req.ID, message.ID)
+			s.Require().NoError(json.Unmarshal(message.Result, &result))
+			break
+		}
+		s.Require().Equal(qilin.MethodNotificationToolsStream, message.Method)
+		s.Require().Equal(req.ID, message.Params.RequestID)
+		s.Require().Equal("text", message.Params.Content.Type)
+		streamed = append(streamed, message.Params.Content.Text)
+	}
+	s.Require().Equal(logLines, streamed, "the chunks must arrive before the final result")
+	s.Require().Equal("done", result.Text)
+}
+
+// TestStreamableTestSuite_PipelinedRequests tests two requests posted in one body
+func (s *StreamableTestSuite) TestStreamableTestSuite_PipelinedRequests() {
+	initResp := s.initializeSession()
+	defer initResp.Body.Close()
+
+	sessionID := SessionIDFromResponse(s.T(), initResp)
+	s.Require().NotEmpty(sessionID)
+
+	pingReq := NewJSONRPCRequest(s.T(), qilin.MethodPing, nil)
+	promptsReq := NewJSONRPCRequest(s.T(), qilin.MethodPromptsList, map[string]any{})
+	var body bytes.Buffer
+	for _, req := range []JSONRPCRequest{pingReq, promptsReq} {
+		reqBytes, err := json.Marshal(req)
+		s.Require().NoError(err)
+		body.Write(reqBytes)
+		body.WriteByte('\n')
+	}
+
+	url := fmt.Sprintf("http://%s/mcp", s.address)
+	httpReq, err := http.NewRequest("POST", url, &body)
+	s.Require().NoError(err)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(transport.MCPSessionID, sessionID)
+
+	client := &http.Client{}
+	resp, err := client.Do(httpReq)
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+	responses := make(map[string]JSONRPCResponse)
+	for line := range StreamIterFromResponse(s.T(), resp) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || bytes.HasPrefix(line, []byte(":")) {
+			continue
+		}
+		response := JSONRPCResponseFromBytes(s.T(), line)
+		responses[response.ID] = response
+		if len(responses) == 2 {
+			break
+		}
+	}
+	s.Require().Contains(responses, pingReq.ID)
+	s.Require().Nil(responses[pingReq.ID].Error)
+	s.Require().Equal("{}", string(responses[pingReq.ID].Result))
+	s.Require().Contains(responses, promptsReq.ID)
+	s.Require().Nil(responses[promptsReq.ID].Error)
+	s.Require().Contains(string(responses[promptsReq.ID].Result), "greeting")
+}
+
+// TestStreamableTestSuite_Notification_Accepted tests that a POST carrying only a notification is answered with 202 Accepted
+func (s *StreamableTestSuite) TestStreamableTestSuite_Notification_Accepted() {
+	initResp := s.initializeSession()
+	defer initResp.Body.Close()
+
+	sessionID := SessionIDFromResponse(s.T(), initResp)
+	s.Require().NotEmpty(sessionID)
+
+	notification, err := json.Marshal(map[string]any{
+		"jsonrpc": qilin.JSONRPCVersion,
+		"method":  qilin.MethodInitializedNotification,
+	})
+	s.Require().NoError(err)
+
+	url := fmt.Sprintf("http://%s/mcp", s.address)
+	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(notification))
+	s.Require().NoError(err)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(transport.MCPSessionID, sessionID)
+
+	client := &http.Client{}
+	resp, err := client.Do(httpReq)
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusAccepted, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	s.Require().NoError(err)
+	s.Require().Empty(body)
+}
+
+// TestStreamableTestSuite_Accept_JSONOnly tests that the client accepting only JSON is never switched to SSE
+func (s *StreamableTestSuite) TestStreamableTestSuite_Accept_JSONOnly() {
+	initResp := s.initializeSession()
+	defer initResp.Body.Close()
+
+	sessionID := SessionIDFromResponse(s.T(), initResp)
+	s.Require().NotEmpty(sessionID)
+
+	req := NewJSONRPCRequest(s.T(), qilin.MethodResourceSubscribe, map[string]any{
+		"uri": "beer://detail/1",
+	})
+	reqBytes, err := json.Marshal(req)
+	s.Require().NoError(err)
+
+	url := fmt.Sprintf("http://%s/mcp", s.address)
+	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(reqBytes))
+	s.Require().NoError(err)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json")
+	httpReq.Header.Set(transport.MCPSessionID, sessionID)
+
+	client := &http.Client{}
+	resp, err := client.Do(httpReq)
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+	s.Require().True(strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json"))
+	body, err := io.ReadAll(resp.Body)
+	s.Require().NoError(err)
+	response := JSONRPCResponseFromBytes(s.T(), bytes.TrimSpace(body))
+	s.Require().Equal(req.ID, response.ID)
+	s.Require().NotNil(response.Error)
+	s.Require().Equal(-32600, response.Error.Code)
+
+	var pipelined bytes.Buffer
+	for _, req := range []JSONRPCRequest{
+		NewJSONRPCRequest(s.T(), qilin.MethodPing, nil),
+		NewJSONRPCRequest(s.T(), qilin.MethodPing, nil),
+	} {
+		reqBytes, err := json.Marshal(req)
+		s.Require().NoError(err)
+		pipelined.Write(reqBytes)
+		pipelined.WriteByte('\n')
+	}
+	httpReq, err = http.NewRequest("POST", url, &pipelined)
+	s.Require().NoError(err)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json")
+	httpReq.Header.Set(transport.MCPSessionID, sessionID)
+
+	pipelinedResp, err := client.Do(httpReq)
+	s.Require().NoError(err)
+	defer pipelinedResp.Body.Close()
+	s.Require().Equal(http.StatusNotAcceptable, pipelinedResp.StatusCode)
+}
+
+// TestStreamableTestSuite_Accept_EventStream tests that the client accepting SSE is switched to the stream
+func (s *StreamableTestSuite) TestStreamableTestSuite_Accept_EventStream() {
+	initResp := s.initializeSession()
+	defer initResp.Body.Close()
+
+	sessionID := SessionIDFromResponse(s.T(), initResp)
+	s.Require().NotEmpty(sessionID)
+
+	req := NewJSONRPCRequest(s.T(), qilin.MethodResourceSubscribe, map[string]any{
+		"uri": "beer://detail/1",
+	})
+	reqBytes, err := json.Marshal(req)
+	s.Require().NoError(err)
+
+	url := fmt.Sprintf("http://%s/mcp", s.address)
+	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(reqBytes))
+	s.Require().NoError(err)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json, text/event-stream")
+	httpReq.Header.Set(transport.MCPSessionID, sessionID)
+
+	client := &http.Client{}
+	resp, err := client.Do(httpReq)
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+	s.Require().True(strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream"))
+}
+
+// TestStreamableTestSuite_ToolsCall_Abort tests that the tool aborting the connection closes it and discards the session
+func (s *StreamableTestSuite) TestStreamableTestSuite_ToolsCall_Abort() {
+	initResp := s.initializeSession()
+	defer initResp.Body.Close()
+
+	sessionID := SessionIDFromResponse(s.T(), initResp)
+	s.Require().NotEmpty(sessionID)
+
+	url := fmt.Sprintf("http://%s/mcp", s.address)
+	post := func(req JSONRPCRequest) *http.Response {
+		reqBytes, err := json.Marshal(req)
+		s.Require().NoError(err)
+		httpReq, err := http.NewRequest("POST", url, bytes.NewReader(reqBytes))
+		s.Require().NoError(err)
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set(transport.MCPSessionID, sessionID)
+		resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(httpReq)
+		s.Require().NoError(err)
+		return resp
+	}
+
+	resp := post(NewJSONRPCRequest(s.T(), qilin.MethodToolsCall, map[string]any{
+		"name":      "revoke",
+		"arguments": map[string]any{},
+	}))
+	defer resp.Body.Close()
+	// the connection is closed without waiting for the client.
+	_, err := io.ReadAll(resp.Body)
+	s.Require().NoError(err)
+
+	pingResp := post(NewJSONRPCRequest(s.T(), qilin.MethodPing, nil))
+	defer pingResp.Body.Close()
+	s.Require().Equal(http.StatusNotFound, pingResp.StatusCode)
+}
+
 func (s *StreamableTestSuite) initializeSession() *http.Response {
 	params := map[string]any{
 		"protocolVersion": qilin.LatestProtocolVersion,
@@ -505,3 +899,434 @@ func (s *StreamableTestSuite) initializeSession() *http.Response {
 
 	return resp
 }
+
+// TestStreamable_InitializeTimeout tests that the connection sending no initialize is closed after the timeout
+func TestStreamable_InitializeTimeout(t *testing.T) {
+	type test struct {
+		options      []qilin.Option
+		expectClosed bool
+	}
+	tests := map[string]test{
+		"with initialize timeout": {
+			options:      []qilin.Option{qilin.WithInitializeTimeout(100 * time.Millisecond)},
+			expectClosed: true,
+		},
+		"without initialize timeout": {
+			expectClosed: false,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := NewQilin(t, tc.options...)
+			listener, err := net.Listen("tcp", "localhost:0")
+			require.NoError(t, err, "failed to create tcp listener")
+			ctx, cancel := context.WithCancel(context.Background())
+			t.Cleanup(cancel)
+			ready := make(chan struct{}, 1)
+			go func() {
+				streamable := transport.NewStreamable(transport.StreamableWithNetListener(listener))
+				q.Start(
+					qilin.StartWithReadySignal(ready),
+					qilin.StartWithContext(ctx),
+					qilin.StartWithListener(streamable))
+			}()
+			<-ready
+
+			// open the stream without the session, and send nothing.
+			reqCtx, cancelReq := context.WithCancel(t.Context())
+			defer cancelReq()
+			httpReq, err := http.NewRequestWithContext(reqCtx, "GET", fmt.Sprintf("http://%s/mcp", listener.Addr()), nil)
+			require.NoError(t, err)
+			httpReq.Header.Set("Accept", "text/event-stream")
+
+			// nothing is written until the connection is closed, so wait for the response aside.
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				resp, err := http.DefaultClient.Do(httpReq)
+				if err != nil {
+					return
+				}
+				defer resp.Body.Close()
+				_, _ = io.Copy(io.Discard, resp.Body)
+			}()
+			select {
+			case <-closed:
+				require.True(t, tc.expectClosed, "expected the connection to be kept open")
+			case <-time.After(time.Second):
+				require.False(t, tc.expectClosed, "expected the connection to be closed after the timeout")
+			}
+		})
+	}
+}
+
+// TestStreamable_ServeMux tests that several servers are served on one HTTP server, each at its own path
+func TestStreamable_ServeMux(t *testing.T) {
+	mux := http.NewServeMux()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	names := []string{"beer_hall", "wine_bar"}
+	for _, name := range names {
+		q := qilin.New(name)
+		q.Tool("whoami", (*struct{})(nil), func(c qilin.ToolContext) error {
+			return c.String(name)
+		})
+		streamable := transport.NewStreamable(
+			transport.StreamableWithServeMux(mux),
+			transport.StreamableWithPath("/"+name+"/mcp"))
+		ready := make(chan struct{}, 1)
+		go func() {
+			q.Start(
+				qilin.StartWithReadySignal(ready),
+				qilin.StartWithContext(ctx),
+				qilin.StartWithListener(streamable))
+		}()
+		<-ready
+	}
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err, "failed to create tcp listener")
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+
+	post := func(url, sessionID string, req JSONRPCRequest) *http.Response {
+		reqBytes, err := json.Marshal(req)
+		require.NoError(t, err)
+		httpReq, err := http.NewRequest("POST", url, bytes.NewReader(reqBytes))
+		require.NoError(t, err)
+		httpReq.Header.Set("Content-Type", "application/json")
+		if sessionID != "" {
+			httpReq.Header.Set(transport.MCPSessionID, sessionID)
+		}
+		resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(httpReq)
+		require.NoError(t, err)
+		return resp
+	}
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			url := fmt.Sprintf("http://%s/%s/mcp", listener.Addr(), name)
+			initResp := post(url, "", NewJSONRPCRequest(t, qilin.MethodInitialize, map[string]any{
+				"protocolVersion": qilin.LatestProtocolVersion,
+				"capabilities":    map[string]any{},
+				"clientInfo": map[string]any{
+					"name":    "test-client",
+					"version": "1.0.0",
+				},
+			}))
+			defer initResp.Body.Close()
+			sessionID := SessionIDFromResponse(t, initResp)
+
+			resp := post(url, sessionID, NewJSONRPCRequest(t, qilin.MethodToolsCall, map[string]any{
+				"name":      "whoami",
+				"arguments": map[string]any{},
+			}))
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			response := JSONRPCResponseFromBytes(t, body)
+			require.Nil(t, response.Error)
+			require.JSONEq(t, fmt.Sprintf(`{"type":"text","text":%q}`, name), string(response.Result))
+		})
+	}
+}
+
+// TestStreamable_RetryAfter tests that the resource read telling to retry later carries the retry-after in both the error data and the header
+func TestStreamable_RetryAfter(t *testing.T) {
+	q := NewQilin(t)
+	q.Resource("rate_limited", "beer://rate_limited", func(c qilin.ResourceContext) error {
+		return &qilin.RetryAfterError{RetryAfter: 1500 * time.Millisecond, Err: errors.New("the brewery API is rate-limited")}
+	})
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err, "failed to create tcp listener")
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	ready := make(chan struct{}, 1)
+	go func() {
+		streamable := transport.NewStreamable(transport.StreamableWithNetListener(listener))
+		q.Start(
+			qilin.StartWithReadySignal(ready),
+			qilin.StartWithContext(ctx),
+			qilin.StartWithListener(streamable))
+	}()
+	<-ready
+
+	url := fmt.Sprintf("http://%s/mcp", listener.Addr())
+	post := func(sessionID string, req JSONRPCRequest) *http.Response {
+		reqBytes, err := json.Marshal(req)
+		require.NoError(t, err)
+		httpReq, err := http.NewRequest("POST", url, bytes.NewReader(reqBytes))
+		require.NoError(t, err)
+		httpReq.Header.Set("Content-Type", "application/json")
+		if sessionID != "" {
+			httpReq.Header.Set(transport.MCPSessionID, sessionID)
+		}
+		resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(httpReq)
+		require.NoError(t, err)
+		return resp
+	}
+	initResp := post("", NewJSONRPCRequest(t, qilin.MethodInitialize, map[string]any{
+		"protocolVersion": qilin.LatestProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo": map[string]any{
+			"name":    "test-client",
+			"version": "1.0.0",
+		},
+	}))
+	defer initResp.Body.Close()
+	sessionID := SessionIDFromResponse(t, initResp)
+
+	resp := post(sessionID, NewJSONRPCRequest(t, qilin.MethodResourcesRead, map[string]any{
+		"uri": "beer://rate_limited",
+	}))
+	defer resp.Body.Close()
+	require.Equal(t, "2", resp.Header.Get("Retry-After"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	var response struct {
+		Error struct {
+			Code int64 `json:"code"`
+			Data struct {
+				RetryAfter int64 `json:"retryAfter"`
+			} `json:"data"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(body, &response), "unexpected body: %s", body)
+	require.Equal(t, int64(-32003), response.Error.Code)
+	require.Equal(t, int64(2), response.Error.Data.RetryAfter)
+}
+
+// TestStreamable_StreamingDisabled tests that the tools never switch the response to the stream with WithStreamingDisabled
+func TestStreamable_StreamingDisabled(t *testing.T) {
+	q := NewQilin(t, qilin.WithStreamingDisabled())
+	q.Tool("stream", (*struct{})(nil), func(c qilin.ToolContext) error {
+		return c.String(fmt.Sprint(errors.Is(c.Stream("chunk"), qilin.ErrToolStreamNotSupported)))
+	})
+	q.Tool("progress", (*struct{})(nil), func(c qilin.ToolContext) error {
+		return c.String(fmt.Sprint(c.Progress(1, 2, "half")))
+	})
+	q.Tool("elicit", (*struct{})(nil), func(c qilin.ToolContext) error {
+		_, err := c.Elicit(c.Context(), nil, "which beer?")
+		return c.String(fmt.Sprint(errors.Is(err, qilin.ErrElicitationNotSupported)))
+	})
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err, "failed to create tcp listener")
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	ready := make(chan struct{}, 1)
+	go func() {
+		streamable := transport.NewStreamable(transport.StreamableWithNetListener(listener))
+		q.Start(
+			qilin.StartWithReadySignal(ready),
+			qilin.StartWithContext(ctx),
+			qilin.StartWithListener(streamable))
+	}()
+	<-ready
+
+	url := fmt.Sprintf("http://%s/mcp", listener.Addr())
+	post := func(sessionID string, req JSONRPCRequest) *http.Response {
+		reqBytes, err := json.Marshal(req)
+		require.NoError(t, err)
+		httpReq, err := http.NewRequest("POST", url, bytes.NewReader(reqBytes))
+		require.NoError(t, err)
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("Accept", "application/json, text/event-stream")
+		if sessionID != "" {
+			httpReq.Header.Set(transport.MCPSessionID, sessionID)
+		}
+		resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(httpReq)
+		require.NoError(t, err)
+		return resp
+	}
+	initResp := post("", NewJSONRPCRequest(t, qilin.MethodInitialize, map[string]any{
+		"protocolVersion": qilin.LatestProtocolVersion,
+		"capabilities": map[string]any{
+			"elicitation": map[string]any{},
+		},
+		"clientInfo": map[string]any{
+			"name":    "test-client",
+			"version": "1.0.0",
+		},
+	}))
+	defer initResp.Body.Close()
+	sessionID := SessionIDFromResponse(t, initResp)
+
+	tests := map[string]string{
+		"stream":   "true",
+		"progress": "<nil>",
+		"elicit":   "true",
+	}
+	for name, expect := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp := post(sessionID, NewJSONRPCRequest(t, qilin.MethodToolsCall, map[string]any{
+				"name":  name,
+				"_meta": map[string]any{"progressToken": "token"},
+			}))
+			defer resp.Body.Close()
+			require.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json"), "expected the plain JSON response")
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			var response struct {
+				Result struct {
+					Text string `json:"text"`
+				} `json:"result"`
+			}
+			require.NoError(t, json.Unmarshal(body, &response), "unexpected body: %s", body)
+			require.Equal(t, expect, response.Result.Text, "unexpected body: %s", body)
+		})
+	}
+}
+
+// TestStreamable_InitializeProbe tests that the initialize probing the capabilities does not start a session
+func TestStreamable_InitializeProbe(t *testing.T) {
+	store := &recordingSessionStore{}
+	q := NewQilin(t, qilin.WithSessionStore(store), qilin.WithInitializeProbe(qilin.MetaProbe))
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err, "failed to create tcp listener")
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	ready := make(chan struct{}, 1)
+	go func() {
+		streamable := transport.NewStreamable(transport.StreamableWithNetListener(listener))
+		q.Start(
+			qilin.StartWithReadySignal(ready),
+			qilin.StartWithContext(ctx),
+			qilin.StartWithListener(streamable))
+	}()
+	<-ready
+
+	url := fmt.Sprintf("http://%s/mcp", listener.Addr())
+	initialize := func(meta map[string]any) *http.Response {
+		params := map[string]any{
+			"protocolVersion": qilin.LatestProtocolVersion,
+			"capabilities":    map[string]any{},
+			"clientInfo": map[string]any{
+				"name":    "test-client",
+				"version": "1.0.0",
+			},
+		}
+		if meta != nil {
+			params["_meta"] = meta
+		}
+		reqBytes, err := json.Marshal(NewJSONRPCRequest(t, qilin.MethodInitialize, params))
+		require.NoError(t, err)
+		httpReq, err := http.NewRequest("POST", url, bytes.NewReader(reqBytes))
+		require.NoError(t, err)
+		httpReq.Header.Set("Content-Type", "application/json")
+		resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(httpReq)
+		require.NoError(t, err)
+		return resp
+	}
+
+	probeResp := initialize(map[string]any{"probe": true})
+	defer probeResp.Body.Close()
+	require.Equal(t, http.StatusOK, probeResp.StatusCode)
+	require.Empty(t, probeResp.Header.Get(transport.MCPSessionID))
+	body, err := io.ReadAll(probeResp.Body)
+	require.NoError(t, err)
+	var response struct {
+		Result struct {
+			ProtocolVersion string         `json:"protocolVersion"`
+			Capabilities    map[string]any `json:"capabilities"`
+		} `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(body, &response), "unexpected body: %s", body)
+	require.Equal(t, qilin.LatestProtocolVersion, response.Result.ProtocolVersion)
+	require.Contains(t, response.Result.Capabilities, "tools")
+	store.mu.Lock()
+	require.Empty(t, store.ids, "expected the probe not to issue a session")
+	store.mu.Unlock()
+
+	initResp := initialize(nil)
+	defer initResp.Body.Close()
+	sessionID := SessionIDFromResponse(t, initResp)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	require.Equal(t, []string{sessionID}, store.ids)
+}
+
+// TestStreamable_CancellationPreempter tests that the cancellation sent in another POST cancels the in-flight request
+func TestStreamable_CancellationPreempter(t *testing.T) {
+	q := NewQilin(t)
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	q.Tool("brew", (*struct{})(nil), func(c qilin.ToolContext) error {
+		close(started)
+		select {
+		case <-c.Context().Done():
+			close(canceled)
+		case <-time.After(5 * time.Second):
+		}
+		return c.String("brewed")
+	})
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err, "failed to create tcp listener")
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	ready := make(chan struct{}, 1)
+	go func() {
+		streamable := transport.NewStreamable(transport.StreamableWithNetListener(listener))
+		q.Start(
+			qilin.StartWithReadySignal(ready),
+			qilin.StartWithContext(ctx),
+			qilin.StartWithListener(streamable),
+			qilin.StartWithPreempter(qilin.CancellationPreempter()))
+	}()
+	<-ready
+
+	url := fmt.Sprintf("http://%s/mcp", listener.Addr())
+	post := func(sessionID string, req any) *http.Response {
+		reqBytes, err := json.Marshal(req)
+		require.NoError(t, err)
+		httpReq, err := http.NewRequest("POST", url, bytes.NewReader(reqBytes))
+		require.NoError(t, err)
+		httpReq.Header.Set("Content-Type", "application/json")
+		if sessionID != "" {
+			httpReq.Header.Set(transport.MCPSessionID, sessionID)
+		}
+		resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(httpReq)
+		require.NoError(t, err)
+		return resp
+	}
+	initResp := post("", NewJSONRPCRequest(t, qilin.MethodInitialize, map[string]any{
+		"protocolVersion": qilin.LatestProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo": map[string]any{
+			"name":    "test-client",
+			"version": "1.0.0",
+		},
+	}))
+	defer initResp.Body.Close()
+	sessionID := SessionIDFromResponse(t, initResp)
+
+	call := NewJSONRPCRequest(t, qilin.MethodToolsCall, map[string]any{
+		"name":      "brew",
+		"arguments": map[string]any{},
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp := post(sessionID, call)
+		_ = resp.Body.Close()
+	}()
+	<-started
+
+	cancelResp := post(sessionID, map[string]any{
+		"jsonrpc": qilin.JSONRPCVersion,
+		"method":  qilin.MethodNotificationCancelled,
+		"params": qilin.NotificationsCancelledRequestParams{
+			RequestID: call.ID,
+			Reason:    "the customer left",
+		},
+	})
+	defer cancelResp.Body.Close()
+	require.Equal(t, http.StatusAccepted, cancelResp.StatusCode)
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the in-flight request to be canceled")
+	}
+	<-done
+}
@@ -2,6 +2,7 @@ package transport_test
 
 import (
 	"net"
+	"time"
 
 	"github.com/miyamo2/qilin"
 	"github.com/miyamo2/qilin/transport"
@@ -121,3 +122,11 @@ func ExampleStreamableWithAccessControlAllowHeaders() {
 
 	q.Start(qilin.StartWithListener(streamable))
 }
+
+func ExampleStreamableWithSSERetry() {
+	streamable := transport.NewStreamable(transport.StreamableWithSSERetry(3 * time.Second))
+
+	q := qilin.New("example")
+
+	q.Start(qilin.StartWithListener(streamable))
+}
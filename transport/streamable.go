@@ -3,14 +3,18 @@ package transport
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	internaltransport "github.com/miyamo2/qilin/internal/transport"
@@ -34,10 +38,22 @@ type Streamable struct {
 	allowCORSMethods      string
 	allowCORSHeaders      string
 	errCh                 chan error
+	sseRetry              time.Duration
 	startOnce             sync.Once
 	mux                   *http.ServeMux
+	server                *http.Server
 	sessionDiscard        func(ctx context.Context, sessionID string) error
 	setSessionDiscardOnce sync.Once
+	// errorStatusCodes is the HTTP status codes of the responses, keyed by the JSON-RPC error code.
+	errorStatusCodes map[int64]int
+	// maxBodyBytes is the maximum size of the POST body. zero or less means no limit.
+	maxBodyBytes int64
+	// mounted reports whether the endpoint is registered on the http.ServeMux served by the caller,
+	// instead of serving its own http.Server.
+	mounted bool
+	// done is closed on closing the mounted Streamable.
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 var (
@@ -67,8 +83,11 @@ func (s *Streamable) Writer(rw io.Writer) jsonrpc2.Writer {
 // Accept See: jsonrpc2.Listener#Accept
 func (s *Streamable) Accept(ctx context.Context) (io.ReadWriteCloser, error) {
 	s.startOnce.Do(func() {
+		if s.mounted {
+			return
+		}
 		go func() {
-			s.errCh <- http.Serve(s.netListener, s.mux)
+			s.errCh <- s.server.Serve(s.netListener)
 		}()
 	})
 	select {
@@ -77,13 +96,23 @@ func (s *Streamable) Accept(ctx context.Context) (io.ReadWriteCloser, error) {
 		return nil, err
 	case <-ctx.Done():
 		return nil, ctx.Err()
+	case <-s.done:
+		return nil, net.ErrClosed
 	case rwc := <-s.rwc:
 		return internaltransport.NewQilinIO(rwc), nil
 	}
 }
 
 // Close See: jsonrpc2.Listener#Close
+//
+// If mounted on the http.ServeMux, it stops accepting the connections, but the http.ServeMux keeps being served by the caller.
 func (s *Streamable) Close() error {
+	if s.mounted {
+		s.closeOnce.Do(func() {
+			close(s.done)
+		})
+		return nil
+	}
 	defer close(s.rwc)
 	err := s.netListener.Close()
 	if err != nil {
@@ -124,29 +153,199 @@ func (s *Streamable) serveHTTP(w http.ResponseWriter, r *http.Request) {
 	s.cors(w, r)
 	w.Header().Set("content-type", "application/json; charset=utf-8")
 
-	err := s.authorizer.Authorize(r.Header.Get("authorization"))
+	select {
+	case <-s.done:
+		// the mounted Streamable is closed, while the http.ServeMux is still served.
+		http.Error(w, "the server is closed", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	credential := r.Header.Get("authorization")
+	err := s.authorizer.Authorize(credential)
 	if err != nil {
 		slog.ErrorContext(r.Context(), "[qilin] authorize failed", "error", err)
 		http.Error(w, "authorize failed", http.StatusUnauthorized)
 		return
 	}
+	var principal any
+	if pa, ok := s.authorizer.(PrincipalAuthorizer); ok {
+		principal, err = pa.Principal(credential)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "[qilin] resolve principal failed", "error", err)
+			http.Error(w, "authorize failed", http.StatusUnauthorized)
+			return
+		}
+	}
 
 	flusher := w.(http.Flusher)
 
+	eventStream := acceptsEventStream(r.Header.Get("accept"))
+	if r.Method == http.MethodGet && !eventStream {
+		http.Error(w, "the stream requires accepting text/event-stream", http.StatusNotAcceptable)
+		return
+	}
+
+	body := r.Body
+	var requests int
+	var accepted bool
+	if r.Method == http.MethodPost {
+		if s.maxBodyBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+		}
+		// the body may contain several messages, so read it ahead to count the requests.
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, "the request body is too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "failed to read the request body", http.StatusBadRequest)
+			return
+		}
+		body = io.NopCloser(bytes.NewReader(b))
+		var scan messageScan
+		scan, err = scanMessages(b)
+		if err != nil {
+			writeJSONRPCError(w, err)
+			return
+		}
+		requests = scan.requests
+		// the notifications and the responses are only accepted, with no response.
+		accepted = scan.messages > 0 && scan.messages == scan.notificationsOrResponses
+		if requests > 1 && !eventStream {
+			http.Error(w, "pipelined requests require accepting text/event-stream", http.StatusNotAcceptable)
+			return
+		}
+	}
+
 	ctx, cancel := context.WithCancel(r.Context())
 	rwc := &StreamableReadWriteCloser{
-		w:             w,
-		r:             r.Body,
-		flusher:       flusher,
-		requestHeader: r.Header,
-		ctx:           ctx,
-		cancel:        cancel,
+		w:                w,
+		r:                body,
+		flusher:          flusher,
+		requestHeader:    r.Header,
+		principal:        principal,
+		sseRetry:         s.sseRetry,
+		ctx:              ctx,
+		cancel:           cancel,
+		accepted:         accepted,
+		jsonOnly:         !eventStream,
+		errorStatusCodes: s.errorStatusCodes,
+		closed:           make(chan struct{}),
 	}
 	context.AfterFunc(ctx, func() {
 		_ = rwc.Close()
 	})
-	s.rwc <- rwc
-	<-ctx.Done()
+	if requests > 1 {
+		// the responses are written over the SSE stream, closed once all of them are written.
+		rwc.pendingResponses = requests
+		rwc.SwitchStreamConnection(pipelinedKeepAlive)
+	}
+	select {
+	case s.rwc <- rwc:
+	case <-s.done:
+		rwc.written.Store(true)
+		http.Error(w, "the server is closed", http.StatusServiceUnavailable)
+		cancel()
+	}
+	// the request context may end ahead of Close, such as on the disconnection, so wait for Close to finish writing.
+	<-rwc.closed
+}
+
+// defaultMaxBodyBytes is the maximum size of the POST body, unless StreamableWithMaxBodyBytes is set.
+const defaultMaxBodyBytes = 10 << 20
+
+// pipelinedKeepAlive is the keep-alive of the SSE stream the responses to the pipelined requests are written over.
+const pipelinedKeepAlive = 5 * time.Second
+
+// acceptsEventStream reports whether the Accept header allows the response over the SSE stream.
+// A missing Accept header allows any response.
+func acceptsEventStream(accept string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+	for v := range strings.SplitSeq(accept, ",") {
+		mediaType, _, _ := strings.Cut(v, ";")
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "text/event-stream", "text/*", "*/*":
+			return true
+		}
+	}
+	return false
+}
+
+// messageScan is the summary of the JSON-RPC messages in the body.
+type messageScan struct {
+	// messages is the number of the messages.
+	messages int
+	// requests is the number of the requests.
+	requests int
+	// notificationsOrResponses is the number of the notifications and the responses.
+	notificationsOrResponses int
+}
+
+// jsonrpcError is the JSON-RPC error answered before the body is passed to the connection.
+type jsonrpcError struct {
+	code    int64
+	message string
+}
+
+func (e *jsonrpcError) Error() string {
+	return e.message
+}
+
+// scanMessages scans the JSON-RPC messages in the body, which may contain several newline-delimited messages.
+// It fails with the parse error if the body is not valid JSON, and with the invalid request error
+// if a message is not a JSON object, such as a batch, which is not supported.
+func scanMessages(body []byte) (messageScan, error) {
+	var scan messageScan
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			return scan, nil
+		}
+		if err != nil {
+			return messageScan{}, &jsonrpcError{code: -32700, message: "parse error"}
+		}
+		var msg struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Result json.RawMessage `json:"result"`
+			Error  json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			return messageScan{}, &jsonrpcError{code: -32600, message: "invalid request: the message must be a JSON object"}
+		}
+		scan.messages++
+		switch {
+		case len(msg.ID) > 0 && msg.Method != "":
+			scan.requests++
+		case msg.Method != "", len(msg.ID) > 0 && (len(msg.Result) > 0 || len(msg.Error) > 0):
+			scan.notificationsOrResponses++
+		}
+	}
+}
+
+// writeJSONRPCError answers the error with 400 Bad Request.
+func writeJSONRPCError(w http.ResponseWriter, err error) {
+	var rpcErr *jsonrpcError
+	if !errors.As(err, &rpcErr) {
+		rpcErr = &jsonrpcError{code: -32603, message: err.Error()}
+	}
+	b, _ := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      nil,
+		"error": map[string]any{
+			"code":    rpcErr.code,
+			"message": rpcErr.message,
+		},
+	})
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = w.Write(b)
 }
 
 func (s *Streamable) deleteSession(w http.ResponseWriter, r *http.Request) {
@@ -182,6 +381,12 @@ type streamableOptions struct {
 	accessControlAllowOriginMethods []string
 	accessControlAllowOriginHeaders []string
 	authorizer                      Authorizer
+	sseRetry                        time.Duration
+	httpServer                      *http.Server
+	path                            string
+	serveMux                        *http.ServeMux
+	errorStatusCodes                map[int64]int
+	maxBodyBytes                    int64
 }
 
 // StreamableOption configures the Streamable transport.
@@ -234,6 +439,92 @@ func StreamableWithAuthorizer(authorizer Authorizer) StreamableOption {
 	}
 }
 
+// StreamableWithSSERetry settings the reconnection time sent to the client as the SSE `retry` field.
+//
+// If not set, the `retry` field is not sent and the client uses its default reconnection time.
+func StreamableWithSSERetry(retry time.Duration) StreamableOption {
+	return func(s *streamableOptions) {
+		s.sseRetry = retry
+	}
+}
+
+// StreamableWithHTTPServer settings the http.Server to serve on,
+// so that the timeouts and the limits such as `ReadHeaderTimeout`, `IdleTimeout` and `MaxHeaderBytes` can be configured.
+//
+// The Handler of the server is replaced with the one of the Streamable transport.
+//
+//	transport.NewStreamable(transport.StreamableWithHTTPServer(&http.Server{
+//		ReadHeaderTimeout: 5 * time.Second,
+//		IdleTimeout:       time.Minute,
+//		MaxHeaderBytes:    1 << 16,
+//	}))
+func StreamableWithHTTPServer(server *http.Server) StreamableOption {
+	return func(s *streamableOptions) {
+		s.httpServer = server
+	}
+}
+
+// StreamableWithPath settings the path of the endpoint.
+//
+// If not set, it defaults to "/mcp".
+func StreamableWithPath(path string) StreamableOption {
+	return func(s *streamableOptions) {
+		s.path = path
+	}
+}
+
+// StreamableWithServeMux registers the endpoint on the given http.ServeMux, instead of serving its own http.Server,
+// so that several Qilin instances can be served on one HTTP server, each at its own path set by StreamableWithPath.
+//
+// The http.ServeMux must be served by the caller, and the address, the net.Listener and the http.Server options are ignored.
+//
+//	mux := http.NewServeMux()
+//	beer := transport.NewStreamable(transport.StreamableWithServeMux(mux), transport.StreamableWithPath("/beer/mcp"))
+//	wine := transport.NewStreamable(transport.StreamableWithServeMux(mux), transport.StreamableWithPath("/wine/mcp"))
+//	go beerQilin.Start(qilin.StartWithListener(beer))
+//	go wineQilin.Start(qilin.StartWithListener(wine))
+//	http.ListenAndServe(":3001", mux)
+func StreamableWithServeMux(mux *http.ServeMux) StreamableOption {
+	return func(s *streamableOptions) {
+		s.serveMux = mux
+	}
+}
+
+// DefaultErrorStatusCodes is the HTTP status codes of the standard JSON-RPC error codes,
+// to be passed to StreamableWithErrorStatusCodes.
+var DefaultErrorStatusCodes = map[int64]int{
+	-32700: http.StatusBadRequest,          // parse error
+	-32600: http.StatusBadRequest,          // invalid request
+	-32601: http.StatusNotFound,            // method not found
+	-32602: http.StatusBadRequest,          // invalid params
+	-32603: http.StatusInternalServerError, // internal error
+}
+
+// StreamableWithErrorStatusCodes settings the HTTP status codes of the JSON-RPC error responses, keyed by the JSON-RPC error code,
+// for the gateways routing on the status. The body is still the JSON-RPC error.
+//
+// If not set, or the error code is not in the map, the error responses are sent with 200 OK.
+// The responses over the SSE stream are always sent with 200 OK.
+//
+// The map is copied, so changing it afterward does not affect the transport.
+//
+//	transport.NewStreamable(transport.StreamableWithErrorStatusCodes(transport.DefaultErrorStatusCodes))
+func StreamableWithErrorStatusCodes(statusCodes map[int64]int) StreamableOption {
+	return func(s *streamableOptions) {
+		s.errorStatusCodes = maps.Clone(statusCodes)
+	}
+}
+
+// StreamableWithMaxBodyBytes settings the maximum size of the POST body in bytes.
+// The requests with the larger body are rejected with 413 Request Entity Too Large.
+//
+// If not set, it defaults to 10 MiB. Zero or less means no limit.
+func StreamableWithMaxBodyBytes(n int64) StreamableOption {
+	return func(s *streamableOptions) {
+		s.maxBodyBytes = n
+	}
+}
+
 // NewStreamable creates new Streamable transport.
 func NewStreamable(options ...StreamableOption) *Streamable {
 	opts := &streamableOptions{
@@ -242,11 +533,13 @@ func NewStreamable(options ...StreamableOption) *Streamable {
 		accessControlAllowOriginMethods: defaultAccessControlAllowMethods,
 		accessControlAllowOriginHeaders: defaultAccessControlAllowHeaders,
 		authorizer:                      DefaultAuthorizer(),
+		path:                            "/mcp",
+		maxBodyBytes:                    defaultMaxBodyBytes,
 	}
 	for _, opt := range options {
 		opt(opts)
 	}
-	if opts.netListener == nil {
+	if opts.netListener == nil && opts.serveMux == nil {
 		var err error
 		opts.netListener, err = net.Listen("tcp", opts.address)
 		if err != nil {
@@ -263,13 +556,31 @@ func NewStreamable(options ...StreamableOption) *Streamable {
 		errCh:            make(chan error, 1),
 		framer:           newStreamableFramer(),
 		authorizer:       opts.authorizer,
+		sseRetry:         opts.sseRetry,
+		errorStatusCodes: opts.errorStatusCodes,
+		maxBodyBytes:     opts.maxBodyBytes,
+		done:             make(chan struct{}),
 	}
 
-	s.mux = http.NewServeMux()
-	s.mux.HandleFunc("OPTIONS /mcp", s.preflight)
-	s.mux.HandleFunc("GET /mcp", s.serveHTTP)
-	s.mux.HandleFunc("POST /mcp", s.serveHTTP)
-	s.mux.HandleFunc("DELETE /mcp", s.deleteSession)
+	s.mux = opts.serveMux
+	if s.mux == nil {
+		s.mux = http.NewServeMux()
+	}
+	s.mux.HandleFunc("OPTIONS "+opts.path, s.preflight)
+	s.mux.HandleFunc("GET "+opts.path, s.serveHTTP)
+	s.mux.HandleFunc("POST "+opts.path, s.serveHTTP)
+	s.mux.HandleFunc("DELETE "+opts.path, s.deleteSession)
+
+	if opts.serveMux != nil {
+		s.mounted = true
+		return s
+	}
+
+	s.server = opts.httpServer
+	if s.server == nil {
+		s.server = &http.Server{}
+	}
+	s.server.Handler = s.mux
 
 	return s
 }
@@ -279,6 +590,7 @@ func NewStreamable(options ...StreamableOption) *Streamable {
 // WARNING: Do not implement this interface.
 type HttpIO interface {
 	SwitchStreamConnection(keepAlive time.Duration)
+	SwitchStreamResponse(keepAlive time.Duration)
 	Probe() error
 	io.ReadWriteCloser
 	SessionIDHolder
@@ -297,14 +609,35 @@ type StreamableReadWriteCloser struct {
 	flusher       http.Flusher
 	r             io.ReadCloser
 	requestHeader http.Header
+	principal     any
+	sseRetry      time.Duration
 	ctx           context.Context
 	cancel        context.CancelFunc
 	sse           bool
-	closeOnce     sync.Once
+	// pendingResponses is the number of the responses to write before closing the stream connection.
+	// zero means the stream connection is not closed by the responses.
+	pendingResponses int
+	// accepted responds 202 Accepted with no body on closing, unless anything is written.
+	// it is set for the POST requests carrying only the notifications and the responses.
+	accepted bool
+	// written reports whether the status or the body has been written.
+	written atomic.Bool
+	// jsonOnly reports whether the client accepts only the JSON response.
+	// if true, the connection is never switched to the stream.
+	jsonOnly bool
+	// errorStatusCodes is the HTTP status codes of the plain error responses, keyed by the JSON-RPC error code.
+	errorStatusCodes map[int64]int
+	// mu serializes the writes of the messages, the probes and the headers.
+	mu        sync.Mutex
+	closeOnce sync.Once
+	// closed is closed once Close returns, so that serveHTTP does not finish the http.ResponseWriter ahead of it.
+	// nil if not waited.
+	closed chan struct{}
 }
 
 const (
-	sseMessage = "event: message\ndata: %s\n\n"
+	sseMessage      = "event: message\ndata: %s\n\n"
+	sseRetryMessage = "retry: %d\n\n"
 )
 
 // Read See: io.ReadWriteCloser#Read
@@ -320,46 +653,80 @@ func (s *StreamableReadWriteCloser) Read(p []byte) (n int, err error) {
 
 // Write See: io.ReadWriteCloser#Write
 func (s *StreamableReadWriteCloser) Write(p []byte) (n int, err error) {
-	select {
-	case <-s.ctx.Done():
-		return 0, io.EOF
-	default:
-		// no-op
+	n, closing, err := s.write(p)
+	if closing {
+		_ = s.Close()
 	}
+	return n, err
+}
+
+// write writes the message, and reports whether the connection is to be closed after it.
+func (s *StreamableReadWriteCloser) write(p []byte) (n int, closing bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// checked under the lock, as Close cancels the context under the lock.
+	if s.ctx.Err() != nil {
+		return 0, false, io.EOF
+	}
+	s.written.Store(true)
 	switch {
 	case s.sse:
-		n, err = fmt.Fprintf(s.w, sseMessage, p)
+		if s.pendingResponses > 0 && isResponse(p) {
+			s.pendingResponses--
+			closing = s.pendingResponses == 0
+		}
+		// multi-line data must be split into multiple data fields.
+		data := bytes.ReplaceAll(bytes.TrimRight(p, "\n"), []byte("\n"), []byte("\ndata: "))
+		_, err = fmt.Fprintf(s.w, sseMessage, data)
 		if err != nil {
-			return 0, err
+			return 0, closing, err
 		}
+		n = len(p)
 	default:
-		defer func() {
-			_ = s.Close()
-		}()
+		closing = true
+		s.writeErrorHeader(p)
 		n, err = s.w.Write(p)
 		if err != nil {
-			return 0, err
+			return 0, closing, err
 		}
 	}
 	s.flusher.Flush()
-	return
+	return n, closing, nil
 }
 
 // Close See: io.ReadWriteCloser#Close
 func (s *StreamableReadWriteCloser) Close() error {
 	var err error
 	s.closeOnce.Do(func() {
-		_ = s.r.Close()
-		s.cancel()
-		s.flusher.Flush()
+		if s.closed != nil {
+			defer close(s.closed)
+		}
 		err = s.r.Close()
-		if err != nil {
-			return
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// write ahead of canceling, since serveHTTP returns and finishes the http.ResponseWriter once canceled.
+		if s.accepted && !s.written.Swap(true) {
+			s.w.Header().Del("content-type")
+			s.w.WriteHeader(http.StatusAccepted)
 		}
+		s.flusher.Flush()
+		s.cancel()
 	})
 	return err
 }
 
+// Accepted reports whether the request carries only the notifications and the responses.
+// It is answered with 202 Accepted and no body on closing, unless anything else is written.
+func (s *StreamableReadWriteCloser) Accepted() bool {
+	return s.accepted
+}
+
+// AcceptsEventStream reports whether the client accepts the response over the SSE stream by the Accept header.
+// If not, SwitchStreamConnection and SwitchStreamResponse are no-op.
+func (s *StreamableReadWriteCloser) AcceptsEventStream() bool {
+	return !s.jsonOnly
+}
+
 // SessionID See: SessionIDHolder#SessionID
 func (s *StreamableReadWriteCloser) SessionID() string {
 	return s.requestHeader.Get(MCPSessionID)
@@ -370,14 +737,34 @@ func (s *StreamableReadWriteCloser) SetSessionID(sessionID string) {
 	s.w.Header().Set(MCPSessionID, sessionID)
 }
 
+// RequestHeader returns the header of the HTTP request.
+func (s *StreamableReadWriteCloser) RequestHeader() http.Header {
+	return s.requestHeader
+}
+
+// Principal returns the principal resolved by the PrincipalAuthorizer. nil if not resolved.
+func (s *StreamableReadWriteCloser) Principal() any {
+	return s.principal
+}
+
 // SwitchStreamConnection marks the StreamableReadWriteCloser as a streamable connection.
+// It is a no-op if already switched, or if the client does not accept the SSE stream.
 func (s *StreamableReadWriteCloser) SwitchStreamConnection(keepAlive time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sse || s.jsonOnly {
+		return
+	}
 	s.sse = true
+	s.written.Store(true)
 	s.w.Header().Set("content-type", "text/event-stream; charset=utf-8")
 	s.w.Header().Set("cache-control", "no-cache")
 	s.w.Header().Set("connection", "keep-alive")
 	s.w.Header().Set("keep-alive", fmt.Sprintf("timeout=%d", int(keepAlive.Seconds())))
-	_ = s.Probe()
+	if s.sseRetry > 0 {
+		_, _ = fmt.Fprintf(s.w, sseRetryMessage, s.sseRetry.Milliseconds())
+	}
+	_ = s.probe()
 	go func() {
 		ticker := time.NewTicker(time.Duration(float64(keepAlive) * 0.8))
 		defer ticker.Stop()
@@ -392,8 +779,69 @@ func (s *StreamableReadWriteCloser) SwitchStreamConnection(keepAlive time.Durati
 	}()
 }
 
+// SwitchStreamResponse marks the StreamableReadWriteCloser as a streamable connection closed once the response is written,
+// so that the notifications tied to the request are sent ahead of the response.
+func (s *StreamableReadWriteCloser) SwitchStreamResponse(keepAlive time.Duration) {
+	s.mu.Lock()
+	if s.jsonOnly {
+		s.mu.Unlock()
+		return
+	}
+	if s.pendingResponses == 0 {
+		s.pendingResponses = 1
+	}
+	s.mu.Unlock()
+	s.SwitchStreamConnection(keepAlive)
+}
+
+// writeErrorHeader writes the headers of the plain error response,
+// such as the HTTP status code mapped to the JSON-RPC error code and the `Retry-After` header.
+func (s *StreamableReadWriteCloser) writeErrorHeader(p []byte) {
+	if !bytes.Contains(p, []byte(`"error"`)) {
+		return
+	}
+	var res struct {
+		Error *struct {
+			Code int64           `json:"code"`
+			Data json.RawMessage `json:"data"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(p, &res); err != nil || res.Error == nil {
+		return
+	}
+	var data struct {
+		RetryAfter int64 `json:"retryAfter"`
+	}
+	if err := json.Unmarshal(res.Error.Data, &data); err == nil && data.RetryAfter > 0 {
+		s.w.Header().Set("retry-after", strconv.FormatInt(data.RetryAfter, 10))
+	}
+	if status, ok := s.errorStatusCodes[res.Error.Code]; ok {
+		s.w.WriteHeader(status)
+	}
+}
+
+// isResponse reports whether the message is a JSON-RPC response.
+func isResponse(p []byte) bool {
+	msg, err := jsonrpc2.DecodeMessage(p)
+	if err != nil {
+		return false
+	}
+	_, ok := msg.(*jsonrpc2.Response)
+	return ok
+}
+
 // Probe sends a comment message to the client to keep the connection alive.
 func (s *StreamableReadWriteCloser) Probe() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return io.EOF
+	}
+	return s.probe()
+}
+
+// probe sends a comment message without locking.
+func (s *StreamableReadWriteCloser) probe() error {
 	if _, err := s.w.Write([]byte(`:\n\n`)); err != nil {
 		return fmt.Errorf("failed to write probe: %w", err)
 	}
@@ -412,10 +860,12 @@ func (s *StreamableReadWriteCloser) Context() context.Context {
 func (s *StreamableReadWriteCloser) NoticeError(err error) {
 	switch {
 	case errors.Is(err, ErrMissingSessionID):
+		s.written.Store(true)
 		s.w.WriteHeader(http.StatusBadRequest)
 		_, _ = s.w.Write([]byte("missing session id"))
 		s.flusher.Flush()
 	case errors.Is(err, ErrSessionNotFound):
+		s.written.Store(true)
 		s.w.WriteHeader(http.StatusNotFound)
 		_, _ = s.w.Write([]byte("session not found"))
 		s.flusher.Flush()
@@ -0,0 +1,393 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamableReadWriteCloser_SwitchStreamConnection(t *testing.T) {
+	type test struct {
+		sseRetry time.Duration
+		expect   string
+		count    int
+	}
+	tests := map[string]test{
+		"with retry": {
+			sseRetry: 3 * time.Second,
+			expect:   "retry: 3000\n\n",
+			count:    1,
+		},
+		"without retry": {},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			ctx, cancel := context.WithCancel(t.Context())
+			defer cancel()
+			rwc := &StreamableReadWriteCloser{
+				w:        recorder,
+				flusher:  recorder,
+				sseRetry: tc.sseRetry,
+				ctx:      ctx,
+				cancel:   cancel,
+			}
+			rwc.SwitchStreamConnection(time.Minute)
+			body := recorder.Body.String()
+			if !strings.HasPrefix(body, tc.expect) {
+				t.Fatalf("expected %q to start with %q", body, tc.expect)
+			}
+			if got := strings.Count(body, "retry:"); got != tc.count {
+				t.Fatalf("expected retry to be sent %d times, got %d", tc.count, got)
+			}
+		})
+	}
+}
+
+func TestStreamableReadWriteCloser_SwitchStreamResponse(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	rwc := &StreamableReadWriteCloser{
+		w:       recorder,
+		flusher: recorder,
+		r:       io.NopCloser(strings.NewReader("")),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	rwc.SwitchStreamResponse(time.Minute)
+	if _, err := rwc.Write([]byte(`{"jsonrpc":"2.0","method":"notifications/tools/stream","params":{}}`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("expected the stream to be kept open after the notification")
+	}
+	if _, err := rwc.Write([]byte(`{"jsonrpc":"2.0","id":"1","result":{}}`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Fatal("expected the stream to be closed after the response")
+	}
+	if got := strings.Count(recorder.Body.String(), "event: message"); got != 2 {
+		t.Fatalf("expected 2 messages, got %d: %q", got, recorder.Body.String())
+	}
+}
+
+func TestScanMessages(t *testing.T) {
+	type test struct {
+		body       string
+		expect     messageScan
+		expectCode int64
+	}
+	tests := map[string]test{
+		"single request": {
+			body:   `{"jsonrpc":"2.0","id":"1","method":"ping"}`,
+			expect: messageScan{messages: 1, requests: 1},
+		},
+		"newline-delimited requests": {
+			body:   "{\"jsonrpc\":\"2.0\",\"id\":\"1\",\"method\":\"ping\"}\n{\"jsonrpc\":\"2.0\",\"id\":2,\"method\":\"tools/list\"}\n",
+			expect: messageScan{messages: 2, requests: 2},
+		},
+		"notifications and responses": {
+			body:   `{"jsonrpc":"2.0","method":"notifications/initialized"}{"jsonrpc":"2.0","id":"qilin-1","result":{}}{"jsonrpc":"2.0","id":"1","method":"ping"}`,
+			expect: messageScan{messages: 3, requests: 1, notificationsOrResponses: 2},
+		},
+		"neither request, notification nor response": {
+			body:   `{"jsonrpc":"2.0","id":"1"}`,
+			expect: messageScan{messages: 1},
+		},
+		"malformed tail": {
+			body:       `{"jsonrpc":"2.0","id":"1","method":"ping"}{"jsonrpc"`,
+			expectCode: -32700,
+		},
+		"batch": {
+			body:       `[{"jsonrpc":"2.0","id":"1","method":"ping"}]`,
+			expectCode: -32600,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := scanMessages([]byte(tc.body))
+			if tc.expectCode != 0 {
+				var rpcErr *jsonrpcError
+				if !errors.As(err, &rpcErr) || rpcErr.code != tc.expectCode {
+					t.Fatalf("expected the error %d, got %v", tc.expectCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.expect {
+				t.Fatalf("expected %+v, got %+v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestStreamable_malformedBody(t *testing.T) {
+	type test struct {
+		body       string
+		expectCode int64
+	}
+	tests := map[string]test{
+		"invalid json": {
+			body:       `{bad json`,
+			expectCode: -32700,
+		},
+		"batch": {
+			body:       `[{"jsonrpc":"2.0","id":"1","method":"initialize","params":{}}]`,
+			expectCode: -32600,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			s := NewStreamable(StreamableWithServeMux(mux))
+			defer s.Close()
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/mcp", strings.NewReader(tc.body))
+			mux.ServeHTTP(recorder, req)
+			if recorder.Code != http.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", http.StatusBadRequest, recorder.Code)
+			}
+			var res struct {
+				Error struct {
+					Code int64 `json:"code"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &res); err != nil {
+				t.Fatalf("unexpected error: %v: %s", err, recorder.Body.String())
+			}
+			if res.Error.Code != tc.expectCode {
+				t.Fatalf("expected %d, got %d", tc.expectCode, res.Error.Code)
+			}
+		})
+	}
+}
+
+func TestStreamableWithHTTPServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := NewStreamable(
+		StreamableWithNetListener(listener),
+		StreamableWithHTTPServer(&http.Server{ReadHeaderTimeout: 100 * time.Millisecond}),
+	)
+	defer s.Close()
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go s.Accept(ctx)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	// send the request line without completing the header
+	if _, err := conn.Write([]byte("POST /mcp HTTP/1.1\r\nHost: localhost\r\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	start := time.Now()
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("expected the connection to be closed by the server, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the connection to be closed within the ReadHeaderTimeout, took %v", elapsed)
+	}
+}
+
+func TestAcceptsEventStream(t *testing.T) {
+	type test struct {
+		accept string
+		expect bool
+	}
+	tests := map[string]test{
+		"empty":                    {accept: "", expect: true},
+		"json only":                {accept: "application/json", expect: false},
+		"json and event stream":    {accept: "application/json, text/event-stream", expect: true},
+		"event stream with params": {accept: "application/json, Text/Event-Stream;q=0.9", expect: true},
+		"wildcard":                 {accept: "*/*", expect: true},
+		"text wildcard":            {accept: "text/*", expect: true},
+		"json with charset":        {accept: "application/json; charset=utf-8", expect: false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := acceptsEventStream(tc.accept); got != tc.expect {
+				t.Fatalf("expected %t, got %t", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestStreamableReadWriteCloser_errorStatusCodes(t *testing.T) {
+	type test struct {
+		statusCodes map[int64]int
+		message     string
+		expect      int
+	}
+	tests := map[string]test{
+		"method not found": {
+			statusCodes: DefaultErrorStatusCodes,
+			message:     `{"jsonrpc":"2.0","id":"1","error":{"code":-32601,"message":"method not found"}}`,
+			expect:      http.StatusNotFound,
+		},
+		"invalid params": {
+			statusCodes: DefaultErrorStatusCodes,
+			message:     `{"jsonrpc":"2.0","id":"1","error":{"code":-32602,"message":"invalid params"}}`,
+			expect:      http.StatusBadRequest,
+		},
+		"internal error": {
+			statusCodes: DefaultErrorStatusCodes,
+			message:     `{"jsonrpc":"2.0","id":"1","error":{"code":-32603,"message":"internal error"}}`,
+			expect:      http.StatusInternalServerError,
+		},
+		"unmapped error code": {
+			statusCodes: DefaultErrorStatusCodes,
+			message:     `{"jsonrpc":"2.0","id":"1","error":{"code":-32002,"message":"resource not found"}}`,
+			expect:      http.StatusOK,
+		},
+		"result": {
+			statusCodes: DefaultErrorStatusCodes,
+			message:     `{"jsonrpc":"2.0","id":"1","result":{}}`,
+			expect:      http.StatusOK,
+		},
+		"without status codes": {
+			message: `{"jsonrpc":"2.0","id":"1","error":{"code":-32601,"message":"method not found"}}`,
+			expect:  http.StatusOK,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			ctx, cancel := context.WithCancel(t.Context())
+			defer cancel()
+			rwc := &StreamableReadWriteCloser{
+				w:                recorder,
+				flusher:          recorder,
+				r:                io.NopCloser(strings.NewReader("")),
+				ctx:              ctx,
+				cancel:           cancel,
+				errorStatusCodes: tc.statusCodes,
+			}
+			if _, err := rwc.Write([]byte(tc.message)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if recorder.Code != tc.expect {
+				t.Fatalf("expected %d, got %d", tc.expect, recorder.Code)
+			}
+			if got := recorder.Body.String(); got != tc.message {
+				t.Fatalf("expected the body %s, got %s", tc.message, got)
+			}
+		})
+	}
+}
+
+func TestStreamableWithMaxBodyBytes(t *testing.T) {
+	t.Run("within the limit", func(t *testing.T) {
+		mux := http.NewServeMux()
+		s := NewStreamable(StreamableWithServeMux(mux), StreamableWithMaxBodyBytes(64))
+		defer s.Close()
+		body := `{"jsonrpc":"2.0","method":"notifications/initialized"}`
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/mcp", strings.NewReader(body))
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			mux.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+		rwc, err := s.Accept(t.Context())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b, err := io.ReadAll(rwc)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(b) != body {
+			t.Fatalf("expected %s, got %s", body, b)
+		}
+		_ = rwc.Close()
+		<-done
+	})
+	t.Run("over the limit", func(t *testing.T) {
+		mux := http.NewServeMux()
+		s := NewStreamable(StreamableWithServeMux(mux), StreamableWithMaxBodyBytes(64))
+		defer s.Close()
+		body := `{"jsonrpc":"2.0","method":"notifications/initialized","params":{"padding":"` + strings.Repeat("x", 64) + `"}}`
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/mcp", strings.NewReader(body))
+		mux.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("expected %d, got %d", http.StatusRequestEntityTooLarge, recorder.Code)
+		}
+	})
+}
+
+// cancelObservingRecorder records whether the context is canceled when the status is written.
+type cancelObservingRecorder struct {
+	*httptest.ResponseRecorder
+	ctx             context.Context
+	canceledOnWrite bool
+}
+
+func (r *cancelObservingRecorder) WriteHeader(code int) {
+	r.canceledOnWrite = r.ctx.Err() != nil
+	r.ResponseRecorder.WriteHeader(code)
+}
+
+func TestStreamableReadWriteCloser_Close_accepted(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	recorder := &cancelObservingRecorder{ResponseRecorder: httptest.NewRecorder(), ctx: ctx}
+	rwc := &StreamableReadWriteCloser{
+		w:        recorder,
+		flusher:  recorder,
+		r:        io.NopCloser(strings.NewReader("")),
+		ctx:      ctx,
+		cancel:   cancel,
+		accepted: true,
+		closed:   make(chan struct{}),
+	}
+	if err := rwc.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("expected %d, got %d", http.StatusAccepted, recorder.Code)
+	}
+	if recorder.canceledOnWrite {
+		t.Fatal("expected 202 Accepted to be written before canceling the request")
+	}
+	select {
+	case <-rwc.closed:
+	default:
+		t.Fatal("expected closed to be closed once Close returns")
+	}
+	if _, err := rwc.Write([]byte(`{"jsonrpc":"2.0","id":"1","result":{}}`)); err != io.EOF {
+		t.Fatalf("expected io.EOF after closing, got %v", err)
+	}
+}
+
+func TestStreamableWithErrorStatusCodes(t *testing.T) {
+	statusCodes := map[int64]int{-32601: http.StatusNotFound}
+	opts := &streamableOptions{}
+	StreamableWithErrorStatusCodes(statusCodes)(opts)
+	statusCodes[-32601] = http.StatusTeapot
+	statusCodes[-32602] = http.StatusTeapot
+	if got := opts.errorStatusCodes[-32601]; got != http.StatusNotFound {
+		t.Fatalf("expected %d, got %d", http.StatusNotFound, got)
+	}
+	if _, ok := opts.errorStatusCodes[-32602]; ok {
+		t.Fatal("expected the change of the map not to affect the option")
+	}
+}
@@ -37,6 +37,19 @@ type Authorizer interface {
 	Authorize(credential string) error
 }
 
+// PrincipalAuthorizer is an Authorizer that also resolves the principal of the credential.
+//
+// The principal is available in the handlers via Context.Principal.
+type PrincipalAuthorizer interface {
+	Authorizer
+	// Principal returns the principal of the authorized credential.
+	//
+	//   ## params
+	//
+	//   - credential: Representing the credential to authorize. Provided from the authorization header.
+	Principal(credential string) (any, error)
+}
+
 // compatibility check
 var _ Authorizer = (*noopAuthorizer)(nil)
 
@@ -2,7 +2,11 @@ package qilin
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
 	"weak"
 
 	"github.com/invopop/jsonschema"
@@ -80,6 +84,10 @@ const (
 	// https://modelcontextprotocol.io/specification/2025-03-26/server/resources#subscriptions
 	MethodNotificationResourceUpdated = "notifications/resources/updated"
 
+	// MethodNotificationCancelled Notifies that a previously-issued request is cancelled.
+	// https://modelcontextprotocol.io/specification/2025-03-26/basic/utilities/cancellation
+	MethodNotificationCancelled = "notifications/cancelled"
+
 	// NOT PLAN:
 	// MethodNotificationPromptsListChanged Notifies when the list of available prompt templates changes.
 	// https://modelcontextprotocol.io/specification/2025-03-26/server/prompts#list-changed-notification
@@ -90,6 +98,18 @@ const (
 	// https://spec.modelcontextprotocol.io/specification/2024-11-05/server/tools/list_changed/
 	// MethodNotificationToolsListChanged = "notifications/tools/list_changed"
 
+	// MethodNotificationToolsStream Sends a chunk of the result of a tool ahead of the final result.
+	// It is a qilin extension to MCP.
+	MethodNotificationToolsStream = "notifications/tools/stream"
+
+	// MethodNotificationProgress Notifies the progress of a long-running request.
+	// https://modelcontextprotocol.io/specification/2025-03-26/basic/utilities/progress
+	MethodNotificationProgress = "notifications/progress"
+
+	// MethodElicitationCreate Requests additional information from the user via the client.
+	// https://modelcontextprotocol.io/specification/2025-06-18/client/elicitation
+	MethodElicitationCreate = "elicitation/create"
+
 	// MethodCompletionComplete Completes a prompt template with provided parameters.
 	MethodCompletionComplete = "completion/complete"
 
@@ -157,14 +177,20 @@ type initializeRequestParams struct {
 	// The client MAY decide to support older versions as well.
 	ProtocolVersion string `json:"protocolVersion"`
 
-	Capabilities clientCapabilities `json:"capabilities"`
+	Capabilities ClientCapabilities `json:"capabilities"`
 
 	ClientInfo implementation `json:"clientInfo"`
+
+	// Meta is the metadata of the request.
+	Meta struct {
+		// Locale is the locale of the client for the whole session, such as "ja-JP".
+		Locale string `json:"locale,omitzero"`
+	} `json:"_meta,omitzero"`
 }
 
-// clientCapabilities is a set of capabilities a client may support. Known capabilities are defined here, in this schema,
+// ClientCapabilities is a set of capabilities a client may support. Known capabilities are defined here, in this schema,
 // but this is not a closed set: any client can define its own, additional capabilities.
-type clientCapabilities struct {
+type ClientCapabilities struct {
 	// Experimental is non-standard capabilities that the client supports.
 	Experimental map[string]any `json:"experimental,omitzero"`
 
@@ -173,6 +199,9 @@ type clientCapabilities struct {
 
 	// Roots presents if the client supports listing roots.
 	Roots *RootsCapability `json:"roots,omitzero"`
+
+	// Elicitation presents if the client supports elicitation from the user.
+	Elicitation map[string]any `json:"elicitation,omitzero"`
 }
 
 // RootsCapability represents the client's capability to support roots features.
@@ -188,6 +217,12 @@ type callToolRequestParams struct {
 
 	// Arguments contains the arguments to use for the Tool.
 	Arguments json.RawMessage `json:"arguments,omitempty"`
+
+	// Meta is the metadata of the request.
+	Meta struct {
+		// ProgressToken is the token to tie the progress notifications to the request.
+		ProgressToken json.RawMessage `json:"progressToken,omitempty"`
+	} `json:"_meta,omitzero"`
 }
 
 // NotificationsCancelledRequestParams is sent by either side to indicate that it is cancelling a previously-issued request.
@@ -270,7 +305,71 @@ var (
 )
 
 // ResourceURI indicates a URI to a resource or sub-resource.
-type ResourceURI url.URL
+//
+// It keeps the original string it was parsed from and marshals it verbatim,
+// so the URI a client sees is exactly the one registered or sent.
+type ResourceURI struct {
+	uri *url.URL
+	raw string
+}
+
+// ParseResourceURI parses the raw URI into a ResourceURI.
+//
+// It returns ErrInvalidResourceURI if the URI has no scheme or host.
+func ParseResourceURI(raw string) (*ResourceURI, error) {
+	uri, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if uri.Scheme == "" || uri.Host == "" {
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidResourceURI, raw)
+	}
+	return &ResourceURI{uri: uri, raw: raw}, nil
+}
+
+// ResourceURIFromURL converts the url.URL into a ResourceURI. The returned value shares the underlying url.URL.
+func ResourceURIFromURL(uri *url.URL) *ResourceURI {
+	return &ResourceURI{uri: uri}
+}
+
+// resourceURIFromRaw returns a ResourceURI of the parsed uri keeping its raw form.
+func resourceURIFromRaw(uri *url.URL, raw string) *ResourceURI {
+	return &ResourceURI{uri: uri, raw: raw}
+}
+
+// URL returns the ResourceURI as url.URL. The returned value shares the underlying ResourceURI.
+func (r *ResourceURI) URL() *url.URL {
+	if r == nil {
+		return nil
+	}
+	return r.uri
+}
+
+// clone returns a copy of the ResourceURI, not sharing the underlying url.URL.
+func (r *ResourceURI) clone() *ResourceURI {
+	if r == nil {
+		return nil
+	}
+	clone := *r
+	if r.uri != nil {
+		uri := *r.uri
+		clone.uri = &uri
+	}
+	return &clone
+}
+
+// String returns the original string of the ResourceURI if any, otherwise the string of the url.URL.
+func (r *ResourceURI) String() string {
+	switch {
+	case r == nil:
+		return ""
+	case r.raw != "":
+		return r.raw
+	case r.uri != nil:
+		return r.uri.String()
+	}
+	return ""
+}
 
 func (r *ResourceURI) UnmarshalJSON(bytes []byte) error {
 	var raw string
@@ -281,13 +380,12 @@ func (r *ResourceURI) UnmarshalJSON(bytes []byte) error {
 	if err != nil {
 		return err
 	}
-	*r = ResourceURI(*uri)
+	*r = ResourceURI{uri: uri, raw: raw}
 	return nil
 }
 
 func (r ResourceURI) MarshalJSON() ([]byte, error) {
-	uri := url.URL(r)
-	return json.Marshal(uri.String())
+	return json.Marshal(r.String())
 }
 
 type ResourceContent interface {
@@ -303,8 +401,75 @@ type resourceContentBase struct {
 	// uri of this resource.
 	uri weak.Pointer[url.URL]
 
+	// strongURI of this resource. takes precedence over uri.
+	//
+	// Embedded resources hold the URI strongly, since the caller's reference may be dropped before marshaling.
+	strongURI *url.URL
+
+	// name of the registered resource, if known.
+	name string
+
+	// description of the registered resource, if known.
+	description string
+
 	// MIME type of this resource, if known.
 	mimeType string
+
+	// lastModified is the time this resource was last modified. zero if unknown.
+	lastModified time.Time
+
+	// checksum of the raw bytes of this resource. nil if not computed.
+	checksum *contentChecksum
+}
+
+// contentChecksum is the checksum of the raw bytes of a resource content.
+type contentChecksum struct {
+	// Algorithm of the checksum, such as "sha256".
+	Algorithm string `json:"algorithm"`
+
+	// Value of the checksum.
+	Value string `json:"value"`
+}
+
+// newEmbedResourceContentBase creates a resourceContentBase that holds a copy of the URI.
+func newEmbedResourceContentBase(uri *url.URL, mimeType string) resourceContentBase {
+	base := resourceContentBase{
+		mimeType: mimeType,
+	}
+	if uri != nil {
+		clone := *uri
+		base.strongURI = &clone
+	}
+	return base
+}
+
+// resourceURI returns the URI of this resource.
+func (b resourceContentBase) resourceURI() *url.URL {
+	if b.strongURI != nil {
+		return b.strongURI
+	}
+	return b.uri.Value()
+}
+
+// withStrongURI returns the base holding the URI strongly. uri is used if the base lost its URI.
+func (b resourceContentBase) withStrongURI(uri *url.URL) resourceContentBase {
+	if v := b.resourceURI(); v != nil {
+		uri = v
+	}
+	if uri != nil {
+		clone := *uri
+		b.strongURI = &clone
+	}
+	return b
+}
+
+// resourceURIString returns the URI of this resource as string. empty if the URI is not available.
+func (b resourceContentBase) resourceURIString() string {
+	uri := b.resourceURI()
+	if uri == nil {
+		return ""
+	}
+	return uri.String()
 }
 
 // compatibility check
@@ -315,24 +480,49 @@ type textResourceContent struct {
 	resourceContentBase
 
 	// text of the item. This must only be set if the item can actually be represented as text (not binary data).
-	text    string
+	text string
+
+	// schema of the JSON text, if declared.
+	schema *jsonschema.Schema
+
 	marshal JSONMarshalFunc
 }
 
+// textResourceContentMeta is the metadata of the text resource content.
+type textResourceContentMeta struct {
+	// Schema is the JSON Schema of the text.
+	Schema *jsonschema.Schema `json:"schema,omitzero"`
+
+	// Checksum is the checksum of the text.
+	Checksum *contentChecksum `json:"checksum,omitzero"`
+}
+
 func (t textResourceContent) MarshalJSON() ([]byte, error) {
+	var meta *textResourceContentMeta
+	if t.schema != nil || t.checksum != nil {
+		meta = &textResourceContentMeta{Schema: t.schema, Checksum: t.checksum}
+	}
 	return t.marshal(struct {
-		URI      string `json:"uri"`
-		MimeType string `json:"mimeType,omitzero"`
-		Text     string `json:"text,omitzero"`
+		URI          string                   `json:"uri"`
+		Name         string                   `json:"name,omitzero"`
+		Description  string                   `json:"description,omitzero"`
+		MimeType     string                   `json:"mimeType,omitzero"`
+		LastModified time.Time                `json:"lastModified,omitzero"`
+		Text         string                   `json:"text,omitzero"`
+		Meta         *textResourceContentMeta `json:"_meta,omitzero"`
 	}{
-		URI:      t.uri.Value().String(),
-		MimeType: t.mimeType,
-		Text:     t.text,
+		URI:          t.resourceURIString(),
+		Name:         t.name,
+		Description:  t.description,
+		MimeType:     t.mimeType,
+		LastModified: t.lastModified,
+		Text:         t.text,
+		Meta:         meta,
 	})
 }
 
 func (t textResourceContent) GetURI() *url.URL {
-	return t.uri.Value()
+	return t.resourceURI()
 }
 
 func (t textResourceContent) GetMimeType() string {
@@ -347,30 +537,140 @@ type binaryResourceContent struct {
 	resourceContentBase
 
 	// blob is a base64-encoded string representing the binary data of the item.
-	blob    string
+	blob string
+
+	// size is the total size of the binary data in bytes.
+	size int64
+
+	// blobRange is the range of the binary data contained in blob. nil if blob is the whole data.
+	blobRange *BlobRange
+
 	marshal JSONMarshalFunc
 }
 
+// binaryResourceContentMeta is the metadata of the binary resource content.
+type binaryResourceContentMeta struct {
+	// Size is the total size of the binary data in bytes.
+	Size int64 `json:"size"`
+
+	// Range is the range of the binary data contained in the blob.
+	Range *BlobRange `json:"range,omitzero"`
+
+	// Checksum is the checksum of the binary data contained in the blob.
+	Checksum *contentChecksum `json:"checksum,omitzero"`
+}
+
 func (b binaryResourceContent) MarshalJSON() ([]byte, error) {
 	return b.marshal(struct {
-		URI      string `json:"uri"`
-		MimeType string `json:"mimeType,omitzero"`
-		Blob     string `json:"blob"`
+		URI          string                    `json:"uri"`
+		Name         string                    `json:"name,omitzero"`
+		Description  string                    `json:"description,omitzero"`
+		MimeType     string                    `json:"mimeType,omitzero"`
+		LastModified time.Time                 `json:"lastModified,omitzero"`
+		Blob         string                    `json:"blob"`
+		Meta         binaryResourceContentMeta `json:"_meta"`
 	}{
-		URI:      b.uri.Value().String(),
-		MimeType: b.mimeType,
-		Blob:     b.blob,
+		URI:          b.resourceURIString(),
+		Name:         b.name,
+		Description:  b.description,
+		MimeType:     b.mimeType,
+		LastModified: b.lastModified,
+		Blob:         b.blob,
+		Meta: binaryResourceContentMeta{
+			Size:     b.size,
+			Range:    b.blobRange,
+			Checksum: b.checksum,
+		},
 	})
 }
 
 func (b binaryResourceContent) GetURI() *url.URL {
-	return b.uri.Value()
+	return b.resourceURI()
 }
 
 func (b binaryResourceContent) GetMimeType() string {
 	return b.mimeType
 }
 
+// compatibility check
+var _ ResourceContent = (*resourceLinkResourceContent)(nil)
+
+// resourceLinkResourceContent is a resource content that links to the child resource of a collection.
+type resourceLinkResourceContent struct {
+	// resource is the child resource linked to.
+	resource Resource
+
+	marshal JSONMarshalFunc
+}
+
+func (r resourceLinkResourceContent) MarshalJSON() ([]byte, error) {
+	return r.marshal(struct {
+		Type string `json:"type"`
+		Resource
+	}{
+		Type:     "resource_link",
+		Resource: r.resource,
+	})
+}
+
+func (r resourceLinkResourceContent) GetURI() *url.URL {
+	return r.resource.URI.URL()
+}
+
+func (r resourceLinkResourceContent) GetMimeType() string {
+	return r.resource.MimeType
+}
+
+// withLastModified returns the content with the last modified time set, if the content is built by qilin.
+// The custom contents are returned as is.
+func withLastModified(content ResourceContent, t time.Time) ResourceContent {
+	switch v := content.(type) {
+	case textResourceContent:
+		v.lastModified = t
+		return v
+	case binaryResourceContent:
+		v.lastModified = t
+		return v
+	}
+	return content
+}
+
+// withTruncatedText returns the text content with the text shortened by at least n bytes, at the rune boundary.
+// The checksum is recomputed over the shortened text. ok is false if the content is not text or the text is not longer than n bytes.
+func withTruncatedText(content ResourceContent, n int, checksum *resourceChecksum) (_ ResourceContent, ok bool) {
+	v, ok := content.(textResourceContent)
+	if !ok || len(v.text) <= n {
+		return content, false
+	}
+	v.text = truncateText(v.text, len(v.text)-n)
+	if v.checksum != nil {
+		v.checksum = checksum.of([]byte(v.text))
+	}
+	return v, true
+}
+
+// truncateText returns the longest prefix of s within n bytes, not splitting a rune.
+func truncateText(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// withStrongURI returns the content holding its URI strongly, so that it can be embedded beyond the read.
+// uri is the one the content was read from, used if the content lost its URI.
+func withStrongURI(content ResourceContent, uri *url.URL) ResourceContent {
+	switch v := content.(type) {
+	case textResourceContent:
+		v.resourceContentBase = v.resourceContentBase.withStrongURI(uri)
+		return v
+	case binaryResourceContent:
+		v.resourceContentBase = v.resourceContentBase.withStrongURI(uri)
+		return v
+	}
+	return content
+}
+
 // readResourceResult is the server's response to a resources/read request from the client.
 type readResourceResult struct {
 	// Contents is the content of the resource.
@@ -395,6 +695,97 @@ type Resource struct {
 
 	// MimeType of this resource, if known.
 	MimeType string `json:"mimeType,omitzero"`
+
+	// Size of the raw resource content in bytes, if known.
+	Size int64 `json:"size,omitzero"`
+
+	// LastModified is the time this resource was last modified, if known.
+	LastModified time.Time `json:"lastModified,omitzero"`
+
+	// reportsLastModified reports whether the resource is registered with ResourceWithLastModified.
+	reportsLastModified bool
+}
+
+// elicitRequestParams is sent from the server to the client to request additional information from the user.
+type elicitRequestParams struct {
+	// Message to present to the user.
+	Message string `json:"message"`
+
+	// RequestedSchema is the JSON Schema of the requested information.
+	//
+	// It is restricted to a flat object with the properties of primitive types.
+	RequestedSchema *jsonschema.Schema `json:"requestedSchema"`
+}
+
+const (
+	// elicitActionAccept means the user submitted the requested information.
+	elicitActionAccept = "accept"
+
+	// elicitActionDecline means the user explicitly declined the request.
+	elicitActionDecline = "decline"
+
+	// elicitActionCancel means the user dismissed the request without making an explicit choice.
+	elicitActionCancel = "cancel"
+)
+
+// elicitResult is the client's response to an elicitation/create request.
+type elicitResult struct {
+	// Action is the action of the user in response to the elicitation. one of accept, decline and cancel.
+	Action string `json:"action"`
+
+	// Content is the submitted information. only present if Action is accept.
+	Content map[string]any `json:"content,omitzero"`
+}
+
+// toolStreamNotificationParams is sent from the server to the client with a chunk of the result of a tool.
+type toolStreamNotificationParams struct {
+	// RequestID is the ID of the `tools/call` request the chunk belongs to.
+	RequestID any `json:"requestId"`
+
+	// Content is the chunk of the result.
+	Content CallToolContent `json:"content"`
+}
+
+// progressNotificationParams is sent from the server to the client with the progress of a request.
+type progressNotificationParams struct {
+	// ProgressToken is the token given in the request.
+	ProgressToken json.RawMessage `json:"progressToken"`
+
+	// Progress is the progress so far. It increases every time.
+	Progress float64 `json:"progress"`
+
+	// Total is the total of the progress, if known.
+	Total float64 `json:"total,omitzero"`
+
+	// Message describes the current progress.
+	Message string `json:"message,omitzero"`
+}
+
+// selfDescription describes the server and its inventory.
+type selfDescription struct {
+	Name              string                      `json:"name"`
+	Version           string                      `json:"version"`
+	Instructions      string                      `json:"instructions,omitzero"`
+	Tools             []Tool                      `json:"tools"`
+	Resources         []describedResource         `json:"resources"`
+	ResourceTemplates []describedResourceTemplate `json:"resourceTemplates"`
+	Prompts           []Prompt                    `json:"prompts"`
+}
+
+// describedResource is a Resource in the self description.
+type describedResource struct {
+	Resource
+
+	// Subscribable indicates the clients can subscribe to the changes of this resource.
+	Subscribable bool `json:"subscribable"`
+}
+
+// describedResourceTemplate is a resource template in the self description.
+type describedResourceTemplate struct {
+	resourceTemplate
+
+	// Subscribable indicates the clients can subscribe to the changes of the resources matching this template.
+	Subscribable bool `json:"subscribable"`
 }
 
 // resourceTemplate a template description for resources available on the server.
@@ -416,6 +807,73 @@ type resourceTemplate struct {
 	// MimeType for all resources that match this template.
 	// This should only be included if all resources matching this template have the same type.
 	MimeType string `json:"mimeType,omitzero"`
+
+	// Meta is the metadata of this template. nil if there is none.
+	Meta *resourceTemplateMeta `json:"_meta,omitzero"`
+}
+
+// resourceTemplateMeta is the metadata of the resource template.
+type resourceTemplateMeta struct {
+	// ParamEnums is the allowed values of each path parameter, declared with ResourceWithParamEnum.
+	ParamEnums map[string][]string `json:"paramEnums"`
+}
+
+// completeRequestParams is sent from the client to the server to ask for completion options.
+type completeRequestParams struct {
+	// Ref is the reference to the prompt or the resource template.
+	Ref completeReference `json:"ref"`
+
+	// Argument is the argument being completed.
+	Argument completeArgument `json:"argument"`
+}
+
+const (
+	// completeRefResource references a resource template by its URI template.
+	completeRefResource = "ref/resource"
+
+	// completeRefPrompt references a prompt by its name.
+	completeRefPrompt = "ref/prompt"
+)
+
+// completeReference references the prompt or the resource template to complete the argument of.
+type completeReference struct {
+	// Type is one of ref/resource and ref/prompt.
+	Type string `json:"type"`
+
+	// URI is the URI template of the resource. only present if Type is ref/resource.
+	URI string `json:"uri,omitzero"`
+
+	// Name is the name of the prompt. only present if Type is ref/prompt.
+	Name string `json:"name,omitzero"`
+}
+
+// completeArgument is the argument being completed.
+type completeArgument struct {
+	// Name of the argument.
+	Name string `json:"name"`
+
+	// Value of the argument typed so far.
+	Value string `json:"value"`
+}
+
+// maxCompletionValues is the maximum number of the values in a completion result.
+const maxCompletionValues = 100
+
+// completeResult is the server's response to a completion/complete request.
+type completeResult struct {
+	Completion completion `json:"completion"`
+}
+
+// completion is the completion options of the argument.
+type completion struct {
+	// Values are the completion values, up to maxCompletionValues.
+	Values []string `json:"values"`
+
+	// Total is the total number of the completion values, which may exceed the number of Values.
+	Total int `json:"total"`
+
+	// HasMore indicates there are more completion values than Values.
+	HasMore bool `json:"hasMore"`
 }
 
 // listResourcesResult is the server's response to a request for a list of resources.
@@ -424,8 +882,19 @@ type listResourcesResult struct {
 	Resources []Resource `json:"resources"`
 }
 
+// paginatedRequestParams is sent from the client to the server to request a page of a list.
+type paginatedRequestParams struct {
+	// Cursor is an opaque token representing the current pagination position.
+	// If provided, the server should return results starting after this cursor.
+	Cursor string `json:"cursor,omitzero"`
+}
+
 // listResourceTemplatesResult is the server's response to a request for a list of resource templates.
 type listResourceTemplatesResult struct {
+	// NextCursor is an opaque token representing the pagination position after the last returned result.
+	// If present, there may be more results available.
+	NextCursor string `json:"nextCursor,omitzero"`
+
 	// ResourceTemplates is a list of resource templates available on the server.
 	ResourceTemplates []resourceTemplate `json:"resourceTemplates"`
 }
@@ -434,6 +903,99 @@ type listResourceTemplatesResult struct {
 type readResourceRequestParams struct {
 	// URI of the resource to read. The URI can use any protocol; it is up to the server how to interpret it.
 	URI *ResourceURI `json:"uri"`
+
+	// Meta is the metadata of the request.
+	Meta *readResourceRequestMeta `json:"_meta,omitzero"`
+}
+
+// blobRange returns the requested byte range. nil if not requested.
+func (p readResourceRequestParams) blobRange() *BlobRange {
+	if p.Meta == nil {
+		return nil
+	}
+	return p.Meta.Range
+}
+
+// ResponseLimitMode is the way WithMaxResponseBytes handles the result exceeding the limit.
+type ResponseLimitMode int
+
+const (
+	// ResponseLimitError fails the request with ErrResponseTooLarge.
+	ResponseLimitError ResponseLimitMode = iota
+	// ResponseLimitTruncate cuts the text at the end of the result, and drops the trailing resource contents not fitting.
+	// The result that cannot be truncated, such as an image, fails with ErrResponseTooLarge.
+	ResponseLimitTruncate
+)
+
+// EmptyToolContentMode is the way `tools/call` handles the tool handler returning without writing any content.
+type EmptyToolContentMode int
+
+const (
+	// EmptyToolContentText sends an empty text content.
+	EmptyToolContentText EmptyToolContentMode = iota
+	// EmptyToolContentError fails the call with ErrToolContentNotWritten.
+	EmptyToolContentError
+)
+
+// TransportKind is the kind of the transport the request is served on.
+type TransportKind int
+
+const (
+	// TransportKindUnknown is the transport other than the ones provided by qilin.
+	TransportKindUnknown TransportKind = iota
+	// TransportKindStdio is the stdio transport.
+	TransportKindStdio
+	// TransportKindStreamable is the streamable HTTP transport.
+	TransportKindStreamable
+)
+
+// String returns the name of the transport kind.
+func (k TransportKind) String() string {
+	switch k {
+	case TransportKindStdio:
+		return "stdio"
+	case TransportKindStreamable:
+		return "streamable"
+	}
+	return "unknown"
+}
+
+// requestMeta is the metadata common to the requests.
+type requestMeta struct {
+	// TimeoutMs is the timeout in milliseconds the client is willing to wait for.
+	TimeoutMs int64 `json:"timeoutMs,omitzero"`
+}
+
+// readResourceRequestMeta is the metadata of the resources/read request.
+type readResourceRequestMeta struct {
+	// Range is the requested byte range of the blob.
+	Range *BlobRange `json:"range,omitzero"`
+}
+
+// BlobRange is a byte range of the blob resource.
+type BlobRange struct {
+	// Start is the zero-based offset of the first byte, inclusive.
+	Start int64 `json:"start"`
+
+	// End is the zero-based offset of the last byte, exclusive. zero means the end of the blob.
+	End int64 `json:"end,omitzero"`
+}
+
+// slice returns the range of data and the applied range.
+func (r BlobRange) slice(data []byte) ([]byte, BlobRange) {
+	applied := r.bounds(int64(len(data)))
+	return data[applied.Start:applied.End], applied
+}
+
+// bounds returns the range applied to a blob of the given size.
+func (r BlobRange) bounds(size int64) BlobRange {
+	start := min(max(r.Start, 0), size)
+	end := r.End
+	if end == 0 || end > size {
+		end = size
+	}
+	end = max(end, start)
+	return BlobRange{Start: start, End: end}
 }
 
 // Tool defines a Tool that the client can call.
@@ -452,6 +1014,9 @@ type Tool struct {
 
 	// handler handles invoke the Tool with the provided arguments.
 	handler ToolHandlerFunc
+
+	// lenientBinding coerces the string-encoded numbers and booleans on binding the arguments.
+	lenientBinding bool
 }
 
 // ToolAnnotations represents additional properties describing a Tool to clients.
@@ -485,6 +1050,31 @@ type ToolAnnotations struct {
 	OpenWorldHint bool `json:"openWorldHint,omitzero"`
 }
 
+// ContentAnnotations represents the annotations of a content in the Tool result,
+// informing clients how to use or display the content.
+type ContentAnnotations struct {
+	// Audience describes who the content is intended for. e.g. PromptRoleUser and PromptRoleAssistant
+	Audience []PromptRole
+
+	// Priority describes how important the content is, from 0 (least important) to 1 (most important).
+	// Zero is omitted, leaving it unspecified.
+	Priority float64
+}
+
+func (a ContentAnnotations) MarshalJSON() ([]byte, error) {
+	audience := make([]string, 0, len(a.Audience))
+	for _, v := range a.Audience {
+		audience = append(audience, v.String())
+	}
+	return json.Marshal(struct {
+		Audience []string `json:"audience,omitempty"`
+		Priority float64  `json:"priority,omitzero"`
+	}{
+		Audience: audience,
+		Priority: a.Priority,
+	})
+}
+
 type listToolsResponse struct {
 	NextCursor string `json:"nextCursor,omitzero"`
 	Tools      []Tool `json:"tools"`
@@ -499,15 +1089,20 @@ var _ CallToolContent = (*textCallToolContent)(nil)
 
 type textCallToolContent struct {
 	Text        string
-	Annotations *ToolAnnotations
+	Annotations *ContentAnnotations
 	marshal     JSONMarshalFunc
 }
 
 func (t *textCallToolContent) MarshalJSON() ([]byte, error) {
-	return t.marshal(struct {
-		Type        string           `json:"type"`
-		Text        string           `json:"text"`
-		Annotations *ToolAnnotations `json:"annotations,omitzero"`
+	marshal := t.marshal
+	if marshal == nil {
+		// created by TextContent, and not yet passed to ToolContext.Fail
+		marshal = json.Marshal
+	}
+	return marshal(struct {
+		Type        string              `json:"type"`
+		Text        string              `json:"text"`
+		Annotations *ContentAnnotations `json:"annotations,omitzero"`
 	}{
 		Type:        t.GetType(),
 		Text:        t.Text,
@@ -591,19 +1186,184 @@ func (e *embedResourceCallToolContent) GetType() string {
 	return "resource"
 }
 
-// prompt defines a prompt template that the client can request.
-type prompt struct {
+// compatibility check
+var _ CallToolContent = (*resourceLinkCallToolContent)(nil)
+
+type resourceLinkCallToolContent struct {
+	URI         string
+	Name        string
+	Description string
+	MimeType    string
+	marshal     JSONMarshalFunc
+}
+
+func (r *resourceLinkCallToolContent) MarshalJSON() ([]byte, error) {
+	marshal := r.marshal
+	if marshal == nil {
+		// created by ResourceLinkContent, and not yet passed to ToolContext.Fail
+		marshal = json.Marshal
+	}
+	return marshal(struct {
+		Type        string `json:"type"`
+		URI         string `json:"uri"`
+		Name        string `json:"name"`
+		Description string `json:"description,omitzero"`
+		MimeType    string `json:"mimeType,omitzero"`
+	}{
+		Type:        r.GetType(),
+		URI:         r.URI,
+		Name:        r.Name,
+		Description: r.Description,
+		MimeType:    r.MimeType,
+	})
+}
+
+func (r *resourceLinkCallToolContent) GetType() string {
+	return "resource_link"
+}
+
+// TextContent creates the text content, such as the remediation passed to ToolContext.Fail.
+func TextContent(text string) CallToolContent {
+	return &textCallToolContent{Text: text}
+}
+
+// ResourceLinkContent creates the link to the resource, such as the remediation passed to ToolContext.Fail.
+// ToolContext.Fail fails with ErrInvalidResourceURI if uri is nil.
+func ResourceLinkContent(uri *url.URL, name, description, mimeType string) CallToolContent {
+	var raw string
+	if uri != nil {
+		raw = uri.String()
+	}
+	return &resourceLinkCallToolContent{
+		URI:         raw,
+		Name:        name,
+		Description: description,
+		MimeType:    mimeType,
+	}
+}
+
+// compatibility check
+var _ CallToolContent = (*errorCallToolContent)(nil)
+
+// errorCallToolContent is the error result of a tool, carrying the contents describing how to fix it.
+type errorCallToolContent struct {
+	Contents []CallToolContent
+	marshal  JSONMarshalFunc
+}
+
+func (e *errorCallToolContent) MarshalJSON() ([]byte, error) {
+	return e.marshal(struct {
+		Content []CallToolContent `json:"content"`
+		IsError bool              `json:"isError"`
+	}{
+		Content: e.Contents,
+		IsError: true,
+	})
+}
+
+func (e *errorCallToolContent) GetType() string {
+	return "error"
+}
+
+// compatibility check
+var _ CallToolContent = (*tableCallToolContent)(nil)
+
+// tableCallToolContent is the result of a tool carrying the text content rendering a table,
+// and the table itself as the structured content of the result.
+type tableCallToolContent struct {
+	Headers     []string
+	Rows        [][]string
+	Annotations *ContentAnnotations
+	marshal     JSONMarshalFunc
+}
+
+// toolTable is the structured representation of a table.
+type toolTable struct {
+	Headers []string   `json:"headers"`
+	Rows    [][]string `json:"rows"`
+}
+
+func (t *tableCallToolContent) MarshalJSON() ([]byte, error) {
+	type textContent struct {
+		Type        string              `json:"type"`
+		Text        string              `json:"text"`
+		Annotations *ContentAnnotations `json:"annotations,omitzero"`
+	}
+	return t.marshal(struct {
+		Content           []textContent `json:"content"`
+		StructuredContent toolTable     `json:"structuredContent"`
+	}{
+		Content: []textContent{
+			{
+				Type:        t.GetType(),
+				Text:        t.Text(),
+				Annotations: t.Annotations,
+			},
+		},
+		StructuredContent: toolTable{
+			Headers: t.Headers,
+			Rows:    t.Rows,
+		},
+	})
+}
+
+func (t *tableCallToolContent) GetType() string {
+	return "text"
+}
+
+// Text renders the table as a Markdown table.
+func (t *tableCallToolContent) Text() string {
+	var b strings.Builder
+	writeRow := func(cells []string) {
+		b.WriteString("|")
+		for idx := range t.Headers {
+			var cell string
+			if idx < len(cells) {
+				cell = cells[idx]
+			}
+			b.WriteString(" ")
+			b.WriteString(markdownTableCellReplacer.Replace(cell))
+			b.WriteString(" |")
+		}
+		b.WriteString("\n")
+	}
+	writeRow(t.Headers)
+	b.WriteString("|")
+	for range t.Headers {
+		b.WriteString(" --- |")
+	}
+	b.WriteString("\n")
+	for _, row := range t.Rows {
+		writeRow(row)
+	}
+	return b.String()
+}
+
+// markdownTableCellReplacer escapes the characters breaking a cell of Markdown tables.
+var markdownTableCellReplacer = strings.NewReplacer("|", "\\|", "\r\n", "<br>", "\n", "<br>")
+
+// Prompt defines a prompt template that the client can request.
+type Prompt struct {
 	// Name is the unique identifier for the prompt.
 	Name string `json:"name"`
 
+	// Title is a human-readable title for the prompt.
+	Title string `json:"title,omitzero"`
+
 	// Description of the prompt that is human-readable.
 	Description string `json:"description,omitzero"`
 
 	// Arguments contains the JSON Schema that defines the expected parameters for the prompt.
 	Arguments []PromptArgument `json:"arguments,omitzero"`
 
+	// Meta is the arbitrary metadata of the prompt.
+	Meta map[string]any `json:"_meta,omitzero"`
+
 	// handler handles invocation of the prompt with the provided arguments.
 	handler PromptHandlerFunc
+
+	// strictArguments rejects the arguments not declared in Arguments on binding.
+	strictArguments bool
 }
 
 // PromptArgument represents an argument that a prompt accepts.
@@ -616,6 +1376,9 @@ type PromptArgument struct {
 
 	// Required indicates whether the argument is required.
 	Required bool `json:"required,omitzero"`
+
+	// Default is the value used when the argument is omitted by the client.
+	Default string `json:"default,omitzero"`
 }
 
 // promptMessage represents content that is part of a prompt.
@@ -708,7 +1471,7 @@ func (e *embedResourcePromptContent) GetType() string {
 // listPromptsResult is the server's response to a prompts/list request.
 type listPromptsResult struct {
 	// Prompts is a list of prompt templates available on the server.
-	Prompts []prompt `json:"prompts"`
+	Prompts []Prompt `json:"prompts"`
 }
 
 // getPromptRequestParams sent from the client to the server to get a specific prompt.
@@ -732,6 +1495,23 @@ type getPromptResult struct {
 // subscribeResourcesRequestParams sent from the client to request resources/updated notifications from the server whenever a particular resource changes.
 type subscribeResourcesRequestParams struct {
 	URI *ResourceURI `json:"uri"`
+
+	// Meta is the optional hints of the subscription.
+	Meta *subscribeResourcesRequestMeta `json:"_meta,omitzero"`
+}
+
+// minInterval returns the requested minimum interval between the notifications. zero if not requested.
+func (p subscribeResourcesRequestParams) minInterval() time.Duration {
+	if p.Meta == nil || p.Meta.MinIntervalMs <= 0 {
+		return 0
+	}
+	return time.Duration(p.Meta.MinIntervalMs) * time.Millisecond
+}
+
+// subscribeResourcesRequestMeta is the metadata of the resources/subscribe request.
+type subscribeResourcesRequestMeta struct {
+	// MinIntervalMs is the minimum interval in milliseconds between the resources/updated notifications.
+	MinIntervalMs int64 `json:"minIntervalMs,omitzero"`
 }
 
 // unsubscribeResourcesRequestParams from the client to request cancellation of resources/updated notifications from the server.
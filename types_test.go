@@ -1,7 +1,9 @@
 package qilin
 
 import (
+	"encoding/json"
 	"errors"
+	"net/url"
 	"testing"
 )
 
@@ -91,3 +93,97 @@ func TestPromptRoleFromString(t *testing.T) {
 		})
 	}
 }
+
+func TestParseResourceURI(t *testing.T) {
+	type test struct {
+		raw     string
+		want    string
+		wantErr error
+	}
+	tests := map[string]test{
+		"happy path": {
+			raw:  "beer://detail/1",
+			want: "beer://detail/1",
+		},
+		"host only": {
+			raw:  "beer://list",
+			want: "beer://list",
+		},
+		"empty": {
+			raw:     "",
+			wantErr: ErrInvalidResourceURI,
+		},
+		"without scheme": {
+			raw:     "/detail/1",
+			wantErr: ErrInvalidResourceURI,
+		},
+		"without host": {
+			raw:     "beer:///detail/1",
+			wantErr: ErrInvalidResourceURI,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseResourceURI(tc.raw)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.URL().String() != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, got.URL().String())
+			}
+		})
+	}
+	t.Run("malformed", func(t *testing.T) {
+		if _, err := ParseResourceURI("beer://detail/%zz"); err == nil {
+			t.Fatalf("expected error, got nil")
+		}
+	})
+}
+
+func TestResourceURIFromURL(t *testing.T) {
+	uri, err := url.Parse("beer://detail/1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := ResourceURIFromURL(uri)
+	if got.URL() != uri {
+		t.Fatalf("expected %v, got %v", uri, got.URL())
+	}
+	if ResourceURIFromURL(nil).URL() != nil {
+		t.Fatalf("expected nil")
+	}
+}
+
+func TestContent_MarshalJSON(t *testing.T) {
+	type test struct {
+		content CallToolContent
+		expect  string
+	}
+	tests := map[string]test{
+		"TextContent": {
+			content: TextContent("the deploy key has expired"),
+			expect:  `{"type":"text","text":"the deploy key has expired"}`,
+		},
+		"ResourceLinkContent": {
+			content: ResourceLinkContent(&url.URL{Scheme: "docs", Host: "rotate-keys"}, "rotate-keys", "", ""),
+			expect:  `{"type":"resource_link","uri":"docs://rotate-keys","name":"rotate-keys"}`,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			b, err := json.Marshal(tc.content)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != tc.expect {
+				t.Fatalf("expected %s, got %s", tc.expect, b)
+			}
+		})
+	}
+}